@@ -0,0 +1,126 @@
+// Package arrowformat encodes query results in the Apache Arrow IPC stream format
+package arrowformat
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/apache/arrow/go/v15/arrow"
+	"github.com/apache/arrow/go/v15/arrow/array"
+	"github.com/apache/arrow/go/v15/arrow/ipc"
+	"github.com/apache/arrow/go/v15/arrow/memory"
+	v3 "go.signoz.io/signoz/pkg/query-service/model/v3"
+)
+
+// ContentType is the media type of the Arrow IPC stream format
+const ContentType = "application/vnd.apache.arrow.stream"
+
+// Schema is the schema of the encoded results, each point of a series and each
+// row of a list is a record. The points have labels and a value, the rows have
+// their data as a JSON object instead
+var Schema = arrow.NewSchema([]arrow.Field{
+	{Name: "query_name", Type: arrow.BinaryTypes.String},
+	{Name: "labels", Type: arrow.MapOf(arrow.BinaryTypes.String, arrow.BinaryTypes.String), Nullable: true},
+	{Name: "timestamp", Type: arrow.FixedWidthTypes.Timestamp_ns},
+	{Name: "value", Type: arrow.PrimitiveTypes.Float64, Nullable: true},
+	{Name: "data", Type: arrow.BinaryTypes.String, Nullable: true},
+}, nil)
+
+// Accepts returns true if the client asked for the Arrow stream format
+func Accepts(r *http.Request) bool {
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(accept))
+		if err == nil && mediaType == ContentType {
+			return true
+		}
+	}
+	return false
+}
+
+// Supported returns true if the results can be encoded as Arrow, the tables
+// are not supported
+func Supported(results []*v3.Result) bool {
+	for _, result := range results {
+		if result.Table != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// EncodeResults writes the series and lists of the results to w as an Arrow IPC
+// stream, with a record batch per result
+func EncodeResults(w io.Writer, results []*v3.Result) error {
+	if !Supported(results) {
+		return errors.New("table results are not supported in arrow format")
+	}
+
+	mem := memory.NewGoAllocator()
+	writer := ipc.NewWriter(w, ipc.WithSchema(Schema), ipc.WithAllocator(mem))
+	builder := array.NewRecordBuilder(mem, Schema)
+	defer builder.Release()
+
+	for _, result := range results {
+		if err := appendResult(builder, result); err != nil {
+			writer.Close()
+			return err
+		}
+		record := builder.NewRecord()
+		err := writer.Write(record)
+		record.Release()
+		if err != nil {
+			writer.Close()
+			return fmt.Errorf("error writing arrow record: %w", err)
+		}
+	}
+	return writer.Close()
+}
+
+func appendResult(builder *array.RecordBuilder, result *v3.Result) error {
+	queryNames := builder.Field(0).(*array.StringBuilder)
+	labels := builder.Field(1).(*array.MapBuilder)
+	labelKeys := labels.KeyBuilder().(*array.StringBuilder)
+	labelValues := labels.ItemBuilder().(*array.StringBuilder)
+	timestamps := builder.Field(2).(*array.TimestampBuilder)
+	values := builder.Field(3).(*array.Float64Builder)
+	data := builder.Field(4).(*array.StringBuilder)
+
+	for _, series := range result.Series {
+		keys := make([]string, 0, len(series.Labels))
+		for key := range series.Labels {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, point := range series.Points {
+			queryNames.Append(result.QueryName)
+			labels.Append(true)
+			for _, key := range keys {
+				labelKeys.Append(key)
+				labelValues.Append(series.Labels[key])
+			}
+			timestamps.Append(arrow.Timestamp(point.Timestamp * 1e6))
+			values.Append(point.Value)
+			data.AppendNull()
+		}
+	}
+
+	for _, row := range result.List {
+		rowData, err := json.Marshal(row.Data)
+		if err != nil {
+			return fmt.Errorf("error marshalling row data: %w", err)
+		}
+		queryNames.Append(result.QueryName)
+		labels.AppendNull()
+		timestamps.Append(arrow.Timestamp(row.Timestamp.UnixNano()))
+		values.AppendNull()
+		data.Append(string(rowData))
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+package arrowformat
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/apache/arrow/go/v15/arrow/array"
+	"github.com/apache/arrow/go/v15/arrow/ipc"
+	v3 "go.signoz.io/signoz/pkg/query-service/model/v3"
+)
+
+func TestAccepts(t *testing.T) {
+	testCases := []struct {
+		accept   string
+		expected bool
+	}{
+		{accept: "", expected: false},
+		{accept: "application/json", expected: false},
+		{accept: "application/vnd.apache.arrow.stream", expected: true},
+		{accept: "application/json, application/vnd.apache.arrow.stream;q=0.9", expected: true},
+	}
+	for _, tc := range testCases {
+		r := httptest.NewRequest("POST", "/api/v3/query_range", nil)
+		r.Header.Set("Accept", tc.accept)
+		if got := Accepts(r); got != tc.expected {
+			t.Errorf("expected Accepts(%q) to be %v, got %v", tc.accept, tc.expected, got)
+		}
+	}
+}
+
+// decodeResults decodes the stream back to results, the series of each result
+// are keyed by their labels
+func decodeResults(t *testing.T, data []byte) []*v3.Result {
+	reader, err := ipc.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("error reading arrow stream: %s", err)
+	}
+	defer reader.Release()
+
+	results := make([]*v3.Result, 0)
+	for reader.Next() {
+		record := reader.Record()
+		queryNames := record.Column(0).(*array.String)
+		labels := record.Column(1).(*array.Map)
+		labelKeys := labels.Keys().(*array.String)
+		labelValues := labels.Items().(*array.String)
+		timestamps := record.Column(2).(*array.Timestamp)
+		values := record.Column(3).(*array.Float64)
+		data := record.Column(4).(*array.String)
+
+		result := &v3.Result{}
+		seriesByLabels := map[string]*v3.Series{}
+		for i := 0; i < int(record.NumRows()); i++ {
+			result.QueryName = queryNames.Value(i)
+			if data.IsValid(i) {
+				row := &v3.Row{Timestamp: time.Unix(0, int64(timestamps.Value(i))).UTC()}
+				if err := json.Unmarshal([]byte(data.Value(i)), &row.Data); err != nil {
+					t.Fatalf("error unmarshalling row data: %s", err)
+				}
+				result.List = append(result.List, row)
+				continue
+			}
+			seriesLabels := map[string]string{}
+			start, end := labels.ValueOffsets(i)
+			for j := start; j < end; j++ {
+				seriesLabels[labelKeys.Value(int(j))] = labelValues.Value(int(j))
+			}
+			key, _ := json.Marshal(seriesLabels)
+			series, ok := seriesByLabels[string(key)]
+			if !ok {
+				series = &v3.Series{Labels: seriesLabels}
+				seriesByLabels[string(key)] = series
+				result.Series = append(result.Series, series)
+			}
+			series.Points = append(series.Points, v3.Point{Timestamp: int64(timestamps.Value(i)) / 1e6, Value: values.Value(i)})
+		}
+		results = append(results, result)
+	}
+	if err := reader.Err(); err != nil {
+		t.Fatalf("error reading arrow stream: %s", err)
+	}
+	return results
+}
+
+func TestEncodeResults(t *testing.T) {
+	results := []*v3.Result{
+		{
+			QueryName: "A",
+			Series: []*v3.Series{
+				{
+					Labels: map[string]string{"service_name": "frontend", "operation": "GET"},
+					Points: []v3.Point{{Timestamp: 1675115520000, Value: 1.5}, {Timestamp: 1675115580000, Value: 2}},
+				},
+				{
+					Labels: map[string]string{},
+					Points: []v3.Point{{Timestamp: 1675115520000, Value: 3}},
+				},
+			},
+		},
+		{
+			QueryName: "B",
+			List: []*v3.Row{
+				{Timestamp: time.Unix(0, 1675115520000000123).UTC(), Data: map[string]interface{}{"body": "hello", "severity_number": float64(9)}},
+			},
+		},
+	}
+
+	var buf bytes.Buffer
+	if err := EncodeResults(&buf, results); err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+
+	decoded := decodeResults(t, buf.Bytes())
+	if !reflect.DeepEqual(decoded, results) {
+		expected, _ := json.Marshal(results)
+		got, _ := json.Marshal(decoded)
+		t.Errorf("expected %s, got %s", expected, got)
+	}
+}
+
+func TestEncodeResultsTableNotSupported(t *testing.T) {
+	results := []*v3.Result{{QueryName: "A", Table: &v3.Table{}}}
+	if Supported(results) {
+		t.Errorf("expected tables to not be supported")
+	}
+	var buf bytes.Buffer
+	if err := EncodeResults(&buf, results); err == nil {
+		t.Errorf("expected an error for table results")
+	}
+	if buf.Len() != 0 {
+		t.Errorf("expected nothing to be written, got %d bytes", buf.Len())
+	}
+}
@@ -4145,6 +4145,28 @@ func (r *ClickHouseReader) GetLatestReceivedMetric(
 	return result, nil
 }
 
+// escapeLikePattern escapes the characters that have a special meaning in a
+// clickhouse LIKE pattern so that s is matched literally.
+func escapeLikePattern(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// withTagAttributesTimeRange adds the conditions limiting the tag attributes
+// to the ones seen in the time range. start and end are in unix nanoseconds,
+// zero means unbounded. The timestamp of the tag attributes is in seconds, so
+// the range is widened to whole seconds.
+func withTagAttributesTimeRange(conditions []string, args []interface{}, start, end int64) ([]string, []interface{}) {
+	if start > 0 {
+		args = append(args, start/int64(time.Second))
+		conditions = append(conditions, fmt.Sprintf("timestamp >= toDateTime($%d)", len(args)))
+	}
+	if end > 0 {
+		args = append(args, (end+int64(time.Second)-1)/int64(time.Second))
+		conditions = append(conditions, fmt.Sprintf("timestamp <= toDateTime($%d)", len(args)))
+	}
+	return conditions, args
+}
+
 func isColumn(tableStatement, attrType, field, datType string) bool {
 	// value of attrType will be `resource` or `tag`, if `tag` change it to `attribute`
 	name := utils.GetClickhouseColumnName(attrType, datType, field)
@@ -4164,7 +4186,8 @@ func (r *ClickHouseReader) GetLogAggregateAttributes(ctx context.Context, req *v
 	switch req.Operator {
 	case
 		v3.AggregateOperatorCountDistinct,
-		v3.AggregateOperatorCount:
+		v3.AggregateOperatorCount,
+		v3.AggregateOperatorCountIf:
 		where = "tagKey ILIKE $1"
 		stringAllowed = true
 	case
@@ -4243,13 +4266,21 @@ func (r *ClickHouseReader) GetLogAttributeKeys(ctx context.Context, req *v3.Filt
 	var rows driver.Rows
 	var response v3.FilterAttributeKeyResponse
 
+	var conditions []string
+	var args []interface{}
+	// the keys are matched case-insensitively, `Service.Name` finds `service.name`
 	if len(req.SearchText) != 0 {
-		query = fmt.Sprintf("select distinct tagKey, tagType, tagDataType from  %s.%s where tagKey ILIKE $1 limit $2", r.logsDB, r.logsTagAttributeTable)
-		rows, err = r.db.Query(ctx, query, fmt.Sprintf("%%%s%%", req.SearchText), req.Limit)
-	} else {
-		query = fmt.Sprintf("select distinct tagKey, tagType, tagDataType from  %s.%s limit $1", r.logsDB, r.logsTagAttributeTable)
-		rows, err = r.db.Query(ctx, query, req.Limit)
+		args = append(args, fmt.Sprintf("%%%s%%", req.SearchText))
+		conditions = append(conditions, fmt.Sprintf("lower(tagKey) LIKE lower($%d)", len(args)))
 	}
+	conditions, args = withTagAttributesTimeRange(conditions, args, req.Start, req.End)
+	query = fmt.Sprintf("select distinct tagKey, tagType, tagDataType from  %s.%s", r.logsDB, r.logsTagAttributeTable)
+	if len(conditions) > 0 {
+		query = fmt.Sprintf("%s where %s", query, strings.Join(conditions, " and "))
+	}
+	args = append(args, req.Limit)
+	query = fmt.Sprintf("%s limit $%d", query, len(args))
+	rows, err = r.db.Query(ctx, query, args...)
 
 	if err != nil {
 		zap.L().Error("Error while executing query", zap.Error(err))
@@ -4287,7 +4318,7 @@ func (r *ClickHouseReader) GetLogAttributeKeys(ctx context.Context, req *v3.Filt
 		if (v3.AttributeKey{} == f) {
 			continue
 		}
-		if len(req.SearchText) == 0 || strings.Contains(f.Key, req.SearchText) {
+		if len(req.SearchText) == 0 || strings.Contains(strings.ToLower(f.Key), strings.ToLower(req.SearchText)) {
 			response.AttributeKeys = append(response.AttributeKeys, f)
 		}
 	}
@@ -4333,35 +4364,59 @@ func (r *ClickHouseReader) GetLogAttributeValues(ctx context.Context, req *v3.Fi
 
 	searchText := fmt.Sprintf("%%%s%%", req.SearchText)
 
+	// withValueFilters narrows down the values by the search text and the
+	// value prefix when present
+	withValueFilters := func(query string, args []interface{}, valueColumn string) (string, []interface{}) {
+		if req.FilterAttributeKeyDataType != v3.AttributeKeyDataTypeString {
+			valueColumn = fmt.Sprintf("toString(%s)", valueColumn)
+		}
+		if len(req.SearchText) != 0 {
+			args = append(args, searchText)
+			query = fmt.Sprintf("%s and %s ILIKE $%d", query, valueColumn, len(args))
+		}
+		if len(req.ValuePrefix) != 0 {
+			args = append(args, escapeLikePattern(req.ValuePrefix)+"%")
+			query = fmt.Sprintf("%s and %s LIKE $%d", query, valueColumn, len(args))
+		}
+		return query, args
+	}
+
+	var args []interface{}
 	// check if the tagKey is a topLevelColumn
 	if _, ok := constants.StaticFieldsLogsV3[req.FilterAttributeKey]; ok {
 		// query the column for the last 48 hours
-		filterValueColumnWhere := req.FilterAttributeKey
 		selectKey := req.FilterAttributeKey
 		if req.FilterAttributeKeyDataType != v3.AttributeKeyDataTypeString {
-			filterValueColumnWhere = fmt.Sprintf("toString(%s)", req.FilterAttributeKey)
 			selectKey = fmt.Sprintf("toInt64(%s)", req.FilterAttributeKey)
 		}
 
-		// prepare the query and run
-		if len(req.SearchText) != 0 {
-			query = fmt.Sprintf("select distinct %s from %s.%s where timestamp >= toInt64(toUnixTimestamp(now() - INTERVAL 48 HOUR)*1000000000) and %s ILIKE $1 limit $2", selectKey, r.logsDB, r.logsTable, filterValueColumnWhere)
-			rows, err = r.db.Query(ctx, query, searchText, req.Limit)
+		// unless a time range is given
+		query = fmt.Sprintf("select distinct %s from %s.%s where ", selectKey, r.logsDB, r.logsTable)
+		if req.Start > 0 || req.End > 0 {
+			var conditions []string
+			if req.Start > 0 {
+				args = append(args, req.Start)
+				conditions = append(conditions, fmt.Sprintf("timestamp >= $%d", len(args)))
+			}
+			if req.End > 0 {
+				args = append(args, req.End)
+				conditions = append(conditions, fmt.Sprintf("timestamp <= $%d", len(args)))
+			}
+			query += strings.Join(conditions, " and ")
 		} else {
-			query = fmt.Sprintf("select distinct %s from %s.%s where timestamp >= toInt64(toUnixTimestamp(now() - INTERVAL 48 HOUR)*1000000000) limit $1", selectKey, r.logsDB, r.logsTable)
-			rows, err = r.db.Query(ctx, query, req.Limit)
-		}
-	} else if len(req.SearchText) != 0 {
-		filterValueColumnWhere := filterValueColumn
-		if req.FilterAttributeKeyDataType != v3.AttributeKeyDataTypeString {
-			filterValueColumnWhere = fmt.Sprintf("toString(%s)", filterValueColumn)
+			query += "timestamp >= toInt64(toUnixTimestamp(now() - INTERVAL 48 HOUR)*1000000000)"
 		}
-		query = fmt.Sprintf("select distinct %s  from  %s.%s where tagKey=$1 and %s ILIKE $2  and tagType=$3 limit $4", filterValueColumn, r.logsDB, r.logsTagAttributeTable, filterValueColumnWhere)
-		rows, err = r.db.Query(ctx, query, req.FilterAttributeKey, searchText, req.TagType, req.Limit)
+		query, args = withValueFilters(query, args, req.FilterAttributeKey)
 	} else {
-		query = fmt.Sprintf("select distinct %s from  %s.%s where tagKey=$1 and tagType=$2 limit $3", filterValueColumn, r.logsDB, r.logsTagAttributeTable)
-		rows, err = r.db.Query(ctx, query, req.FilterAttributeKey, req.TagType, req.Limit)
+		args = append(args, req.FilterAttributeKey, req.TagType)
+		conditions := []string{"tagKey=$1", "tagType=$2"}
+		conditions, args = withTagAttributesTimeRange(conditions, args, req.Start, req.End)
+		query = fmt.Sprintf("select distinct %s from  %s.%s where %s", filterValueColumn, r.logsDB, r.logsTagAttributeTable, strings.Join(conditions, " and "))
+		query, args = withValueFilters(query, args, filterValueColumn)
 	}
+	args = append(args, req.Limit)
+	query = fmt.Sprintf("%s limit $%d", query, len(args))
+	rows, err = r.db.Query(ctx, query, args...)
 
 	if err != nil {
 		zap.L().Error("Error while executing query", zap.Error(err))
@@ -4405,8 +4460,9 @@ func (r *ClickHouseReader) GetQBFilterSuggestionsForLogs(
 	req *v3.QBFilterSuggestionsRequest,
 ) (*v3.QBFilterSuggestionsResponse, *model.ApiError) {
 	suggestions := v3.QBFilterSuggestionsResponse{
-		AttributeKeys:  []v3.AttributeKey{},
-		ExampleQueries: []v3.FilterSet{},
+		AttributeKeys:        []v3.AttributeKey{},
+		ExampleQueries:       []v3.FilterSet{},
+		RecommendedOperators: []v3.AttributeKeyOperators{},
 	}
 
 	// Use existing autocomplete logic for generating attribute suggestions
@@ -4415,6 +4471,8 @@ func (r *ClickHouseReader) GetQBFilterSuggestionsForLogs(
 			SearchText: req.SearchText,
 			DataSource: v3.DataSourceLogs,
 			Limit:      req.Limit,
+			Start:      req.Start,
+			End:        req.End,
 		})
 	if err != nil {
 		return nil, model.InternalError(fmt.Errorf("couldn't get attribute keys: %w", err))
@@ -4422,6 +4480,20 @@ func (r *ClickHouseReader) GetQBFilterSuggestionsForLogs(
 
 	suggestions.AttributeKeys = attribKeysResp.AttributeKeys
 
+	// Keys already in the existing filter are redundant suggestions
+	filteredKeys := map[v3.AttributeKey]bool{}
+	if req.ExistingFilter != nil {
+		for _, item := range req.ExistingFilter.Items {
+			filteredKeys[v3.AttributeKey{Key: item.Key.Key, Type: item.Key.Type}] = true
+		}
+	}
+	isFilteredKey := func(a v3.AttributeKey) bool {
+		return filteredKeys[v3.AttributeKey{Key: a.Key, Type: a.Type}]
+	}
+	if req.HideFilteredKeys {
+		suggestions.AttributeKeys = slices.DeleteFunc(suggestions.AttributeKeys, isFilteredKey)
+	}
+
 	// Rank suggested attributes
 	slices.SortFunc(suggestions.AttributeKeys, func(a v3.AttributeKey, b v3.AttributeKey) int {
 
@@ -4429,6 +4501,10 @@ func (r *ClickHouseReader) GetQBFilterSuggestionsForLogs(
 		attribKeyScore := func(a v3.AttributeKey) int {
 
 			// Scoring criteria is expected to get more sophisticated in follow up changes
+			if isFilteredKey(a) {
+				return -1
+			}
+
 			if a.Type == v3.AttributeKeyTypeResource {
 				return 2
 			}
@@ -4444,6 +4520,13 @@ func (r *ClickHouseReader) GetQBFilterSuggestionsForLogs(
 		return attribKeyScore(b) - attribKeyScore(a)
 	})
 
+	for _, key := range suggestions.AttributeKeys {
+		suggestions.RecommendedOperators = append(suggestions.RecommendedOperators, v3.AttributeKeyOperators{
+			Key:       key,
+			Operators: v3.RecommendedFilterOperators(key.DataType),
+		})
+	}
+
 	// Put together suggested example queries.
 
 	newExampleQuery := func() v3.FilterSet {
@@ -4471,7 +4554,10 @@ func (r *ClickHouseReader) GetQBFilterSuggestionsForLogs(
 			FilterAttributeKey:         topAttrib.Key,
 			FilterAttributeKeyDataType: topAttrib.DataType,
 			TagType:                    v3.TagType(topAttrib.Type),
+			ValuePrefix:                req.ValuePrefix,
 			Limit:                      1,
+			Start:                      req.Start,
+			End:                        req.End,
 		})
 
 		if err != nil {
@@ -4484,7 +4570,7 @@ func (r *ClickHouseReader) GetQBFilterSuggestionsForLogs(
 
 				exampleQuery.Items = append(exampleQuery.Items, v3.FilterItem{
 					Key:      topAttrib,
-					Operator: "=",
+					Operator: v3.ExampleFilterOperator(topAttrib.DataType),
 					Value:    value,
 				})
 
@@ -4522,6 +4608,43 @@ func (r *ClickHouseReader) GetQBFilterSuggestionsForLogs(
 	return &suggestions, nil
 }
 
+// GetAttributeColumnStatus reports, for each of the given log attribute keys,
+// whether it is a materialized column on the logs table or has to be
+// extracted from the attribute maps at query time.
+func (r *ClickHouseReader) GetAttributeColumnStatus(
+	ctx context.Context,
+	req *v3.AttributeColumnStatusRequest,
+) (*v3.AttributeColumnStatusResponse, *model.ApiError) {
+	response := v3.AttributeColumnStatusResponse{
+		AttributeKeys: []v3.AttributeKey{},
+	}
+	if len(req.AttributeKeys) == 0 {
+		return &response, nil
+	}
+
+	statements := []model.ShowCreateTableStatement{}
+	query := fmt.Sprintf("SHOW CREATE TABLE %s.%s", r.logsDB, r.logsLocalTable)
+	err := r.db.Select(ctx, &statements, query)
+	if err != nil {
+		return nil, model.InternalError(fmt.Errorf("error while fetching logs schema: %w", err))
+	}
+	if len(statements) == 0 {
+		return nil, model.InternalError(fmt.Errorf("no create table statement found for %s.%s", r.logsDB, r.logsLocalTable))
+	}
+
+	for _, key := range req.AttributeKeys {
+		// top level fields are always columns on the logs table
+		if _, ok := constants.StaticFieldsLogsV3[key.Key]; ok {
+			key.IsColumn = true
+		} else {
+			key.IsColumn = isColumn(statements[0].Statement, string(key.Type), key.Key, string(key.DataType))
+		}
+		response.AttributeKeys = append(response.AttributeKeys, key)
+	}
+
+	return &response, nil
+}
+
 func readRow(vars []interface{}, columnNames []string, countOfNumberCols int) ([]string, map[string]string, []map[string]string, *v3.Point) {
 	// Each row will have a value and a timestamp, and an optional list of label values
 	// example: {Timestamp: ..., Value: ...}
@@ -4596,7 +4719,7 @@ func readRow(vars []interface{}, columnNames []string, countOfNumberCols int) ([
 		case *uint, *uint8, *uint64, *uint16, *uint32:
 			if _, ok := constants.ReservedColumnTargetAliases[colName]; ok || countOfNumberCols == 1 {
 				isValidPoint = true
-				point.Value = float64(reflect.ValueOf(v).Elem().Uint())
+				setUintValue(&point, reflect.ValueOf(v).Elem().Uint())
 			} else {
 				groupBy = append(groupBy, fmt.Sprintf("%v", reflect.ValueOf(v).Elem().Uint()))
 				if _, ok := groupAttributes[colName]; !ok {
@@ -4610,7 +4733,7 @@ func readRow(vars []interface{}, columnNames []string, countOfNumberCols int) ([
 				value := reflect.ValueOf(v).Elem().Elem().Uint()
 				if _, ok := constants.ReservedColumnTargetAliases[colName]; ok || countOfNumberCols == 1 {
 					isValidPoint = true
-					point.Value = float64(value)
+					setUintValue(&point, value)
 				} else {
 					groupBy = append(groupBy, fmt.Sprintf("%v", value))
 					if _, ok := groupAttributes[colName]; !ok {
@@ -4622,7 +4745,7 @@ func readRow(vars []interface{}, columnNames []string, countOfNumberCols int) ([
 		case *int, *int8, *int16, *int32, *int64:
 			if _, ok := constants.ReservedColumnTargetAliases[colName]; ok || countOfNumberCols == 1 {
 				isValidPoint = true
-				point.Value = float64(reflect.ValueOf(v).Elem().Int())
+				point.SetIntValue(reflect.ValueOf(v).Elem().Int())
 			} else {
 				groupBy = append(groupBy, fmt.Sprintf("%v", reflect.ValueOf(v).Elem().Int()))
 				if _, ok := groupAttributes[colName]; !ok {
@@ -4636,7 +4759,7 @@ func readRow(vars []interface{}, columnNames []string, countOfNumberCols int) ([
 				value := reflect.ValueOf(v).Elem().Elem().Int()
 				if _, ok := constants.ReservedColumnTargetAliases[colName]; ok || countOfNumberCols == 1 {
 					isValidPoint = true
-					point.Value = float64(value)
+					point.SetIntValue(value)
 				} else {
 					groupBy = append(groupBy, fmt.Sprintf("%v", value))
 					if _, ok := groupAttributes[colName]; !ok {
@@ -4662,6 +4785,44 @@ func readRow(vars []interface{}, columnNames []string, countOfNumberCols int) ([
 	return groupBy, groupAttributes, groupAttributesArray, nil
 }
 
+// setUintValue sets the value of the point to the unsigned integer, keeping the
+// exact value of the integers a float64 can't represent that fit an int64
+func setUintValue(point *v3.Point, value uint64) {
+	if value > math.MaxInt64 {
+		point.Value = float64(value)
+		return
+	}
+	point.SetIntValue(int64(value))
+}
+
+// readCount returns the scanned value of the count column
+func readCount(v interface{}) int64 {
+	value := reflect.Indirect(reflect.ValueOf(v))
+	switch value.Kind() {
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int64(value.Uint())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return value.Int()
+	case reflect.Float32, reflect.Float64:
+		return int64(value.Float())
+	}
+	return 0
+}
+
+// readBandValue returns the scanned value of a band column
+func readBandValue(v interface{}) float64 {
+	value := reflect.Indirect(reflect.ValueOf(v))
+	switch value.Kind() {
+	case reflect.Float32, reflect.Float64:
+		return value.Float()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(value.Uint())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(value.Int())
+	}
+	return 0
+}
+
 func readRowsForTimeSeriesResult(rows driver.Rows, vars []interface{}, columnNames []string, countOfNumberCols int) ([]*v3.Series, error) {
 	// when groupBy is applied, each combination of cartesian product
 	// of attribute values is a separate series. Each item in seriesToPoints
@@ -4693,11 +4854,29 @@ func readRowsForTimeSeriesResult(rows driver.Rows, vars []interface{}, columnNam
 	// }
 	seriesToAttrs := make(map[string]map[string]string)
 	labelsArray := make(map[string][]map[string]string)
+
+	// the count of the rows aggregated in each point and the band around it are not
+	// labels nor the value, they are read separately and kept aligned with the points
+	countIdx := slices.Index(columnNames, constants.ResultCountColumn)
+	bandMinIdx := slices.Index(columnNames, constants.ResultBandMinColumn)
+	bandMaxIdx := slices.Index(columnNames, constants.ResultBandMaxColumn)
+	var rowVars []interface{}
+	var rowColumnNames []string
+	for idx, columnName := range columnNames {
+		if !slices.Contains(constants.ResultCompanionColumns, columnName) {
+			rowVars = append(rowVars, vars[idx])
+			rowColumnNames = append(rowColumnNames, columnName)
+		}
+	}
+	seriesToCounts := make(map[string][]int64)
+	seriesToBandMin := make(map[string][]float64)
+	seriesToBandMax := make(map[string][]float64)
+
 	for rows.Next() {
 		if err := rows.Scan(vars...); err != nil {
 			return nil, err
 		}
-		groupBy, groupAttributes, groupAttributesArray, metricPoint := readRow(vars, columnNames, countOfNumberCols)
+		groupBy, groupAttributes, groupAttributesArray, metricPoint := readRow(rowVars, rowColumnNames, countOfNumberCols)
 		// skip the point if the value is NaN or Inf
 		// are they ever useful enough to be returned?
 		if metricPoint != nil && (math.IsNaN(metricPoint.Value) || math.IsInf(metricPoint.Value, 0)) {
@@ -4712,13 +4891,27 @@ func readRowsForTimeSeriesResult(rows driver.Rows, vars []interface{}, columnNam
 		labelsArray[key] = groupAttributesArray
 		if metricPoint != nil {
 			seriesToPoints[key] = append(seriesToPoints[key], *metricPoint)
+			if countIdx != -1 {
+				seriesToCounts[key] = append(seriesToCounts[key], readCount(vars[countIdx]))
+			}
+			if bandMinIdx != -1 && bandMaxIdx != -1 {
+				seriesToBandMin[key] = append(seriesToBandMin[key], readBandValue(vars[bandMinIdx]))
+				seriesToBandMax[key] = append(seriesToBandMax[key], readBandValue(vars[bandMaxIdx]))
+			}
 		}
 	}
 
 	var seriesList []*v3.Series
 	for _, key := range keys {
 		points := seriesToPoints[key]
-		series := v3.Series{Labels: seriesToAttrs[key], Points: points, LabelsArray: labelsArray[key]}
+		series := v3.Series{
+			Labels:      seriesToAttrs[key],
+			Points:      points,
+			LabelsArray: labelsArray[key],
+			Counts:      seriesToCounts[key],
+			BandMin:     seriesToBandMin[key],
+			BandMax:     seriesToBandMax[key],
+		}
 		seriesList = append(seriesList, &series)
 	}
 	return seriesList, getPersonalisedError(rows.Err())
@@ -4746,31 +4939,7 @@ func (r *ClickHouseReader) GetTimeSeriesResultV3(ctx context.Context, query stri
 
 	defer utils.Elapsed("GetTimeSeriesResultV3", ctxArgs)()
 
-	// Hook up query progress reporting if requested.
-	queryId := ctx.Value("queryId")
-	if queryId != nil {
-		qid, ok := queryId.(string)
-		if !ok {
-			zap.L().Error("GetTimeSeriesResultV3: queryId in ctx not a string as expected", zap.Any("queryId", queryId))
-
-		} else {
-			ctx = clickhouse.Context(ctx, clickhouse.WithProgress(
-				func(p *clickhouse.Progress) {
-					go func() {
-						err := r.queryProgressTracker.ReportQueryProgress(qid, p)
-						if err != nil {
-							zap.L().Error(
-								"Couldn't report query progress",
-								zap.String("queryId", qid), zap.Error(err),
-							)
-						}
-					}()
-				},
-			))
-		}
-	}
-
-	rows, err := r.db.Query(ctx, query)
+	rows, err := r.db.Query(r.withProgress(ctx), query)
 
 	if err != nil {
 		zap.L().Error("error while reading time series result", zap.Error(err))
@@ -4787,6 +4956,9 @@ func (r *ClickHouseReader) GetTimeSeriesResultV3(ctx context.Context, query stri
 
 	for i := range columnTypes {
 		vars[i] = reflect.New(columnTypes[i].ScanType()).Interface()
+		if slices.Contains(constants.ResultCompanionColumns, columnNames[i]) {
+			continue
+		}
 		switch columnTypes[i].ScanType().Kind() {
 		case reflect.Float32,
 			reflect.Float64,
@@ -4807,6 +4979,156 @@ func (r *ClickHouseReader) GetTimeSeriesResultV3(ctx context.Context, query stri
 	return readRowsForTimeSeriesResult(rows, vars, columnNames, countOfNumberCols)
 }
 
+// withProgress hooks up the progress reporting of the query, to the query progress
+// tracker if a queryId is requested and to the scan meter of the context, if any.
+// The query can only have a single progress callback
+func (r *ClickHouseReader) withProgress(ctx context.Context) context.Context {
+	var qid string
+	if queryId := ctx.Value("queryId"); queryId != nil {
+		var ok bool
+		if qid, ok = queryId.(string); !ok {
+			zap.L().Error("withProgress: queryId in ctx not a string as expected", zap.Any("queryId", queryId))
+		}
+	}
+	meter, _ := ctx.Value(common.ClickHouseScanMeterKey).(common.ScanMeter)
+	if qid == "" && meter == nil {
+		return ctx
+	}
+
+	return clickhouse.Context(ctx, clickhouse.WithProgress(
+		func(p *clickhouse.Progress) {
+			if meter != nil {
+				meter.AddScanned(p.Rows, p.Bytes)
+			}
+			if qid == "" {
+				return
+			}
+			go func() {
+				err := r.queryProgressTracker.ReportQueryProgress(qid, p)
+				if err != nil {
+					zap.L().Error(
+						"Couldn't report query progress",
+						zap.String("queryId", qid), zap.Error(err),
+					)
+				}
+			}()
+		},
+	))
+}
+
+// EstimateQueryCost returns the rows, parts and marks ClickHouse estimates the
+// query reads, summed over the tables read
+func (r *ClickHouseReader) EstimateQueryCost(ctx context.Context, query string) (*v3.QueryCostEstimate, error) {
+	var rows []model.ExplainEstimateRow
+	if err := r.db.Select(ctx, &rows, "EXPLAIN ESTIMATE "+query); err != nil {
+		zap.L().Error("error while estimating query cost", zap.Error(err))
+		return nil, err
+	}
+
+	estimate := &v3.QueryCostEstimate{}
+	for _, row := range rows {
+		estimate.Rows += row.Rows
+		estimate.Parts += row.Parts
+		estimate.Marks += row.Marks
+	}
+	return estimate, nil
+}
+
+// ValidateQuery checks the syntax of the query with EXPLAIN SYNTAX, which parses
+// the query without reading any data
+func (r *ClickHouseReader) ValidateQuery(ctx context.Context, query string) error {
+	var rows []struct {
+		Explain string `ch:"explain"`
+	}
+	return r.db.Select(ctx, &rows, "EXPLAIN SYNTAX "+query)
+}
+
+// CancelQuery kills the running query with the query_id, asynchronously
+func (r *ClickHouseReader) CancelQuery(ctx context.Context, queryID string) error {
+	return r.db.Exec(ctx, "KILL QUERY WHERE query_id = ? ASYNC", queryID)
+}
+
+// GetExemplarsV3 runs the exemplars query and returns the exemplar trace id of each
+// labelled interval
+func (r *ClickHouseReader) GetExemplarsV3(ctx context.Context, query string) ([]v3.Exemplar, error) {
+
+	ctxArgs := map[string]interface{}{"query": query}
+	for k, v := range logCommentKVs(ctx) {
+		ctxArgs[k] = v
+	}
+
+	defer utils.Elapsed("GetExemplarsV3", ctxArgs)()
+
+	rows, err := r.db.Query(ctx, query)
+	if err != nil {
+		zap.L().Error("error while reading exemplars", zap.Error(err))
+		return nil, err
+	}
+	defer rows.Close()
+
+	var (
+		columnTypes = rows.ColumnTypes()
+		columnNames = rows.Columns()
+		vars        = make([]interface{}, len(columnTypes))
+	)
+	for i := range columnTypes {
+		vars[i] = reflect.New(columnTypes[i].ScanType()).Interface()
+	}
+
+	return readRowsForExemplars(rows, vars, columnNames)
+}
+
+// GetAnnotations returns the annotation events between start and end, in
+// milliseconds, sorted by timestamp
+func (r *ClickHouseReader) GetAnnotations(ctx context.Context, start, end int64) ([]v3.Annotation, error) {
+	annotations := []v3.Annotation{}
+	query := "SELECT id, timestamp, title, text FROM annotations WHERE timestamp >= ? AND timestamp <= ? ORDER BY timestamp, id"
+	if err := r.localDB.SelectContext(ctx, &annotations, query, start, end); err != nil {
+		zap.L().Error("error while reading annotations", zap.Error(err))
+		return nil, err
+	}
+	return annotations, nil
+}
+
+// CreateAnnotation stores the annotation event and sets its id
+func (r *ClickHouseReader) CreateAnnotation(ctx context.Context, annotation *v3.Annotation) error {
+	res, err := r.localDB.ExecContext(ctx, "INSERT INTO annotations (timestamp, title, text, created_at) VALUES (?, ?, ?, ?)",
+		annotation.Timestamp, annotation.Title, annotation.Text, time.Now())
+	if err != nil {
+		zap.L().Error("error while creating annotation", zap.Error(err))
+		return err
+	}
+	annotation.ID, err = res.LastInsertId()
+	return err
+}
+
+// readRowsForExemplars reads the rows of the exemplars query, the ts and trace_id
+// columns are the interval and the trace id, all the other columns are the labels
+func readRowsForExemplars(rows driver.Rows, vars []interface{}, columnNames []string) ([]v3.Exemplar, error) {
+	var exemplars []v3.Exemplar
+	for rows.Next() {
+		if err := rows.Scan(vars...); err != nil {
+			return nil, err
+		}
+		exemplar := v3.Exemplar{Labels: make(map[string]string)}
+		for idx, v := range vars {
+			value := reflect.Indirect(reflect.ValueOf(v)).Interface()
+			switch columnNames[idx] {
+			case "ts":
+				if ts, ok := value.(time.Time); ok {
+					exemplar.Timestamp = ts.UnixMilli()
+				}
+			case "trace_id":
+				exemplar.TraceID = fmt.Sprint(value)
+			default:
+				exemplar.Labels[columnNames[idx]] = fmt.Sprint(value)
+			}
+		}
+		exemplars = append(exemplars, exemplar)
+	}
+	return exemplars, getPersonalisedError(rows.Err())
+}
+
 // GetListResultV3 runs the query and returns list of rows
 func (r *ClickHouseReader) GetListResultV3(ctx context.Context, query string) ([]*v3.Row, error) {
 
@@ -4817,7 +5139,7 @@ func (r *ClickHouseReader) GetListResultV3(ctx context.Context, query string) ([
 
 	defer utils.Elapsed("GetListResultV3", ctxArgs)()
 
-	rows, err := r.db.Query(ctx, query)
+	rows, err := r.db.Query(r.withProgress(ctx), query)
 
 	if err != nil {
 		zap.L().Error("error while reading time series result", zap.Error(err))
@@ -1,9 +1,17 @@
 package clickhouseReader
 
 import (
+	"context"
+	"math"
+	"reflect"
 	"testing"
+	"time"
 
+	"github.com/ClickHouse/clickhouse-go/v2/lib/driver"
+	_ "github.com/mattn/go-sqlite3"
 	"github.com/stretchr/testify/assert"
+	v3 "go.signoz.io/signoz/pkg/query-service/model/v3"
+	"go.signoz.io/signoz/pkg/query-service/utils"
 )
 
 type GetStatusFiltersTest struct {
@@ -27,3 +35,146 @@ func TestGetStatusFilters(t *testing.T) {
 		assert.Equal(getStatusFilters(test.query, test.statusParams, test.excludeMap), test.expected)
 	}
 }
+
+// fakeRows is a driver.Rows over in-memory rows, Scan assigns each value of
+// the current row to the matching destination pointer
+type fakeRows struct {
+	driver.Rows
+	rows [][]interface{}
+	idx  int
+}
+
+func (r *fakeRows) Next() bool {
+	r.idx++
+	return r.idx <= len(r.rows)
+}
+
+func (r *fakeRows) Scan(dest ...interface{}) error {
+	for i, v := range r.rows[r.idx-1] {
+		reflect.ValueOf(dest[i]).Elem().Set(reflect.ValueOf(v))
+	}
+	return nil
+}
+
+func (r *fakeRows) Err() error {
+	return nil
+}
+
+func TestReadRowsForTimeSeriesResultCounts(t *testing.T) {
+	ts := time.UnixMilli(1680066360000).UTC()
+	rows := &fakeRows{rows: [][]interface{}{
+		{ts, "GET", uint64(3), float64(10)},
+		{ts.Add(time.Minute), "GET", uint64(5), float64(20)},
+		{ts, "POST", uint64(1), float64(30)},
+	}}
+	vars := []interface{}{new(time.Time), new(string), new(uint64), new(float64)}
+	columnNames := []string{"ts", "method", "__count", "value"}
+
+	seriesList, err := readRowsForTimeSeriesResult(rows, vars, columnNames, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, []*v3.Series{
+		{
+			Labels:      map[string]string{"method": "GET"},
+			LabelsArray: []map[string]string{{"method": "GET"}},
+			Points:      []v3.Point{{Timestamp: 1680066360000, Value: 10}, {Timestamp: 1680066420000, Value: 20}},
+			Counts:      []int64{3, 5},
+		},
+		{
+			Labels:      map[string]string{"method": "POST"},
+			LabelsArray: []map[string]string{{"method": "POST"}},
+			Points:      []v3.Point{{Timestamp: 1680066360000, Value: 30}},
+			Counts:      []int64{1},
+		},
+	}, seriesList)
+}
+
+func TestReadRowsForTimeSeriesResultMetricNames(t *testing.T) {
+	ts := time.UnixMilli(1680066360000).UTC()
+	// the rows of a query with additional metrics, labelled with the metric names
+	rows := &fakeRows{rows: [][]interface{}{
+		{"system_cpu_usage", "host-1", ts, float64(0.5)},
+		{"system_cpu_usage", "host-1", ts.Add(time.Minute), float64(0.7)},
+		{"system_memory_usage", "host-1", ts, float64(1024)},
+	}}
+	vars := []interface{}{new(string), new(string), new(time.Time), new(float64)}
+	columnNames := []string{"__name__", "host_name", "ts", "value"}
+
+	seriesList, err := readRowsForTimeSeriesResult(rows, vars, columnNames, 1)
+	assert.NoError(t, err)
+	assert.Len(t, seriesList, 2)
+	pointsByName := make(map[string][]v3.Point)
+	for _, series := range seriesList {
+		assert.Equal(t, "host-1", series.Labels["host_name"])
+		pointsByName[series.Labels["__name__"]] = series.Points
+	}
+	assert.Equal(t, map[string][]v3.Point{
+		"system_cpu_usage":    {{Timestamp: 1680066360000, Value: 0.5}, {Timestamp: 1680066420000, Value: 0.7}},
+		"system_memory_usage": {{Timestamp: 1680066360000, Value: 1024}},
+	}, pointsByName)
+}
+
+func TestReadRowsForTimeSeriesResultBand(t *testing.T) {
+	ts := time.UnixMilli(1680066360000).UTC()
+	rows := &fakeRows{rows: [][]interface{}{
+		{ts, "GET", float64(2), float64(30), float64(10)},
+		// the NaN point is skipped together with its band
+		{ts.Add(time.Minute), "GET", float64(1), float64(1), math.NaN()},
+		{ts.Add(2 * time.Minute), "GET", float64(5), float64(15), float64(8)},
+	}}
+	vars := []interface{}{new(time.Time), new(string), new(float64), new(float64), new(float64)}
+	columnNames := []string{"ts", "method", "__band_min", "__band_max", "value"}
+
+	seriesList, err := readRowsForTimeSeriesResult(rows, vars, columnNames, 1)
+	assert.NoError(t, err)
+	assert.Len(t, seriesList, 1)
+	series := seriesList[0]
+	assert.Equal(t, map[string]string{"method": "GET"}, series.Labels)
+	assert.Equal(t, []v3.Point{{Timestamp: 1680066360000, Value: 10}, {Timestamp: 1680066480000, Value: 8}}, series.Points)
+	assert.Equal(t, []float64{2, 5}, series.BandMin)
+	assert.Equal(t, []float64{30, 15}, series.BandMax)
+}
+
+func TestReadRowsForExemplars(t *testing.T) {
+	ts := time.UnixMilli(1680066360000).UTC()
+	rows := &fakeRows{rows: [][]interface{}{
+		{"frontend", ts, "4bf92f3577b34da6a3ce929d0e0e4736"},
+		{"frontend", ts.Add(time.Minute), "00f067aa0ba902b7a3ce929d0e0e4736"},
+	}}
+	vars := []interface{}{new(string), new(time.Time), new(string)}
+	columnNames := []string{"service_name", "ts", "trace_id"}
+
+	exemplars, err := readRowsForExemplars(rows, vars, columnNames)
+	assert.NoError(t, err)
+	assert.Equal(t, []v3.Exemplar{
+		{Labels: map[string]string{"service_name": "frontend"}, Timestamp: 1680066360000, TraceID: "4bf92f3577b34da6a3ce929d0e0e4736"},
+		{Labels: map[string]string{"service_name": "frontend"}, Timestamp: 1680066420000, TraceID: "00f067aa0ba902b7a3ce929d0e0e4736"},
+	}, exemplars)
+}
+
+func TestCreateAnnotation(t *testing.T) {
+	r := &ClickHouseReader{localDB: utils.NewQueryServiceDBForTests(t)}
+	ctx := context.Background()
+
+	annotations := []v3.Annotation{
+		{Timestamp: 3000, Title: "deploy v1.1.0", Text: "rollback of the cart service"},
+		{Timestamp: 1000, Title: "deploy v1.0.0"},
+		{Timestamp: 5000, Title: "deploy v1.2.0"},
+	}
+	for i := range annotations {
+		if err := r.CreateAnnotation(ctx, &annotations[i]); err != nil {
+			t.Fatalf("expected no error, got %s", err)
+		}
+		if annotations[i].ID == 0 {
+			t.Errorf("expected the id of the created annotation to be set")
+		}
+	}
+
+	got, err := r.GetAnnotations(ctx, 1000, 3000)
+	if err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+	expected := []v3.Annotation{annotations[1], annotations[0]}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected the created annotations within the range %v, got %v", expected, got)
+	}
+}
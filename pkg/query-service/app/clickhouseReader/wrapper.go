@@ -60,12 +60,21 @@ func (c clickhouseConnWrapper) addClickHouseSettings(ctx context.Context, query
 		settings["timeout_before_checking_execution_speed"] = c.settings.TimeoutBeforeCheckingExecutionSpeed
 	}
 
+	if priority, ok := ctx.Value(common.ClickHousePriorityKey).(int); ok && priority > 0 {
+		settings["priority"] = priority
+	}
+
 	// only list queries of
 	if c.settings.OptimizeReadInOrderRegex != "" && c.settings.OptimizeReadInOrderRegexCompiled.Match([]byte(query)) {
 		settings["optimize_read_in_order"] = 0
 	}
 
-	ctx = clickhouse.Context(ctx, clickhouse.WithSettings(settings))
+	options := []clickhouse.QueryOption{clickhouse.WithSettings(settings)}
+	if queryID, ok := ctx.Value(common.ClickHouseQueryIDKey).(string); ok && queryID != "" {
+		options = append(options, clickhouse.WithQueryID(queryID))
+	}
+
+	ctx = clickhouse.Context(ctx, options...)
 	return ctx
 }
 
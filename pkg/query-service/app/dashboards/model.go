@@ -115,6 +115,19 @@ func InitDB(dataSourceName string) (*sqlx.DB, error) {
 		return nil, fmt.Errorf("error in creating ttl_status table: %s", err.Error())
 	}
 
+	tableSchema = `CREATE TABLE IF NOT EXISTS annotations (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		timestamp INTEGER NOT NULL,
+		title TEXT NOT NULL,
+		text TEXT NOT NULL DEFAULT '',
+		created_at datetime NOT NULL
+	);
+	CREATE INDEX IF NOT EXISTS idx_annotations_timestamp ON annotations (timestamp);`
+	_, err = db.Exec(tableSchema)
+	if err != nil {
+		return nil, fmt.Errorf("error in creating annotations table: %s", err.Error())
+	}
+
 	// sqlite does not support "IF NOT EXISTS"
 	createdAt := `ALTER TABLE rules ADD COLUMN created_at datetime;`
 	_, err = db.Exec(createdAt)
@@ -24,6 +24,7 @@ import (
 	"github.com/prometheus/prometheus/promql"
 
 	"go.signoz.io/signoz/pkg/query-service/agentConf"
+	"go.signoz.io/signoz/pkg/query-service/app/arrowformat"
 	"go.signoz.io/signoz/pkg/query-service/app/dashboards"
 	"go.signoz.io/signoz/pkg/query-service/app/explorer"
 	"go.signoz.io/signoz/pkg/query-service/app/integrations"
@@ -318,8 +319,11 @@ func (aH *APIHandler) RegisterQueryRangeV3Routes(router *mux.Router, am *AuthMid
 		withCacheControl(AutoCompleteCacheControlAge, aH.autoCompleteAttributeValues))).Methods(http.MethodGet)
 	subRouter.HandleFunc("/query_range", am.ViewAccess(aH.QueryRangeV3)).Methods(http.MethodPost)
 	subRouter.HandleFunc("/query_range/format", am.ViewAccess(aH.QueryRangeV3Format)).Methods(http.MethodPost)
+	subRouter.HandleFunc("/clickhouse/validate", am.ViewAccess(aH.validateClickHouseQueries)).Methods(http.MethodPost)
+	subRouter.HandleFunc("/annotations", am.EditAccess(aH.createAnnotation)).Methods(http.MethodPost)
 
 	subRouter.HandleFunc("/filter_suggestions", am.ViewAccess(aH.getQueryBuilderSuggestions)).Methods(http.MethodGet)
+	subRouter.HandleFunc("/attribute_column_status", am.ViewAccess(aH.getAttributeColumnStatus)).Methods(http.MethodGet)
 
 	// TODO(Raj): Remove this handler after /ws based path has been completely rolled out.
 	subRouter.HandleFunc("/query_progress", am.ViewAccess(aH.GetQueryProgressUpdates)).Methods(http.MethodGet)
@@ -3399,6 +3403,24 @@ func (aH *APIHandler) getSavedViews(w http.ResponseWriter, r *http.Request) {
 	aH.Respond(w, queries)
 }
 
+func (aH *APIHandler) createAnnotation(w http.ResponseWriter, r *http.Request) {
+	var annotation v3.Annotation
+	if err := json.NewDecoder(r.Body).Decode(&annotation); err != nil {
+		RespondError(w, &model.ApiError{Typ: model.ErrorBadData, Err: err}, nil)
+		return
+	}
+	if err := annotation.Validate(); err != nil {
+		RespondError(w, &model.ApiError{Typ: model.ErrorBadData, Err: err}, nil)
+		return
+	}
+	if err := aH.reader.CreateAnnotation(r.Context(), &annotation); err != nil {
+		RespondError(w, &model.ApiError{Typ: model.ErrorInternal, Err: err}, nil)
+		return
+	}
+
+	aH.Respond(w, annotation)
+}
+
 func (aH *APIHandler) createSavedViews(w http.ResponseWriter, r *http.Request) {
 	var view v3.SavedView
 	err := json.NewDecoder(r.Body).Decode(&view)
@@ -3519,6 +3541,29 @@ func (aH *APIHandler) getQueryBuilderSuggestions(w http.ResponseWriter, r *http.
 	aH.Respond(w, response)
 }
 
+func (aH *APIHandler) getAttributeColumnStatus(w http.ResponseWriter, r *http.Request) {
+	req, err := parseAttributeColumnStatusRequest(r)
+	if err != nil {
+		RespondError(w, err, nil)
+		return
+	}
+
+	if req.DataSource != v3.DataSourceLogs {
+		RespondError(w, model.BadRequest(
+			fmt.Errorf("attribute column status not supported for %s", req.DataSource),
+		), nil)
+		return
+	}
+
+	response, err := aH.reader.GetAttributeColumnStatus(r.Context(), req)
+	if err != nil {
+		RespondError(w, err, nil)
+		return
+	}
+
+	aH.Respond(w, response)
+}
+
 func (aH *APIHandler) autoCompleteAttributeKeys(w http.ResponseWriter, r *http.Request) {
 	var response *v3.FilterAttributeKeyResponse
 	req, err := parseFilterAttributeKeyRequest(r)
@@ -3658,6 +3703,32 @@ func (aH *APIHandler) QueryRangeV3Format(w http.ResponseWriter, r *http.Request)
 	aH.Respond(w, queryRangeParams)
 }
 
+// validateClickHouseQueries reports the syntax errors of the raw clickhouse queries
+// of the query range params, without running the queries
+func (aH *APIHandler) validateClickHouseQueries(w http.ResponseWriter, r *http.Request) {
+	queryRangeParams, apiErrorObj := ParseQueryRangeParams(r)
+	if apiErrorObj != nil {
+		zap.L().Error("error parsing clickhouse query params", zap.Error(apiErrorObj.Err))
+		RespondError(w, apiErrorObj, nil)
+		return
+	}
+
+	errQueriesByName, err := aH.querier.ValidateClickHouseQueries(r.Context(), queryRangeParams)
+	if err != nil {
+		RespondError(w, &model.ApiError{Typ: model.ErrorBadData, Err: err}, nil)
+		return
+	}
+
+	validation := v3.ClickHouseQueryValidation{Valid: len(errQueriesByName) == 0}
+	if len(errQueriesByName) > 0 {
+		validation.Errors = make(map[string]string, len(errQueriesByName))
+		for queryName, err := range errQueriesByName {
+			validation.Errors[queryName] = err.Error()
+		}
+	}
+	aH.Respond(w, validation)
+}
+
 func (aH *APIHandler) queryRangeV3(ctx context.Context, queryRangeParams *v3.QueryRangeParamsV3, w http.ResponseWriter, r *http.Request) {
 
 	var result []*v3.Result
@@ -3764,9 +3835,27 @@ func (aH *APIHandler) queryRangeV3(ctx context.Context, queryRangeParams *v3.Que
 		break
 	}
 
+	if respondArrow(w, r, result) {
+		return
+	}
+
 	aH.Respond(w, resp)
 }
 
+// respondArrow writes the results as an Arrow IPC stream if the client asked for it,
+// it returns false if the results are to be written as JSON instead
+func respondArrow(w http.ResponseWriter, r *http.Request, result []*v3.Result) bool {
+	if !arrowformat.Accepts(r) || !arrowformat.Supported(result) {
+		return false
+	}
+	w.Header().Set("Content-Type", arrowformat.ContentType)
+	w.WriteHeader(http.StatusOK)
+	if err := arrowformat.EncodeResults(w, result); err != nil {
+		zap.L().Error("error encoding the results as arrow", zap.Error(err))
+	}
+	return true
+}
+
 func sendQueryResultEvents(r *http.Request, result []*v3.Result, queryRangeParams *v3.QueryRangeParamsV3) {
 	referrer := r.Header.Get("Referer")
 
@@ -4101,6 +4190,10 @@ func (aH *APIHandler) queryRangeV4(ctx context.Context, queryRangeParams *v3.Que
 		Result: result,
 	}
 
+	if respondArrow(w, r, result) {
+		return
+	}
+
 	aH.Respond(w, resp)
 }
 
@@ -108,6 +108,32 @@ func getJSONFilterKey(key v3.AttributeKey, op v3.FilterOperator, isArray bool) (
 	return keyname, nil
 }
 
+// getJSONArrayAggregationKey returns the expression of the array JSON key expanded
+// for the aggregate operator, each element with arrayJoin or the sum of the elements
+// of each log line with arraySum
+func getJSONArrayAggregationKey(key v3.AttributeKey, arrayAggregation v3.ArrayAggregation) (string, error) {
+	if !key.DataType.IsArray() {
+		return "", fmt.Errorf("array aggregation requires an array key, got %s of type %q", key.Key, key.DataType)
+	}
+	arrayKey, err := getJSONFilterKey(key, v3.FilterOperatorHas, true)
+	if err != nil {
+		return "", err
+	}
+	switch arrayAggregation {
+	case v3.ArrayAggregationJoin:
+		return fmt.Sprintf("arrayJoin(%s)", arrayKey), nil
+	case v3.ArrayAggregationSum:
+		return fmt.Sprintf("arraySum(%s)", arrayKey), nil
+	default:
+		return "", fmt.Errorf("unsupported array aggregation: %s", arrayAggregation)
+	}
+}
+
+// getJSONExistsFilter returns the filter of the log lines with the JSON key
+func getJSONExistsFilter(key v3.AttributeKey) string {
+	return fmt.Sprintf(jsonLogOperators[v3.FilterOperatorExists], BODY, getPath(strings.Split(key.Key, ".")[1:]))
+}
+
 // takes the path and the values and generates where clauses for better usage of index
 func getPathIndexFilter(path string) string {
 	filters := []string{}
@@ -2,6 +2,7 @@ package v3
 
 import (
 	"fmt"
+	"slices"
 	"strings"
 
 	"go.signoz.io/signoz/pkg/query-service/constants"
@@ -100,6 +101,73 @@ func getClickhouseColumnName(key v3.AttributeKey) string {
 	return clickhouseColumn
 }
 
+// selectCount returns the select of the count of the rows aggregated in each
+// point, for the queries with IncludeCount
+func selectCount(mq *v3.BuilderQuery) string {
+	if !mq.IncludeCount {
+		return ""
+	}
+	return " toUInt64(count(*)) as " + constants.ResultCountColumn + ","
+}
+
+// selectBand returns the select of the min and max of the aggregate attribute
+// in each point, for the queries with IncludeBand
+func selectBand(mq *v3.BuilderQuery) string {
+	if !mq.IncludeBand {
+		return ""
+	}
+	aggregationKey := getClickhouseColumnName(mq.AggregateAttribute)
+	return fmt.Sprintf(" toFloat64(min(%s)) as %s, toFloat64(max(%s)) as %s,", aggregationKey, constants.ResultBandMinColumn, aggregationKey, constants.ResultBandMaxColumn)
+}
+
+// selectHeatmapBucket returns the select of the index of the value bucket of the
+// aggregate attribute, for the heatmap queries
+func selectHeatmapBucket(mq *v3.BuilderQuery) string {
+	if mq.Heatmap == nil {
+		return ""
+	}
+	bucket := utils.HeatmapBucketExpr(getClickhouseColumnName(mq.AggregateAttribute), mq.Heatmap.Min, mq.Heatmap.Max, mq.Heatmap.Count)
+	return fmt.Sprintf(" toString(%s) as %s,", bucket, constants.ResultHeatmapBucketColumn)
+}
+
+// logsListColumnExprs are the select expressions of the logs list columns which
+// aren't selected as is
+var logsListColumnExprs = map[string]string{
+	"attributes_string":  "CAST((attributes_string_key, attributes_string_value), 'Map(String, String)') as attributes_string",
+	"attributes_int64":   "CAST((attributes_int64_key, attributes_int64_value), 'Map(String, Int64)') as attributes_int64",
+	"attributes_float64": "CAST((attributes_float64_key, attributes_float64_value), 'Map(String, Float64)') as attributes_float64",
+	"attributes_bool":    "CAST((attributes_bool_key, attributes_bool_value), 'Map(String, Bool)') as attributes_bool",
+	"resources_string":   "CAST((resources_string_key, resources_string_value), 'Map(String, String)') as resources_string",
+}
+
+// logsListSelect returns the select of a logs list query, projected to the list
+// columns of the query if any. The timestamp and the id are always selected
+func logsListSelect(mq *v3.BuilderQuery) string {
+	if len(mq.ListColumns) == 0 {
+		return constants.LogsSQLSelect
+	}
+	columns := []string{"timestamp", "id"}
+	for _, column := range mq.ListColumns {
+		if !slices.Contains(columns, column) {
+			columns = append(columns, column)
+		}
+	}
+	for idx, column := range columns {
+		if expr, ok := logsListColumnExprs[column]; ok {
+			columns[idx] = expr
+		}
+	}
+	return "SELECT " + strings.Join(columns, ", ") + " "
+}
+
+// heatmapGroupBy returns the group by of the query, with the value bucket for the heatmap queries
+func heatmapGroupBy(mq *v3.BuilderQuery) []v3.AttributeKey {
+	if mq.Heatmap == nil {
+		return mq.GroupBy
+	}
+	return append(slices.Clone(mq.GroupBy), v3.AttributeKey{Key: constants.ResultHeatmapBucketColumn})
+}
+
 // getSelectLabels returns the select labels for the query based on groupBy and aggregateOperator
 func getSelectLabels(aggregatorOperator v3.AggregateOperator, groupBy []v3.AttributeKey) string {
 	var selectLabels string
@@ -234,7 +302,9 @@ func buildLogsTimeSeriesFilterQuery(fs *v3.FilterSet, groupBy []v3.AttributeKey,
 	}
 
 	// add conditions for aggregate attribute
-	if aggregateAttribute.Key != "" {
+	if aggregateAttribute.Key != "" && aggregateAttribute.IsJSON && aggregateAttribute.DataType.IsArray() {
+		conditions = append(conditions, getJSONExistsFilter(aggregateAttribute))
+	} else if aggregateAttribute.Key != "" {
 		existsFilter := GetExistsNexistsFilter(v3.FilterOperatorExists, v3.FilterItem{Key: aggregateAttribute})
 		conditions = append(conditions, existsFilter)
 	}
@@ -274,11 +344,11 @@ func buildLogsQuery(panelType v3.PanelType, start, end, step int64, mq *v3.Build
 	} else if panelType == v3.PanelTypeGraph || panelType == v3.PanelTypeValue {
 		// Select the aggregate value for interval
 		queryTmpl =
-			fmt.Sprintf("SELECT toStartOfInterval(fromUnixTimestamp64Nano(timestamp), INTERVAL %d SECOND) AS ts,", step)
+			fmt.Sprintf("SELECT %s AS ts,", utils.StartOfIntervalExpr("fromUnixTimestamp64Nano(timestamp)", step, mq.AlignmentOffset))
 	}
 
 	queryTmpl =
-		queryTmpl + selectLabels +
+		queryTmpl + selectLabels + selectCount(mq) + selectBand(mq) + selectHeatmapBucket(mq) +
 			" %s as value " +
 			"from signoz_logs.distributed_logs " +
 			"where " + timeFilter + "%s" +
@@ -291,7 +361,7 @@ func buildLogsQuery(panelType v3.PanelType, start, end, step int64, mq *v3.Build
 		queryTmpl = "SELECT " + getSelectKeys(mq.AggregateOperator, mq.GroupBy) + " from (" + queryTmpl + ")"
 	}
 
-	groupBy := groupByAttributeKeyTags(panelType, graphLimitQtype, mq.GroupBy...)
+	groupBy := groupByAttributeKeyTags(panelType, graphLimitQtype, heatmapGroupBy(mq)...)
 	if panelType != v3.PanelTypeList && groupBy != "" {
 		groupBy = " group by " + groupBy
 	}
@@ -305,7 +375,12 @@ func buildLogsQuery(panelType v3.PanelType, start, end, step int64, mq *v3.Build
 	}
 
 	aggregationKey := ""
-	if mq.AggregateAttribute.Key != "" {
+	if mq.ArrayAggregation != "" {
+		aggregationKey, err = getJSONArrayAggregationKey(mq.AggregateAttribute, mq.ArrayAggregation)
+		if err != nil {
+			return "", err
+		}
+	} else if mq.AggregateAttribute.Key != "" {
 		aggregationKey = getClickhouseColumnName(mq.AggregateAttribute)
 	}
 
@@ -351,14 +426,29 @@ func buildLogsQuery(panelType v3.PanelType, start, end, step int64, mq *v3.Build
 		return query, nil
 	case v3.AggregateOperatorCount:
 		op := "toFloat64(count(*))"
+		if mq.ArrayAggregation != "" {
+			// the count of the expanded elements rather than of the log lines
+			op = fmt.Sprintf("toFloat64(count(%s))", aggregationKey)
+		}
 		query := fmt.Sprintf(queryTmpl, op, filterSubQuery, groupBy, having, orderBy)
 		return query, nil
 	case v3.AggregateOperatorCountDistinct:
 		op := fmt.Sprintf("toFloat64(count(distinct(%s)))", aggregationKey)
 		query := fmt.Sprintf(queryTmpl, op, filterSubQuery, groupBy, having, orderBy)
 		return query, nil
+	case v3.AggregateOperatorCountIf:
+		condition, err := buildLogsTimeSeriesFilterQuery(mq.AggregateFilters, nil, v3.AttributeKey{})
+		if err != nil {
+			return "", err
+		}
+		if condition == "" {
+			return "", fmt.Errorf("aggregate filters are required for aggregate operator %s", mq.AggregateOperator)
+		}
+		op := fmt.Sprintf("toFloat64(countIf(%s))", condition)
+		query := fmt.Sprintf(queryTmpl, op, filterSubQuery, groupBy, having, orderBy)
+		return query, nil
 	case v3.AggregateOperatorNoOp:
-		queryTmpl := constants.LogsSQLSelect + "from signoz_logs.distributed_logs where %s%s order by %s"
+		queryTmpl := logsListSelect(mq) + "from signoz_logs.distributed_logs where %s%s order by %s"
 		query := fmt.Sprintf(queryTmpl, timeFilter, filterSubQuery, orderBy)
 		return query, nil
 	default:
@@ -356,6 +356,76 @@ var testBuildLogsQueryData = []struct {
 		TableName:     "logs",
 		ExpectedQuery: "SELECT toStartOfInterval(fromUnixTimestamp64Nano(timestamp), INTERVAL 60 SECOND) AS ts, toFloat64(count(*)) as value from signoz_logs.distributed_logs where (timestamp >= 1680066360726210000 AND timestamp <= 1680066458000000000) group by ts order by value DESC",
 	},
+	{
+		Name:      "Test aggregate avg with include count",
+		PanelType: v3.PanelTypeGraph,
+		Start:     1680066360726210000,
+		End:       1680066458000000000,
+		BuilderQuery: &v3.BuilderQuery{
+			QueryName:          "A",
+			StepInterval:       60,
+			AggregateAttribute: v3.AttributeKey{Key: "bytes", DataType: v3.AttributeKeyDataTypeFloat64, Type: v3.AttributeKeyTypeTag},
+			AggregateOperator:  v3.AggregateOperatorAvg,
+			Expression:         "A",
+			GroupBy:            []v3.AttributeKey{{Key: "method", DataType: v3.AttributeKeyDataTypeString, Type: v3.AttributeKeyTypeTag}},
+			IncludeCount:       true,
+		},
+		TableName: "logs",
+		ExpectedQuery: "SELECT toStartOfInterval(fromUnixTimestamp64Nano(timestamp), INTERVAL 60 SECOND) AS ts," +
+			" attributes_string_value[indexOf(attributes_string_key, 'method')] as `method`," +
+			" toUInt64(count(*)) as __count, " +
+			"avg(attributes_float64_value[indexOf(attributes_float64_key, 'bytes')]) as value from signoz_logs.distributed_logs " +
+			"where (timestamp >= 1680066360726210000 AND timestamp <= 1680066458000000000) " +
+			"AND has(attributes_string_key, 'method') " +
+			"AND has(attributes_float64_key, 'bytes') " +
+			"group by `method`,ts " +
+			"order by value DESC",
+	},
+	{
+		Name:      "Test aggregate avg with include band",
+		PanelType: v3.PanelTypeGraph,
+		Start:     1680066360726210000,
+		End:       1680066458000000000,
+		BuilderQuery: &v3.BuilderQuery{
+			QueryName:          "A",
+			StepInterval:       60,
+			AggregateAttribute: v3.AttributeKey{Key: "bytes", DataType: v3.AttributeKeyDataTypeFloat64, Type: v3.AttributeKeyTypeTag},
+			AggregateOperator:  v3.AggregateOperatorAvg,
+			Expression:         "A",
+			IncludeBand:        true,
+		},
+		TableName: "logs",
+		ExpectedQuery: "SELECT toStartOfInterval(fromUnixTimestamp64Nano(timestamp), INTERVAL 60 SECOND) AS ts," +
+			" toFloat64(min(attributes_float64_value[indexOf(attributes_float64_key, 'bytes')])) as __band_min," +
+			" toFloat64(max(attributes_float64_value[indexOf(attributes_float64_key, 'bytes')])) as __band_max, " +
+			"avg(attributes_float64_value[indexOf(attributes_float64_key, 'bytes')]) as value from signoz_logs.distributed_logs " +
+			"where (timestamp >= 1680066360726210000 AND timestamp <= 1680066458000000000) " +
+			"AND has(attributes_float64_key, 'bytes') " +
+			"group by ts " +
+			"order by value DESC",
+	},
+	{
+		Name:      "Test aggregate count with heatmap",
+		PanelType: v3.PanelTypeGraph,
+		Start:     1680066360726210000,
+		End:       1680066458000000000,
+		BuilderQuery: &v3.BuilderQuery{
+			QueryName:          "A",
+			StepInterval:       60,
+			AggregateAttribute: v3.AttributeKey{Key: "bytes", DataType: v3.AttributeKeyDataTypeFloat64, Type: v3.AttributeKeyTypeTag},
+			AggregateOperator:  v3.AggregateOperatorCount,
+			Expression:         "A",
+			Heatmap:            &v3.HeatmapBuckets{Min: 0, Max: 100, Count: 10},
+		},
+		TableName: "logs",
+		ExpectedQuery: "SELECT toStartOfInterval(fromUnixTimestamp64Nano(timestamp), INTERVAL 60 SECOND) AS ts," +
+			" toString(least(greatest(toInt64(floor((toFloat64(attributes_float64_value[indexOf(attributes_float64_key, 'bytes')]) - 0) / 10)), 0), 9)) as __bucket, " +
+			"toFloat64(count(*)) as value from signoz_logs.distributed_logs " +
+			"where (timestamp >= 1680066360726210000 AND timestamp <= 1680066458000000000) " +
+			"AND has(attributes_float64_key, 'bytes') " +
+			"group by `__bucket`,ts " +
+			"order by value DESC",
+	},
 	{
 		Name:      "Test aggregate count on a attribute",
 		PanelType: v3.PanelTypeGraph,
@@ -783,6 +853,24 @@ var testBuildLogsQueryData = []struct {
 			"CAST((resources_string_key, resources_string_value), 'Map(String, String)') as resources_string " +
 			"from signoz_logs.distributed_logs where (timestamp >= 1680066360726210000 AND timestamp <= 1680066458000000000) order by `method` ASC",
 	},
+	{
+		Name:      "Test Noop with list columns",
+		PanelType: v3.PanelTypeList,
+		Start:     1680066360726210000,
+		End:       1680066458000000000,
+		BuilderQuery: &v3.BuilderQuery{
+			SelectColumns:     []v3.AttributeKey{},
+			QueryName:         "A",
+			StepInterval:      60,
+			AggregateOperator: v3.AggregateOperatorNoOp,
+			Expression:        "A",
+			Filters:           &v3.FilterSet{Operator: "AND", Items: []v3.FilterItem{}},
+			ListColumns:       []string{"body", "severity_text", "id", "resources_string"},
+		},
+		ExpectedQuery: "SELECT timestamp, id, body, severity_text, " +
+			"CAST((resources_string_key, resources_string_value), 'Map(String, String)') as resources_string " +
+			"from signoz_logs.distributed_logs where (timestamp >= 1680066360726210000 AND timestamp <= 1680066458000000000) order by timestamp DESC",
+	},
 	{
 		Name:      "Test Noop with filter",
 		PanelType: v3.PanelTypeList,
@@ -1041,6 +1129,93 @@ var testBuildLogsQueryData = []struct {
 		TableName:     "logs",
 		ExpectedQuery: "SELECT now() as ts, attributes_string_value[indexOf(attributes_string_key, 'name')] as `name`, toFloat64(count(*)) as value from signoz_logs.distributed_logs where (timestamp >= 1680066360726210000 AND timestamp <= 1680066458000000000) AND lower(body) like lower('%requestor_list%') AND lower(body) like lower('%index_service%') AND has(JSONExtract(JSON_QUERY(body, '$.\"requestor_list\"[*]'), 'Array(String)'), 'index_service') AND has(attributes_string_key, 'name') group by `name` order by `name` DESC",
 	},
+	{
+		Name:      "Test aggregate count_if",
+		PanelType: v3.PanelTypeGraph,
+		Start:     1680066360726210000,
+		End:       1680066458000000000,
+		BuilderQuery: &v3.BuilderQuery{
+			QueryName:         "A",
+			StepInterval:      60,
+			AggregateOperator: v3.AggregateOperatorCountIf,
+			Expression:        "A",
+			Filters: &v3.FilterSet{Operator: "AND", Items: []v3.FilterItem{
+				{Key: v3.AttributeKey{Key: "service_name", DataType: v3.AttributeKeyDataTypeString, Type: v3.AttributeKeyTypeResource}, Value: "redis", Operator: "="},
+			}},
+			AggregateFilters: &v3.FilterSet{Operator: "AND", Items: []v3.FilterItem{
+				{Key: v3.AttributeKey{Key: "status_code", DataType: v3.AttributeKeyDataTypeInt64, Type: v3.AttributeKeyTypeTag}, Value: 500, Operator: ">="},
+			}},
+		},
+		TableName:     "logs",
+		ExpectedQuery: "SELECT toStartOfInterval(fromUnixTimestamp64Nano(timestamp), INTERVAL 60 SECOND) AS ts, toFloat64(countIf(attributes_int64_value[indexOf(attributes_int64_key, 'status_code')] >= 500)) as value from signoz_logs.distributed_logs where (timestamp >= 1680066360726210000 AND timestamp <= 1680066458000000000) AND resources_string_value[indexOf(resources_string_key, 'service_name')] = 'redis' group by ts order by value DESC",
+	},
+	{
+		Name:      "Test aggregate count_if with multiple conditions and group by",
+		PanelType: v3.PanelTypeTable,
+		Start:     1680066360726210000,
+		End:       1680066458000000000,
+		BuilderQuery: &v3.BuilderQuery{
+			QueryName:         "A",
+			StepInterval:      60,
+			AggregateOperator: v3.AggregateOperatorCountIf,
+			Expression:        "A",
+			AggregateFilters: &v3.FilterSet{Operator: "AND", Items: []v3.FilterItem{
+				{Key: v3.AttributeKey{Key: "status_code", DataType: v3.AttributeKeyDataTypeInt64, Type: v3.AttributeKeyTypeTag}, Value: 500, Operator: ">="},
+				{Key: v3.AttributeKey{Key: "body", DataType: v3.AttributeKeyDataTypeString, IsColumn: true}, Value: "timeout", Operator: "contains"},
+			}},
+			GroupBy: []v3.AttributeKey{
+				{Key: "service_name", DataType: v3.AttributeKeyDataTypeString, Type: v3.AttributeKeyTypeResource},
+			},
+		},
+		TableName:     "logs",
+		ExpectedQuery: "SELECT now() as ts, resources_string_value[indexOf(resources_string_key, 'service_name')] as `service_name`, toFloat64(countIf(attributes_int64_value[indexOf(attributes_int64_key, 'status_code')] >= 500 AND lower(body) LIKE lower('%timeout%'))) as value from signoz_logs.distributed_logs where (timestamp >= 1680066360726210000 AND timestamp <= 1680066458000000000) AND has(resources_string_key, 'service_name') group by `service_name` order by value DESC",
+	}, {
+		Name:      "Test aggregate count with alignment offset",
+		PanelType: v3.PanelTypeGraph,
+		Start:     1680066360726210000,
+		End:       1680066458000000000,
+		BuilderQuery: &v3.BuilderQuery{
+			QueryName:         "A",
+			StepInterval:      3600,
+			AggregateOperator: v3.AggregateOperatorCount,
+			Expression:        "A",
+			AlignmentOffset:   1800,
+		},
+		TableName:     "logs",
+		ExpectedQuery: "SELECT toStartOfInterval(fromUnixTimestamp64Nano(timestamp) - INTERVAL 1800 SECOND, INTERVAL 3600 SECOND) + INTERVAL 1800 SECOND AS ts, toFloat64(count(*)) as value from signoz_logs.distributed_logs where (timestamp >= 1680066360726210000 AND timestamp <= 1680066458000000000) group by ts order by value DESC",
+	},
+	{
+		Name:      "Test count over the elements of an array attribute",
+		PanelType: v3.PanelTypeGraph,
+		Start:     1680066360726210000,
+		End:       1680066458000000000,
+		BuilderQuery: &v3.BuilderQuery{
+			QueryName:          "A",
+			StepInterval:       60,
+			AggregateAttribute: v3.AttributeKey{Key: "body.header_values[*]", DataType: v3.AttributeKeyDataTypeArrayString, IsJSON: true},
+			AggregateOperator:  v3.AggregateOperatorCount,
+			ArrayAggregation:   v3.ArrayAggregationJoin,
+			Expression:         "A",
+		},
+		TableName:     "logs",
+		ExpectedQuery: "SELECT toStartOfInterval(fromUnixTimestamp64Nano(timestamp), INTERVAL 60 SECOND) AS ts, toFloat64(count(arrayJoin(JSONExtract(JSON_QUERY(body, '$.\"header_values\"[*]'), 'Array(String)')))) as value from signoz_logs.distributed_logs where (timestamp >= 1680066360726210000 AND timestamp <= 1680066458000000000) AND JSON_EXISTS(body, '$.\"header_values\"[*]') group by ts order by value DESC",
+	},
+	{
+		Name:      "Test sum of the sums of an array attribute",
+		PanelType: v3.PanelTypeGraph,
+		Start:     1680066360726210000,
+		End:       1680066458000000000,
+		BuilderQuery: &v3.BuilderQuery{
+			QueryName:          "A",
+			StepInterval:       60,
+			AggregateAttribute: v3.AttributeKey{Key: "body.sizes[*]", DataType: v3.AttributeKeyDataTypeArrayInt64, IsJSON: true},
+			AggregateOperator:  v3.AggregateOperatorSum,
+			ArrayAggregation:   v3.ArrayAggregationSum,
+			Expression:         "A",
+		},
+		TableName:     "logs",
+		ExpectedQuery: "SELECT toStartOfInterval(fromUnixTimestamp64Nano(timestamp), INTERVAL 60 SECOND) AS ts, sum(arraySum(JSONExtract(JSON_QUERY(body, '$.\"sizes\"[*]'), 'Array(Int64)'))) as value from signoz_logs.distributed_logs where (timestamp >= 1680066360726210000 AND timestamp <= 1680066458000000000) AND JSON_EXISTS(body, '$.\"sizes\"[*]') group by ts order by value DESC",
+	},
 }
 
 func TestBuildLogsQuery(t *testing.T) {
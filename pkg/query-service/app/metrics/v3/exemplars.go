@@ -0,0 +1,49 @@
+package v3
+
+import (
+	"fmt"
+
+	"go.signoz.io/signoz/pkg/query-service/app/metrics/v4/helpers"
+	"go.signoz.io/signoz/pkg/query-service/common"
+	"go.signoz.io/signoz/pkg/query-service/constants"
+	v3 "go.signoz.io/signoz/pkg/query-service/model/v3"
+	"go.signoz.io/signoz/pkg/query-service/utils"
+)
+
+// PrepareExemplarsQuery prepares the query to be used for fetching one exemplar
+// trace id per step interval of each series of the metric query
+// start and end are in milliseconds
+func PrepareExemplarsQuery(start, end int64, mq *v3.BuilderQuery) (string, error) {
+
+	start, end = common.AdjustedMetricTimeRange(start, end, mq.StepInterval, *mq)
+
+	// the exemplars are of the whole histogram, the le buckets are
+	// never a label of the series of the quantiles
+	tags := []string{}
+	for _, tag := range mq.GroupBy {
+		if tag.Key != "le" {
+			tags = append(tags, tag.Key)
+		}
+	}
+
+	filterSubQuery, err := helpers.PrepareTimeseriesFilterQueryV3(start, end, mq)
+	if err != nil {
+		return "", err
+	}
+
+	exemplarsTableFilter := fmt.Sprintf("metric_name = %s AND unix_milli >= %d AND unix_milli < %d AND trace_id != ''", utils.ClickHouseFormattedValue(mq.AggregateAttribute.Key), start, end)
+
+	queryTmpl :=
+		"SELECT %s" +
+			" toStartOfInterval(toDateTime(intDiv(unix_milli, 1000)), INTERVAL %d SECOND) as ts," +
+			" any(trace_id) as trace_id" +
+			" FROM " + constants.SIGNOZ_METRIC_DBNAME + "." + constants.SIGNOZ_EXEMPLARS_TABLENAME +
+			" INNER JOIN" +
+			" (%s) as filtered_time_series" +
+			" USING fingerprint" +
+			" WHERE " + exemplarsTableFilter +
+			" GROUP BY %s" +
+			" ORDER BY ts"
+
+	return fmt.Sprintf(queryTmpl, groupSelect(tags...), mq.StepInterval, filterSubQuery, groupBy(tags...)), nil
+}
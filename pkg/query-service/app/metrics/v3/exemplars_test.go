@@ -0,0 +1,59 @@
+package v3
+
+import (
+	"testing"
+
+	v3 "go.signoz.io/signoz/pkg/query-service/model/v3"
+)
+
+func TestPrepareExemplarsQuery(t *testing.T) {
+	cases := []struct {
+		name     string
+		query    *v3.BuilderQuery
+		expected string
+	}{
+		{
+			name: "without group by",
+			query: &v3.BuilderQuery{
+				QueryName:          "A",
+				StepInterval:       60,
+				DataSource:         v3.DataSourceMetrics,
+				AggregateOperator:  v3.AggregateOperatorSumRate,
+				AggregateAttribute: v3.AttributeKey{Key: "signoz_calls_total"},
+				Temporality:        v3.Cumulative,
+				Expression:         "A",
+			},
+			expected: "SELECT  toStartOfInterval(toDateTime(intDiv(unix_milli, 1000)), INTERVAL 60 SECOND) as ts, any(trace_id) as trace_id FROM signoz_metrics.distributed_exemplars INNER JOIN (SELECT DISTINCT fingerprint FROM signoz_metrics.time_series_v4_1day WHERE metric_name = 'signoz_calls_total' AND temporality = 'Cumulative' AND unix_milli >= 1650931200000 AND unix_milli < 1651078380000) as filtered_time_series USING fingerprint WHERE metric_name = 'signoz_calls_total' AND unix_milli >= 1650991920000 AND unix_milli < 1651078380000 AND trace_id != '' GROUP BY ts ORDER BY ts",
+		},
+		{
+			name: "histogram quantile grouped by service name",
+			query: &v3.BuilderQuery{
+				QueryName:          "A",
+				StepInterval:       60,
+				DataSource:         v3.DataSourceMetrics,
+				AggregateOperator:  v3.AggregateOperatorHistQuant99,
+				AggregateAttribute: v3.AttributeKey{Key: "signoz_latency_bucket"},
+				Temporality:        v3.Cumulative,
+				Filters: &v3.FilterSet{Operator: "AND", Items: []v3.FilterItem{
+					{Key: v3.AttributeKey{Key: "service_name"}, Operator: v3.FilterOperatorEqual, Value: "frontend"},
+				}},
+				GroupBy:    []v3.AttributeKey{{Key: "service_name"}, {Key: "le"}},
+				Expression: "A",
+			},
+			expected: "SELECT service_name,  toStartOfInterval(toDateTime(intDiv(unix_milli, 1000)), INTERVAL 60 SECOND) as ts, any(trace_id) as trace_id FROM signoz_metrics.distributed_exemplars INNER JOIN (SELECT DISTINCT JSONExtractString(labels, 'service_name') as service_name, JSONExtractString(labels, 'le') as le, fingerprint FROM signoz_metrics.time_series_v4_6hrs WHERE metric_name = 'signoz_latency_bucket' AND temporality = 'Cumulative' AND unix_milli >= 1650974400000 AND unix_milli < 1651078380000 AND JSONExtractString(labels, 'service_name') = 'frontend') as filtered_time_series USING fingerprint WHERE metric_name = 'signoz_latency_bucket' AND unix_milli >= 1650991980000 AND unix_milli < 1651078380000 AND trace_id != '' GROUP BY service_name,ts ORDER BY ts",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			query, err := PrepareExemplarsQuery(1650991982000, 1651078382000, c.query)
+			if err != nil {
+				t.Fatalf("unexpected error: %v\n", err)
+			}
+
+			if query != c.expected {
+				t.Fatalf("expected: %s, got: %s\n", c.expected, query)
+			}
+		})
+	}
+}
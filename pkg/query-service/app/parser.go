@@ -872,13 +872,79 @@ func parseQBFilterSuggestionsRequest(r *http.Request) (
 		}
 	}
 
+	// the time range is optional, the suggestions are not bounded without it
+	var start, end int64
+	if len(r.URL.Query().Get("start")) > 0 {
+		startTime, err := parseTime("start", r)
+		if err != nil {
+			return nil, model.BadRequest(err)
+		}
+		start = startTime.UnixNano()
+	}
+	if len(r.URL.Query().Get("end")) > 0 {
+		endTime, err := parseTime("end", r)
+		if err != nil {
+			return nil, model.BadRequest(err)
+		}
+		end = endTime.UnixNano()
+	}
+	if start < 0 || end < 0 || (end > 0 && start > end) {
+		return nil, model.BadRequest(fmt.Errorf("invalid time range: start %d, end %d", start, end))
+	}
+
+	var hideFilteredKeys bool
+	if hideFilteredKeysStr := r.URL.Query().Get("hideFilteredKeys"); len(hideFilteredKeysStr) > 0 {
+		var err error
+		hideFilteredKeys, err = strconv.ParseBool(hideFilteredKeysStr)
+		if err != nil {
+			return nil, model.BadRequest(fmt.Errorf("invalid hideFilteredKeys: %s", hideFilteredKeysStr))
+		}
+	}
+
 	searchText := r.URL.Query().Get("searchText")
+	valuePrefix := r.URL.Query().Get("valuePrefix")
 
 	return &v3.QBFilterSuggestionsRequest{
-		DataSource:     dataSource,
-		Limit:          limit,
-		SearchText:     searchText,
-		ExistingFilter: existingFilter,
+		DataSource:       dataSource,
+		Limit:            limit,
+		SearchText:       searchText,
+		ValuePrefix:      valuePrefix,
+		ExistingFilter:   existingFilter,
+		Start:            start,
+		End:              end,
+		HideFilteredKeys: hideFilteredKeys,
+	}, nil
+}
+
+func parseAttributeColumnStatusRequest(r *http.Request) (
+	*v3.AttributeColumnStatusRequest, *model.ApiError,
+) {
+	dataSource := v3.DataSource(r.URL.Query().Get("dataSource"))
+	if err := dataSource.Validate(); err != nil {
+		return nil, model.BadRequest(err)
+	}
+
+	// attributes are passed as base64 encoded JSON, same as existingFilter
+	// for filter suggestions
+	attributesB64 := r.URL.Query().Get("attributes")
+	if len(attributesB64) == 0 {
+		return nil, model.BadRequest(fmt.Errorf("attributes is required"))
+	}
+
+	decodedAttributesJson, err := base64.RawURLEncoding.DecodeString(attributesB64)
+	if err != nil {
+		return nil, model.BadRequest(fmt.Errorf("couldn't base64 decode attributes: %w", err))
+	}
+
+	attributeKeys := []v3.AttributeKey{}
+	err = json.Unmarshal(decodedAttributesJson, &attributeKeys)
+	if err != nil {
+		return nil, model.BadRequest(fmt.Errorf("couldn't JSON decode attributes: %w", err))
+	}
+
+	return &v3.AttributeColumnStatusRequest{
+		DataSource:    dataSource,
+		AttributeKeys: attributeKeys,
 	}, nil
 }
 
@@ -945,18 +1011,65 @@ func parseFilterAttributeValueRequest(r *http.Request) (*v3.FilterAttributeValue
 		TagType:                    tagType,
 		Limit:                      limit,
 		SearchText:                 r.URL.Query().Get("searchText"),
+		ValuePrefix:                r.URL.Query().Get("valuePrefix"),
 		FilterAttributeKey:         r.URL.Query().Get("attributeKey"),
 		FilterAttributeKeyDataType: filterAttributeKeyDataType,
 	}
 	return &req, nil
 }
 
+// maxRoundDecimals is the max number of decimals the point values can be rounded
+// to, float64 values have about 15 significant decimal digits
+const maxRoundDecimals = 15
+
+// minSampleTo is the min number of points the series can be sampled to, the
+// min and the max point of a single bucket
+const minSampleTo = 2
+
 func validateQueryRangeParamsV3(qp *v3.QueryRangeParamsV3) error {
 	err := qp.CompositeQuery.Validate()
 	if err != nil {
 		return err
 	}
 
+	if err := qp.ValuePanelMultiSeriesStrategy.Validate(); err != nil {
+		return err
+	}
+
+	if err := qp.SeriesSort.Validate(); err != nil {
+		return err
+	}
+
+	if err := qp.ListMerge.Validate(); err != nil {
+		return err
+	}
+
+	if err := qp.TopNWithOther.Validate(); err != nil {
+		return err
+	}
+
+	if qp.RoundDecimals != nil && (*qp.RoundDecimals < 0 || *qp.RoundDecimals > maxRoundDecimals) {
+		return fmt.Errorf("invalid round decimals: %d, must be between 0 and %d", *qp.RoundDecimals, maxRoundDecimals)
+	}
+
+	if qp.MarkGaps && qp.CompositeQuery.FillGaps {
+		return fmt.Errorf("mark gaps and fill gaps can't be combined")
+	}
+
+	if qp.SampleTo != 0 && qp.SampleTo < minSampleTo {
+		return fmt.Errorf("invalid sample to: %d, must be at least %d", qp.SampleTo, minSampleTo)
+	}
+
+	if qp.Priority != nil && (*qp.Priority < baseconstants.MinQueryPriority || *qp.Priority > baseconstants.MaxQueryPriority) {
+		return fmt.Errorf("invalid priority: %d, must be between %d and %d", *qp.Priority, baseconstants.MinQueryPriority, baseconstants.MaxQueryPriority)
+	}
+
+	for _, ratio := range qp.Ratios {
+		if err := ratio.Validate(qp.CompositeQuery); err != nil {
+			return err
+		}
+	}
+
 	var expressions []string
 	for _, q := range qp.CompositeQuery.BuilderQueries {
 		expressions = append(expressions, q.Expression)
@@ -1145,7 +1258,8 @@ func ParseQueryRangeParams(r *http.Request) (*v3.QueryRangeParamsV3, *model.ApiE
 				chQuery.Query = strings.Replace(chQuery.Query, fmt.Sprintf("$%s", name), fmt.Sprint(value), -1)
 			}
 
-			tmpl := template.New("clickhouse-query")
+			// unknown macros are rejected instead of being rendered as "<no value>"
+			tmpl := template.New("clickhouse-query").Option("missingkey=error")
 			tmpl, err := tmpl.Parse(chQuery.Query)
 			if err != nil {
 				return nil, &model.ApiError{Typ: model.ErrorBadData, Err: err}
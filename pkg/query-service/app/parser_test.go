@@ -3,6 +3,7 @@ package app
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"strings"
@@ -496,6 +497,63 @@ func TestParseQueryRangeParamsCompositeQuery(t *testing.T) {
 			expectErr: true,
 			errMsg:    "builder query A is invalid: group by is invalid",
 		},
+		{
+			desc: "invalid list column for builder query",
+			compositeQuery: v3.CompositeQuery{
+				PanelType: v3.PanelTypeList,
+				QueryType: v3.QueryTypeBuilder,
+				BuilderQueries: map[string]*v3.BuilderQuery{
+					"A": {
+						QueryName:         "A",
+						DataSource:        "logs",
+						AggregateOperator: "noop",
+						ListColumns:       []string{"body", "unknown"},
+						Expression:        "A",
+					},
+				},
+			},
+			expectErr: true,
+			errMsg:    "builder query A is invalid: invalid list column: unknown",
+		},
+		{
+			desc: "trailing window not a multiple of the step interval",
+			compositeQuery: v3.CompositeQuery{
+				PanelType: v3.PanelTypeGraph,
+				QueryType: v3.QueryTypeBuilder,
+				BuilderQueries: map[string]*v3.BuilderQuery{
+					"A": {
+						QueryName:         "A",
+						DataSource:        "logs",
+						AggregateOperator: "count",
+						StepInterval:      60,
+						TrailingWindow:    90,
+						Expression:        "A",
+					},
+				},
+			},
+			expectErr: true,
+			errMsg:    "builder query A is invalid: invalid trailing window: 90",
+		},
+		{
+			desc: "array aggregation of a non array attribute",
+			compositeQuery: v3.CompositeQuery{
+				PanelType: v3.PanelTypeGraph,
+				QueryType: v3.QueryTypeBuilder,
+				BuilderQueries: map[string]*v3.BuilderQuery{
+					"A": {
+						QueryName:          "A",
+						DataSource:         "logs",
+						AggregateOperator:  "sum",
+						AggregateAttribute: v3.AttributeKey{Key: "body.size", DataType: v3.AttributeKeyDataTypeInt64, IsJSON: true},
+						StepInterval:       60,
+						ArrayAggregation:   v3.ArrayAggregationJoin,
+						Expression:         "A",
+					},
+				},
+			},
+			expectErr: true,
+			errMsg:    "builder query A is invalid: array aggregation requires an array typed JSON aggregate attribute",
+		},
 	}
 
 	for _, tc := range reqCases {
@@ -1032,6 +1090,63 @@ func TestParseQueryRangeParamsPromQLVars(t *testing.T) {
 	}
 }
 
+func TestParseQueryRangeParamsClickHouseMacros(t *testing.T) {
+	reqCases := []struct {
+		desc      string
+		query     string
+		expectErr bool
+		errMsg    string
+		// expectedQuery is a format string for the start, end timestamps in seconds and the step
+		expectedQuery string
+	}{
+		{
+			desc:          "time range and step macros",
+			query:         "SELECT toStartOfInterval(timestamp, INTERVAL {{.step}} SECOND) AS ts, count() AS value FROM signoz_logs.distributed_logs WHERE timestamp >= {{.start_timestamp}} AND timestamp <= {{.end_timestamp}} GROUP BY ts",
+			expectedQuery: "SELECT toStartOfInterval(timestamp, INTERVAL %[3]d SECOND) AS ts, count() AS value FROM signoz_logs.distributed_logs WHERE timestamp >= %[1]d AND timestamp <= %[2]d GROUP BY ts",
+		},
+		{
+			desc:      "unknown macro",
+			query:     "SELECT count() FROM signoz_logs.distributed_logs WHERE timestamp >= {{.start_time}}",
+			expectErr: true,
+			errMsg:    "start_time",
+		},
+	}
+
+	for _, tc := range reqCases {
+		t.Run(tc.desc, func(t *testing.T) {
+
+			queryRangeParams := &v3.QueryRangeParamsV3{
+				Start: time.Now().Add(-time.Hour).UnixMilli(),
+				End:   time.Now().UnixMilli(),
+				Step:  60,
+				CompositeQuery: &v3.CompositeQuery{
+					PanelType: v3.PanelTypeGraph,
+					QueryType: v3.QueryTypeClickHouseSQL,
+					ClickHouseQueries: map[string]*v3.ClickHouseQuery{
+						"A": {Query: tc.query},
+					},
+				},
+				Variables: map[string]interface{}{},
+			}
+
+			body := &bytes.Buffer{}
+			err := json.NewEncoder(body).Encode(queryRangeParams)
+			require.NoError(t, err)
+			req := httptest.NewRequest(http.MethodPost, "/api/v3/query_range", body)
+
+			parsedQueryRangeParams, apiErr := ParseQueryRangeParams(req)
+			if tc.expectErr {
+				require.Error(t, apiErr)
+				require.Contains(t, apiErr.Error(), tc.errMsg)
+			} else {
+				require.Nil(t, apiErr)
+				expectedQuery := fmt.Sprintf(tc.expectedQuery, parsedQueryRangeParams.Start/1000, parsedQueryRangeParams.End/1000, parsedQueryRangeParams.Step)
+				require.Equal(t, expectedQuery, parsedQueryRangeParams.CompositeQuery.ClickHouseQueries["A"].Query)
+			}
+		})
+	}
+}
+
 func TestQueryRangeFormula(t *testing.T) {
 	reqCases := []struct {
 		desc           string
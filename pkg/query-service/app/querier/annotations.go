@@ -0,0 +1,40 @@
+package querier
+
+import (
+	"context"
+
+	v3 "go.signoz.io/signoz/pkg/query-service/model/v3"
+	"go.uber.org/zap"
+)
+
+// attachAnnotations sets the annotation events within the time range of the
+// request on each result. The annotations are fetched once for all the results,
+// a failure to fetch them is logged and the results are returned without them
+func (q *querier) attachAnnotations(ctx context.Context, params *v3.QueryRangeParamsV3, results []*v3.Result) {
+	if len(results) == 0 {
+		return
+	}
+	annotations, err := q.reader.GetAnnotations(ctx, params.Start, params.End)
+	if err != nil {
+		zap.L().Error("error fetching annotations", zap.Error(err))
+		return
+	}
+	annotations = annotationsInWindow(annotations, params.Start, params.End)
+	if len(annotations) == 0 {
+		return
+	}
+	for _, result := range results {
+		result.Annotations = annotations
+	}
+}
+
+// annotationsInWindow returns the annotations with a timestamp in [start, end]
+func annotationsInWindow(annotations []v3.Annotation, start, end int64) []v3.Annotation {
+	inWindow := make([]v3.Annotation, 0, len(annotations))
+	for _, annotation := range annotations {
+		if annotation.Timestamp >= start && annotation.Timestamp <= end {
+			inWindow = append(inWindow, annotation)
+		}
+	}
+	return inWindow
+}
@@ -0,0 +1,21 @@
+package querier
+
+import (
+	v3 "go.signoz.io/signoz/pkg/query-service/model/v3"
+)
+
+// attachAppliedFilters sets the filters each builder query was run with on its
+// results. The formulas are left out, they are run with the filters of their queries
+func attachAppliedFilters(results []*v3.Result, builderQueries map[string]*v3.BuilderQuery) {
+	for _, result := range results {
+		builderQuery, ok := builderQueries[result.QueryName]
+		if !ok || builderQuery.QueryName != builderQuery.Expression {
+			continue
+		}
+		filters := builderQuery.Filters
+		if filters == nil {
+			filters = &v3.FilterSet{Operator: "AND", Items: []v3.FilterItem{}}
+		}
+		result.AppliedFilters = filters
+	}
+}
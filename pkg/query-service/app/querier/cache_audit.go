@@ -0,0 +1,78 @@
+package querier
+
+import (
+	"context"
+	"math"
+	"math/rand"
+
+	v3 "go.signoz.io/signoz/pkg/query-service/model/v3"
+	"go.uber.org/zap"
+)
+
+const (
+	// cacheAuditOverlapSteps is the number of steps at the end of the cached
+	// points that are refetched by an audit
+	cacheAuditOverlapSteps     = 3
+	defaultCacheAuditTolerance = 0.01
+)
+
+// cacheAuditSampled returns true if the cache merge should be audited
+func (q *querier) cacheAuditSampled() bool {
+	return q.cacheAuditSampleRate > 0 && rand.Float64() < q.cacheAuditSampleRate
+}
+
+// auditCachedSeries refetches the last steps of the cached series with fetch and
+// logs a warning when the fetched values diverge from the cached values by more
+// than the tolerance, relative to the larger of the two. It returns the number of
+// diverging points. step is in seconds, the cached series are not modified
+func (q *querier) auditCachedSeries(ctx context.Context, cacheKey string, cachedSeries []*v3.Series, step int64, fetch func(ctx context.Context, start, end int64) ([]*v3.Series, error)) int {
+	var lastTimestamp int64
+	for _, series := range cachedSeries {
+		for _, point := range series.Points {
+			lastTimestamp = max(lastTimestamp, point.Timestamp)
+		}
+	}
+	if lastTimestamp == 0 {
+		return 0
+	}
+	overlapStart := lastTimestamp - (cacheAuditOverlapSteps-1)*step*1000
+
+	fetchedSeries, err := fetch(ctx, overlapStart, lastTimestamp)
+	if err != nil {
+		zap.L().Error("error fetching the cache audit overlap", zap.String("cacheKey", cacheKey), zap.Error(err))
+		return 0
+	}
+	fetchedValues := make(map[string]map[int64]float64, len(fetchedSeries))
+	for _, series := range fetchedSeries {
+		values := make(map[int64]float64, len(series.Points))
+		for _, point := range series.Points {
+			values[point.Timestamp] = point.Value
+		}
+		fetchedValues[labelsToString(series.Labels)] = values
+	}
+
+	diverged := 0
+	for _, series := range cachedSeries {
+		labels := labelsToString(series.Labels)
+		for _, point := range series.Points {
+			if point.Timestamp < overlapStart || math.IsNaN(point.Value) {
+				continue
+			}
+			fetched, ok := fetchedValues[labels][point.Timestamp]
+			if !ok || math.IsNaN(fetched) {
+				continue
+			}
+			if math.Abs(point.Value-fetched) > q.cacheAuditTolerance*math.Max(math.Abs(point.Value), math.Abs(fetched)) {
+				diverged++
+				zap.L().Warn("cached series diverge from the fetched series",
+					zap.String("cacheKey", cacheKey),
+					zap.String("labels", labels),
+					zap.Int64("timestamp", point.Timestamp),
+					zap.Float64("cached", point.Value),
+					zap.Float64("fetched", fetched),
+				)
+			}
+		}
+	}
+	return diverged
+}
@@ -0,0 +1,107 @@
+package querier
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	v3 "go.signoz.io/signoz/pkg/query-service/model/v3"
+	"go.uber.org/zap"
+)
+
+// paramsHashKeySuffix is appended to the cache key to store the hash of the query
+// params the cached series were computed for
+const paramsHashKeySuffix = "#paramsHash"
+
+// promQueryParamsHash hashes the params that determine the cached series of the prom query
+func promQueryParamsHash(promQuery *v3.PromQuery, step int64) string {
+	return hashParams(struct {
+		Query string
+		Step  int64
+	}{promQuery.Query, step})
+}
+
+// builderQueryParamsHash hashes the params of the builder query the cache key is
+// generated from. The options applied to the series after the cache, e.g. the
+// legends, the moving average or the percentages, share the cached series and are
+// left out
+func builderQueryParamsHash(builderQuery *v3.BuilderQuery) string {
+	params := struct {
+		DataSource         v3.DataSource
+		StepInterval       int64
+		AggregateOperator  v3.AggregateOperator
+		TimeAggregation    v3.TimeAggregation
+		SpaceAggregation   v3.SpaceAggregation
+		AggregateAttribute v3.AttributeKey
+		AdditionalMetrics  []v3.AttributeKey
+		ArrayAggregation   v3.ArrayAggregation
+		AlignmentOffset    int64
+		Filters            *v3.FilterSet
+		AggregateFilters   *v3.FilterSet
+		GroupBy            []v3.AttributeKey
+		Having             []v3.Having
+		Limit              uint64
+		OrderBy            []v3.OrderBy
+	}{
+		DataSource:         builderQuery.DataSource,
+		StepInterval:       builderQuery.StepInterval,
+		AggregateOperator:  builderQuery.AggregateOperator,
+		TimeAggregation:    builderQuery.TimeAggregation,
+		SpaceAggregation:   builderQuery.SpaceAggregation,
+		AggregateAttribute: builderQuery.AggregateAttribute,
+		AdditionalMetrics:  builderQuery.AdditionalMetrics,
+		ArrayAggregation:   builderQuery.ArrayAggregation,
+		AlignmentOffset:    builderQuery.AlignmentOffset,
+		Filters:            builderQuery.Filters,
+		AggregateFilters:   builderQuery.AggregateFilters,
+		GroupBy:            builderQuery.GroupBy,
+		Having:             builderQuery.Having,
+	}
+	// the cached series are in absolute time, the shift only moves the time range,
+	// and the limit is applied to the metric series after the query
+	if builderQuery.DataSource != v3.DataSourceMetrics {
+		params.Limit = builderQuery.Limit
+		params.OrderBy = builderQuery.OrderBy
+	}
+	return hashParams(params)
+}
+
+func hashParams(params interface{}) string {
+	data, err := json.Marshal(params)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// checkKeyCollision returns an error if the series cached under the key were computed
+// for different query params, i.e. the key generator failed to tell the queries apart.
+// The check is only done in testing mode.
+func (q *querier) checkKeyCollision(cacheKey, paramsHash string) error {
+	if !q.testingMode || q.cache == nil {
+		return nil
+	}
+	data, _, err := q.cache.Retrieve(cacheKey+paramsHashKeySuffix, true)
+	if err != nil || data == nil {
+		return nil
+	}
+	if string(data) != paramsHash {
+		zap.L().Warn("cache key collision", zap.String("cacheKey", cacheKey))
+		return fmt.Errorf("cache key collision: series cached under %q were computed for different query params", cacheKey)
+	}
+	return nil
+}
+
+// storeParamsHash stores the hash of the query params alongside the cached series,
+// it is only done in testing mode
+func (q *querier) storeParamsHash(cacheKey, paramsHash string) {
+	if !q.testingMode || q.cache == nil {
+		return
+	}
+	if err := q.cache.Store(cacheKey+paramsHashKeySuffix, []byte(paramsHash), time.Hour); err != nil {
+		zap.L().Error("error storing params hash", zap.Error(err))
+	}
+}
@@ -0,0 +1,78 @@
+package querier
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.signoz.io/signoz/pkg/query-service/cache/status"
+	"go.uber.org/zap"
+)
+
+const defaultCacheRetrieveBackoff = 20 * time.Millisecond
+
+// cacheErrors are the cache backend errors of the queries of a request, by query
+// name. The queries retrieve their cached series concurrently
+type cacheErrors struct {
+	mu      sync.Mutex
+	byQuery map[string]error
+}
+
+type cacheErrorsKey struct{}
+
+func newCacheErrors() *cacheErrors {
+	return &cacheErrors{byQuery: make(map[string]error)}
+}
+
+// withCacheErrors returns a context in which the cache backend errors of the
+// queries are recorded in errs
+func withCacheErrors(ctx context.Context, errs *cacheErrors) context.Context {
+	return context.WithValue(ctx, cacheErrorsKey{}, errs)
+}
+
+// recordCacheError records the cache backend error of the query in the context, if any
+func recordCacheError(ctx context.Context, queryName string, err error) {
+	if errs, ok := ctx.Value(cacheErrorsKey{}).(*cacheErrors); ok {
+		errs.mu.Lock()
+		errs.byQuery[queryName] = err
+		errs.mu.Unlock()
+	}
+}
+
+// note returns the warning for the cache backend error of the query, if any
+func (e *cacheErrors) note(queryName string) (string, bool) {
+	if e == nil {
+		return "", false
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	err, ok := e.byQuery[queryName]
+	if !ok {
+		return "", false
+	}
+	return fmt.Sprintf("the cache is unavailable, the series were fetched from the database: %s", err), true
+}
+
+// retrieveCachedSeries retrieves the cached series of the query, retrying a cache
+// backend error up to the max retries. A miss is not an error. The last error is
+// recorded in the context, the query then proceeds without cached series
+func (q *querier) retrieveCachedSeries(ctx context.Context, queryName, cacheKey string) ([]byte, status.RetrieveStatus, error) {
+	for retry := 0; ; retry++ {
+		data, retrieveStatus, err := q.cache.Retrieve(cacheKey, true)
+		if err == nil {
+			return data, retrieveStatus, nil
+		}
+		if retry >= q.cacheRetrieveRetries {
+			zap.L().Error("error retrieving the cached series", zap.String("query", queryName), zap.Error(err))
+			recordCacheError(ctx, queryName, err)
+			return data, retrieveStatus, err
+		}
+		select {
+		case <-ctx.Done():
+			recordCacheError(ctx, queryName, err)
+			return data, retrieveStatus, err
+		case <-time.After(q.cacheRetrieveBackoff):
+		}
+	}
+}
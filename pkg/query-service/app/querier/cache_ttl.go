@@ -0,0 +1,31 @@
+package querier
+
+import (
+	"time"
+)
+
+const (
+	minCacheTTL = time.Minute
+	maxCacheTTL = 6 * time.Hour
+)
+
+// CacheTTLPolicy returns the ttl of the cached series of a query over [start, end]
+// in milliseconds with the step in seconds
+type CacheTTLPolicy func(start, end, step int64) time.Duration
+
+// DefaultCacheTTLPolicy caches the series for a sixth of the requested range, but
+// at least one step, bounded by [minCacheTTL, maxCacheTTL]. The series of a short
+// range are mostly in flux and change soon, those of a long range change rarely
+func DefaultCacheTTLPolicy(start, end, step int64) time.Duration {
+	ttl := time.Duration(end-start) * time.Millisecond / 6
+	if stepTTL := time.Duration(step) * time.Second; ttl < stepTTL {
+		ttl = stepTTL
+	}
+	if ttl < minCacheTTL {
+		return minCacheTTL
+	}
+	if ttl > maxCacheTTL {
+		return maxCacheTTL
+	}
+	return ttl
+}
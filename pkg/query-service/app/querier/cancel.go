@@ -0,0 +1,18 @@
+package querier
+
+import (
+	"context"
+	"time"
+)
+
+// queryIDTTL is how long the query ids issued for the queries can be cancelled
+const queryIDTTL = time.Hour
+
+// CancelQuery kills the running clickhouse query with the query id. Only the
+// query ids issued for the queries of the user in the context can be cancelled
+func (q *querier) CancelQuery(ctx context.Context, queryID string) error {
+	if err := q.queryIDs.CheckOwner(ctx, queryID); err != nil {
+		return err
+	}
+	return q.reader.CancelQuery(ctx, queryID)
+}
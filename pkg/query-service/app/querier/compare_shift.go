@@ -0,0 +1,54 @@
+package querier
+
+import (
+	"context"
+
+	v3 "go.signoz.io/signoz/pkg/query-service/model/v3"
+)
+
+// runCompareShiftQueries runs the builder queries with a CompareShift over the window
+// shifted back by the compare shift, and returns their results with the timestamps
+// realigned to the requested window. The shifted queries are cached like the others,
+// under the keys of their shift
+func (q *querier) runCompareShiftQueries(ctx context.Context, params *v3.QueryRangeParamsV3, keys map[string]v3.AttributeKey) ([]*v3.Result, map[string]error, error) {
+	shiftedQueries := make(map[string]*v3.BuilderQuery)
+	for queryName, builderQuery := range params.CompositeQuery.BuilderQueries {
+		if builderQuery.Disabled || builderQuery.CompareShift == 0 {
+			continue
+		}
+		shiftedQuery := *builderQuery
+		shiftedQuery.ShiftBy += builderQuery.CompareShift
+		shiftedQuery.CompareShift = 0
+		shiftedQueries[queryName] = &shiftedQuery
+	}
+	if len(shiftedQueries) == 0 {
+		return nil, nil, nil
+	}
+
+	shiftedCompositeQuery := *params.CompositeQuery
+	shiftedCompositeQuery.BuilderQueries = shiftedQueries
+	shiftedParams := *params
+	shiftedParams.CompositeQuery = &shiftedCompositeQuery
+
+	results, errQueriesByName, err := q.runBuilderQueries(ctx, &shiftedParams, keys)
+	for _, result := range results {
+		compareShift := params.CompositeQuery.BuilderQueries[result.QueryName].CompareShift
+		realignSeries(result.Series, compareShift*1000)
+		result.CompareShift = compareShift
+	}
+	return results, errQueriesByName, err
+}
+
+// realignSeries moves the points of the series forward by the shift in milliseconds.
+// The points are replaced, not modified in place, as they might be shared with
+// the series being cached
+func realignSeries(seriesList []*v3.Series, shift int64) {
+	for _, series := range seriesList {
+		points := make([]v3.Point, len(series.Points))
+		for idx, point := range series.Points {
+			points[idx] = point
+			points[idx].Timestamp = point.Timestamp + shift
+		}
+		series.Points = points
+	}
+}
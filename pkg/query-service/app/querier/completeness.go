@@ -0,0 +1,73 @@
+package querier
+
+import (
+	"math"
+
+	v3 "go.signoz.io/signoz/pkg/query-service/model/v3"
+)
+
+// attachCompleteness sets the completeness of the series of the results of the
+// builder and prom queries over the steps of the requested time range. The series
+// are new series, the merged series are shared with the cache
+func attachCompleteness(results []*v3.Result, params *v3.QueryRangeParamsV3) {
+	for _, result := range results {
+		start, end, step, alignmentOffset := resultGrid(params, result.QueryName)
+		if step <= 0 {
+			continue
+		}
+		expected := stepsInRange(start, end, step, alignmentOffset)
+		seriesList := make([]*v3.Series, 0, len(result.Series))
+		for _, series := range result.Series {
+			withCompleteness := *series
+			completeness := seriesCompleteness(series.Points, start, end, expected)
+			withCompleteness.Completeness = &completeness
+			seriesList = append(seriesList, &withCompleteness)
+		}
+		result.Series = seriesList
+	}
+}
+
+// resultGrid returns the time range of the points of the result of the query,
+// moved back by the shift of the builder queries, and the step grid shifted by
+// the alignment offset, in milliseconds. The step is 0 for the queries without steps
+func resultGrid(params *v3.QueryRangeParamsV3, queryName string) (start, end, step, alignmentOffset int64) {
+	start, end, step = params.Start, params.End, resultStep(params, queryName)*1000
+	if builderQuery, ok := params.CompositeQuery.BuilderQueries[queryName]; ok {
+		start -= builderQuery.ShiftBy * 1000
+		end -= builderQuery.ShiftBy * 1000
+		alignmentOffset = builderQuery.AlignmentOffset * 1000
+	}
+	return start, end, step, alignmentOffset
+}
+
+// firstStepAt returns the first timestamp of the step grid shifted by the
+// alignment offset at or after start, in milliseconds
+func firstStepAt(start, step, alignmentOffset int64) int64 {
+	return int64(math.Ceil(float64(start-alignmentOffset)/float64(step)))*step + alignmentOffset
+}
+
+// stepsInRange returns the number of the steps, on the grid shifted by the
+// alignment offset, that start in [start, end], in milliseconds
+func stepsInRange(start, end, step, alignmentOffset int64) int64 {
+	first := firstStepAt(start, step, alignmentOffset)
+	if first > end {
+		return 0
+	}
+	return (end-first)/step + 1
+}
+
+// seriesCompleteness returns the ratio of the distinct timestamps in [start, end]
+// with a (non NaN) value to the expected number of steps, capped to 1
+func seriesCompleteness(points []v3.Point, start, end, expected int64) float64 {
+	if expected == 0 {
+		return 0
+	}
+	timestamps := make(map[int64]struct{}, len(points))
+	for _, point := range points {
+		if point.Timestamp < start || point.Timestamp > end || math.IsNaN(point.Value) {
+			continue
+		}
+		timestamps[point.Timestamp] = struct{}{}
+	}
+	return math.Min(1, float64(len(timestamps))/float64(expected))
+}
@@ -0,0 +1,34 @@
+package querier
+
+import (
+	"math"
+	"sort"
+
+	v3 "go.signoz.io/signoz/pkg/query-service/model/v3"
+)
+
+// annotateCounterResets sets the reset timestamps of each series to the
+// timestamps of the points with a value lower than the previous point.
+// NaN values are skipped. The points are not modified
+func annotateCounterResets(seriesList []*v3.Series) {
+	for _, series := range seriesList {
+		points := series.Points
+		if !sort.SliceIsSorted(points, func(i, j int) bool { return points[i].Timestamp < points[j].Timestamp }) {
+			points = append([]v3.Point(nil), points...)
+			sort.Slice(points, func(i, j int) bool { return points[i].Timestamp < points[j].Timestamp })
+		}
+
+		var resets []int64
+		previous := math.NaN()
+		for _, point := range points {
+			if math.IsNaN(point.Value) {
+				continue
+			}
+			if !math.IsNaN(previous) && point.Value < previous {
+				resets = append(resets, point.Timestamp)
+			}
+			previous = point.Value
+		}
+		series.ResetTimestamps = resets
+	}
+}
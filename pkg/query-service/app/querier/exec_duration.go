@@ -0,0 +1,31 @@
+package querier
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+type execDurationKey struct{}
+
+// withExecDuration returns a context in which the wall-clock time of the
+// reader calls is added to d, in nanoseconds. The reader calls of a query can
+// run concurrently, so d is updated atomically
+func withExecDuration(ctx context.Context, d *atomic.Int64) context.Context {
+	return context.WithValue(ctx, execDurationKey{}, d)
+}
+
+// recordExecDuration adds the time since start to the duration of the context, if any
+func recordExecDuration(ctx context.Context, start time.Time) {
+	if d, ok := ctx.Value(execDurationKey{}).(*atomic.Int64); ok {
+		d.Add(int64(time.Since(start)))
+	}
+}
+
+// execMillis returns the accumulated duration of the reader calls in milliseconds
+func execMillis(d *atomic.Int64) int64 {
+	if d == nil {
+		return 0
+	}
+	return time.Duration(d.Load()).Milliseconds()
+}
@@ -0,0 +1,65 @@
+package querier
+
+import (
+	"context"
+
+	metricsV3 "go.signoz.io/signoz/pkg/query-service/app/metrics/v3"
+	v3 "go.signoz.io/signoz/pkg/query-service/model/v3"
+	"go.uber.org/zap"
+)
+
+// attachExemplars fetches the exemplars of the metrics builder queries with
+// IncludeExemplars and attaches them to the points of the results. The exemplars
+// are optional, a failure to fetch them is logged and the points are returned as is
+func (q *querier) attachExemplars(ctx context.Context, params *v3.QueryRangeParamsV3, results []*v3.Result) {
+	for _, result := range results {
+		builderQuery, ok := params.CompositeQuery.BuilderQueries[result.QueryName]
+		if !ok || !builderQuery.IncludeExemplars || builderQuery.DataSource != v3.DataSourceMetrics {
+			continue
+		}
+		query, err := metricsV3.PrepareExemplarsQuery(params.Start, params.End, builderQuery)
+		if err != nil {
+			zap.L().Error("error preparing exemplars query", zap.String("queryName", result.QueryName), zap.Error(err))
+			continue
+		}
+		exemplars, err := q.reader.GetExemplarsV3(ctx, query)
+		if err != nil {
+			zap.L().Error("error fetching exemplars", zap.String("queryName", result.QueryName), zap.Error(err))
+			continue
+		}
+		attachSeriesExemplars(result.Series, exemplars)
+	}
+}
+
+// attachSeriesExemplars sets the exemplar trace ids of the series, by the timestamps
+// of the points with the labels and the timestamp of an exemplar. The exemplars are
+// kept apart from the points as few of the points have one
+func attachSeriesExemplars(seriesList []*v3.Series, exemplars []v3.Exemplar) {
+	traceIDs := make(map[string]map[int64]string)
+	for _, exemplar := range exemplars {
+		key := labelsToString(exemplar.Labels)
+		if traceIDs[key] == nil {
+			traceIDs[key] = make(map[int64]string)
+		}
+		traceIDs[key][exemplar.Timestamp] = exemplar.TraceID
+	}
+
+	for _, series := range seriesList {
+		seriesTraceIDs, ok := traceIDs[labelsToString(series.Labels)]
+		if !ok {
+			continue
+		}
+		var seriesExemplars map[int64]string
+		for _, point := range series.Points {
+			traceID, ok := seriesTraceIDs[point.Timestamp]
+			if !ok {
+				continue
+			}
+			if seriesExemplars == nil {
+				seriesExemplars = make(map[int64]string)
+			}
+			seriesExemplars[point.Timestamp] = traceID
+		}
+		series.Exemplars = seriesExemplars
+	}
+}
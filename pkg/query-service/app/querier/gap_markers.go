@@ -0,0 +1,87 @@
+package querier
+
+import (
+	"math"
+	"sort"
+
+	v3 "go.signoz.io/signoz/pkg/query-service/model/v3"
+)
+
+// markGaps inserts a null point at each step of the time range the series of
+// the results have no value at, the NaN points are replaced by null points too.
+// The series and the points are new, the merged series are shared with the cache
+func markGaps(results []*v3.Result, params *v3.QueryRangeParamsV3) {
+	for _, result := range results {
+		start, end, step, alignmentOffset := resultGrid(params, result.QueryName)
+		if step <= 0 {
+			continue
+		}
+		seriesList := make([]*v3.Series, 0, len(result.Series))
+		for _, series := range result.Series {
+			seriesList = append(seriesList, gapMarkedSeries(series, start, end, step, alignmentOffset))
+		}
+		result.Series = seriesList
+	}
+}
+
+// gapMarkedSeries returns a copy of the series with a null point at each step in
+// [start, end] without a value, ordered by timestamp. The counts and the band are
+// kept aligned with the points, the inserted null points aggregate no rows and
+// have a zero count and a zero band
+func gapMarkedSeries(series *v3.Series, start, end, step, alignmentOffset int64) *v3.Series {
+	// srcIdx is the index of the point in the series, -1 for the inserted points
+	type markedPoint struct {
+		point  v3.Point
+		srcIdx int
+	}
+	marked := make([]markedPoint, 0, len(series.Points))
+	present := make(map[int64]struct{}, len(series.Points))
+	for idx, point := range series.Points {
+		if math.IsNaN(point.Value) {
+			point = v3.Point{Timestamp: point.Timestamp, Value: math.NaN(), Null: true}
+		}
+		present[point.Timestamp] = struct{}{}
+		marked = append(marked, markedPoint{point: point, srcIdx: idx})
+	}
+	for ts := firstStepAt(start, step, alignmentOffset); ts <= end; ts += step {
+		if _, ok := present[ts]; !ok {
+			marked = append(marked, markedPoint{point: v3.Point{Timestamp: ts, Value: math.NaN(), Null: true}, srcIdx: -1})
+		}
+	}
+	sort.SliceStable(marked, func(i, j int) bool {
+		return marked[i].point.Timestamp < marked[j].point.Timestamp
+	})
+
+	withCounts := len(series.Counts) == len(series.Points) && len(series.Counts) > 0
+	withBand := len(series.BandMin) == len(series.Points) && len(series.BandMax) == len(series.Points) && len(series.BandMin) > 0
+	gapMarked := *series
+	gapMarked.Points = make([]v3.Point, 0, len(marked))
+	gapMarked.Counts, gapMarked.BandMin, gapMarked.BandMax = nil, nil, nil
+	for _, m := range marked {
+		gapMarked.Points = append(gapMarked.Points, m.point)
+		var count int64
+		var bandMin, bandMax float64
+		if m.srcIdx >= 0 {
+			if withCounts {
+				count = series.Counts[m.srcIdx]
+			}
+			if withBand {
+				bandMin, bandMax = series.BandMin[m.srcIdx], series.BandMax[m.srcIdx]
+			}
+		}
+		if withCounts {
+			gapMarked.Counts = append(gapMarked.Counts, count)
+		}
+		if withBand {
+			gapMarked.BandMin = append(gapMarked.BandMin, bandMin)
+			gapMarked.BandMax = append(gapMarked.BandMax, bandMax)
+		}
+	}
+	if !withCounts {
+		gapMarked.Counts = series.Counts
+	}
+	if !withBand {
+		gapMarked.BandMin, gapMarked.BandMax = series.BandMin, series.BandMax
+	}
+	return &gapMarked
+}
@@ -0,0 +1,56 @@
+package querier
+
+import (
+	"slices"
+	"strconv"
+
+	"go.signoz.io/signoz/pkg/query-service/constants"
+	v3 "go.signoz.io/signoz/pkg/query-service/model/v3"
+)
+
+// attachHeatmaps converts the series of the heatmap queries, a series per value
+// bucket labelled with the index of the bucket, into the heatmaps of their results
+func attachHeatmaps(results []*v3.Result, builderQueries map[string]*v3.BuilderQuery) {
+	for _, result := range results {
+		builderQuery, ok := builderQueries[result.QueryName]
+		if !ok || builderQuery.Heatmap == nil {
+			continue
+		}
+		result.Heatmap = seriesToHeatmap(result.Series, builderQuery.Heatmap)
+		result.Series = nil
+	}
+}
+
+// seriesToHeatmap returns the counts of the value buckets at each timestamp of the
+// series, the value buckets without rows at a timestamp have a zero count
+func seriesToHeatmap(seriesList []*v3.Series, buckets *v3.HeatmapBuckets) *v3.Heatmap {
+	countsByTimestamp := make(map[int64][]int64)
+	for _, series := range seriesList {
+		bucket, err := strconv.Atoi(series.Labels[constants.ResultHeatmapBucketColumn])
+		if err != nil || bucket < 0 || bucket >= buckets.Count {
+			continue
+		}
+		for _, point := range series.Points {
+			counts, ok := countsByTimestamp[point.Timestamp]
+			if !ok {
+				counts = make([]int64, buckets.Count)
+				countsByTimestamp[point.Timestamp] = counts
+			}
+			counts[bucket] += int64(point.Value)
+		}
+	}
+
+	heatmap := &v3.Heatmap{
+		Timestamps: make([]int64, 0, len(countsByTimestamp)),
+		Bounds:     buckets.Bounds(),
+		Counts:     make([][]int64, 0, len(countsByTimestamp)),
+	}
+	for timestamp := range countsByTimestamp {
+		heatmap.Timestamps = append(heatmap.Timestamps, timestamp)
+	}
+	slices.Sort(heatmap.Timestamps)
+	for _, timestamp := range heatmap.Timestamps {
+		heatmap.Counts = append(heatmap.Counts, countsByTimestamp[timestamp])
+	}
+	return heatmap
+}
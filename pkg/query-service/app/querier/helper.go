@@ -8,8 +8,10 @@ import (
 	"sync"
 	"time"
 
+	"go.opentelemetry.io/otel/trace"
 	logsV3 "go.signoz.io/signoz/pkg/query-service/app/logs/v3"
 	metricsV3 "go.signoz.io/signoz/pkg/query-service/app/metrics/v3"
+	"go.signoz.io/signoz/pkg/query-service/app/queryBuilder"
 	tracesV3 "go.signoz.io/signoz/pkg/query-service/app/traces/v3"
 	"go.signoz.io/signoz/pkg/query-service/cache/status"
 	"go.signoz.io/signoz/pkg/query-service/constants"
@@ -85,6 +87,18 @@ func (q *querier) runBuilderQuery(
 	defer wg.Done()
 	queryName := builderQuery.QueryName
 
+	ctx, span := q.tracer.Start(ctx, "runBuilderQuery", trace.WithAttributes(
+		attrQueryName.String(queryName),
+		attrQueryType.String(string(builderQuery.DataSource)),
+	))
+	defer span.End()
+
+	// the query can't match any row, the reader is not called
+	if queryBuilder.ContradictoryFilters(builderQuery.Filters) {
+		ch <- channelResult{Name: queryName, Series: []*v3.Series{}}
+		return
+	}
+
 	var preferRPM bool
 
 	if q.featureLookUp != nil {
@@ -97,6 +111,8 @@ func (q *querier) runBuilderQuery(
 		start = start - builderQuery.ShiftBy*1000
 		end = end - builderQuery.ShiftBy*1000
 	}
+	// the step intervals of the trailing window of the first point are fetched too
+	start -= trailingWindowLookBack(builderQuery)
 
 	if builderQuery.DataSource == v3.DataSourceLogs {
 		var query string
@@ -114,17 +130,23 @@ func (q *querier) runBuilderQuery(
 
 		cacheKey := cacheKeys[queryName]
 		var cachedData []byte
+		retrieveStatus := status.RetrieveStatusKeyMiss
 		if !params.NoCache && q.cache != nil {
-			var retrieveStatus status.RetrieveStatus
-			data, retrieveStatus, err := q.cache.Retrieve(cacheKey, true)
+			var data []byte
+			var err error
+			data, retrieveStatus, err = q.retrieveCachedSeries(ctx, queryName, cacheKey)
 			zap.L().Info("cache retrieve status", zap.String("status", retrieveStatus.String()))
+			span.SetAttributes(attrCacheStatus.String(retrieveStatus.String()))
 			if err == nil {
 				cachedData = data
 			}
 		}
-		misses, replaceCachedData := q.findMissingTimeRanges(start, end, builderQuery.StepInterval, cachedData)
+		if err := q.checkKeyCollision(cacheKey, builderQueryParamsHash(builderQuery)); err != nil && cachedData != nil {
+			ch <- channelResult{Err: err, Name: queryName, Query: query, Series: nil}
+			return
+		}
+		cachedSeries, misses, replaceCachedData := q.findMissingTimeRanges(ctx, cacheKey, start, end, builderQuery.StepInterval, builderQuery.AlignmentOffset, cachedData)
 		missedSeries := make([]*v3.Series, 0)
-		cachedSeries := make([]*v3.Series, 0)
 		for _, miss := range misses {
 			query, err = prepareLogsQuery(ctx, miss.start, miss.end, builderQuery, params, preferRPM)
 			if err != nil {
@@ -143,10 +165,16 @@ func (q *querier) runBuilderQuery(
 			}
 			missedSeries = append(missedSeries, series...)
 		}
-		if err := json.Unmarshal(cachedData, &cachedSeries); err != nil && cachedData != nil {
-			zap.L().Error("error unmarshalling cached data", zap.Error(err))
+		if len(misses) > 0 && len(cachedSeries) > 0 && !replaceCachedData && q.cacheAuditSampled() {
+			q.auditCachedSeries(ctx, cacheKey, cachedSeries, builderQuery.StepInterval, func(ctx context.Context, start, end int64) ([]*v3.Series, error) {
+				query, err := prepareLogsQuery(ctx, start, end, builderQuery, params, preferRPM)
+				if err != nil {
+					return nil, err
+				}
+				return q.execClickHouseQuery(ctx, query)
+			})
 		}
-		mergedSeries := mergeSerieses(cachedSeries, missedSeries)
+		mergedSeries := q.mergeCachedSeries(cachedSeries, missedSeries, builderQuery.StepInterval)
 		if replaceCachedData {
 			mergedSeries = missedSeries
 		}
@@ -156,19 +184,20 @@ func (q *querier) runBuilderQuery(
 		missedSeriesLen := len(missedSeries)
 		if missedSeriesLen > 0 && !params.NoCache && q.cache != nil {
 			// caching the data
-			mergedSeriesData, marshallingErr = json.Marshal(mergedSeries)
+			mergedSeriesData, marshallingErr = json.Marshal(q.excludeFluxTail(mergedSeries, builderQuery.StepInterval, builderQuery.AlignmentOffset))
 			if marshallingErr != nil {
 				zap.L().Error("error marshalling merged series", zap.Error(marshallingErr))
 			}
 		}
 
 		// response doesn't need everything
-		filterCachedPoints(mergedSeries, start, end)
+		filterCachedPoints(mergedSeries, start, end, !q.strictTimeRangeFilter)
 
 		ch <- channelResult{
-			Err:    nil,
-			Name:   queryName,
-			Series: mergedSeries,
+			Err:        nil,
+			Name:       queryName,
+			Series:     mergedSeries,
+			CacheStats: q.cacheStats(params, retrieveStatus, start, end, misses),
 		}
 
 		// Cache the seriesList for future queries
@@ -179,6 +208,7 @@ func (q *querier) runBuilderQuery(
 				zap.L().Error("error storing merged series", zap.Error(err))
 				return
 			}
+			q.storeParamsHash(cacheKey, builderQueryParamsHash(builderQuery))
 		}
 
 		return
@@ -252,17 +282,23 @@ func (q *querier) runBuilderQuery(
 
 	cacheKey := cacheKeys[queryName]
 	var cachedData []byte
+	retrieveStatus := status.RetrieveStatusKeyMiss
 	if !params.NoCache && q.cache != nil {
-		var retrieveStatus status.RetrieveStatus
-		data, retrieveStatus, err := q.cache.Retrieve(cacheKey, true)
+		var data []byte
+		var err error
+		data, retrieveStatus, err = q.retrieveCachedSeries(ctx, queryName, cacheKey)
 		zap.L().Info("cache retrieve status", zap.String("status", retrieveStatus.String()))
+		span.SetAttributes(attrCacheStatus.String(retrieveStatus.String()))
 		if err == nil {
 			cachedData = data
 		}
 	}
-	misses, replaceCachedData := q.findMissingTimeRanges(start, end, builderQuery.StepInterval, cachedData)
+	if err := q.checkKeyCollision(cacheKey, builderQueryParamsHash(builderQuery)); err != nil && cachedData != nil {
+		ch <- channelResult{Err: err, Name: queryName, Series: nil}
+		return
+	}
+	cachedSeries, misses, replaceCachedData := q.findMissingTimeRanges(ctx, cacheKey, start, end, builderQuery.StepInterval, builderQuery.AlignmentOffset, cachedData)
 	missedSeries := make([]*v3.Series, 0)
-	cachedSeries := make([]*v3.Series, 0)
 	for _, miss := range misses {
 		query, err := metricsV3.PrepareMetricQuery(
 			miss.start,
@@ -293,10 +329,16 @@ func (q *querier) runBuilderQuery(
 		}
 		missedSeries = append(missedSeries, series...)
 	}
-	if err := json.Unmarshal(cachedData, &cachedSeries); err != nil && cachedData != nil {
-		zap.L().Error("error unmarshalling cached data", zap.Error(err))
+	if len(misses) > 0 && len(cachedSeries) > 0 && !replaceCachedData && q.cacheAuditSampled() {
+		q.auditCachedSeries(ctx, cacheKey, cachedSeries, builderQuery.StepInterval, func(ctx context.Context, start, end int64) ([]*v3.Series, error) {
+			query, err := metricsV3.PrepareMetricQuery(start, end, params.CompositeQuery.QueryType, params.CompositeQuery.PanelType, builderQuery, metricsV3.Options{})
+			if err != nil {
+				return nil, err
+			}
+			return q.execClickHouseQuery(ctx, query)
+		})
 	}
-	mergedSeries := mergeSerieses(cachedSeries, missedSeries)
+	mergedSeries := q.mergeCachedSeries(cachedSeries, missedSeries, builderQuery.StepInterval)
 	if replaceCachedData {
 		mergedSeries = missedSeries
 	}
@@ -305,18 +347,19 @@ func (q *querier) runBuilderQuery(
 	missedSeriesLen := len(missedSeries)
 	if missedSeriesLen > 0 && !params.NoCache && q.cache != nil {
 		// caching the data
-		mergedSeriesData, marshallingErr = json.Marshal(mergedSeries)
+		mergedSeriesData, marshallingErr = json.Marshal(q.excludeFluxTail(mergedSeries, builderQuery.StepInterval, builderQuery.AlignmentOffset))
 		if marshallingErr != nil {
 			zap.L().Error("error marshalling merged series", zap.Error(marshallingErr))
 		}
 	}
 
 	// response doesn't need everything
-	filterCachedPoints(mergedSeries, start, end)
+	filterCachedPoints(mergedSeries, start, end, !q.strictTimeRangeFilter)
 	ch <- channelResult{
-		Err:    nil,
-		Name:   queryName,
-		Series: mergedSeries,
+		Err:        nil,
+		Name:       queryName,
+		Series:     mergedSeries,
+		CacheStats: q.cacheStats(params, retrieveStatus, start, end, misses),
 	}
 
 	// Cache the seriesList for future queries
@@ -326,6 +369,7 @@ func (q *querier) runBuilderQuery(
 			zap.L().Error("error storing merged series", zap.Error(err))
 			return
 		}
+		q.storeParamsHash(cacheKey, builderQueryParamsHash(builderQuery))
 	}
 }
 
@@ -342,6 +386,9 @@ func (q *querier) runBuilderExpression(
 
 	queryName := builderQuery.QueryName
 
+	ctx, span := q.tracer.Start(ctx, "runBuilderExpression", trace.WithAttributes(attrQueryName.String(queryName)))
+	defer span.End()
+
 	queries, err := q.builder.PrepareQueries(params, keys)
 	if err != nil {
 		ch <- channelResult{Err: err, Name: queryName, Query: "", Series: nil}
@@ -357,18 +404,20 @@ func (q *querier) runBuilderExpression(
 
 	cacheKey := cacheKeys[queryName]
 	var cachedData []byte
+	retrieveStatus := status.RetrieveStatusKeyMiss
 	if !params.NoCache && q.cache != nil {
-		var retrieveStatus status.RetrieveStatus
-		data, retrieveStatus, err := q.cache.Retrieve(cacheKey, true)
+		var data []byte
+		var err error
+		data, retrieveStatus, err = q.retrieveCachedSeries(ctx, queryName, cacheKey)
 		zap.L().Info("cache retrieve status", zap.String("status", retrieveStatus.String()))
+		span.SetAttributes(attrCacheStatus.String(retrieveStatus.String()))
 		if err == nil {
 			cachedData = data
 		}
 	}
 	step := postprocess.StepIntervalForFunction(params, queryName)
-	misses, replaceCachedData := q.findMissingTimeRanges(params.Start, params.End, step, cachedData)
+	cachedSeries, misses, replaceCachedData := q.findMissingTimeRanges(ctx, cacheKey, params.Start, params.End, step, 0, cachedData)
 	missedSeries := make([]*v3.Series, 0)
-	cachedSeries := make([]*v3.Series, 0)
 	for _, miss := range misses {
 		missQueries, _ := q.builder.PrepareQueries(&v3.QueryRangeParamsV3{
 			Start:          miss.start,
@@ -386,10 +435,7 @@ func (q *querier) runBuilderExpression(
 		}
 		missedSeries = append(missedSeries, series...)
 	}
-	if err := json.Unmarshal(cachedData, &cachedSeries); err != nil && cachedData != nil {
-		zap.L().Error("error unmarshalling cached data", zap.Error(err))
-	}
-	mergedSeries := mergeSerieses(cachedSeries, missedSeries)
+	mergedSeries := q.mergeCachedSeries(cachedSeries, missedSeries, step)
 	if replaceCachedData {
 		mergedSeries = missedSeries
 	}
@@ -399,18 +445,19 @@ func (q *querier) runBuilderExpression(
 	var marshallingErr error
 	if missedSeriesLen > 0 && !params.NoCache && q.cache != nil {
 		// caching the data
-		mergedSeriesData, marshallingErr = json.Marshal(mergedSeries)
+		mergedSeriesData, marshallingErr = json.Marshal(q.excludeFluxTail(mergedSeries, step, 0))
 		if marshallingErr != nil {
 			zap.L().Error("error marshalling merged series", zap.Error(marshallingErr))
 		}
 	}
 
 	// response doesn't need everything
-	filterCachedPoints(mergedSeries, params.Start, params.End)
+	filterCachedPoints(mergedSeries, params.Start, params.End, !q.strictTimeRangeFilter)
 	ch <- channelResult{
-		Err:    nil,
-		Name:   queryName,
-		Series: mergedSeries,
+		Err:        nil,
+		Name:       queryName,
+		Series:     mergedSeries,
+		CacheStats: q.cacheStats(params, retrieveStatus, params.Start, params.End, misses),
 	}
 
 	// Cache the seriesList for future queries
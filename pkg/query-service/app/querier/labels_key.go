@@ -0,0 +1,88 @@
+package querier
+
+import (
+	"maps"
+	"sort"
+
+	"github.com/cespare/xxhash"
+	v3 "go.signoz.io/signoz/pkg/query-service/model/v3"
+)
+
+// SeriesMergeKey is how the series are keyed when the cached and missed
+// series are merged
+type SeriesMergeKey string
+
+const (
+	// SeriesMergeKeyString keys the series by the sorted key=value string of
+	// their labels
+	SeriesMergeKeyString SeriesMergeKey = "string"
+	// SeriesMergeKeyHash keys the series by the xxhash of their sorted labels,
+	// which allocates less for large numbers of series
+	SeriesMergeKeyHash SeriesMergeKey = "hash"
+)
+
+// labelsSep separates the label names and values in the hashed bytes, it
+// can't occur in valid utf-8 so distinct label sets hash distinct bytes
+const labelsSep = '\xff'
+
+// labelsHash returns the xxhash of the sorted labels
+func labelsHash(labels map[string]string, b []byte) (uint64, []byte) {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	b = b[:0]
+	for _, k := range keys {
+		b = append(b, k...)
+		b = append(b, labelsSep)
+		b = append(b, labels[k]...)
+		b = append(b, labelsSep)
+	}
+	return xxhash.Sum64(b), b
+}
+
+// mergeSerieses merges the missed series with the cached series, keyed by
+// the configured strategy
+func (q *querier) mergeSerieses(cachedSeries, missedSeries []*v3.Series) []*v3.Series {
+	if q.seriesMergeKey == SeriesMergeKeyHash {
+		return mergeSeriesesByHash(cachedSeries, missedSeries)
+	}
+	return mergeSerieses(cachedSeries, missedSeries)
+}
+
+// mergeSeriesesByHash is mergeSerieses with the series keyed by the hash of
+// their labels. The series with the same hash are compared by their labels,
+// so a hash collision doesn't merge distinct series.
+func mergeSeriesesByHash(cachedSeries, missedSeries []*v3.Series) []*v3.Series {
+	seriesesByHash := make(map[uint64][]*v3.Series, len(cachedSeries))
+	mergedSeries := make([]*v3.Series, 0, len(cachedSeries))
+	var b []byte
+	var hash uint64
+
+	add := func(series *v3.Series) {
+		hash, b = labelsHash(series.Labels, b)
+		for _, existing := range seriesesByHash[hash] {
+			if maps.Equal(existing.Labels, series.Labels) {
+				existing.Points = append(existing.Points, series.Points...)
+				return
+			}
+		}
+		seriesesByHash[hash] = append(seriesesByHash[hash], series)
+		mergedSeries = append(mergedSeries, series)
+	}
+	for _, series := range cachedSeries {
+		add(series)
+	}
+	for _, series := range missedSeries {
+		add(series)
+	}
+
+	// Sort the points in each series by timestamp
+	for _, series := range mergedSeries {
+		series.SortPoints()
+		series.RemoveDuplicatePoints()
+	}
+	return mergedSeries
+}
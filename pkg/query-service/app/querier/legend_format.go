@@ -0,0 +1,48 @@
+package querier
+
+import (
+	"strings"
+
+	v3 "go.signoz.io/signoz/pkg/query-service/model/v3"
+)
+
+// applyLegendFormats names the series of the builder queries with a legend format
+// from their labels. The named series are copies, the merged series are shared with
+// the cache
+func applyLegendFormats(results []*v3.Result, builderQueries map[string]*v3.BuilderQuery) {
+	for _, result := range results {
+		builderQuery, ok := builderQueries[result.QueryName]
+		if !ok || builderQuery.LegendFormat == "" {
+			continue
+		}
+		seriesList := make([]*v3.Series, 0, len(result.Series))
+		for _, series := range result.Series {
+			named := *series
+			named.Name = renderLegendFormat(builderQuery.LegendFormat, series.Labels)
+			seriesList = append(seriesList, &named)
+		}
+		result.Series = seriesList
+	}
+}
+
+// renderLegendFormat replaces the {label} placeholders of the format with the
+// values of the labels, the missing labels with empty strings. An unclosed {
+// is kept as is
+func renderLegendFormat(format string, labels map[string]string) string {
+	var name strings.Builder
+	for {
+		open := strings.IndexByte(format, '{')
+		if open < 0 {
+			break
+		}
+		closing := strings.IndexByte(format[open:], '}')
+		if closing < 0 {
+			break
+		}
+		name.WriteString(format[:open])
+		name.WriteString(labels[strings.TrimSpace(format[open+1:open+closing])])
+		format = format[open+closing+1:]
+	}
+	name.WriteString(format)
+	return name.String()
+}
@@ -0,0 +1,98 @@
+package querier
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	v3 "go.signoz.io/signoz/pkg/query-service/model/v3"
+)
+
+// mergeListResults merges the rows of the list results into a single result,
+// ordered by the timestamp of the merge and limited to the merge limit. The rows
+// keep the name of the query they are from
+func mergeListResults(results []*v3.Result, merge *v3.ListMerge) *v3.Result {
+	// ordered by name so that the rows with the same timestamp are in a stable order
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].QueryName < results[j].QueryName
+	})
+
+	queryNames := make([]string, 0, len(results))
+	var rows []*v3.Row
+	for _, result := range results {
+		queryNames = append(queryNames, result.QueryName)
+		for _, row := range result.List {
+			rows = append(rows, &v3.Row{Timestamp: row.Timestamp, Data: row.Data, QueryName: result.QueryName})
+		}
+	}
+
+	timestamps := make(map[*v3.Row]time.Time, len(rows))
+	for _, row := range rows {
+		if timestamp, ok := rowTimestamp(row, merge.TimestampField); ok {
+			timestamps[row] = timestamp
+		}
+	}
+	sort.SliceStable(rows, func(i, j int) bool {
+		ti, iok := timestamps[rows[i]]
+		tj, jok := timestamps[rows[j]]
+		// the rows without the timestamp are last
+		if !iok || !jok {
+			return iok && !jok
+		}
+		if merge.Order == "asc" {
+			return ti.Before(tj)
+		}
+		return ti.After(tj)
+	})
+	if merge.Limit > 0 && len(rows) > merge.Limit {
+		rows = rows[:merge.Limit]
+	}
+
+	queryName := merge.QueryName
+	if queryName == "" {
+		queryName = strings.Join(queryNames, ",")
+	}
+	return &v3.Result{QueryName: queryName, List: rows, Total: sumTotals(results)}
+}
+
+// rowTimestamp returns the timestamp of the row, read from the field of the row data
+// if set. The numeric fields are unix nanoseconds like the logs timestamp
+func rowTimestamp(row *v3.Row, field string) (time.Time, bool) {
+	if field == "" {
+		return row.Timestamp, true
+	}
+	value, ok := row.Data[field]
+	if !ok || value == nil {
+		return time.Time{}, false
+	}
+	// the list rows hold pointers to the scanned values
+	v := reflect.Indirect(reflect.ValueOf(value))
+	if !v.IsValid() {
+		return time.Time{}, false
+	}
+	if timestamp, ok := v.Interface().(time.Time); ok {
+		return timestamp, true
+	}
+	switch v.Kind() {
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		return time.Unix(0, v.Int()), true
+	case reflect.Uint, reflect.Uint32, reflect.Uint64:
+		return time.Unix(0, int64(v.Uint())), true
+	case reflect.Float32, reflect.Float64:
+		return time.Unix(0, int64(v.Float())), true
+	}
+	return time.Time{}, false
+}
+
+// sumTotals returns the sum of the totals of the results, if all of them are set
+func sumTotals(results []*v3.Result) *int64 {
+	var total int64
+	for _, result := range results {
+		if result.Total == nil {
+			return nil
+		}
+		total += *result.Total
+	}
+	return &total
+}
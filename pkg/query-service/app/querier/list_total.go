@@ -0,0 +1,103 @@
+package querier
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"time"
+
+	v3 "go.signoz.io/signoz/pkg/query-service/model/v3"
+	"go.uber.org/zap"
+)
+
+const defaultListTotalTTL = time.Minute
+
+// listTotalParams returns the params counting the rows matching each of the list
+// queries, built as table panel count queries with the filters of the list queries
+func listTotalParams(params *v3.QueryRangeParamsV3) *v3.QueryRangeParamsV3 {
+	countQueries := make(map[string]*v3.BuilderQuery, len(params.CompositeQuery.BuilderQueries))
+	for queryName, builderQuery := range params.CompositeQuery.BuilderQueries {
+		countQuery := *builderQuery
+		countQuery.AggregateOperator = v3.AggregateOperatorCount
+		countQuery.AggregateAttribute = v3.AttributeKey{}
+		countQuery.GroupBy = nil
+		countQuery.OrderBy = nil
+		countQuery.Having = nil
+		countQuery.SelectColumns = nil
+		countQuery.Functions = nil
+		countQuery.Limit = 0
+		countQuery.Offset = 0
+		countQuery.PageSize = 0
+		countQueries[queryName] = &countQuery
+	}
+	return &v3.QueryRangeParamsV3{
+		Start:     params.Start,
+		End:       params.End,
+		Step:      params.Step,
+		Variables: params.Variables,
+		NoCache:   params.NoCache,
+		CompositeQuery: &v3.CompositeQuery{
+			QueryType:      v3.QueryTypeBuilder,
+			PanelType:      v3.PanelTypeTable,
+			BuilderQueries: countQueries,
+		},
+	}
+}
+
+// attachListTotals counts the rows matching each of the list queries and sets the
+// totals of the results. The counts are cached for the list total ttl, so that paging
+// through the same list counts the rows once. A failed count leaves the total unset
+func (q *querier) attachListTotals(ctx context.Context, params *v3.QueryRangeParamsV3, keys map[string]v3.AttributeKey, results []*v3.Result) {
+	countParams := listTotalParams(params)
+	queries, err := q.builder.PrepareQueries(countParams, keys)
+	if err != nil {
+		zap.L().Error("error preparing list total queries", zap.Error(err))
+		return
+	}
+
+	for _, result := range results {
+		query, ok := queries[result.QueryName]
+		if !ok {
+			continue
+		}
+		total, err := q.listTotal(ctx, query, params.NoCache)
+		if err != nil {
+			zap.L().Error("error counting list total", zap.String("queryName", result.QueryName), zap.Error(err))
+			continue
+		}
+		result.Total = &total
+	}
+}
+
+// listTotal returns the count of the count query, from the cache if it was counted
+// within the list total ttl
+func (q *querier) listTotal(ctx context.Context, query string, noCache bool) (int64, error) {
+	sum := sha256.Sum256([]byte(query))
+	cacheKey := "list_total:" + hex.EncodeToString(sum[:])
+	useCache := !noCache && q.cache != nil
+
+	if useCache {
+		if data, _, err := q.cache.Retrieve(cacheKey, false); err == nil && data != nil {
+			if total, err := strconv.ParseInt(string(data), 10, 64); err == nil {
+				return total, nil
+			}
+		}
+	}
+
+	seriesList, err := q.execClickHouseQuery(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+	var total int64
+	if len(seriesList) > 0 && len(seriesList[0].Points) > 0 {
+		total = int64(seriesList[0].Points[0].Value)
+	}
+
+	if useCache {
+		if err := q.cache.Store(cacheKey, []byte(strconv.FormatInt(total, 10)), q.listTotalTTL); err != nil {
+			zap.L().Error("error storing list total", zap.Error(err))
+		}
+	}
+	return total, nil
+}
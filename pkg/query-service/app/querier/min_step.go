@@ -0,0 +1,38 @@
+package querier
+
+import (
+	"fmt"
+
+	v3 "go.signoz.io/signoz/pkg/query-service/model/v3"
+)
+
+// enforceMinStep raises the steps of the builder and prom queries below the min
+// step to the min step, and returns a note for each query whose step was raised
+func (q *querier) enforceMinStep(params *v3.QueryRangeParamsV3) map[string]string {
+	minStep := int64(q.minStep.Seconds())
+	if minStep <= 0 || params.CompositeQuery == nil {
+		return nil
+	}
+	note := func(step int64) string {
+		return fmt.Sprintf("the step of %ds was raised to the min step of %ds", step, minStep)
+	}
+
+	notes := make(map[string]string)
+	switch params.CompositeQuery.QueryType {
+	case v3.QueryTypeBuilder:
+		for queryName, builderQuery := range params.CompositeQuery.BuilderQueries {
+			if builderQuery.StepInterval < minStep {
+				notes[queryName] = note(builderQuery.StepInterval)
+				builderQuery.StepInterval = minStep
+			}
+		}
+	case v3.QueryTypePromQL:
+		if params.Step < minStep {
+			for queryName := range params.CompositeQuery.PromQueries {
+				notes[queryName] = note(params.Step)
+			}
+			params.Step = minStep
+		}
+	}
+	return notes
+}
@@ -0,0 +1,78 @@
+package querier
+
+import (
+	"context"
+	"sync"
+
+	metricsV3 "go.signoz.io/signoz/pkg/query-service/app/metrics/v3"
+	v3 "go.signoz.io/signoz/pkg/query-service/model/v3"
+)
+
+const defaultMaxConcurrentMissFetches = 4
+
+// fetchPromMisses fetches the misses of the prom query, at most maxConcurrentMissFetches
+// at a time. The series are returned in the order of the misses. The first failed fetch
+// cancels the others, its error and the query it ran are returned
+func (q *querier) fetchPromMisses(ctx context.Context, promQuery *v3.PromQuery, step int64, misses []missInterval) ([]*v3.Series, string, error) {
+	if len(misses) <= 1 || q.maxConcurrentMissFetches == 1 {
+		missedSeries := make([]*v3.Series, 0)
+		for _, miss := range misses {
+			query := metricsV3.BuildPromQuery(promQuery, step, miss.start, miss.end)
+			series, err := q.execPromQuery(ctx, query)
+			if err != nil {
+				return nil, query.Query, err
+			}
+			missedSeries = append(missedSeries, series...)
+		}
+		return missedSeries, "", nil
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg       sync.WaitGroup
+		once     sync.Once
+		firstErr error
+		errQuery string
+	)
+	seriesByMiss := make([][]*v3.Series, len(misses))
+	sem := make(chan struct{}, q.maxConcurrentMissFetches)
+	for idx, miss := range misses {
+		wg.Add(1)
+		go func(idx int, miss missInterval) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			// the query is not run once another miss failed
+			if ctx.Err() != nil {
+				return
+			}
+			query := metricsV3.BuildPromQuery(promQuery, step, miss.start, miss.end)
+			series, err := q.execPromQuery(ctx, query)
+			if err != nil {
+				once.Do(func() {
+					firstErr, errQuery = err, query.Query
+					cancel()
+				})
+				return
+			}
+			seriesByMiss[idx] = series
+		}(idx, miss)
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, errQuery, firstErr
+	}
+	// the request itself was cancelled before all the misses were fetched
+	if err := ctx.Err(); err != nil {
+		return nil, promQuery.Query, err
+	}
+
+	missedSeries := make([]*v3.Series, 0)
+	for _, series := range seriesByMiss {
+		missedSeries = append(missedSeries, series...)
+	}
+	return missedSeries, "", nil
+}
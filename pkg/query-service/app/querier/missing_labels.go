@@ -0,0 +1,55 @@
+package querier
+
+import (
+	v3 "go.signoz.io/signoz/pkg/query-service/model/v3"
+)
+
+// fillMissingGroupByLabels sets the group by labels missing from the series of the
+// builder queries to the placeholder. It is applied to the returned series after
+// they are merged and cached, so the placeholder never ends up in the cache and
+// can't be merged with a series that has the placeholder as a real value.
+// The labels are replaced, not modified in place, as they might be shared with
+// the series being cached
+func fillMissingGroupByLabels(results []*v3.Result, builderQueries map[string]*v3.BuilderQuery, placeholder string) {
+	for _, result := range results {
+		builderQuery, ok := builderQueries[result.QueryName]
+		if !ok || len(builderQuery.GroupBy) == 0 {
+			continue
+		}
+		for _, series := range result.Series {
+			var missing []string
+			for _, groupBy := range builderQuery.GroupBy {
+				if value, ok := series.Labels[groupBy.Key]; !ok || value == "" {
+					missing = append(missing, groupBy.Key)
+				}
+			}
+			if len(missing) == 0 {
+				continue
+			}
+
+			labels := make(map[string]string, len(series.Labels)+len(missing))
+			for key, value := range series.Labels {
+				labels[key] = value
+			}
+			labelsArray := make([]map[string]string, 0, len(series.LabelsArray)+len(missing))
+			for _, item := range series.LabelsArray {
+				filledItem := make(map[string]string, len(item))
+				for key, value := range item {
+					if value == "" {
+						value = placeholder
+					}
+					filledItem[key] = value
+				}
+				labelsArray = append(labelsArray, filledItem)
+			}
+			for _, key := range missing {
+				if _, ok := labels[key]; !ok {
+					labelsArray = append(labelsArray, map[string]string{key: placeholder})
+				}
+				labels[key] = placeholder
+			}
+			series.Labels = labels
+			series.LabelsArray = labelsArray
+		}
+	}
+}
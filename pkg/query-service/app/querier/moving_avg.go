@@ -0,0 +1,73 @@
+package querier
+
+import (
+	"maps"
+	"math"
+	"slices"
+	"strconv"
+
+	v3 "go.signoz.io/signoz/pkg/query-service/model/v3"
+)
+
+// smoothSeries applies the moving average of the builder queries with MovingAvg
+// to their returned series, either in place of the series or as companion series.
+// The smoothed series are new series, the merged series are shared with the cache
+func smoothSeries(results []*v3.Result, builderQueries map[string]*v3.BuilderQuery) {
+	for _, result := range results {
+		builderQuery, ok := builderQueries[result.QueryName]
+		if !ok || builderQuery.MovingAvg == nil {
+			continue
+		}
+		movingAvg := builderQuery.MovingAvg
+		seriesList := make([]*v3.Series, 0, len(result.Series))
+		var companions []*v3.Series
+		for _, series := range result.Series {
+			smoothed := *series
+			smoothed.Points = movingAvgPoints(series.Points, movingAvg.Window)
+			if !movingAvg.Companion {
+				seriesList = append(seriesList, &smoothed)
+				continue
+			}
+			smoothed.Labels = maps.Clone(series.Labels)
+			if smoothed.Labels == nil {
+				smoothed.Labels = map[string]string{}
+			}
+			smoothed.Labels[v3.MovingAvgLabel] = strconv.Itoa(movingAvg.Window)
+			smoothed.LabelsArray = append(slices.Clone(series.LabelsArray), map[string]string{v3.MovingAvgLabel: smoothed.Labels[v3.MovingAvgLabel]})
+			// the companion only carries the smoothed values
+			smoothed.Counts, smoothed.BandMin, smoothed.BandMax = nil, nil, nil
+			seriesList = append(seriesList, series)
+			companions = append(companions, &smoothed)
+		}
+		result.Series = append(seriesList, companions...)
+	}
+}
+
+// movingAvgPoints returns the points with each value replaced by the average of
+// the values of the window of points ending at it. The points at the start, with
+// fewer points before them than the window, are averaged over the points available,
+// and the NaN values are left out of the averages
+func movingAvgPoints(points []v3.Point, window int) []v3.Point {
+	smoothed := make([]v3.Point, len(points))
+	var sum float64
+	var count int
+	for idx, point := range points {
+		if !math.IsNaN(point.Value) {
+			sum += point.Value
+			count++
+		}
+		if idx >= window {
+			if dropped := points[idx-window].Value; !math.IsNaN(dropped) {
+				sum -= dropped
+				count--
+			}
+		}
+		smoothed[idx] = point
+		if count == 0 {
+			smoothed[idx].Value = math.NaN()
+		} else {
+			smoothed[idx].Value = sum / float64(count)
+		}
+	}
+	return smoothed
+}
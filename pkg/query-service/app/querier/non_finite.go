@@ -0,0 +1,55 @@
+package querier
+
+import (
+	"math"
+	"slices"
+
+	v3 "go.signoz.io/signoz/pkg/query-service/model/v3"
+)
+
+// NonFiniteValues is how the NaN and Inf values of the fetched points are handled
+type NonFiniteValues string
+
+const (
+	// NonFiniteValuesNull turns the points with a NaN or Inf value into null
+	// points, encoded as a null value in the cached series and the responses
+	NonFiniteValuesNull NonFiniteValues = "null"
+	// NonFiniteValuesKeep keeps the points, the values are encoded as the
+	// strings "NaN", "+Inf" and "-Inf" like in the prometheus API
+	NonFiniteValuesKeep NonFiniteValues = "keep"
+	// NonFiniteValuesDrop drops the points with a NaN or Inf value
+	NonFiniteValuesDrop NonFiniteValues = "drop"
+)
+
+// isNonFinite reports whether the value of the point is NaN or Inf
+func isNonFinite(point v3.Point) bool {
+	return math.IsNaN(point.Value) || math.IsInf(point.Value, 0)
+}
+
+// normalizeNonFiniteValues handles the NaN and Inf values of the points of
+// the series, before they are merged and cached
+func (q *querier) normalizeNonFiniteValues(seriesList []*v3.Series) {
+	switch q.nonFiniteValues {
+	case NonFiniteValuesNull:
+		for _, series := range seriesList {
+			if !slices.ContainsFunc(series.Points, isNonFinite) {
+				continue
+			}
+			points := make([]v3.Point, len(series.Points))
+			for idx, point := range series.Points {
+				points[idx] = point
+				if isNonFinite(point) {
+					points[idx] = v3.Point{Timestamp: point.Timestamp, Value: math.NaN(), Null: true}
+				}
+			}
+			series.Points = points
+		}
+	case NonFiniteValuesDrop:
+		for _, series := range seriesList {
+			// the counts and the band are aligned with the points and dropped with them
+			filterPoints(series, func(point v3.Point) bool {
+				return !isNonFinite(point)
+			})
+		}
+	}
+}
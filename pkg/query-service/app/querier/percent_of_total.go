@@ -0,0 +1,51 @@
+package querier
+
+import (
+	"math"
+
+	v3 "go.signoz.io/signoz/pkg/query-service/model/v3"
+)
+
+// percentOfTotal replaces the point values of the series of the builder queries
+// with PercentOfTotal by their percentage of the total of the series at the
+// timestamp. The points are new points, the merged series are shared with the cache
+func percentOfTotal(results []*v3.Result, builderQueries map[string]*v3.BuilderQuery) {
+	for _, result := range results {
+		builderQuery, ok := builderQueries[result.QueryName]
+		if !ok || !builderQuery.PercentOfTotal {
+			continue
+		}
+		result.Series = seriesPercentOfTotal(result.Series)
+	}
+}
+
+// seriesPercentOfTotal returns the series with the point values as percentages of
+// the totals at their timestamps, null points where the total is zero. The NaN
+// values are left out of the totals
+func seriesPercentOfTotal(seriesList []*v3.Series) []*v3.Series {
+	totals := make(map[int64]float64)
+	for _, series := range seriesList {
+		for _, point := range series.Points {
+			if !math.IsNaN(point.Value) {
+				totals[point.Timestamp] += point.Value
+			}
+		}
+	}
+
+	percentSeriesList := make([]*v3.Series, 0, len(seriesList))
+	for _, series := range seriesList {
+		percentSeries := *series
+		percentSeries.Points = make([]v3.Point, len(series.Points))
+		// the band is of the values, not of their percentages
+		percentSeries.BandMin, percentSeries.BandMax = nil, nil
+		for idx, point := range series.Points {
+			if total := totals[point.Timestamp]; total == 0 || math.IsNaN(point.Value) {
+				percentSeries.Points[idx] = v3.Point{Timestamp: point.Timestamp, Value: math.NaN(), Null: true}
+			} else {
+				percentSeries.Points[idx] = v3.Point{Timestamp: point.Timestamp, Value: point.Value / total * 100}
+			}
+		}
+		percentSeriesList = append(percentSeriesList, &percentSeries)
+	}
+	return percentSeriesList
+}
@@ -0,0 +1,50 @@
+package querier
+
+import (
+	v3 "go.signoz.io/signoz/pkg/query-service/model/v3"
+)
+
+// selectPoints keeps the points of the series at the indices, in the order of the
+// indices. The counts and the band aligned with the points are kept with them.
+// The slices are replaced, not modified in place, as they might be shared with
+// the series being cached
+func selectPoints(series *v3.Series, indices []int) {
+	withCounts := len(series.Counts) == len(series.Points)
+	withBand := len(series.BandMin) == len(series.Points) && len(series.BandMax) == len(series.Points)
+	points := make([]v3.Point, 0, len(indices))
+	var counts []int64
+	var bandMin, bandMax []float64
+	for _, idx := range indices {
+		points = append(points, series.Points[idx])
+		if withCounts {
+			counts = append(counts, series.Counts[idx])
+		}
+		if withBand {
+			bandMin = append(bandMin, series.BandMin[idx])
+			bandMax = append(bandMax, series.BandMax[idx])
+		}
+	}
+	series.Points = points
+	if withCounts {
+		series.Counts = counts
+	}
+	if withBand {
+		series.BandMin, series.BandMax = bandMin, bandMax
+	}
+}
+
+// filterPoints keeps the points of the series for which keep returns true, with
+// the counts and the band aligned with them, and returns the number of dropped points
+func filterPoints(series *v3.Series, keep func(v3.Point) bool) int {
+	indices := make([]int, 0, len(series.Points))
+	for idx, point := range series.Points {
+		if keep(point) {
+			indices = append(indices, idx)
+		}
+	}
+	dropped := len(series.Points) - len(indices)
+	if dropped > 0 {
+		selectPoints(series, indices)
+	}
+	return dropped
+}
@@ -1,13 +1,15 @@
 package querier
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
-	"math"
+	"hash/fnv"
 	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	logsV3 "go.signoz.io/signoz/pkg/query-service/app/logs/v3"
@@ -16,7 +18,15 @@ import (
 	tracesV3 "go.signoz.io/signoz/pkg/query-service/app/traces/v3"
 	chErrors "go.signoz.io/signoz/pkg/query-service/errors"
 
+	"github.com/prometheus/prometheus/promql"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 	"go.signoz.io/signoz/pkg/query-service/cache"
+	"go.signoz.io/signoz/pkg/query-service/cache/status"
+	"go.signoz.io/signoz/pkg/query-service/common"
+	"go.signoz.io/signoz/pkg/query-service/constants"
 	"go.signoz.io/signoz/pkg/query-service/interfaces"
 	"go.signoz.io/signoz/pkg/query-service/model"
 	v3 "go.signoz.io/signoz/pkg/query-service/model/v3"
@@ -24,14 +34,31 @@ import (
 	"go.uber.org/zap"
 )
 
+const tracerName = "go.signoz.io/signoz/pkg/query-service/app/querier"
+
+// span attribute keys used by the querier
+const (
+	attrQueryName   = attribute.Key("query.name")
+	attrQueryType   = attribute.Key("query.type")
+	attrPanelType   = attribute.Key("query.panel_type")
+	attrCacheStatus = attribute.Key("cache.status")
+	attrSeriesCount = attribute.Key("result.series_count")
+)
+
 type channelResult struct {
-	Series []*v3.Series
-	List   []*v3.Row
-	Err    error
-	Name   string
-	Query  string
+	Series     []*v3.Series
+	Matrix     promql.Matrix
+	List       []*v3.Row
+	Err        error
+	Name       string
+	Query      string
+	CacheStats *v3.CacheStats
+	QueryID    string
 }
 
+// cacheKeyLockCount is the number of locks the cache keys are spread over
+const cacheKeyLockCount = 64
+
 type missInterval struct {
 	start, end int64 // in milliseconds
 }
@@ -42,13 +69,100 @@ type querier struct {
 	keyGenerator cache.KeyGenerator
 
 	fluxInterval time.Duration
+	// fluxIntervalSteps is the flux interval as a number of steps of the query,
+	// the larger of the two flux intervals is used
+	fluxIntervalSteps int
+	// maxMisses is the max number of misses of a cached query, over which the whole
+	// range is refetched, 0 means no limit
+	maxMisses int
+	// nowFunc is the clock the flux interval is measured against
+	nowFunc func() time.Time
 
 	builder       *queryBuilder.QueryBuilder
 	featureLookUp interfaces.FeatureLookup
 
+	tracer trace.Tracer
+
+	// staleWhileRevalidate serves cached series immediately when the only misses
+	// are the flux tail, and refetches the misses in the background
+	staleWhileRevalidate bool
+	revalidateTimeout    time.Duration
+	// revalidateSem bounds the number of background revalidations in flight
+	revalidateSem chan struct{}
+
+	// maxConcurrentMissFetches bounds the misses of a prom query fetched concurrently
+	maxConcurrentMissFetches int
+	// readerHardTimeout bounds the wait for each reader call, regardless of the context
+	readerHardTimeout time.Duration
+	// queryIDs are the clickhouse query ids issued for the queries, which only
+	// the users they were issued for can cancel
+	queryIDs *common.QueryIDs
+	// listTotalTTL is how long the totals of the list queries are cached
+	listTotalTTL time.Duration
+
+	// strictTimeRangeFilter filters the points with a zero timestamp
+	// outside the requested time range like any other point
+	strictTimeRangeFilter bool
+
+	// maxSeries is the max number of series returned per query of a graph panel,
+	// the series are ranked by maxSeriesRankBy. 0 means no limit
+	maxSeries       int
+	maxSeriesRankBy SeriesRankBy
+
+	// maxLabelValueLength is the max length of the label values returned,
+	// the longer values are truncated. 0 means no limit
+	maxLabelValueLength int
+
+	// maxTimeRanges are the max time ranges of the queries of each data source
+	maxTimeRanges map[v3.DataSource]time.Duration
+
+	// minStep is the floor of the step of the builder and prom queries, 0 means no floor
+	minStep time.Duration
+
+	// alignCacheSeams aligns the first fresh point after the cached points
+	// of a series to the grid of the cached points
+	alignCacheSeams bool
+
+	// cacheTTLPolicy computes the ttl of the cached series of a prom query
+	cacheTTLPolicy CacheTTLPolicy
+
+	// rateLimitBackoff is the initial wait before retrying a rate limited query,
+	// doubled on each retry up to rateLimitMaxBackoff
+	rateLimitBackoff    time.Duration
+	rateLimitMaxBackoff time.Duration
+	rateLimitMaxRetries int
+
+	// cacheAuditSampleRate is the fraction of the cache merges audited against
+	// freshly fetched data, cacheAuditTolerance the relative divergence tolerated
+	cacheAuditSampleRate float64
+	cacheAuditTolerance  float64
+
+	// nonFiniteValues is how the NaN and Inf values of the fetched points are handled
+	nonFiniteValues NonFiniteValues
+
+	// seriesMergeKey is how the series are keyed when merged with the cached series
+	seriesMergeKey SeriesMergeKey
+
+	// maxScannedRows and maxScannedBytes are the scan budget of a request
+	maxScannedRows  uint64
+	maxScannedBytes uint64
+
+	// cacheErrorWarnings notes the cache backend errors in the results,
+	// the failed retrievals are retried cacheRetrieveRetries times
+	cacheErrorWarnings   bool
+	cacheRetrieveRetries int
+	cacheRetrieveBackoff time.Duration
+
+	// cacheKeyLocks serialize the read-modify-write of the cached series,
+	// a key always maps to the same lock
+	cacheKeyLocks [cacheKeyLockCount]sync.Mutex
+
 	// used for testing
 	// TODO(srikanthccv): remove this once we have a proper mock
-	testingMode     bool
+	testingMode bool
+	// mu guards queriesExecuted and timeRanges, which are recorded from
+	// concurrent queries and background revalidations
+	mu              sync.Mutex
 	queriesExecuted []string
 	// tuple of start and end time in milliseconds
 	timeRanges     [][]int
@@ -62,6 +176,108 @@ type QuerierOptions struct {
 	KeyGenerator  cache.KeyGenerator
 	FluxInterval  time.Duration
 	FeatureLookup interfaces.FeatureLookup
+	// TracerProvider is used to create spans around query execution
+	// if not set, the global tracer provider is used
+	TracerProvider trace.TracerProvider
+	// StaleWhileRevalidate returns the cached series immediately when the only
+	// misses are the flux tail, and refetches the misses in the background
+	StaleWhileRevalidate bool
+	// RevalidateTimeout is the timeout for a background revalidation
+	RevalidateTimeout time.Duration
+	// MaxConcurrentRevalidations is the max number of background revalidations in flight
+	MaxConcurrentRevalidations int
+	// ListTotalTTL is how long the total count of the rows of a list query is
+	// cached, defaults to a minute
+	ListTotalTTL time.Duration
+	// ReaderHardTimeout is the max time a reader call is waited for, even if the
+	// reader ignores the context deadline, 0 means no hard timeout
+	ReaderHardTimeout time.Duration
+	// MaxConcurrentMissFetches is the max number of the misses of a prom query
+	// fetched concurrently, 1 fetches them one after the other
+	MaxConcurrentMissFetches int
+	// StrictTimeRangeFilter filters the points with a zero timestamp outside the
+	// requested time range, by default they are always retained
+	StrictTimeRangeFilter bool
+	// MaxSeries truncates the series of each query of a graph panel to the top
+	// MaxSeries series ranked by MaxSeriesRankBy, or to the first MaxSeries series
+	// in the SeriesSort order of the request, 0 means no limit
+	MaxSeries int
+	// MaxSeriesRankBy is the value the series are ranked by when truncating,
+	// defaults to SeriesRankByTotal
+	MaxSeriesRankBy SeriesRankBy
+	// MaxLabelValueLength truncates the label values of the returned series to
+	// MaxLabelValueLength bytes, 0 means no limit. The filters match the full values
+	MaxLabelValueLength int
+	// MaxTimeRanges are the max time ranges the queries of each data source can
+	// span, the queries over longer ranges are rejected with a resource limit
+	// error. The data sources without a max time range are not limited.
+	// PromQL queries are metrics queries, ClickHouse SQL queries are not limited
+	MaxTimeRanges map[v3.DataSource]time.Duration
+	// MaxScannedRows and MaxScannedBytes are the budget of the rows and bytes the
+	// clickhouse queries of a request can read in total, from their progress. The
+	// request is aborted with a resource limit error once either is exceeded. PromQL
+	// queries are not counted, 0 means no budget
+	MaxScannedRows  uint64
+	MaxScannedBytes uint64
+	// MinStep floors the step of the builder and PromQL queries, the queries
+	// requested with a smaller step are run with MinStep, which is noted in their
+	// results. Rounded down to seconds, 0 means no floor
+	MinStep time.Duration
+	// DisableFluxRefetch stops refetching the [End - FluxInterval, End] range of
+	// cached queries, for sources without ingestion lag or settled historical data.
+	// A fully cached window is then a complete hit
+	DisableFluxRefetch bool
+	// FluxIntervalSteps is the flux interval as a number of steps of the query, for
+	// the coarse step queries whose latest bucket is partial for longer than the
+	// FluxInterval. The larger of the two flux intervals is used, 0 means only FluxInterval
+	FluxIntervalSteps int
+	// MaxMisses is the max number of misses of a cached query, a fragmented cache
+	// with more misses is replaced by refetching the whole range in a single query
+	// rather than a query per miss. 0 means no limit
+	MaxMisses int
+	// NowFunc returns the current time the flux interval is measured against,
+	// defaults to time.Now
+	NowFunc func() time.Time
+	// AlignCacheSeams aligns the first fresh point after the cached points of a
+	// series to the step grid when it is less than one step after them
+	AlignCacheSeams bool
+	// CacheTTLPolicy computes the ttl of the cached series of a prom query from
+	// the requested range and step, defaults to DefaultCacheTTLPolicy
+	CacheTTLPolicy CacheTTLPolicy
+
+	// RateLimitBackoff is the initial wait before retrying a query rejected because
+	// too many queries are running, it is doubled on each retry up to RateLimitMaxBackoff
+	RateLimitBackoff    time.Duration
+	RateLimitMaxBackoff time.Duration
+	// RateLimitMaxRetries is the max number of retries of a rate limited query,
+	// a negative value disables the retries
+	RateLimitMaxRetries int
+
+	// CacheAuditSampleRate is the fraction, in [0, 1], of the partial cache hits and
+	// stale revalidations for which the last steps of the cached series are refetched
+	// and compared with the cached values, 0 disables the audit
+	CacheAuditSampleRate float64
+	// CacheAuditTolerance is the relative divergence between the cached and the
+	// refetched values above which a warning is logged, defaults to 1%
+	CacheAuditTolerance float64
+
+	// NonFiniteValues is how the NaN and Inf values of the points fetched from
+	// clickhouse and prometheus are handled, defaults to NonFiniteValuesNull
+	NonFiniteValues NonFiniteValues
+
+	// SeriesMergeKey is how the series are keyed when merged with the cached
+	// series, defaults to SeriesMergeKeyString
+	SeriesMergeKey SeriesMergeKey
+
+	// CacheErrorWarnings notes the cache backend errors in the results of the
+	// queries, which are still run against the database without cached series
+	CacheErrorWarnings bool
+	// CacheRetrieveRetries is the max number of retries of a failed retrieval of
+	// the cached series of a query, 0 means no retry
+	CacheRetrieveRetries int
+	// CacheRetrieveBackoff is the wait before a retry of a failed retrieval,
+	// defaults to 20ms
+	CacheRetrieveBackoff time.Duration
 
 	// used for testing
 	TestingMode    bool
@@ -70,11 +286,87 @@ type QuerierOptions struct {
 }
 
 func NewQuerier(opts QuerierOptions) interfaces.Querier {
+	tracerProvider := opts.TracerProvider
+	if tracerProvider == nil {
+		tracerProvider = otel.GetTracerProvider()
+	}
+
+	revalidateTimeout := opts.RevalidateTimeout
+	if revalidateTimeout == 0 {
+		revalidateTimeout = defaultRevalidateTimeout
+	}
+	maxConcurrentRevalidations := opts.MaxConcurrentRevalidations
+	if maxConcurrentRevalidations == 0 {
+		maxConcurrentRevalidations = defaultMaxConcurrentRevalidations
+	}
+	listTotalTTL := opts.ListTotalTTL
+	if listTotalTTL == 0 {
+		listTotalTTL = defaultListTotalTTL
+	}
+	maxConcurrentMissFetches := opts.MaxConcurrentMissFetches
+	if maxConcurrentMissFetches == 0 {
+		maxConcurrentMissFetches = defaultMaxConcurrentMissFetches
+	}
+
+	maxSeriesRankBy := opts.MaxSeriesRankBy
+	if maxSeriesRankBy == "" {
+		maxSeriesRankBy = SeriesRankByTotal
+	}
+
+	cacheTTLPolicy := opts.CacheTTLPolicy
+	if cacheTTLPolicy == nil {
+		cacheTTLPolicy = DefaultCacheTTLPolicy
+	}
+
+	rateLimitBackoff := opts.RateLimitBackoff
+	if rateLimitBackoff == 0 {
+		rateLimitBackoff = defaultRateLimitBackoff
+	}
+	rateLimitMaxBackoff := opts.RateLimitMaxBackoff
+	if rateLimitMaxBackoff == 0 {
+		rateLimitMaxBackoff = defaultRateLimitMaxBackoff
+	}
+
+	cacheRetrieveBackoff := opts.CacheRetrieveBackoff
+	if cacheRetrieveBackoff == 0 {
+		cacheRetrieveBackoff = defaultCacheRetrieveBackoff
+	}
+	rateLimitMaxRetries := opts.RateLimitMaxRetries
+	if rateLimitMaxRetries == 0 {
+		rateLimitMaxRetries = defaultRateLimitMaxRetries
+	}
+
+	cacheAuditTolerance := opts.CacheAuditTolerance
+	if cacheAuditTolerance == 0 {
+		cacheAuditTolerance = defaultCacheAuditTolerance
+	}
+
+	nonFiniteValues := opts.NonFiniteValues
+	if nonFiniteValues == "" {
+		nonFiniteValues = NonFiniteValuesNull
+	}
+
+	// without a flux interval only the points after now are considered in flux,
+	// and only the not yet cached ranges are fetched
+	fluxInterval, fluxIntervalSteps := opts.FluxInterval, opts.FluxIntervalSteps
+	if opts.DisableFluxRefetch {
+		fluxInterval, fluxIntervalSteps = 0, 0
+	}
+
+	nowFunc := opts.NowFunc
+	if nowFunc == nil {
+		nowFunc = time.Now
+	}
+
 	return &querier{
 		cache:        opts.Cache,
 		reader:       opts.Reader,
 		keyGenerator: opts.KeyGenerator,
-		fluxInterval: opts.FluxInterval,
+		fluxInterval: fluxInterval,
+		nowFunc:      nowFunc,
+
+		fluxIntervalSteps: fluxIntervalSteps,
+		maxMisses:         opts.MaxMisses,
 
 		builder: queryBuilder.NewQueryBuilder(queryBuilder.QueryBuilderOptions{
 			BuildTraceQuery:  tracesV3.PrepareTracesQuery,
@@ -82,6 +374,43 @@ func NewQuerier(opts QuerierOptions) interfaces.Querier {
 			BuildMetricQuery: metricsV3.PrepareMetricQuery,
 		}, opts.FeatureLookup),
 		featureLookUp: opts.FeatureLookup,
+		tracer:        tracerProvider.Tracer(tracerName),
+
+		staleWhileRevalidate: opts.StaleWhileRevalidate,
+		revalidateTimeout:    revalidateTimeout,
+		revalidateSem:        make(chan struct{}, maxConcurrentRevalidations),
+
+		maxConcurrentMissFetches: maxConcurrentMissFetches,
+		readerHardTimeout:        opts.ReaderHardTimeout,
+		queryIDs:                 common.NewQueryIDs(queryIDTTL),
+		listTotalTTL:             listTotalTTL,
+
+		strictTimeRangeFilter: opts.StrictTimeRangeFilter,
+
+		maxSeries:       opts.MaxSeries,
+		maxSeriesRankBy: maxSeriesRankBy,
+		alignCacheSeams: opts.AlignCacheSeams,
+		cacheTTLPolicy:  cacheTTLPolicy,
+
+		maxLabelValueLength: opts.MaxLabelValueLength,
+		maxTimeRanges:       opts.MaxTimeRanges,
+		minStep:             opts.MinStep,
+		maxScannedRows:      opts.MaxScannedRows,
+		maxScannedBytes:     opts.MaxScannedBytes,
+
+		rateLimitBackoff:    rateLimitBackoff,
+		rateLimitMaxBackoff: rateLimitMaxBackoff,
+		rateLimitMaxRetries: rateLimitMaxRetries,
+
+		nonFiniteValues: nonFiniteValues,
+		seriesMergeKey:  opts.SeriesMergeKey,
+
+		cacheAuditSampleRate: opts.CacheAuditSampleRate,
+		cacheAuditTolerance:  cacheAuditTolerance,
+
+		cacheErrorWarnings:   opts.CacheErrorWarnings,
+		cacheRetrieveRetries: opts.CacheRetrieveRetries,
+		cacheRetrieveBackoff: cacheRetrieveBackoff,
 
 		testingMode:    opts.TestingMode,
 		returnedSeries: opts.ReturnedSeries,
@@ -89,47 +418,94 @@ func NewQuerier(opts QuerierOptions) interfaces.Querier {
 	}
 }
 
-func (q *querier) execClickHouseQuery(ctx context.Context, query string) ([]*v3.Series, error) {
+func (q *querier) execClickHouseQuery(ctx context.Context, query string) (result []*v3.Series, err error) {
+	ctx, span := q.tracer.Start(ctx, "execClickHouseQuery")
+	defer func() { endSpan(span, len(result), err) }()
+
+	// the remaining queries are not run once the scan budget is exceeded
+	budget := scanBudgetFrom(ctx)
+	if err := budget.exceeded(); err != nil {
+		return nil, err
+	}
+	q.mu.Lock()
 	q.queriesExecuted = append(q.queriesExecuted, query)
+	q.mu.Unlock()
 	if q.testingMode && q.reader == nil {
 		return q.returnedSeries, q.returnedErr
 	}
-	result, err := q.reader.GetTimeSeriesResultV3(ctx, query)
+	err = q.withRateLimitBackoff(ctx, func() error {
+		start := time.Now()
+		var err error
+		result, err = withReaderHardTimeout(ctx, q.readerHardTimeout, func(ctx context.Context) ([]*v3.Series, error) {
+			return q.reader.GetTimeSeriesResultV3(ctx, query)
+		})
+		recordExecDuration(ctx, start)
+		return err
+	})
+	if budgetErr := budget.exceeded(); err != nil && budgetErr != nil {
+		// the query was aborted by the budget
+		err = budgetErr
+	}
 	var pointsWithNegativeTimestamps int
-	// Filter out the points with negative or zero timestamps
-	for idx := range result {
-		series := result[idx]
-		points := make([]v3.Point, 0)
-		for pointIdx := range series.Points {
-			point := series.Points[pointIdx]
-			if point.Timestamp >= 0 {
-				points = append(points, point)
-			} else {
-				pointsWithNegativeTimestamps++
-			}
-		}
-		series.Points = points
+	// Filter out the points with negative timestamps, with their counts and band
+	for _, series := range result {
+		pointsWithNegativeTimestamps += filterPoints(series, func(point v3.Point) bool {
+			return point.Timestamp >= 0
+		})
 	}
 	if pointsWithNegativeTimestamps > 0 {
 		zap.L().Error("found points with negative timestamps for query", zap.String("query", query))
 	}
+	q.normalizeNonFiniteValues(result)
 	return result, err
 }
 
-func (q *querier) execPromQuery(ctx context.Context, params *model.QueryRangeParams) ([]*v3.Series, error) {
+func (q *querier) execPromQuery(ctx context.Context, params *model.QueryRangeParams) (seriesList []*v3.Series, err error) {
+	ctx, span := q.tracer.Start(ctx, "execPromQuery")
+	defer func() { endSpan(span, len(seriesList), err) }()
+
+	q.mu.Lock()
 	q.queriesExecuted = append(q.queriesExecuted, params.Query)
 	if q.testingMode && q.reader == nil {
 		q.timeRanges = append(q.timeRanges, []int{int(params.Start.UnixMilli()), int(params.End.UnixMilli())})
+		q.mu.Unlock()
 		return q.returnedSeries, q.returnedErr
 	}
-	promResult, _, err := q.reader.GetQueryRangeResult(ctx, params)
+	q.mu.Unlock()
+	matrix, err := q.execPromQueryMatrix(ctx, params)
 	if err != nil {
 		return nil, err
 	}
-	matrix, promErr := promResult.Matrix()
-	if promErr != nil {
-		return nil, promErr
+	seriesList = promMatrixToSeries(matrix)
+	q.normalizeNonFiniteValues(seriesList)
+	return seriesList, nil
+}
+
+// execPromQueryMatrix executes the prom query and returns the native prometheus matrix
+func (q *querier) execPromQueryMatrix(ctx context.Context, params *model.QueryRangeParams) (promql.Matrix, error) {
+	var promResult *promql.Result
+	// the prom queries read the samples from clickhouse, and are rate limited alike
+	err := q.withRateLimitBackoff(ctx, func() error {
+		start := time.Now()
+		var err error
+		promResult, err = withReaderHardTimeout(ctx, q.readerHardTimeout, func(ctx context.Context) (*promql.Result, error) {
+			promResult, _, apiErr := q.reader.GetQueryRangeResult(ctx, params)
+			if apiErr != nil {
+				return nil, apiErr
+			}
+			return promResult, nil
+		})
+		recordExecDuration(ctx, start)
+		return err
+	})
+	if err != nil {
+		return nil, err
 	}
+	return promResult.Matrix()
+}
+
+// promMatrixToSeries converts the float samples of the prometheus matrix to series
+func promMatrixToSeries(matrix promql.Matrix) []*v3.Series {
 	var seriesList []*v3.Series
 	for _, v := range matrix {
 		var s v3.Series
@@ -140,124 +516,111 @@ func (q *querier) execPromQuery(ctx context.Context, params *model.QueryRangePar
 		}
 		seriesList = append(seriesList, &s)
 	}
-	return seriesList, nil
+	return seriesList
+}
+
+// endSpan records the number of series and the error if any on the span and ends it
+func endSpan(span trace.Span, seriesCount int, err error) {
+	span.SetAttributes(attrSeriesCount.Int(seriesCount))
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
 }
 
 // findMissingTimeRanges finds the missing time ranges in the seriesList
-// and returns a list of miss structs, It takes the fluxInterval into
-// account to find the missing time ranges.
-//
-// The [End - fluxInterval, End] is always added to the list of misses, because
-// the data might still be in flux and not yet available in the database.
-//
-// replaceCacheData is used to indicate if the cache data should be replaced instead of merging
-// with the new data
-// TODO: Remove replaceCacheData with a better logic
-func findMissingTimeRanges(start, end, step int64, seriesList []*v3.Series, fluxInterval time.Duration) (misses []missInterval, replaceCacheData bool) {
-	replaceCacheData = false
-	var cachedStart, cachedEnd int64
-	for idx := range seriesList {
-		series := seriesList[idx]
-		for pointIdx := range series.Points {
-			point := series.Points[pointIdx]
-			if cachedStart == 0 || point.Timestamp < cachedStart {
-				cachedStart = point.Timestamp
-			}
-			if cachedEnd == 0 || point.Timestamp > cachedEnd {
-				cachedEnd = point.Timestamp
-			}
-		}
+// and returns a list of miss structs, see common.ComputeMissingRangesAt
+func findMissingTimeRanges(start, end, step, alignmentOffset int64, seriesList []*v3.Series, fluxInterval time.Duration, now time.Time) (misses []missInterval, replaceCacheData bool) {
+	ranges, replaceCacheData := common.ComputeMissingRangesAt(start, end, step, alignmentOffset, seriesList, fluxInterval, now)
+	for _, r := range ranges {
+		misses = append(misses, missInterval{start: r.Start, end: r.End})
 	}
+	return misses, replaceCacheData
+}
 
-	// time.Now is used because here we are considering the case where data might not
-	// be fully ingested for last (fluxInterval) minutes
-	endMillis := time.Now().UnixMilli()
-	adjustStep := int64(math.Min(float64(step), 60))
-	roundedMillis := endMillis - (endMillis % (adjustStep * 1000))
-
-	// Exclude the flux interval from the cached end time
-	cachedEnd = int64(
-		math.Min(
-			float64(cachedEnd),
-			float64(roundedMillis-fluxInterval.Milliseconds()),
-		),
-	)
-
-	// There are five cases to consider
-	// 1. Cached time range is a subset of the requested time range
-	// 2. Cached time range is a superset of the requested time range
-	// 3. Cached time range is a left overlap of the requested time range
-	// 4. Cached time range is a right overlap of the requested time range
-	// 5. Cached time range is a disjoint of the requested time range
-	if cachedStart >= start && cachedEnd <= end {
-		// Case 1: Cached time range is a subset of the requested time range
-		// Add misses for the left and right sides of the cached time range
-		misses = append(misses, missInterval{start: start, end: cachedStart - 1})
-		misses = append(misses, missInterval{start: cachedEnd + 1, end: end})
-	} else if cachedStart <= start && cachedEnd >= end {
-		// Case 2: Cached time range is a superset of the requested time range
-		// No misses
-	} else if cachedStart <= start && cachedEnd >= start {
-		// Case 3: Cached time range is a left overlap of the requested time range
-		// Add a miss for the left side of the cached time range
-		misses = append(misses, missInterval{start: cachedEnd + 1, end: end})
-	} else if cachedStart <= end && cachedEnd >= end {
-		// Case 4: Cached time range is a right overlap of the requested time range
-		// Add a miss for the right side of the cached time range
-		misses = append(misses, missInterval{start: start, end: cachedStart - 1})
+// findMissingTimeRanges decodes the cached data of the cache key and finds the
+// missing time ranges in it. Without cached data, or with cached data which fails
+// to decode, the entire range is a miss, and the corrupt entry is deleted.
+// With a time index in the context, the misses are shared with the other queries
+// of the request over the same window and cached span
+func (q *querier) findMissingTimeRanges(ctx context.Context, cacheKey string, start, end, step, alignmentOffset int64, cachedData []byte) (cachedSeries []*v3.Series, misses []missInterval, replaceCachedData bool) {
+	cachedSeries = make([]*v3.Series, 0)
+	if cachedData == nil {
+		return cachedSeries, []missInterval{{start: start, end: end}}, true
+	}
+	if err := json.Unmarshal(cachedData, &cachedSeries); err != nil {
+		zap.L().Error("error unmarshalling cached data, deleting the cache entry", zap.String("cacheKey", cacheKey), zap.Error(err))
+		q.cache.Remove(cacheKey)
+		return make([]*v3.Series, 0), []missInterval{{start: start, end: end}}, true
+	}
+	if index := timeIndexFrom(ctx); index != nil {
+		cachedStart, cachedEnd := common.CachedSpan(cachedSeries)
+		misses, replaceCachedData = index.misses(timeIndexKey{
+			start:           start,
+			end:             end,
+			step:            step,
+			alignmentOffset: alignmentOffset,
+			fluxInterval:    q.fluxIntervalFor(step),
+			cachedStart:     cachedStart,
+			cachedEnd:       cachedEnd,
+		})
 	} else {
-		// Case 5: Cached time range is a disjoint of the requested time range
-		// Add a miss for the entire requested time range
-		misses = append(misses, missInterval{start: start, end: end})
-		replaceCacheData = true
+		misses, replaceCachedData = findMissingTimeRanges(start, end, step, alignmentOffset, cachedSeries, q.fluxIntervalFor(step), q.nowFunc())
 	}
-
-	// remove the struts with start > end
-	var validMisses []missInterval
-	for idx := range misses {
-		miss := misses[idx]
-		if miss.start < miss.end {
-			validMisses = append(validMisses, miss)
-		}
+	if q.maxMisses > 0 && len(misses) > q.maxMisses {
+		// fetching the whole range once is cheaper than a storm of small queries
+		return cachedSeries, []missInterval{{start: start, end: end}}, true
 	}
-	return validMisses, replaceCacheData
+	return cachedSeries, misses, replaceCachedData
 }
 
-// findMissingTimeRanges finds the missing time ranges in the cached data
-// and returns them as a list of misses
-func (q *querier) findMissingTimeRanges(start, end, step int64, cachedData []byte) (misses []missInterval, replaceCachedData bool) {
-	var cachedSeriesList []*v3.Series
-	if err := json.Unmarshal(cachedData, &cachedSeriesList); err != nil {
-		// In case of error, we return the entire range as a miss
-		return []missInterval{{start: start, end: end}}, true
+// fluxIntervalFor returns the flux interval of a query with the step in seconds,
+// the larger of the flux interval and the flux interval steps
+func (q *querier) fluxIntervalFor(step int64) time.Duration {
+	stepsInterval := time.Duration(int64(q.fluxIntervalSteps)*step) * time.Second
+	if stepsInterval > q.fluxInterval {
+		return stepsInterval
 	}
-	return findMissingTimeRanges(start, end, step, cachedSeriesList, q.fluxInterval)
+	return q.fluxInterval
 }
 
 func labelsToString(labels map[string]string) string {
-	type label struct {
-		Key   string
-		Value string
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
 	}
-	var labelsList []label
-	for k, v := range labels {
-		labelsList = append(labelsList, label{Key: k, Value: v})
-	}
-	sort.Slice(labelsList, func(i, j int) bool {
-		return labelsList[i].Key < labelsList[j].Key
-	})
-	labelKVs := make([]string, len(labelsList))
-	for idx := range labelsList {
-		labelKVs[idx] = labelsList[idx].Key + "=" + labelsList[idx].Value
+	sort.Strings(keys)
+
+	var b strings.Builder
+	b.WriteByte('{')
+	for idx, k := range keys {
+		if idx > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(k)
+		b.WriteByte('=')
+		b.WriteString(labels[k])
 	}
-	return fmt.Sprintf("{%s}", strings.Join(labelKVs, ","))
+	b.WriteByte('}')
+	return b.String()
 }
 
-func filterCachedPoints(cachedSeries []*v3.Series, start, end int64) {
+// filterCachedPoints filters out the points in the series that are outside the
+// start and end time range.
+//
+// Points with a zero timestamp carry no time information, for example the result of a
+// query that is not aggregated over time, so they are retained regardless of the time
+// range unless keepZeroTimestamp is false.
+func filterCachedPoints(cachedSeries []*v3.Series, start, end int64, keepZeroTimestamp bool) {
 	for _, c := range cachedSeries {
 		points := []v3.Point{}
 		for _, p := range c.Points {
-			if (p.Timestamp < start || p.Timestamp > end) && p.Timestamp != 0 {
+			if p.Timestamp == 0 && keepZeroTimestamp {
+				points = append(points, p)
+				continue
+			}
+			if p.Timestamp < start || p.Timestamp > end {
 				continue
 			}
 			points = append(points, p)
@@ -266,26 +629,56 @@ func filterCachedPoints(cachedSeries []*v3.Series, start, end int64) {
 	}
 }
 
+// sortSeriesByLabels orders the series of the results by their labels, like the
+// series merged with the cache, so that the uncached, the ClickHouse and the PromQL
+// series are returned in a stable order too. The series slices are new slices
+func sortSeriesByLabels(results []*v3.Result) {
+	for _, result := range results {
+		if len(result.Series) < 2 {
+			continue
+		}
+		labels := make(map[*v3.Series]string, len(result.Series))
+		for _, series := range result.Series {
+			labels[series] = labelsToString(series.Labels)
+		}
+		sorted := make([]*v3.Series, len(result.Series))
+		copy(sorted, result.Series)
+		sort.SliceStable(sorted, func(i, j int) bool {
+			return labels[sorted[i]] < labels[sorted[j]]
+		})
+		result.Series = sorted
+	}
+}
+
 func mergeSerieses(cachedSeries, missedSeries []*v3.Series) []*v3.Series {
 	// Merge the missed series with the cached series by timestamp
-	mergedSeries := make([]*v3.Series, 0)
-	seriesesByLabels := make(map[string]*v3.Series)
-	for idx := range cachedSeries {
-		series := cachedSeries[idx]
+	// the labels of each series are serialized only once
+	seriesesByLabels := make(map[string]*v3.Series, len(cachedSeries))
+	for _, series := range cachedSeries {
 		seriesesByLabels[labelsToString(series.Labels)] = series
 	}
 
-	for idx := range missedSeries {
-		series := missedSeries[idx]
-		if _, ok := seriesesByLabels[labelsToString(series.Labels)]; !ok {
-			seriesesByLabels[labelsToString(series.Labels)] = series
+	for _, series := range missedSeries {
+		labels := labelsToString(series.Labels)
+		if cached, ok := seriesesByLabels[labels]; ok {
+			cached.Points = append(cached.Points, series.Points...)
 			continue
 		}
-		seriesesByLabels[labelsToString(series.Labels)].Points = append(seriesesByLabels[labelsToString(series.Labels)].Points, series.Points...)
+		seriesesByLabels[labels] = series
+	}
+	// The series are ordered by their labels rather than by the map iteration, so
+	// that the same query returns the series in the same order, e.g. for the colors
+	// of the charts to be stable between refreshes
+	labelsKeys := make([]string, 0, len(seriesesByLabels))
+	for labels := range seriesesByLabels {
+		labelsKeys = append(labelsKeys, labels)
 	}
+	sort.Strings(labelsKeys)
+
 	// Sort the points in each series by timestamp
-	for idx := range seriesesByLabels {
-		series := seriesesByLabels[idx]
+	mergedSeries := make([]*v3.Series, 0, len(seriesesByLabels))
+	for _, labels := range labelsKeys {
+		series := seriesesByLabels[labels]
 		series.SortPoints()
 		series.RemoveDuplicatePoints()
 		mergedSeries = append(mergedSeries, series)
@@ -293,22 +686,129 @@ func mergeSerieses(cachedSeries, missedSeries []*v3.Series) []*v3.Series {
 	return mergedSeries
 }
 
+// cacheKeyLock returns the lock guarding the cache entry of the key
+func (q *querier) cacheKeyLock(cacheKey string) *sync.Mutex {
+	h := fnv.New32a()
+	h.Write([]byte(cacheKey))
+	return &q.cacheKeyLocks[h.Sum32()%cacheKeyLockCount]
+}
+
+// storeSeries stores the series computed from readData in the cache under the key.
+// The series are merged with the series currently in the cache, so that concurrent
+// stores for the same key don't discard each other's points. If replace is set, the
+// cached series are replaced instead, but only if they are still the readData.
+// The entry expires after ttl.
+func (q *querier) storeSeries(cacheKey string, seriesList []*v3.Series, readData []byte, replace bool, ttl time.Duration) error {
+	data, err := json.Marshal(seriesList)
+	if err != nil {
+		return fmt.Errorf("error marshalling series: %w", err)
+	}
+
+	mu := q.cacheKeyLock(cacheKey)
+	mu.Lock()
+	defer mu.Unlock()
+
+	currentData, _, err := q.cache.Retrieve(cacheKey, true)
+	// the entry is replaced only if no other request has updated it since it was read
+	if err == nil && currentData != nil && (!replace || !bytes.Equal(currentData, readData)) {
+		// merge copies of the series, the given series are shared with the caller
+		currentSeries := make([]*v3.Series, 0)
+		newSeries := make([]*v3.Series, 0)
+		if err := json.Unmarshal(currentData, &currentSeries); err != nil {
+			zap.L().Error("error unmarshalling cached data", zap.Error(err))
+		} else if err := json.Unmarshal(data, &newSeries); err != nil {
+			return fmt.Errorf("error unmarshalling series: %w", err)
+		} else if data, err = json.Marshal(q.mergeSerieses(currentSeries, newSeries)); err != nil {
+			return fmt.Errorf("error marshalling merged series: %w", err)
+		}
+	}
+
+	return q.cache.Store(cacheKey, data, ttl)
+}
+
+// cacheStats returns the cache stats of a query over [start, end] with the misses,
+// nil if they are not requested
+func (q *querier) cacheStats(params *v3.QueryRangeParamsV3, retrieveStatus status.RetrieveStatus, start, end int64, misses []missInterval) *v3.CacheStats {
+	if !params.CacheStats {
+		return nil
+	}
+	var fetchedMillis int64
+	for _, miss := range misses {
+		fetchedMillis += miss.end - miss.start
+	}
+	// the cache only knows whether the key was found, not whether it covered the range
+	if retrieveStatus == status.RetrieveStatusHit && fetchedMillis >= end-start {
+		retrieveStatus = status.RetrieveStatusRangeMiss
+	} else if retrieveStatus == status.RetrieveStatusHit && fetchedMillis > 0 {
+		retrieveStatus = status.RetrieveStatusPartialHit
+	}
+	return &v3.CacheStats{
+		RetrieveStatus: retrieveStatus.String(),
+		CachedMillis:   end - start - fetchedMillis,
+		FetchedMillis:  fetchedMillis,
+	}
+}
+
+// excludeFluxTail returns copies of the series without the points after the flux
+// boundary, the points that might still be in flux are not to be cached as settled
+func (q *querier) excludeFluxTail(seriesList []*v3.Series, step, alignmentOffset int64) []*v3.Series {
+	settledSeries, _ := q.splitFluxTail(seriesList, step, alignmentOffset)
+	return settledSeries
+}
+
+// splitFluxTail returns copies of the series split at the flux boundary, the
+// settled points up to the boundary and the points of the flux tail after it.
+// The series without a point in the flux tail are left out of the tail
+func (q *querier) splitFluxTail(seriesList []*v3.Series, step, alignmentOffset int64) (settledSeries, tailSeries []*v3.Series) {
+	fluxBoundary := common.FluxBoundaryAt(step, alignmentOffset, q.fluxIntervalFor(step), q.nowFunc())
+	settledSeries = make([]*v3.Series, 0, len(seriesList))
+	tailSeries = make([]*v3.Series, 0)
+	for _, series := range seriesList {
+		points := make([]v3.Point, 0, len(series.Points))
+		var tailPoints []v3.Point
+		for _, point := range series.Points {
+			if point.Timestamp <= fluxBoundary {
+				points = append(points, point)
+			} else {
+				tailPoints = append(tailPoints, point)
+			}
+		}
+		settledSeries = append(settledSeries, &v3.Series{Labels: series.Labels, LabelsArray: series.LabelsArray, Points: points})
+		if len(tailPoints) > 0 {
+			tailSeries = append(tailSeries, &v3.Series{Labels: series.Labels, LabelsArray: series.LabelsArray, Points: tailPoints})
+		}
+	}
+	return settledSeries, tailSeries
+}
+
+// generateCacheKeys generates the cache keys for the query range params and
+// namespaces them with the org of the user in the context, so that tenants with
+// identical queries never share cache entries
+func (q *querier) generateCacheKeys(ctx context.Context, params *v3.QueryRangeParamsV3) map[string]string {
+	return common.NamespaceCacheKeys(ctx, q.keyGenerator.GenerateKeys(params))
+}
+
 func (q *querier) runBuilderQueries(ctx context.Context, params *v3.QueryRangeParamsV3, keys map[string]v3.AttributeKey) ([]*v3.Result, map[string]error, error) {
+	ctx, span := q.tracer.Start(ctx, "runBuilderQueries")
+	defer span.End()
 
-	cacheKeys := q.keyGenerator.GenerateKeys(params)
+	cacheKeys := q.generateCacheKeys(ctx, params)
 
 	ch := make(chan channelResult, len(params.CompositeQuery.BuilderQueries))
 	var wg sync.WaitGroup
+	execDurations := make(map[string]*atomic.Int64, len(params.CompositeQuery.BuilderQueries))
 
 	for queryName, builderQuery := range params.CompositeQuery.BuilderQueries {
 		if builderQuery.Disabled {
 			continue
 		}
+		execDurations[queryName] = new(atomic.Int64)
+		queryCtx := withExecDuration(ctx, execDurations[queryName])
 		wg.Add(1)
 		if queryName == builderQuery.Expression {
-			go q.runBuilderQuery(ctx, builderQuery, params, keys, cacheKeys, ch, &wg)
+			go q.runBuilderQuery(queryCtx, builderQuery, params, keys, cacheKeys, ch, &wg)
 		} else {
-			go q.runBuilderExpression(ctx, builderQuery, params, keys, cacheKeys, ch, &wg)
+			go q.runBuilderExpression(queryCtx, builderQuery, params, keys, cacheKeys, ch, &wg)
 		}
 	}
 
@@ -326,78 +826,130 @@ func (q *querier) runBuilderQueries(ctx context.Context, params *v3.QueryRangePa
 			continue
 		}
 		results = append(results, &v3.Result{
-			QueryName: result.Name,
-			Series:    result.Series,
+			QueryName:       result.Name,
+			Series:          result.Series,
+			CacheStats:      result.CacheStats,
+			ExecutionMillis: execMillis(execDurations[result.Name]),
 		})
 	}
 
 	var err error
 	if len(errs) > 0 {
-		err = fmt.Errorf("error in builder queries")
+		err = fmt.Errorf("error in builder queries: %w", multierr.Combine(errs...))
 	}
 
 	return results, errQueriesByName, err
 }
 
 func (q *querier) runPromQueries(ctx context.Context, params *v3.QueryRangeParamsV3) ([]*v3.Result, map[string]error, error) {
+	ctx, span := q.tracer.Start(ctx, "runPromQueries")
+	defer span.End()
+
 	channelResults := make(chan channelResult, len(params.CompositeQuery.PromQueries))
 	var wg sync.WaitGroup
-	cacheKeys := q.keyGenerator.GenerateKeys(params)
+	cacheKeys := q.generateCacheKeys(ctx, params)
+
+	execDurations := make(map[string]*atomic.Int64, len(params.CompositeQuery.PromQueries))
 
 	for queryName, promQuery := range params.CompositeQuery.PromQueries {
 		if promQuery.Disabled {
 			continue
 		}
+		execDurations[queryName] = new(atomic.Int64)
 		wg.Add(1)
-		go func(queryName string, promQuery *v3.PromQuery) {
+		go func(ctx context.Context, queryName string, promQuery *v3.PromQuery) {
 			defer wg.Done()
+			ctx, span := q.tracer.Start(ctx, "runPromQuery", trace.WithAttributes(attrQueryName.String(queryName)))
+			defer span.End()
+			if params.ReturnPromMatrix {
+				// the native matrix can't be rebuilt from the cached series,
+				// so the query is executed for the whole range without the cache
+				query := metricsV3.BuildPromQuery(promQuery, params.Step, params.Start, params.End)
+				matrix, err := q.execPromQueryMatrix(ctx, query)
+				channelResults <- channelResult{Err: err, Name: queryName, Query: query.Query, Series: promMatrixToSeries(matrix), Matrix: matrix}
+				return
+			}
 			cacheKey, ok := cacheKeys[queryName]
 			var cachedData []byte
+			retrieveStatus := status.RetrieveStatusKeyMiss
 			// Ensure NoCache is not set and cache is not nil
 			if !params.NoCache && q.cache != nil && ok {
-				data, retrieveStatus, err := q.cache.Retrieve(cacheKey, true)
+				var data []byte
+				var err error
+				data, retrieveStatus, err = q.retrieveCachedSeries(ctx, queryName, cacheKey)
 				zap.L().Info("cache retrieve status", zap.String("status", retrieveStatus.String()))
+				span.SetAttributes(attrCacheStatus.String(retrieveStatus.String()))
 				if err == nil {
 					cachedData = data
 				}
 			}
-			misses, replaceCachedData := q.findMissingTimeRanges(params.Start, params.End, params.Step, cachedData)
-			missedSeries := make([]*v3.Series, 0)
-			cachedSeries := make([]*v3.Series, 0)
-			for _, miss := range misses {
-				query := metricsV3.BuildPromQuery(promQuery, params.Step, miss.start, miss.end)
-				series, err := q.execPromQuery(ctx, query)
-				if err != nil {
-					channelResults <- channelResult{Err: err, Name: queryName, Query: query.Query, Series: nil}
-					return
+			paramsHash := promQueryParamsHash(promQuery, params.Step)
+			if err := q.checkKeyCollision(cacheKey, paramsHash); err != nil && cachedData != nil {
+				channelResults <- channelResult{Err: err, Name: queryName, Query: promQuery.Query, Series: nil}
+				return
+			}
+			cachedSeries, misses, replaceCachedData := q.findMissingTimeRanges(ctx, cacheKey, params.Start, params.End, params.Step, 0, cachedData)
+			// the flux tail is not in the cached series, the stale tail stored by the last
+			// fetch is served instead. Without it the tail is fetched for the response
+			var staleTail []*v3.Series
+			var hasStaleTail bool
+			if q.staleWhileRevalidate && cachedData != nil && !replaceCachedData && q.onlyFluxTailMisses(misses, params.End, params.Step) {
+				staleTail, hasStaleTail = q.retrieveFluxTail(cacheKey)
+			}
+			if hasStaleTail {
+				// the misses are refetched in the background, nothing is fetched for the response
+				cachedSeries = q.mergeSerieses(cachedSeries, staleTail)
+				cacheStats := q.cacheStats(params, status.RetrieveStatusRevalidated, params.Start, params.End, misses)
+				if cacheStats != nil {
+					cacheStats.FetchedMillis = 0
+				}
+				channelResults <- channelResult{Err: nil, Name: queryName, Query: promQuery.Query, Series: cachedSeries, CacheStats: cacheStats}
+				q.revalidatePromQuery(cacheKey, promQuery, params, misses, cachedData)
+				return
+			}
+			missedSeries, errQuery, err := q.fetchPromMisses(ctx, promQuery, params.Step, misses)
+			if err != nil {
+				// the cached series are returned with the error, so that the callers
+				// can show the part of the range that was served from the cache
+				var partialSeries []*v3.Series
+				if !replaceCachedData {
+					partialSeries = cachedSeries
+				}
+				cacheStats := q.cacheStats(params, retrieveStatus, params.Start, params.End, misses)
+				if cacheStats != nil {
+					cacheStats.FetchedMillis = 0
 				}
-				missedSeries = append(missedSeries, series...)
+				channelResults <- channelResult{Err: err, Name: queryName, Query: errQuery, Series: partialSeries, CacheStats: cacheStats}
+				return
 			}
-			if err := json.Unmarshal(cachedData, &cachedSeries); err != nil && cachedData != nil {
-				// ideally we should not be getting an error here
-				zap.L().Error("error unmarshalling cached data", zap.Error(err))
+			if len(misses) > 0 && len(cachedSeries) > 0 && !replaceCachedData && q.cacheAuditSampled() {
+				q.auditCachedSeries(ctx, cacheKey, cachedSeries, params.Step, q.promAuditFetch(promQuery, params.Step))
 			}
-			mergedSeries := mergeSerieses(cachedSeries, missedSeries)
+			mergedSeries := q.mergeCachedSeries(cachedSeries, missedSeries, params.Step)
 			if replaceCachedData {
 				mergedSeries = missedSeries
 			}
 
-			channelResults <- channelResult{Err: nil, Name: queryName, Query: promQuery.Query, Series: mergedSeries}
+			channelResults <- channelResult{
+				Err:        nil,
+				Name:       queryName,
+				Query:      promQuery.Query,
+				Series:     mergedSeries,
+				CacheStats: q.cacheStats(params, retrieveStatus, params.Start, params.End, misses),
+			}
 
-			// Cache the seriesList for future queries
-			if len(missedSeries) > 0 && !params.NoCache && q.cache != nil && ok {
-				mergedSeriesData, err := json.Marshal(mergedSeries)
-				if err != nil {
-					zap.L().Error("error marshalling merged series", zap.Error(err))
-					return
-				}
-				err = q.cache.Store(cacheKey, mergedSeriesData, time.Hour)
-				if err != nil {
+			// Cache the seriesList for future queries, unless the request was
+			// cancelled and the missed series may be incomplete
+			if len(missedSeries) > 0 && !params.NoCache && q.cache != nil && ok && ctx.Err() == nil {
+				settledSeries, tailSeries := q.splitFluxTail(mergedSeries, params.Step, 0)
+				if err := q.storeSeries(cacheKey, settledSeries, cachedData, replaceCachedData, q.cacheTTLPolicy(params.Start, params.End, params.Step)); err != nil {
 					zap.L().Error("error storing merged series", zap.Error(err))
 					return
 				}
+				q.storeFluxTail(cacheKey, tailSeries, q.cacheTTLPolicy(params.Start, params.End, params.Step))
+				q.storeParamsHash(cacheKey, paramsHash)
 			}
-		}(queryName, promQuery)
+		}(withExecDuration(ctx, execDurations[queryName]), queryName, promQuery)
 	}
 	wg.Wait()
 	close(channelResults)
@@ -410,35 +962,50 @@ func (q *querier) runPromQueries(ctx context.Context, params *v3.QueryRangeParam
 		if result.Err != nil {
 			errs = append(errs, result.Err)
 			errQueriesByName[result.Name] = result.Err
-			continue
+			// the series served from the cache before the error are still returned
+			if len(result.Series) == 0 {
+				continue
+			}
 		}
 		results = append(results, &v3.Result{
-			QueryName: result.Name,
-			Series:    result.Series,
+			QueryName:       result.Name,
+			Series:          result.Series,
+			Matrix:          result.Matrix,
+			CacheStats:      result.CacheStats,
+			ExecutionMillis: execMillis(execDurations[result.Name]),
 		})
 	}
 
 	var err error
 	if len(errs) > 0 {
-		err = fmt.Errorf("error in prom queries")
+		err = fmt.Errorf("error in prom queries: %w", multierr.Combine(errs...))
 	}
 
 	return results, errQueriesByName, err
 }
 
-func (q *querier) runClickHouseQueries(ctx context.Context, params *v3.QueryRangeParamsV3) ([]*v3.Result, map[string]error, error) {
+// runClickHouseQueries runs each enabled clickhouse query of the params with exec
+func (q *querier) runClickHouseQueries(ctx context.Context, params *v3.QueryRangeParamsV3, exec func(context.Context, string) ([]*v3.Series, error)) ([]*v3.Result, map[string]error, error) {
+	ctx, span := q.tracer.Start(ctx, "runClickHouseQueries")
+	defer span.End()
+
 	channelResults := make(chan channelResult, len(params.CompositeQuery.ClickHouseQueries))
 	var wg sync.WaitGroup
+	execDurations := make(map[string]*atomic.Int64, len(params.CompositeQuery.ClickHouseQueries))
 	for queryName, clickHouseQuery := range params.CompositeQuery.ClickHouseQueries {
 		if clickHouseQuery.Disabled {
 			continue
 		}
+		execDurations[queryName] = new(atomic.Int64)
 		wg.Add(1)
-		go func(queryName string, clickHouseQuery *v3.ClickHouseQuery) {
+		go func(ctx context.Context, queryName string, clickHouseQuery *v3.ClickHouseQuery) {
 			defer wg.Done()
-			series, err := q.execClickHouseQuery(ctx, clickHouseQuery.Query)
-			channelResults <- channelResult{Err: err, Name: queryName, Query: clickHouseQuery.Query, Series: series}
-		}(queryName, clickHouseQuery)
+			ctx, span := q.tracer.Start(ctx, "runClickHouseQuery", trace.WithAttributes(attrQueryName.String(queryName)))
+			defer span.End()
+			queryID := q.queryIDs.Issue(ctx)
+			series, err := exec(context.WithValue(ctx, common.ClickHouseQueryIDKey, queryID), clickHouseQuery.Query)
+			channelResults <- channelResult{Err: err, Name: queryName, Query: clickHouseQuery.Query, Series: series, QueryID: queryID}
+		}(withExecDuration(ctx, execDurations[queryName]), queryName, clickHouseQuery)
 	}
 	wg.Wait()
 	close(channelResults)
@@ -454,19 +1021,23 @@ func (q *querier) runClickHouseQueries(ctx context.Context, params *v3.QueryRang
 			continue
 		}
 		results = append(results, &v3.Result{
-			QueryName: result.Name,
-			Series:    result.Series,
+			QueryName:       result.Name,
+			Series:          result.Series,
+			ExecutionMillis: execMillis(execDurations[result.Name]),
+			QueryID:         result.QueryID,
 		})
 	}
 
 	var err error
 	if len(errs) > 0 {
-		err = fmt.Errorf("error in clickhouse queries")
+		err = fmt.Errorf("error in clickhouse queries: %w", multierr.Combine(errs...))
 	}
 	return results, errQueriesByName, err
 }
 
 func (q *querier) runBuilderListQueries(ctx context.Context, params *v3.QueryRangeParamsV3, keys map[string]v3.AttributeKey) ([]*v3.Result, map[string]error, error) {
+	ctx, span := q.tracer.Start(ctx, "runBuilderListQueries")
+	defer span.End()
 
 	queries, err := q.builder.PrepareQueries(params, keys)
 
@@ -476,19 +1047,48 @@ func (q *querier) runBuilderListQueries(ctx context.Context, params *v3.QueryRan
 
 	ch := make(chan channelResult, len(queries))
 	var wg sync.WaitGroup
+	execDurations := make(map[string]*atomic.Int64, len(queries))
 
 	for name, query := range queries {
+		execDurations[name] = new(atomic.Int64)
+		// the query can't match any row, the reader is not called
+		if builderQuery, ok := params.CompositeQuery.BuilderQueries[name]; ok && queryBuilder.ContradictoryFilters(builderQuery.Filters) {
+			ch <- channelResult{List: []*v3.Row{}, Name: name, Query: query}
+			continue
+		}
 		wg.Add(1)
-		go func(name, query string) {
+		go func(ctx context.Context, name, query string) {
 			defer wg.Done()
-			rowList, err := q.reader.GetListResultV3(ctx, query)
+			ctx, span := q.tracer.Start(ctx, "execListQuery", trace.WithAttributes(attrQueryName.String(name)))
+			budget := scanBudgetFrom(ctx)
+			if err := budget.exceeded(); err != nil {
+				endSpan(span, 0, err)
+				ch <- channelResult{Err: err, Name: name, Query: query}
+				return
+			}
+			queryID := q.queryIDs.Issue(ctx)
+			var rowList []*v3.Row
+			err := q.withRateLimitBackoff(ctx, func() error {
+				start := time.Now()
+				var err error
+				rowList, err = withReaderHardTimeout(context.WithValue(ctx, common.ClickHouseQueryIDKey, queryID), q.readerHardTimeout, func(ctx context.Context) ([]*v3.Row, error) {
+					return q.reader.GetListResultV3(ctx, query)
+				})
+				recordExecDuration(ctx, start)
+				return err
+			})
+			if budgetErr := budget.exceeded(); err != nil && budgetErr != nil {
+				// the query was aborted by the budget
+				err = budgetErr
+			}
+			endSpan(span, len(rowList), err)
 
 			if err != nil {
-				ch <- channelResult{Err: fmt.Errorf("error in query-%s: %v", name, err), Name: name, Query: query}
+				ch <- channelResult{Err: fmt.Errorf("error in query-%s: %w", name, err), Name: name, Query: query}
 				return
 			}
-			ch <- channelResult{List: rowList, Name: name, Query: query}
-		}(name, query)
+			ch <- channelResult{List: rowList, Name: name, Query: query, QueryID: queryID}
+		}(withExecDuration(ctx, execDurations[name]), name, query)
 	}
 
 	wg.Wait()
@@ -505,17 +1105,118 @@ func (q *querier) runBuilderListQueries(ctx context.Context, params *v3.QueryRan
 			continue
 		}
 		res = append(res, &v3.Result{
-			QueryName: r.Name,
-			List:      r.List,
+			QueryName:       r.Name,
+			List:            r.List,
+			ExecutionMillis: execMillis(execDurations[r.Name]),
+			QueryID:         r.QueryID,
 		})
 	}
 	if len(errs) != 0 {
-		return nil, errQuriesByName, fmt.Errorf("encountered multiple errors: %s", multierr.Combine(errs...))
+		return nil, errQuriesByName, fmt.Errorf("encountered multiple errors: %w", multierr.Combine(errs...))
 	}
 	return res, nil, nil
 }
 
+// validateTimeRange validates the time range and step of the query range params
+// An inverted or empty time range makes the cache miss computation unpredictable
+func validateTimeRange(params *v3.QueryRangeParamsV3) error {
+	if params.Start >= params.End {
+		return fmt.Errorf("invalid time range: start %d must be less than end %d", params.Start, params.End)
+	}
+	// step is not used for the list and trace panels
+	if params.CompositeQuery != nil &&
+		(params.CompositeQuery.PanelType == v3.PanelTypeList || params.CompositeQuery.PanelType == v3.PanelTypeTrace) {
+		return nil
+	}
+	if params.Step <= 0 {
+		return fmt.Errorf("invalid step: step must be positive, got %d", params.Step)
+	}
+	return nil
+}
+
+// validateMaxTimeRange validates the time range of the queries against the max
+// time range of their data source, and returns the name of a query over it
+func (q *querier) validateMaxTimeRange(params *v3.QueryRangeParamsV3) (string, error) {
+	if len(q.maxTimeRanges) == 0 || params.CompositeQuery == nil {
+		return "", nil
+	}
+	timeRange := time.Duration(params.End-params.Start) * time.Millisecond
+	exceeds := func(queryName string, dataSource v3.DataSource) error {
+		maxTimeRange, ok := q.maxTimeRanges[dataSource]
+		if !ok || timeRange <= maxTimeRange {
+			return nil
+		}
+		return chErrors.NewResourceLimitError(fmt.Errorf(
+			"the time range %s of query %s exceeds the max time range %s of %s queries, try a shorter time range",
+			timeRange, queryName, maxTimeRange, dataSource,
+		))
+	}
+
+	switch params.CompositeQuery.QueryType {
+	case v3.QueryTypeBuilder:
+		for queryName, builderQuery := range params.CompositeQuery.BuilderQueries {
+			// the expressions are limited by the queries they reference
+			if builderQuery.Disabled || queryName != builderQuery.Expression {
+				continue
+			}
+			if err := exceeds(queryName, builderQuery.DataSource); err != nil {
+				return queryName, err
+			}
+		}
+	case v3.QueryTypePromQL:
+		for queryName, promQuery := range params.CompositeQuery.PromQueries {
+			if promQuery.Disabled {
+				continue
+			}
+			if err := exceeds(queryName, v3.DataSourceMetrics); err != nil {
+				return queryName, err
+			}
+		}
+	}
+	return "", nil
+}
+
 func (q *querier) QueryRange(ctx context.Context, params *v3.QueryRangeParamsV3, keys map[string]v3.AttributeKey) ([]*v3.Result, map[string]error, error) {
+	ctx, span := q.tracer.Start(ctx, "QueryRange")
+	defer span.End()
+	// the query builders complete the builder queries, e.g. with the le group by of
+	// the quantiles, and the callers can share the params, so the params are copied
+	params = params.Clone()
+	priority := constants.DefaultQueryPriority
+	if params.Priority != nil {
+		priority = *params.Priority
+	}
+	ctx = context.WithValue(ctx, common.ClickHousePriorityKey, priority)
+	// the queries of the request share the misses of their windows
+	if timeIndexFrom(ctx) == nil {
+		ctx = withTimeIndex(ctx, newTimeIndex(q.nowFunc()))
+	}
+	if q.maxScannedRows > 0 || q.maxScannedBytes > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithCancel(ctx)
+		defer cancel()
+		ctx = context.WithValue(ctx, common.ClickHouseScanMeterKey, newScanBudget(q.maxScannedRows, q.maxScannedBytes, cancel))
+	}
+	var cacheErrs *cacheErrors
+	if q.cacheErrorWarnings {
+		cacheErrs = newCacheErrors()
+		ctx = withCacheErrors(ctx, cacheErrs)
+	}
+	if params.CompositeQuery != nil {
+		span.SetAttributes(
+			attrQueryType.String(string(params.CompositeQuery.QueryType)),
+			attrPanelType.String(string(params.CompositeQuery.PanelType)),
+		)
+	}
+
+	if err := validateTimeRange(params); err != nil {
+		return nil, nil, err
+	}
+	if queryName, err := q.validateMaxTimeRange(params); err != nil {
+		return nil, map[string]error{queryName: err}, err
+	}
+	stepNotes := q.enforceMinStep(params)
+
 	var results []*v3.Result
 	var err error
 	var errQueriesByName map[string]error
@@ -524,8 +1225,34 @@ func (q *querier) QueryRange(ctx context.Context, params *v3.QueryRangeParamsV3,
 		case v3.QueryTypeBuilder:
 			if params.CompositeQuery.PanelType == v3.PanelTypeList || params.CompositeQuery.PanelType == v3.PanelTypeTrace {
 				results, errQueriesByName, err = q.runBuilderListQueries(ctx, params, keys)
+				if err == nil && params.CompositeQuery.PanelType == v3.PanelTypeTrace {
+					attachSpanTrees(results, params.CompositeQuery.BuilderQueries)
+				}
+				if params.AuditFilters {
+					attachAppliedFilters(results, params.CompositeQuery.BuilderQueries)
+				}
+				if err == nil && params.IncludeListTotal && params.CompositeQuery.PanelType == v3.PanelTypeList {
+					q.attachListTotals(ctx, params, keys, results)
+				}
+				if err == nil && params.ListMerge != nil && len(results) > 0 {
+					results = []*v3.Result{mergeListResults(results, params.ListMerge)}
+				}
 			} else {
 				results, errQueriesByName, err = q.runBuilderQueries(ctx, params, keys)
+				if err == nil {
+					q.attachExemplars(ctx, params, results)
+					var shiftedResults []*v3.Result
+					var errShiftedQueriesByName map[string]error
+					shiftedResults, errShiftedQueriesByName, err = q.runCompareShiftQueries(ctx, params, keys)
+					results = append(results, shiftedResults...)
+					for name, err := range errShiftedQueriesByName {
+						errQueriesByName[name] = err
+					}
+				}
+				attachHeatmaps(results, params.CompositeQuery.BuilderQueries)
+				if params.AuditFilters {
+					attachAppliedFilters(results, params.CompositeQuery.BuilderQueries)
+				}
 			}
 			// in builder query, the only errors we expose are the ones that exceed the resource limits
 			// everything else is internal error as they are not actionable by the user
@@ -537,28 +1264,196 @@ func (q *querier) QueryRange(ctx context.Context, params *v3.QueryRangeParamsV3,
 		case v3.QueryTypePromQL:
 			results, errQueriesByName, err = q.runPromQueries(ctx, params)
 		case v3.QueryTypeClickHouseSQL:
-			results, errQueriesByName, err = q.runClickHouseQueries(ctx, params)
+			results, errQueriesByName, err = q.runClickHouseQueries(ctx, params, q.execClickHouseQuery)
 		default:
 			err = fmt.Errorf("invalid query type")
 		}
 	}
 
+	// the series are ordered the same whether they were merged with the cache or not,
+	// the post processing below keeps the order unless it sorts or ranks the series
+	sortSeriesByLabels(results)
+
+	if params.IncludeCompleteness && params.CompositeQuery != nil && params.CompositeQuery.PanelType == v3.PanelTypeGraph {
+		attachCompleteness(results, params)
+	}
+
+	if params.CompositeQuery != nil && params.CompositeQuery.QueryType == v3.QueryTypeBuilder {
+		applyTrailingWindows(results, params)
+		dropZeroSeries(results, params.CompositeQuery.BuilderQueries)
+		percentOfTotal(results, params.CompositeQuery.BuilderQueries)
+		smoothSeries(results, params.CompositeQuery.BuilderQueries)
+		encodeIntValues(results, params.CompositeQuery.BuilderQueries)
+		applyLegendFormats(results, params.CompositeQuery.BuilderQueries)
+	}
+
 	// return error if the number of series is more than one for value type panel
 	if params.CompositeQuery.PanelType == v3.PanelTypeValue {
 		if len(results) > 1 && params.CompositeQuery.EnabledQueries() > 1 {
 			err = fmt.Errorf("there can be only one active query for value type panel")
 		} else if len(results) == 1 && len(results[0].Series) > 1 {
-			err = fmt.Errorf("there can be only one result series for value type panel but got %d", len(results[0].Series))
+			series, reduceErr := common.ReduceValuePanelSeries(results[0].Series, params.ValuePanelMultiSeriesStrategy)
+			if reduceErr != nil {
+				err = reduceErr
+			} else {
+				results[0].Series = series
+			}
+		}
+	}
+
+	if len(params.Ratios) > 0 && params.CompositeQuery.QueryType == v3.QueryTypeBuilder {
+		results = computeRatios(results, params.Ratios)
+	}
+
+	if params.TopNWithOther != nil {
+		for _, result := range results {
+			if len(result.Series) > params.TopNWithOther.N {
+				result.Series = topSeriesWithOther(result.Series, params.TopNWithOther)
+			}
+		}
+	}
+
+	// the series are sorted before they are truncated, so that the top series by
+	// the sort metric are kept
+	if params.SeriesSort != nil {
+		for _, result := range results {
+			sortSeries(result.Series, params.SeriesSort)
+		}
+	}
+
+	// truncate the series of graph panels instead of rendering thousands of lines
+	if q.maxSeries > 0 && params.CompositeQuery.PanelType == v3.PanelTypeGraph {
+		for _, result := range results {
+			if len(result.Series) <= q.maxSeries {
+				continue
+			}
+			if params.SeriesSort != nil {
+				result.Series = result.Series[:q.maxSeries:q.maxSeries]
+			} else {
+				result.Series = topSeries(result.Series, q.maxSeries, q.maxSeriesRankBy)
+			}
+			result.Truncated = true
+		}
+	}
+
+	if params.DetectCounterResets {
+		for _, result := range results {
+			annotateCounterResets(result.Series)
+		}
+	}
+
+	if params.MissingGroupByPlaceholder != "" && params.CompositeQuery.QueryType == v3.QueryTypeBuilder {
+		fillMissingGroupByLabels(results, params.CompositeQuery.BuilderQueries, params.MissingGroupByPlaceholder)
+	}
+
+	if q.maxLabelValueLength > 0 {
+		for _, result := range results {
+			truncateLabelValues(result.Series, q.maxLabelValueLength)
+		}
+	}
+
+	// the gaps are marked at the step of the query, before the points are sampled
+	if params.MarkGaps && params.CompositeQuery != nil && params.CompositeQuery.PanelType == v3.PanelTypeGraph {
+		markGaps(results, params)
+	}
+
+	if params.SampleTo > 0 {
+		for _, result := range results {
+			samplePoints(result.Series, params.SampleTo)
+		}
+	}
+
+	if params.RoundDecimals != nil {
+		for _, result := range results {
+			roundPointValues(result.Series, *params.RoundDecimals)
+		}
+	}
+
+	if params.IncludeAnnotations {
+		q.attachAnnotations(ctx, params, results)
+	}
+
+	if params.IncludeUnits && params.CompositeQuery.QueryType == v3.QueryTypeBuilder {
+		q.attachUnits(ctx, params, results)
+	}
+
+	for _, result := range results {
+		result.MinTimestamp, result.MaxTimestamp = seriesWindow(result.Series)
+		result.Step = resultStep(params, result.QueryName)
+		if params.IncludeLabelKeys {
+			result.LabelKeys = seriesLabelKeys(result.Series)
+		}
+		if note, ok := stepNotes[result.QueryName]; ok {
+			result.Notes = append(result.Notes, note)
+		}
+		if note, ok := cacheErrs.note(result.QueryName); ok {
+			result.Notes = append(result.Notes, note)
 		}
 	}
 
 	return results, errQueriesByName, err
 }
 
+// seriesLabelKeys returns the sorted union of the label keys of the series
+func seriesLabelKeys(seriesList []*v3.Series) []string {
+	keys := make(map[string]struct{})
+	for _, series := range seriesList {
+		for key := range series.Labels {
+			keys[key] = struct{}{}
+		}
+	}
+	labelKeys := make([]string, 0, len(keys))
+	for key := range keys {
+		labelKeys = append(labelKeys, key)
+	}
+	sort.Strings(labelKeys)
+	return labelKeys
+}
+
+// seriesWindow returns the min and max timestamp of the points of the series,
+// which can differ from the requested time range after merging with the cache
+func seriesWindow(seriesList []*v3.Series) (minTimestamp, maxTimestamp int64) {
+	first := true
+	for _, series := range seriesList {
+		for _, point := range series.Points {
+			// the gap markers are no data
+			if point.Null {
+				continue
+			}
+			if first || point.Timestamp < minTimestamp {
+				minTimestamp = point.Timestamp
+			}
+			if first || point.Timestamp > maxTimestamp {
+				maxTimestamp = point.Timestamp
+			}
+			first = false
+		}
+	}
+	return minTimestamp, maxTimestamp
+}
+
 func (q *querier) QueriesExecuted() []string {
+	q.mu.Lock()
+	defer q.mu.Unlock()
 	return q.queriesExecuted
 }
 
 func (q *querier) TimeRanges() [][]int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
 	return q.timeRanges
 }
+
+// resultStep returns the step in seconds the result of the query was computed
+// with, 0 if it is not known
+func resultStep(params *v3.QueryRangeParamsV3, queryName string) int64 {
+	switch params.CompositeQuery.QueryType {
+	case v3.QueryTypeBuilder:
+		if query, ok := params.CompositeQuery.BuilderQueries[queryName]; ok {
+			return query.StepInterval
+		}
+	case v3.QueryTypePromQL:
+		return params.Step
+	}
+	return 0
+}
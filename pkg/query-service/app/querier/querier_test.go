@@ -2,14 +2,40 @@ package querier
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"math"
+	"reflect"
+	"slices"
+	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/ClickHouse/clickhouse-go/v2"
+	"github.com/prometheus/prometheus/model/histogram"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/promql"
+	"github.com/prometheus/prometheus/util/stats"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 	"go.signoz.io/signoz/pkg/query-service/app/queryBuilder"
+	"go.signoz.io/signoz/pkg/query-service/cache"
 	"go.signoz.io/signoz/pkg/query-service/cache/inmemory"
+	"go.signoz.io/signoz/pkg/query-service/cache/status"
+	"go.signoz.io/signoz/pkg/query-service/common"
+	"go.signoz.io/signoz/pkg/query-service/constants"
+	chErrors "go.signoz.io/signoz/pkg/query-service/errors"
+	"go.signoz.io/signoz/pkg/query-service/featureManager"
+	"go.signoz.io/signoz/pkg/query-service/interfaces"
+	"go.signoz.io/signoz/pkg/query-service/model"
 	v3 "go.signoz.io/signoz/pkg/query-service/model/v3"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
 )
 
 func TestFindMissingTimeRangesZeroFreshNess(t *testing.T) {
@@ -197,7 +223,7 @@ func TestFindMissingTimeRangesZeroFreshNess(t *testing.T) {
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			misses, replaceCachedData := findMissingTimeRanges(tc.requestedStart, tc.requestedEnd, tc.requestedStep, tc.cachedSeries, 0*time.Minute)
+			misses, replaceCachedData := findMissingTimeRanges(tc.requestedStart, tc.requestedEnd, tc.requestedStep, 0, tc.cachedSeries, 0*time.Minute, time.Now())
 			if len(misses) != len(tc.expectedMiss) {
 				t.Errorf("expected %d misses, got %d", len(tc.expectedMiss), len(misses))
 			}
@@ -400,7 +426,7 @@ func TestFindMissingTimeRangesWithFluxInterval(t *testing.T) {
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			misses, _ := findMissingTimeRanges(tc.requestedStart, tc.requestedEnd, tc.requestedStep, tc.cachedSeries, tc.fluxInterval)
+			misses, _ := findMissingTimeRanges(tc.requestedStart, tc.requestedEnd, tc.requestedStep, 0, tc.cachedSeries, tc.fluxInterval, time.Now())
 			if len(misses) != len(tc.expectedMiss) {
 				t.Errorf("expected %d misses, got %d", len(tc.expectedMiss), len(misses))
 			}
@@ -1055,3 +1081,5926 @@ func TestQueryRangeValueTypePromQL(t *testing.T) {
 		}
 	}
 }
+
+func TestQueryRangeValuePanelMultiSeriesStrategy(t *testing.T) {
+	returnedSeries := []*v3.Series{
+		{
+			Labels: map[string]string{"service_name": "b"},
+			Points: []v3.Point{
+				{Timestamp: 1675115596722, Value: 3},
+				{Timestamp: 1675115596722 + 60*1000, Value: 2},
+			},
+		},
+		{
+			Labels: map[string]string{"service_name": "a"},
+			Points: []v3.Point{
+				{Timestamp: 1675115596722, Value: 1},
+				{Timestamp: 1675115596722 + 60*1000, Value: 5},
+			},
+		},
+	}
+
+	testCases := []struct {
+		name           string
+		strategy       v3.ValuePanelMultiSeriesStrategy
+		expectErr      bool
+		expectedLabels map[string]string
+		expectedValues []float64
+	}{
+		{
+			name:      "default strategy returns error",
+			strategy:  "",
+			expectErr: true,
+		},
+		{
+			name:      "error strategy returns error",
+			strategy:  v3.ValuePanelMultiSeriesStrategyError,
+			expectErr: true,
+		},
+		{
+			name:           "first strategy picks the first series by labels",
+			strategy:       v3.ValuePanelMultiSeriesStrategyFirst,
+			expectedLabels: map[string]string{"service_name": "a"},
+			expectedValues: []float64{1, 5},
+		},
+		{
+			name:           "sum strategy adds the series",
+			strategy:       v3.ValuePanelMultiSeriesStrategySum,
+			expectedLabels: map[string]string{},
+			expectedValues: []float64{4, 7},
+		},
+		{
+			name:           "max strategy takes the max of the series",
+			strategy:       v3.ValuePanelMultiSeriesStrategyMax,
+			expectedLabels: map[string]string{},
+			expectedValues: []float64{3, 5},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			params := &v3.QueryRangeParamsV3{
+				Start: 1675115596722,
+				End:   1675115596722 + 120*60*1000,
+				Step:  60,
+				CompositeQuery: &v3.CompositeQuery{
+					QueryType: v3.QueryTypeClickHouseSQL,
+					PanelType: v3.PanelTypeValue,
+					ClickHouseQueries: map[string]*v3.ClickHouseQuery{
+						"A": {Query: "SELECT 1"},
+					},
+				},
+				ValuePanelMultiSeriesStrategy: tc.strategy,
+			}
+			q := NewQuerier(QuerierOptions{
+				Reader:         nil,
+				FluxInterval:   5 * time.Minute,
+				KeyGenerator:   queryBuilder.NewKeyGenerator(),
+				TestingMode:    true,
+				ReturnedSeries: returnedSeries,
+			})
+
+			results, _, err := q.QueryRange(context.Background(), params, nil)
+			if tc.expectErr {
+				if err == nil {
+					t.Errorf("expected error, got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected no error, got %s", err)
+			}
+			if len(results) != 1 || len(results[0].Series) != 1 {
+				t.Fatalf("expected one result with one series, got %v", results)
+			}
+			series := results[0].Series[0]
+			if labelsToString(series.Labels) != labelsToString(tc.expectedLabels) {
+				t.Errorf("expected labels %v, got %v", tc.expectedLabels, series.Labels)
+			}
+			if len(series.Points) != len(tc.expectedValues) {
+				t.Fatalf("expected %d points, got %d", len(tc.expectedValues), len(series.Points))
+			}
+			for idx, point := range series.Points {
+				if point.Value != tc.expectedValues[idx] {
+					t.Errorf("expected value %v at index %d, got %v", tc.expectedValues[idx], idx, point.Value)
+				}
+			}
+		})
+	}
+}
+
+func TestQueryRangeTracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tracerProvider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	params := &v3.QueryRangeParamsV3{
+		Start: 1675115596722,
+		End:   1675115596722 + 120*60*1000,
+		Step:  60,
+		CompositeQuery: &v3.CompositeQuery{
+			QueryType: v3.QueryTypePromQL,
+			PanelType: v3.PanelTypeGraph,
+			PromQueries: map[string]*v3.PromQuery{
+				"A": {Query: "signoz_calls_total"},
+			},
+		},
+	}
+	q := NewQuerier(QuerierOptions{
+		Cache:          inmemory.New(&inmemory.Options{TTL: 60 * time.Minute, CleanupInterval: 10 * time.Minute}),
+		Reader:         nil,
+		FluxInterval:   5 * time.Minute,
+		KeyGenerator:   queryBuilder.NewKeyGenerator(),
+		TracerProvider: tracerProvider,
+		TestingMode:    true,
+		ReturnedSeries: []*v3.Series{
+			{
+				Labels: map[string]string{"service_name": "test"},
+				Points: []v3.Point{{Timestamp: 1675115596722, Value: 1}},
+			},
+		},
+	})
+
+	_, _, err := q.QueryRange(context.Background(), params, nil)
+	if err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+
+	spansByName := make(map[string]sdktrace.ReadOnlySpan)
+	for _, span := range recorder.Ended() {
+		spansByName[span.Name()] = span
+	}
+
+	expectedParents := map[string]string{
+		"runPromQueries": "QueryRange",
+		"runPromQuery":   "runPromQueries",
+		"execPromQuery":  "runPromQuery",
+	}
+	for name, parentName := range expectedParents {
+		span, ok := spansByName[name]
+		if !ok {
+			t.Fatalf("expected span %s to be recorded", name)
+		}
+		parent, ok := spansByName[parentName]
+		if !ok {
+			t.Fatalf("expected span %s to be recorded", parentName)
+		}
+		if span.Parent().SpanID() != parent.SpanContext().SpanID() {
+			t.Errorf("expected span %s to be a child of %s", name, parentName)
+		}
+	}
+
+	hasAttribute := func(span sdktrace.ReadOnlySpan, expected attribute.KeyValue) bool {
+		for _, attr := range span.Attributes() {
+			if attr == expected {
+				return true
+			}
+		}
+		return false
+	}
+	expectedAttributes := map[string][]attribute.KeyValue{
+		"QueryRange":    {attrQueryType.String(string(v3.QueryTypePromQL)), attrPanelType.String(string(v3.PanelTypeGraph))},
+		"runPromQuery":  {attrQueryName.String("A"), attrCacheStatus.String("key miss")},
+		"execPromQuery": {attrSeriesCount.Int(1)},
+	}
+	for name, attrs := range expectedAttributes {
+		for _, attr := range attrs {
+			if !hasAttribute(spansByName[name], attr) {
+				t.Errorf("expected span %s to have attribute %v, got %v", name, attr, spansByName[name].Attributes())
+			}
+		}
+	}
+}
+
+func TestQueryRangeInvalidTimeRange(t *testing.T) {
+	testCases := []struct {
+		name        string
+		start       int64
+		end         int64
+		step        int64
+		expectedErr string
+	}{
+		{
+			name:        "inverted time range",
+			start:       1675115596722 + 60*60*1000,
+			end:         1675115596722,
+			step:        60,
+			expectedErr: "invalid time range",
+		},
+		{
+			name:        "equal time range",
+			start:       1675115596722,
+			end:         1675115596722,
+			step:        60,
+			expectedErr: "invalid time range",
+		},
+		{
+			name:        "zero step",
+			start:       1675115596722,
+			end:         1675115596722 + 60*60*1000,
+			step:        0,
+			expectedErr: "invalid step",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			params := &v3.QueryRangeParamsV3{
+				Start: tc.start,
+				End:   tc.end,
+				Step:  tc.step,
+				CompositeQuery: &v3.CompositeQuery{
+					QueryType: v3.QueryTypePromQL,
+					PanelType: v3.PanelTypeGraph,
+					PromQueries: map[string]*v3.PromQuery{
+						"A": {Query: "signoz_calls_total"},
+					},
+				},
+			}
+			q := NewQuerier(QuerierOptions{
+				Reader:       nil,
+				FluxInterval: 5 * time.Minute,
+				KeyGenerator: queryBuilder.NewKeyGenerator(),
+				TestingMode:  true,
+			})
+
+			_, _, err := q.QueryRange(context.Background(), params, nil)
+			if err == nil || !strings.Contains(err.Error(), tc.expectedErr) {
+				t.Errorf("expected error containing %q, got %v", tc.expectedErr, err)
+			}
+			if len(q.QueriesExecuted()) != 0 {
+				t.Errorf("expected no queries to be executed, got %v", q.QueriesExecuted())
+			}
+		})
+	}
+}
+
+func TestQueryRangeStaleWhileRevalidate(t *testing.T) {
+	now := time.Now()
+	end := now.UnixMilli()
+	start := end - 60*60*1000
+	labels := map[string]string{"service_name": "test"}
+	fluxBoundary := common.FluxBoundaryAt(60, 0, 5*time.Minute, now)
+
+	// the cache holds the settled series and, apart from them, the flux tail
+	settledSeries := []*v3.Series{{Labels: labels}}
+	tailSeries := []*v3.Series{{Labels: labels}}
+	for ts := start; ts <= end; ts += 60 * 1000 {
+		if ts <= fluxBoundary {
+			settledSeries[0].Points = append(settledSeries[0].Points, v3.Point{Timestamp: ts, Value: 1})
+		} else {
+			tailSeries[0].Points = append(tailSeries[0].Points, v3.Point{Timestamp: ts, Value: 1})
+		}
+	}
+	c := inmemory.New(&inmemory.Options{TTL: 60 * time.Minute, CleanupInterval: 10 * time.Minute})
+	for key, seriesList := range map[string][]*v3.Series{
+		// prom queries use the query as the cache key
+		"signoz_calls_total":                     settledSeries,
+		"signoz_calls_total" + fluxTailKeySuffix: tailSeries,
+	} {
+		data, err := json.Marshal(seriesList)
+		if err != nil {
+			t.Fatalf("error marshalling cached series: %s", err)
+		}
+		if err := c.Store(key, data, time.Hour); err != nil {
+			t.Fatalf("error storing cached series: %s", err)
+		}
+	}
+
+	params := &v3.QueryRangeParamsV3{
+		Start: start,
+		End:   end,
+		Step:  60,
+		CompositeQuery: &v3.CompositeQuery{
+			QueryType: v3.QueryTypePromQL,
+			PanelType: v3.PanelTypeGraph,
+			PromQueries: map[string]*v3.PromQuery{
+				"A": {Query: "signoz_calls_total"},
+			},
+		},
+	}
+	q := NewQuerier(QuerierOptions{
+		Cache:                c,
+		Reader:               nil,
+		FluxInterval:         5 * time.Minute,
+		KeyGenerator:         queryBuilder.NewKeyGenerator(),
+		StaleWhileRevalidate: true,
+		NowFunc:              func() time.Time { return now },
+		TestingMode:          true,
+		ReturnedSeries: []*v3.Series{
+			{Labels: labels, Points: []v3.Point{{Timestamp: end, Value: 2}}},
+		},
+	})
+	waitRevalidations := func() {
+		deadline := time.Now().Add(5 * time.Second)
+		for len(q.(*querier).revalidateSem) > 0 {
+			if time.Now().After(deadline) {
+				t.Fatalf("expected the cache to be revalidated")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	results, _, err := q.QueryRange(context.Background(), params, nil)
+	if err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+	if len(results) != 1 || len(results[0].Series) != 1 {
+		t.Fatalf("expected one result with one series, got %v", results)
+	}
+	// the stale cached data, with the stale flux tail, is returned immediately
+	points := results[0].Series[0].Points
+	for _, point := range points {
+		if point.Value != 1 {
+			t.Fatalf("expected only stale cached points, got %v", point)
+		}
+	}
+	if points[len(points)-1].Timestamp <= fluxBoundary {
+		t.Fatalf("expected the stale flux tail to be returned, got the last point %v", points[len(points)-1])
+	}
+
+	// the flux tail is refetched in the background
+	waitRevalidations()
+	if len(q.TimeRanges()) != 1 {
+		t.Fatalf("expected one refetch, got %v", q.TimeRanges())
+	}
+	if fluxStart := int64(q.TimeRanges()[0][0]); fluxStart < end-6*60*1000 {
+		t.Errorf("expected only the flux tail to be refetched, got %v", q.TimeRanges()[0])
+	}
+
+	// the settled series are still stored without the flux tail
+	data, _, err := c.Retrieve("signoz_calls_total", true)
+	if err != nil {
+		t.Fatalf("error retrieving cached series: %s", err)
+	}
+	var revalidated []*v3.Series
+	if err := json.Unmarshal(data, &revalidated); err != nil {
+		t.Fatalf("error unmarshalling cached series: %s", err)
+	}
+	for _, point := range revalidated[0].Points {
+		if point.Timestamp > fluxBoundary {
+			t.Fatalf("expected the flux tail to not be stored with the settled series, got %v", point)
+		}
+	}
+
+	// the next load sees the revalidated flux tail
+	results, _, err = q.QueryRange(context.Background(), params, nil)
+	if err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+	waitRevalidations()
+	points = results[0].Series[0].Points
+	if last := points[len(points)-1]; last.Timestamp != end || last.Value != 2 {
+		t.Errorf("expected the revalidated flux tail point {%d 2}, got %v", end, last)
+	}
+}
+
+func TestQueryRangeStaleWhileRevalidateWithoutFluxTail(t *testing.T) {
+	now := time.Now()
+	end := now.UnixMilli()
+	start := end - 60*60*1000
+	labels := map[string]string{"service_name": "test"}
+	fluxBoundary := common.FluxBoundaryAt(60, 0, 5*time.Minute, now)
+
+	// the settled series are cached, without a flux tail to serve
+	settledSeries := []*v3.Series{{Labels: labels}}
+	for ts := start; ts <= fluxBoundary; ts += 60 * 1000 {
+		settledSeries[0].Points = append(settledSeries[0].Points, v3.Point{Timestamp: ts, Value: 1})
+	}
+	data, err := json.Marshal(settledSeries)
+	if err != nil {
+		t.Fatalf("error marshalling cached series: %s", err)
+	}
+	c := inmemory.New(&inmemory.Options{TTL: 60 * time.Minute, CleanupInterval: 10 * time.Minute})
+	if err := c.Store("signoz_calls_total", data, time.Hour); err != nil {
+		t.Fatalf("error storing cached series: %s", err)
+	}
+
+	params := &v3.QueryRangeParamsV3{
+		Start: start,
+		End:   end,
+		Step:  60,
+		CompositeQuery: &v3.CompositeQuery{
+			QueryType: v3.QueryTypePromQL,
+			PanelType: v3.PanelTypeGraph,
+			PromQueries: map[string]*v3.PromQuery{
+				"A": {Query: "signoz_calls_total"},
+			},
+		},
+	}
+	q := NewQuerier(QuerierOptions{
+		Cache:                c,
+		Reader:               nil,
+		FluxInterval:         5 * time.Minute,
+		KeyGenerator:         queryBuilder.NewKeyGenerator(),
+		StaleWhileRevalidate: true,
+		NowFunc:              func() time.Time { return now },
+		TestingMode:          true,
+		ReturnedSeries: []*v3.Series{
+			{Labels: labels, Points: []v3.Point{{Timestamp: end, Value: 2}}},
+		},
+	})
+
+	results, _, err := q.QueryRange(context.Background(), params, nil)
+	if err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+	// the flux tail is fetched for the response rather than left out of it
+	points := results[0].Series[0].Points
+	if last := points[len(points)-1]; last.Timestamp != end || last.Value != 2 {
+		t.Errorf("expected the fetched flux tail point {%d 2}, got %v", end, last)
+	}
+	if len(q.(*querier).revalidateSem) != 0 {
+		t.Errorf("expected no background revalidation")
+	}
+
+	// and stored for the next load to serve while revalidating it
+	tailSeries, ok := q.(*querier).retrieveFluxTail("signoz_calls_total")
+	if !ok || len(tailSeries) != 1 || len(tailSeries[0].Points) != 1 || tailSeries[0].Points[0].Value != 2 {
+		t.Errorf("expected the fetched flux tail to be stored, got %v", tailSeries)
+	}
+}
+
+func TestQueryRangeCacheKeysNamespacedByTenant(t *testing.T) {
+	params := &v3.QueryRangeParamsV3{
+		Start: 1675115596722,
+		End:   1675115596722 + 120*60*1000,
+		Step:  60,
+		CompositeQuery: &v3.CompositeQuery{
+			QueryType: v3.QueryTypePromQL,
+			PanelType: v3.PanelTypeGraph,
+			PromQueries: map[string]*v3.PromQuery{
+				"A": {Query: "signoz_calls_total"},
+			},
+		},
+	}
+	tenantCtx := func(orgID string) context.Context {
+		user := &model.UserPayload{User: model.User{OrgId: orgID}}
+		return context.WithValue(context.Background(), constants.ContextUserKey, user)
+	}
+	ctxA, ctxB := tenantCtx("org-a"), tenantCtx("org-b")
+
+	q := NewQuerier(QuerierOptions{
+		Cache:        inmemory.New(&inmemory.Options{TTL: 60 * time.Minute, CleanupInterval: 10 * time.Minute}),
+		Reader:       nil,
+		FluxInterval: 5 * time.Minute,
+		KeyGenerator: queryBuilder.NewKeyGenerator(),
+		TestingMode:  true,
+		ReturnedSeries: []*v3.Series{
+			{
+				Labels: map[string]string{"service_name": "test"},
+				Points: []v3.Point{
+					{Timestamp: 1675115596722, Value: 1},
+					{Timestamp: 1675115596722 + 120*60*1000, Value: 1},
+				},
+			},
+		},
+	})
+
+	keysA := q.(*querier).generateCacheKeys(ctxA, params)
+	keysB := q.(*querier).generateCacheKeys(ctxB, params)
+	if keysA["A"] == keysB["A"] {
+		t.Fatalf("expected distinct cache keys for distinct tenants, got %s", keysA["A"])
+	}
+
+	for _, ctx := range []context.Context{ctxA, ctxB} {
+		_, _, err := q.QueryRange(ctx, params, nil)
+		if err != nil {
+			t.Fatalf("expected no error, got %s", err)
+		}
+	}
+	// the second tenant must not be served the first tenant's cached data
+	if len(q.TimeRanges()) != 2 {
+		t.Fatalf("expected two fetches, got %v", q.TimeRanges())
+	}
+	for _, timeRange := range q.TimeRanges() {
+		if timeRange[0] != int(params.Start) || timeRange[1] != int(params.End) {
+			t.Errorf("expected full range fetch %d-%d, got %v", params.Start, params.End, timeRange)
+		}
+	}
+}
+
+func TestQueryRangeCacheTagSuffix(t *testing.T) {
+	newParams := func(suffix string) *v3.QueryRangeParamsV3 {
+		return &v3.QueryRangeParamsV3{
+			Start:          1675115596722,
+			End:            1675115596722 + 120*60*1000,
+			Step:           60,
+			CacheTagSuffix: suffix,
+			CompositeQuery: &v3.CompositeQuery{
+				QueryType: v3.QueryTypePromQL,
+				PanelType: v3.PanelTypeGraph,
+				PromQueries: map[string]*v3.PromQuery{
+					"A": {Query: "signoz_calls_total"},
+				},
+			},
+		}
+	}
+
+	q := NewQuerier(QuerierOptions{
+		Cache:        inmemory.New(&inmemory.Options{TTL: 60 * time.Minute, CleanupInterval: 10 * time.Minute}),
+		Reader:       nil,
+		FluxInterval: 5 * time.Minute,
+		KeyGenerator: queryBuilder.NewKeyGenerator(),
+		TestingMode:  true,
+		ReturnedSeries: []*v3.Series{
+			{
+				Labels: map[string]string{"service_name": "test"},
+				Points: []v3.Point{
+					{Timestamp: 1675115596722, Value: 1},
+					{Timestamp: 1675115596722 + 120*60*1000, Value: 1},
+				},
+			},
+		},
+	})
+
+	keysA := q.(*querier).generateCacheKeys(context.Background(), newParams("service=frontend"))
+	keysB := q.(*querier).generateCacheKeys(context.Background(), newParams("service=cart"))
+	if keysA["A"] == keysB["A"] {
+		t.Fatalf("expected distinct cache keys for distinct suffixes, got %s", keysA["A"])
+	}
+
+	for _, suffix := range []string{"service=frontend", "service=cart", "service=frontend"} {
+		_, _, err := q.QueryRange(context.Background(), newParams(suffix), nil)
+		if err != nil {
+			t.Fatalf("expected no error, got %s", err)
+		}
+	}
+	// the second suffix is not served the data cached for the first one,
+	// which is served from the cache when requested again
+	if len(q.TimeRanges()) != 2 {
+		t.Fatalf("expected two fetches, got %v", q.TimeRanges())
+	}
+}
+
+func TestQueryRangeCacheAudit(t *testing.T) {
+	end := int64(1675115580000)
+	minute := time.Minute.Milliseconds()
+	for _, tc := range []struct {
+		name             string
+		sampleRate       float64
+		expectedWarnings int
+	}{
+		{name: "audit disabled", sampleRate: 0, expectedWarnings: 0},
+		{name: "audit enabled", sampleRate: 1, expectedWarnings: 3},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			core, logs := observer.New(zap.WarnLevel)
+			defer zap.ReplaceGlobals(zap.New(core))()
+
+			// the data changed in the database after it was cached
+			var calls atomic.Int64
+			reader := &mockReader{promResultFn: func() *promql.Result {
+				value, last := float64(1), end-30*minute
+				if calls.Add(1) > 1 {
+					value, last = 2, end
+				}
+				var points []promql.FPoint
+				for ts := end - 60*minute; ts <= last; ts += minute {
+					points = append(points, promql.FPoint{T: ts, F: value})
+				}
+				return &promql.Result{Value: promql.Matrix{
+					{Metric: labels.FromStrings("__name__", "signoz_latency"), Floats: points},
+				}}
+			}}
+			q := NewQuerier(QuerierOptions{
+				Cache:                inmemory.New(&inmemory.Options{TTL: 60 * time.Minute, CleanupInterval: 10 * time.Minute}),
+				Reader:               reader,
+				FluxInterval:         5 * time.Minute,
+				KeyGenerator:         queryBuilder.NewKeyGenerator(),
+				CacheAuditSampleRate: tc.sampleRate,
+			})
+			params := &v3.QueryRangeParamsV3{
+				Start: end - 60*minute,
+				End:   end - 30*minute,
+				Step:  60,
+				CompositeQuery: &v3.CompositeQuery{
+					QueryType:   v3.QueryTypePromQL,
+					PanelType:   v3.PanelTypeGraph,
+					PromQueries: map[string]*v3.PromQuery{"A": {Query: "signoz_latency"}},
+				},
+			}
+			if _, _, err := q.QueryRange(context.Background(), params, nil); err != nil {
+				t.Fatalf("expected no error, got %s", err)
+			}
+
+			// the partial hit refetches the last steps of the cached range
+			params.End = end
+			if _, _, err := q.QueryRange(context.Background(), params, nil); err != nil {
+				t.Fatalf("expected no error, got %s", err)
+			}
+			warnings := logs.FilterMessage("cached series diverge from the fetched series").All()
+			if len(warnings) != tc.expectedWarnings {
+				t.Fatalf("expected %d warnings, got %d", tc.expectedWarnings, len(warnings))
+			}
+			for _, warning := range warnings {
+				fields := warning.ContextMap()
+				if fields["cached"] != float64(1) || fields["fetched"] != float64(2) {
+					t.Errorf("expected the cached and fetched values in the warning, got %v", fields)
+				}
+			}
+		})
+	}
+}
+
+func TestQueryRangeNonFiniteValues(t *testing.T) {
+	end := int64(1675115580000)
+	minute := time.Minute.Milliseconds()
+	for _, tc := range []struct {
+		name            string
+		nonFiniteValues NonFiniteValues
+		expectedPoints  int
+		expectedNulls   int
+	}{
+		{name: "null", nonFiniteValues: "", expectedPoints: 4, expectedNulls: 3},
+		{name: "keep", nonFiniteValues: NonFiniteValuesKeep, expectedPoints: 4},
+		{name: "drop", nonFiniteValues: NonFiniteValuesDrop, expectedPoints: 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			reader := &mockReader{promResultFn: func() *promql.Result {
+				return &promql.Result{Value: promql.Matrix{
+					{
+						Metric: labels.FromStrings("__name__", "signoz_error_ratio"),
+						Floats: []promql.FPoint{
+							{T: end - 60*minute, F: 0.5},
+							{T: end - 59*minute, F: math.NaN()},
+							{T: end - 58*minute, F: math.Inf(1)},
+							{T: end - 57*minute, F: math.Inf(-1)},
+						},
+					},
+				}}
+			}}
+			c := inmemory.New(&inmemory.Options{TTL: 60 * time.Minute, CleanupInterval: 10 * time.Minute})
+			q := NewQuerier(QuerierOptions{
+				Cache:           c,
+				Reader:          reader,
+				FluxInterval:    5 * time.Minute,
+				KeyGenerator:    queryBuilder.NewKeyGenerator(),
+				NonFiniteValues: tc.nonFiniteValues,
+			})
+			params := &v3.QueryRangeParamsV3{
+				Start: end - 60*minute,
+				End:   end,
+				Step:  60,
+				CompositeQuery: &v3.CompositeQuery{
+					QueryType:   v3.QueryTypePromQL,
+					PanelType:   v3.PanelTypeGraph,
+					PromQueries: map[string]*v3.PromQuery{"A": {Query: "signoz_error_ratio"}},
+				},
+			}
+
+			results, _, err := q.QueryRange(context.Background(), params, nil)
+			if err != nil {
+				t.Fatalf("expected no error, got %s", err)
+			}
+			if len(results[0].Series[0].Points) != tc.expectedPoints {
+				t.Errorf("expected %d points, got %v", tc.expectedPoints, results[0].Series[0].Points)
+			}
+
+			response, err := json.Marshal(results)
+			if err != nil || !json.Valid(response) {
+				t.Errorf("expected the response to be valid JSON, got %s, %v", response, err)
+			}
+			cachedData, _, err := c.Retrieve(q.(*querier).generateCacheKeys(context.Background(), params)["A"], true)
+			if err != nil || !json.Valid(cachedData) {
+				t.Fatalf("expected the cached data to be valid JSON, got %s, %v", cachedData, err)
+			}
+			var cachedSeries []*v3.Series
+			if err := json.Unmarshal(cachedData, &cachedSeries); err != nil {
+				t.Fatalf("expected the cached data to be decoded, got %s", err)
+			}
+			if len(cachedSeries[0].Points) != tc.expectedPoints {
+				t.Errorf("expected %d cached points, got %v", tc.expectedPoints, cachedSeries[0].Points)
+			}
+			// the NaN and Inf values are cached as null values
+			var nulls int
+			for _, point := range cachedSeries[0].Points {
+				if point.Null {
+					nulls++
+				}
+			}
+			if nulls != tc.expectedNulls || strings.Count(string(cachedData), `"value":null`) != tc.expectedNulls {
+				t.Errorf("expected %d null cached values, got %s", tc.expectedNulls, cachedData)
+			}
+			if tc.expectedNulls > 0 && (strings.Contains(string(cachedData), "NaN") || strings.Contains(string(cachedData), "Inf")) {
+				t.Errorf("expected no NaN or Inf cached values, got %s", cachedData)
+			}
+		})
+	}
+}
+
+func TestQueryRangeExemplars(t *testing.T) {
+	end := int64(1675115580000)
+	minute := time.Minute.Milliseconds()
+	start := end - 3*minute
+	c := inmemory.New(&inmemory.Options{TTL: 60 * time.Minute, CleanupInterval: 10 * time.Minute})
+	var exemplarsQueries []string
+	reader := &mockReader{
+		timeSeriesFn: func(query string) ([]*v3.Series, error) {
+			return []*v3.Series{
+				{Labels: map[string]string{"service_name": "frontend"}, Points: []v3.Point{
+					{Timestamp: start, Value: 1}, {Timestamp: start + minute, Value: 2}, {Timestamp: start + 2*minute, Value: 3},
+				}},
+				{Labels: map[string]string{"service_name": "redis"}, Points: []v3.Point{
+					{Timestamp: start, Value: 4}, {Timestamp: start + minute, Value: 5},
+				}},
+			}, nil
+		},
+		exemplarsFn: func(query string) ([]v3.Exemplar, error) {
+			exemplarsQueries = append(exemplarsQueries, query)
+			return []v3.Exemplar{
+				{Labels: map[string]string{"service_name": "frontend"}, Timestamp: start + minute, TraceID: "frontend-trace"},
+				{Labels: map[string]string{"service_name": "redis"}, Timestamp: start, TraceID: "redis-trace"},
+				// no series has the labels of the exemplar
+				{Labels: map[string]string{"service_name": "mysql"}, Timestamp: start, TraceID: "mysql-trace"},
+			}, nil
+		},
+	}
+	q := NewQuerier(QuerierOptions{
+		Cache:         c,
+		Reader:        reader,
+		FluxInterval:  5 * time.Minute,
+		KeyGenerator:  queryBuilder.NewKeyGenerator(),
+		FeatureLookup: featureManager.StartManager(),
+	})
+	params := &v3.QueryRangeParamsV3{
+		Start: start,
+		End:   end,
+		Step:  60,
+		CompositeQuery: &v3.CompositeQuery{
+			QueryType: v3.QueryTypeBuilder,
+			PanelType: v3.PanelTypeGraph,
+			BuilderQueries: map[string]*v3.BuilderQuery{
+				"A": {
+					QueryName:          "A",
+					StepInterval:       60,
+					DataSource:         v3.DataSourceMetrics,
+					AggregateAttribute: v3.AttributeKey{Key: "signoz_calls_total"},
+					AggregateOperator:  v3.AggregateOperatorSumRate,
+					Temporality:        v3.Cumulative,
+					GroupBy:            []v3.AttributeKey{{Key: "service_name"}},
+					Expression:         "A",
+					IncludeExemplars:   true,
+				},
+			},
+		},
+	}
+
+	results, _, err := q.QueryRange(context.Background(), params, nil)
+	if err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+	if len(exemplarsQueries) != 1 || !strings.Contains(exemplarsQueries[0], "distributed_exemplars") {
+		t.Fatalf("expected one exemplars query, got %v", exemplarsQueries)
+	}
+	expected := map[string]map[int64]string{
+		"frontend": {start + minute: "frontend-trace"},
+		"redis":    {start: "redis-trace"},
+	}
+	for _, series := range results[0].Series {
+		if !reflect.DeepEqual(series.Exemplars, expected[series.Labels["service_name"]]) {
+			t.Errorf("expected the exemplars %v for %s, got %v", expected[series.Labels["service_name"]], series.Labels["service_name"], series.Exemplars)
+		}
+	}
+	// the exemplars are encoded with the series, apart from the points
+	data, err := json.Marshal(results[0].Series[0])
+	if err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+	if expected := fmt.Sprintf(`"exemplars":{"%d":"frontend-trace"}`, start+minute); !strings.Contains(string(data), expected) {
+		t.Errorf("expected the series encoded with %s, got %s", expected, data)
+	}
+	if strings.Contains(string(data), "exemplarTraceId") {
+		t.Errorf("expected no exemplar in the points, got %s", data)
+	}
+
+	// the exemplars are fetched with each query, not cached with the points
+	cacheKey := queryBuilder.NewKeyGenerator().GenerateKeys(params)["A"]
+	data, _, err = c.Retrieve(cacheKey, true)
+	if err != nil || len(data) == 0 {
+		t.Fatalf("expected the cached series, got %s, %v", data, err)
+	}
+	if strings.Contains(string(data), "trace") {
+		t.Errorf("expected no exemplars in the cached series, got %s", data)
+	}
+}
+
+func TestQueryRangeConcurrentMissFetches(t *testing.T) {
+	minute := time.Minute.Milliseconds()
+	end := int64(1675115580000)
+	start := end - 60*minute
+	newParams := func(start, end int64) *v3.QueryRangeParamsV3 {
+		return &v3.QueryRangeParamsV3{
+			Start: start,
+			End:   end,
+			Step:  60,
+			CompositeQuery: &v3.CompositeQuery{
+				QueryType:   v3.QueryTypePromQL,
+				PanelType:   v3.PanelTypeGraph,
+				PromQueries: map[string]*v3.PromQuery{"A": {Query: "signoz_latency"}},
+			},
+		}
+	}
+
+	t.Run("misses fetched concurrently", func(t *testing.T) {
+		var inFlight, maxInFlight atomic.Int32
+		// the fetches of the two misses wait for each other
+		bothStarted := make(chan struct{})
+		var startedOnce sync.Once
+		reader := &mockReader{promRangeFn: func(_ context.Context, params *model.QueryRangeParams) (*promql.Result, *model.ApiError) {
+			if n := inFlight.Add(1); n > maxInFlight.Load() {
+				maxInFlight.Store(n)
+			}
+			defer inFlight.Add(-1)
+			if inFlight.Load() == 2 {
+				startedOnce.Do(func() { close(bothStarted) })
+			}
+			if params.Start.UnixMilli() != start+20*minute {
+				select {
+				case <-bothStarted:
+				case <-time.After(time.Second):
+				}
+			}
+			return &promql.Result{Value: promql.Matrix{
+				{Metric: labels.FromStrings("__name__", "signoz_latency"), Floats: []promql.FPoint{{T: params.Start.UnixMilli(), F: 1}}},
+			}}, nil
+		}}
+		q := NewQuerier(QuerierOptions{
+			Cache:        inmemory.New(&inmemory.Options{TTL: 60 * time.Minute, CleanupInterval: 10 * time.Minute}),
+			Reader:       reader,
+			KeyGenerator: queryBuilder.NewKeyGenerator(),
+			FluxInterval: 5 * time.Minute,
+			NowFunc:      func() time.Time { return time.UnixMilli(end).Add(time.Hour) },
+		})
+
+		// cache the middle of the range, the range around it is then two misses
+		if _, _, err := q.QueryRange(context.Background(), newParams(start+20*minute, start+40*minute), nil); err != nil {
+			t.Fatalf("expected no error, got %s", err)
+		}
+		results, _, err := q.QueryRange(context.Background(), newParams(start, end), nil)
+		if err != nil {
+			t.Fatalf("expected no error, got %s", err)
+		}
+		if maxInFlight.Load() != 2 {
+			t.Errorf("expected the two misses to be fetched concurrently, got %d in flight", maxInFlight.Load())
+		}
+		var timestamps []int64
+		for _, point := range results[0].Series[0].Points {
+			timestamps = append(timestamps, point.Timestamp)
+		}
+		if !slices.IsSorted(timestamps) || len(timestamps) != 3 || timestamps[0] != start || timestamps[1] != start+20*minute {
+			t.Errorf("expected the points of both misses merged with the cached point, got %v", timestamps)
+		}
+	})
+
+	t.Run("first error cancels the other fetches", func(t *testing.T) {
+		fetchErr := &model.ApiError{Typ: model.ErrorExec, Err: fmt.Errorf("too many parts")}
+		reader := &mockReader{promRangeFn: func(ctx context.Context, params *model.QueryRangeParams) (*promql.Result, *model.ApiError) {
+			if params.Start.UnixMilli() == start+20*minute {
+				return &promql.Result{Value: promql.Matrix{}}, nil
+			}
+			if params.Start.UnixMilli() == start {
+				return nil, fetchErr
+			}
+			select {
+			case <-ctx.Done():
+				return nil, &model.ApiError{Typ: model.ErrorCanceled, Err: ctx.Err()}
+			case <-time.After(5 * time.Second):
+				t.Error("expected the fetch to be cancelled")
+				return &promql.Result{Value: promql.Matrix{}}, nil
+			}
+		}}
+		q := NewQuerier(QuerierOptions{
+			Cache:        inmemory.New(&inmemory.Options{TTL: 60 * time.Minute, CleanupInterval: 10 * time.Minute}),
+			Reader:       reader,
+			KeyGenerator: queryBuilder.NewKeyGenerator(),
+			FluxInterval: 5 * time.Minute,
+			NowFunc:      func() time.Time { return time.UnixMilli(end).Add(time.Hour) },
+		})
+
+		if _, _, err := q.QueryRange(context.Background(), newParams(start+20*minute, start+40*minute), nil); err != nil {
+			t.Fatalf("expected no error, got %s", err)
+		}
+		_, errByName, err := q.QueryRange(context.Background(), newParams(start, end), nil)
+		if err == nil {
+			t.Fatal("expected an error")
+		}
+		if errByName["A"] != fetchErr {
+			t.Errorf("expected the error of the failed fetch, got %v", errByName["A"])
+		}
+	})
+}
+
+func TestQueryRangeReaderHardTimeout(t *testing.T) {
+	end := int64(1675115580000)
+	// the readers ignore the context and only return when released
+	release := make(chan struct{})
+	defer close(release)
+	reader := &mockReader{
+		timeSeriesFn: func(query string) ([]*v3.Series, error) {
+			<-release
+			return nil, nil
+		},
+		promRangeFn: func(_ context.Context, _ *model.QueryRangeParams) (*promql.Result, *model.ApiError) {
+			<-release
+			return &promql.Result{Value: promql.Matrix{}}, nil
+		},
+	}
+	q := NewQuerier(QuerierOptions{
+		Reader:            reader,
+		KeyGenerator:      queryBuilder.NewKeyGenerator(),
+		ReaderHardTimeout: 50 * time.Millisecond,
+	})
+
+	for _, compositeQuery := range []*v3.CompositeQuery{
+		{
+			QueryType:         v3.QueryTypeClickHouseSQL,
+			PanelType:         v3.PanelTypeGraph,
+			ClickHouseQueries: map[string]*v3.ClickHouseQuery{"A": {Query: "SELECT sleep(3)"}},
+		},
+		{
+			QueryType:   v3.QueryTypePromQL,
+			PanelType:   v3.PanelTypeGraph,
+			PromQueries: map[string]*v3.PromQuery{"A": {Query: "signoz_latency"}},
+		},
+	} {
+		t.Run(string(compositeQuery.QueryType), func(t *testing.T) {
+			params := &v3.QueryRangeParamsV3{
+				Start:          end - time.Hour.Milliseconds(),
+				End:            end,
+				Step:           60,
+				CompositeQuery: compositeQuery,
+				NoCache:        true,
+			}
+			// the request context has no deadline
+			start := time.Now()
+			_, errByName, err := q.QueryRange(context.Background(), params, nil)
+			if elapsed := time.Since(start); elapsed > time.Second {
+				t.Errorf("expected the query to return after the hard timeout, took %s", elapsed)
+			}
+			if !errors.Is(err, ErrReaderHardTimeout) || !errors.Is(errByName["A"], ErrReaderHardTimeout) {
+				t.Errorf("expected a hard timeout error, got %v, %v", err, errByName)
+			}
+		})
+	}
+}
+
+func TestQueryRangeListTotal(t *testing.T) {
+	end := int64(1675115580000)
+	var countQueries []string
+	reader := &mockReader{
+		listFn: func(query string) ([]*v3.Row, error) {
+			rows := make([]*v3.Row, 0, 2)
+			for i := 0; i < 2; i++ {
+				rows = append(rows, &v3.Row{Timestamp: time.UnixMilli(end), Data: map[string]interface{}{"body": "log line"}})
+			}
+			return rows, nil
+		},
+		timeSeriesFn: func(query string) ([]*v3.Series, error) {
+			countQueries = append(countQueries, query)
+			return []*v3.Series{{Points: []v3.Point{{Timestamp: end, Value: 1234}}}}, nil
+		},
+	}
+	q := NewQuerier(QuerierOptions{
+		Cache:         inmemory.New(&inmemory.Options{TTL: 60 * time.Minute, CleanupInterval: 10 * time.Minute}),
+		Reader:        reader,
+		KeyGenerator:  queryBuilder.NewKeyGenerator(),
+		FeatureLookup: featureManager.StartManager(),
+	})
+	newParams := func(offset uint64) *v3.QueryRangeParamsV3 {
+		return &v3.QueryRangeParamsV3{
+			Start: end - time.Hour.Milliseconds(),
+			End:   end,
+			CompositeQuery: &v3.CompositeQuery{
+				QueryType: v3.QueryTypeBuilder,
+				PanelType: v3.PanelTypeList,
+				BuilderQueries: map[string]*v3.BuilderQuery{
+					"A": {
+						QueryName:         "A",
+						DataSource:        v3.DataSourceLogs,
+						AggregateOperator: v3.AggregateOperatorNoOp,
+						Expression:        "A",
+						Filters: &v3.FilterSet{Operator: "AND", Items: []v3.FilterItem{
+							{Key: v3.AttributeKey{Key: "service.name", DataType: v3.AttributeKeyDataTypeString, Type: v3.AttributeKeyTypeResource}, Operator: "=", Value: "frontend"},
+						}},
+						OrderBy:  []v3.OrderBy{{ColumnName: "timestamp", Order: "desc"}},
+						PageSize: 2,
+						Offset:   offset,
+					},
+				},
+			},
+			IncludeListTotal: true,
+		}
+	}
+
+	// the total accompanies each page, and is counted once for the pages of the same list
+	for _, offset := range []uint64{0, 2} {
+		results, _, err := q.QueryRange(context.Background(), newParams(offset), nil)
+		if err != nil {
+			t.Fatalf("expected no error, got %s", err)
+		}
+		if len(results) != 1 || len(results[0].List) != 2 {
+			t.Fatalf("expected a page of 2 rows, got %v", results)
+		}
+		if results[0].Total == nil || *results[0].Total != 1234 {
+			t.Errorf("expected the total 1234 with the page at offset %d, got %v", offset, results[0].Total)
+		}
+	}
+	if len(countQueries) != 1 {
+		t.Fatalf("expected the total to be counted once, got %v", countQueries)
+	}
+	if !strings.Contains(countQueries[0], "count(*)") || !strings.Contains(countQueries[0], "'frontend'") ||
+		strings.Contains(countQueries[0], "limit") || strings.Contains(countQueries[0], "LIMIT") {
+		t.Errorf("expected the count of the rows matching the filters, got %s", countQueries[0])
+	}
+
+	// the total is only counted when requested
+	params := newParams(0)
+	params.IncludeListTotal = false
+	results, _, err := q.QueryRange(context.Background(), params, nil)
+	if err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+	if results[0].Total != nil {
+		t.Errorf("expected no total, got %d", *results[0].Total)
+	}
+}
+
+// mockReader implements the reader methods used by the querier,
+// calling any other method panics
+type mockReader struct {
+	interfaces.Reader
+
+	promResult *promql.Result
+	// promResultFn, if set, is called for each prom query instead of returning promResult
+	promResultFn func() *promql.Result
+	// promRangeFn, if set, is called with the range of each prom query instead
+	promRangeFn func(ctx context.Context, params *model.QueryRangeParams) (*promql.Result, *model.ApiError)
+	// timeSeriesErrs is the error returned for each time series query
+	timeSeriesErrs map[string]error
+	// timeSeriesFn, if set, is called for each time series query instead
+	timeSeriesFn func(query string) ([]*v3.Series, error)
+	// estimateFn returns the cost estimate of each query
+	estimateFn func(query string) (*v3.QueryCostEstimate, error)
+	// validateFn returns the syntax error of each query
+	validateFn func(query string) error
+	// exemplarsFn returns the exemplars of each exemplars query
+	exemplarsFn func(query string) ([]v3.Exemplar, error)
+	// listFn returns the rows of each list query
+	listFn func(query string) ([]*v3.Row, error)
+	// annotations are returned for any time range
+	annotations []v3.Annotation
+	// metricUnits are the units of the metrics in their metadata
+	metricUnits map[string]string
+	// scannedRows are the rows each time series query reports it read, the query
+	// is aborted if its context is cancelled then
+	scannedRows uint64
+
+	mu sync.Mutex
+	// queryIDs are the clickhouse query ids the time series queries were run with
+	queryIDs map[string]string
+	// cancelledQueryIDs are the query ids CancelQuery was called with
+	cancelledQueryIDs []string
+	// priorities are the clickhouse priorities the time series queries were run with
+	priorities map[string]int
+	// metadataFetches are the metrics whose metadata was fetched
+	metadataFetches []string
+}
+
+func (m *mockReader) CancelQuery(_ context.Context, queryID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.cancelledQueryIDs = append(m.cancelledQueryIDs, queryID)
+	return nil
+}
+
+func (m *mockReader) GetExemplarsV3(_ context.Context, query string) ([]v3.Exemplar, error) {
+	return m.exemplarsFn(query)
+}
+
+func (m *mockReader) GetAnnotations(_ context.Context, start, end int64) ([]v3.Annotation, error) {
+	return m.annotations, nil
+}
+
+func (m *mockReader) GetMetricMetadata(_ context.Context, metricName, _ string) (*v3.MetricMetadataResponse, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.metadataFetches = append(m.metadataFetches, metricName)
+	return &v3.MetricMetadataResponse{Unit: m.metricUnits[metricName]}, nil
+}
+
+func (m *mockReader) GetListResultV3(_ context.Context, query string) ([]*v3.Row, error) {
+	return m.listFn(query)
+}
+
+func (m *mockReader) ValidateQuery(_ context.Context, query string) error {
+	return m.validateFn(query)
+}
+
+func (m *mockReader) EstimateQueryCost(_ context.Context, query string) (*v3.QueryCostEstimate, error) {
+	return m.estimateFn(query)
+}
+
+func (m *mockReader) GetTimeSeriesResultV3(ctx context.Context, query string) ([]*v3.Series, error) {
+	if queryID, ok := ctx.Value(common.ClickHouseQueryIDKey).(string); ok {
+		m.mu.Lock()
+		if m.queryIDs == nil {
+			m.queryIDs = map[string]string{}
+		}
+		m.queryIDs[query] = queryID
+		m.mu.Unlock()
+	}
+	if priority, ok := ctx.Value(common.ClickHousePriorityKey).(int); ok {
+		m.mu.Lock()
+		if m.priorities == nil {
+			m.priorities = map[string]int{}
+		}
+		m.priorities[query] = priority
+		m.mu.Unlock()
+	}
+	if meter, ok := ctx.Value(common.ClickHouseScanMeterKey).(common.ScanMeter); ok && m.scannedRows > 0 {
+		meter.AddScanned(m.scannedRows, 0)
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+	}
+	if m.timeSeriesFn != nil {
+		return m.timeSeriesFn(query)
+	}
+	return nil, m.timeSeriesErrs[query]
+}
+
+func (m *mockReader) GetQueryRangeResult(ctx context.Context, params *model.QueryRangeParams) (*promql.Result, *stats.QueryStats, *model.ApiError) {
+	if m.promRangeFn != nil {
+		result, apiErr := m.promRangeFn(ctx, params)
+		return result, nil, apiErr
+	}
+	if m.promResultFn != nil {
+		return m.promResultFn(), nil, nil
+	}
+	return m.promResult, nil, nil
+}
+
+func TestQueryRangeReturnPromMatrix(t *testing.T) {
+	matrix := promql.Matrix{
+		{
+			Metric: labels.FromStrings("__name__", "signoz_latency", "service_name", "test"),
+			Floats: []promql.FPoint{{T: 1675115596722, F: 1}},
+			Histograms: []promql.HPoint{
+				{T: 1675115596722, H: &histogram.FloatHistogram{Count: 3, Sum: 42}},
+			},
+		},
+	}
+	params := &v3.QueryRangeParamsV3{
+		Start: 1675115596722,
+		End:   1675115596722 + 120*60*1000,
+		Step:  60,
+		CompositeQuery: &v3.CompositeQuery{
+			QueryType: v3.QueryTypePromQL,
+			PanelType: v3.PanelTypeGraph,
+			PromQueries: map[string]*v3.PromQuery{
+				"A": {Query: "signoz_latency"},
+			},
+		},
+	}
+	q := NewQuerier(QuerierOptions{
+		Cache:        inmemory.New(&inmemory.Options{TTL: 60 * time.Minute, CleanupInterval: 10 * time.Minute}),
+		Reader:       &mockReader{promResult: &promql.Result{Value: matrix}},
+		FluxInterval: 5 * time.Minute,
+		KeyGenerator: queryBuilder.NewKeyGenerator(),
+	})
+
+	// by default only the converted series are returned
+	results, _, err := q.QueryRange(context.Background(), params, nil)
+	if err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+	if len(results) != 1 || results[0].Matrix != nil || len(results[0].Series) != 1 {
+		t.Fatalf("expected only the converted series, got %v", results)
+	}
+
+	params.ReturnPromMatrix = true
+	results, _, err = q.QueryRange(context.Background(), params, nil)
+	if err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+	if len(results) != 1 || len(results[0].Series) != 1 {
+		t.Fatalf("expected one result with one series, got %v", results)
+	}
+	if len(results[0].Matrix) != 1 || len(results[0].Matrix[0].Histograms) != 1 {
+		t.Fatalf("expected the native matrix with histograms, got %v", results[0].Matrix)
+	}
+	if h := results[0].Matrix[0].Histograms[0].H; h.Count != 3 || h.Sum != 42 {
+		t.Errorf("expected the native histogram to survive, got %v", h)
+	}
+}
+
+func TestQueryRangeCombinedErrors(t *testing.T) {
+	errA := errors.New("error in query A")
+	errB := errors.New("error in query B")
+	params := &v3.QueryRangeParamsV3{
+		Start: 1675115596722,
+		End:   1675115596722 + 120*60*1000,
+		Step:  60,
+		CompositeQuery: &v3.CompositeQuery{
+			QueryType: v3.QueryTypeClickHouseSQL,
+			PanelType: v3.PanelTypeGraph,
+			ClickHouseQueries: map[string]*v3.ClickHouseQuery{
+				"A": {Query: "SELECT A"},
+				"B": {Query: "SELECT B"},
+			},
+		},
+	}
+	q := NewQuerier(QuerierOptions{
+		Reader: &mockReader{timeSeriesErrs: map[string]error{
+			"SELECT A": errA,
+			"SELECT B": errB,
+		}},
+		FluxInterval: 5 * time.Minute,
+		KeyGenerator: queryBuilder.NewKeyGenerator(),
+	})
+
+	_, errQueriesByName, err := q.QueryRange(context.Background(), params, nil)
+	if err == nil {
+		t.Fatalf("expected error, got nil")
+	}
+	for _, expectedErr := range []error{errA, errB} {
+		if !errors.Is(err, expectedErr) {
+			t.Errorf("expected %q to be retrievable from %q", expectedErr, err)
+		}
+	}
+	if errQueriesByName["A"] != errA || errQueriesByName["B"] != errB {
+		t.Errorf("expected errors by query name, got %v", errQueriesByName)
+	}
+}
+
+func TestFilterCachedPointsZeroTimestamp(t *testing.T) {
+	testCases := []struct {
+		name              string
+		start             int64
+		end               int64
+		keepZeroTimestamp bool
+		expected          []int64
+	}{
+		{
+			name:              "in range zero timestamp is retained",
+			start:             0,
+			end:               200,
+			keepZeroTimestamp: true,
+			expected:          []int64{0, 100},
+		},
+		{
+			name:              "in range zero timestamp is retained with strict filter",
+			start:             0,
+			end:               200,
+			keepZeroTimestamp: false,
+			expected:          []int64{0, 100},
+		},
+		{
+			name:              "out of range zero timestamp is retained",
+			start:             50,
+			end:               200,
+			keepZeroTimestamp: true,
+			expected:          []int64{0, 100},
+		},
+		{
+			name:              "out of range zero timestamp is filtered with strict filter",
+			start:             50,
+			end:               200,
+			keepZeroTimestamp: false,
+			expected:          []int64{100},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			series := []*v3.Series{
+				{
+					Points: []v3.Point{
+						{Timestamp: 0, Value: 1},
+						{Timestamp: 100, Value: 2},
+						{Timestamp: 300, Value: 3},
+					},
+				},
+			}
+			filterCachedPoints(series, tc.start, tc.end, tc.keepZeroTimestamp)
+
+			var timestamps []int64
+			for _, point := range series[0].Points {
+				timestamps = append(timestamps, point.Timestamp)
+			}
+			if fmt.Sprint(timestamps) != fmt.Sprint(tc.expected) {
+				t.Errorf("expected timestamps %v, got %v", tc.expected, timestamps)
+			}
+		})
+	}
+}
+
+func TestQueryRangeConcurrentCacheStores(t *testing.T) {
+	start := int64(1675115596722)
+	params := &v3.QueryRangeParamsV3{
+		Start: start,
+		End:   start + 120*60*1000,
+		Step:  60,
+		CompositeQuery: &v3.CompositeQuery{
+			QueryType: v3.QueryTypePromQL,
+			PanelType: v3.PanelTypeGraph,
+			PromQueries: map[string]*v3.PromQuery{
+				"A": {Query: "signoz_latency"},
+			},
+		},
+	}
+
+	// each execution returns a different point, as if the two requests
+	// observed the database at different times. Both executions wait for
+	// each other, so that both requests miss the cache before either stores.
+	var calls atomic.Int64
+	var fetched sync.WaitGroup
+	fetched.Add(2)
+	reader := &mockReader{promResultFn: func() *promql.Result {
+		n := calls.Add(1)
+		fetched.Done()
+		fetched.Wait()
+		return &promql.Result{Value: promql.Matrix{
+			{
+				Metric: labels.FromStrings("__name__", "signoz_latency"),
+				Floats: []promql.FPoint{{T: start + n*60*1000, F: float64(n)}},
+			},
+		}}
+	}}
+	c := inmemory.New(&inmemory.Options{TTL: 60 * time.Minute, CleanupInterval: 10 * time.Minute})
+	q := NewQuerier(QuerierOptions{
+		Cache:        c,
+		Reader:       reader,
+		FluxInterval: 5 * time.Minute,
+		KeyGenerator: queryBuilder.NewKeyGenerator(),
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, _, err := q.QueryRange(context.Background(), params, nil); err != nil {
+				t.Errorf("expected no error, got %s", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	cacheKey := q.(*querier).generateCacheKeys(context.Background(), params)["A"]
+	data, _, err := c.Retrieve(cacheKey, true)
+	if err != nil {
+		t.Fatalf("expected the series to be cached, got %s", err)
+	}
+	var cachedSeries []*v3.Series
+	if err := json.Unmarshal(data, &cachedSeries); err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+	if len(cachedSeries) != 1 || len(cachedSeries[0].Points) != 2 {
+		t.Fatalf("expected the points of both queries to be cached, got %v", cachedSeries)
+	}
+}
+
+func TestQueryRangeMaxSeries(t *testing.T) {
+	returnedSeries := []*v3.Series{
+		{
+			Labels: map[string]string{"service_name": "a"},
+			Points: []v3.Point{{Timestamp: 1675115596722, Value: 10}, {Timestamp: 1675115656722, Value: 1}},
+		},
+		{
+			Labels: map[string]string{"service_name": "b"},
+			Points: []v3.Point{{Timestamp: 1675115596722, Value: 1}, {Timestamp: 1675115656722, Value: 5}},
+		},
+		{
+			Labels: map[string]string{"service_name": "c"},
+			Points: []v3.Point{{Timestamp: 1675115596722, Value: 2}, {Timestamp: 1675115656722, Value: 2}},
+		},
+	}
+
+	testCases := []struct {
+		name              string
+		maxSeries         int
+		rankBy            SeriesRankBy
+		expectedServices  []string
+		expectedTruncated bool
+	}{
+		{
+			name:              "rank by total",
+			maxSeries:         2,
+			expectedServices:  []string{"a", "b"},
+			expectedTruncated: true,
+		},
+		{
+			name:              "rank by last",
+			maxSeries:         2,
+			rankBy:            SeriesRankByLast,
+			expectedServices:  []string{"b", "c"},
+			expectedTruncated: true,
+		},
+		{
+			name:             "within limit",
+			maxSeries:        3,
+			expectedServices: []string{"a", "b", "c"},
+		},
+		{
+			name:             "no limit",
+			expectedServices: []string{"a", "b", "c"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			params := &v3.QueryRangeParamsV3{
+				Start: 1675115596722,
+				End:   1675115596722 + 120*60*1000,
+				Step:  60,
+				CompositeQuery: &v3.CompositeQuery{
+					QueryType: v3.QueryTypeClickHouseSQL,
+					PanelType: v3.PanelTypeGraph,
+					ClickHouseQueries: map[string]*v3.ClickHouseQuery{
+						"A": {Query: "SELECT 1"},
+					},
+				},
+			}
+			q := NewQuerier(QuerierOptions{
+				MaxSeries:       tc.maxSeries,
+				MaxSeriesRankBy: tc.rankBy,
+				TestingMode:     true,
+				ReturnedSeries:  returnedSeries,
+			})
+
+			results, _, err := q.QueryRange(context.Background(), params, nil)
+			if err != nil {
+				t.Fatalf("expected no error, got %s", err)
+			}
+			if len(results) != 1 {
+				t.Fatalf("expected one result, got %d", len(results))
+			}
+			if results[0].Truncated != tc.expectedTruncated {
+				t.Errorf("expected truncated to be %v, got %v", tc.expectedTruncated, results[0].Truncated)
+			}
+			services := make([]string, 0)
+			for _, series := range results[0].Series {
+				services = append(services, series.Labels["service_name"])
+			}
+			if tc.expectedTruncated && strings.Join(services, ",") != strings.Join(tc.expectedServices, ",") {
+				t.Errorf("expected series %v, got %v", tc.expectedServices, services)
+			}
+			if len(services) != len(tc.expectedServices) {
+				t.Errorf("expected %d series, got %d", len(tc.expectedServices), len(services))
+			}
+		})
+	}
+}
+
+// constantKeyGenerator generates the same cache key for every query
+type constantKeyGenerator struct{}
+
+func (constantKeyGenerator) GenerateKeys(params *v3.QueryRangeParamsV3) map[string]string {
+	keys := make(map[string]string)
+	for name := range params.CompositeQuery.PromQueries {
+		keys[name] = "constant"
+	}
+	for name := range params.CompositeQuery.BuilderQueries {
+		keys[name] = "constant"
+	}
+	return keys
+}
+
+func TestQueryRangeCacheKeyCollisionDetection(t *testing.T) {
+	newParams := func(query string) *v3.QueryRangeParamsV3 {
+		return &v3.QueryRangeParamsV3{
+			Start: 1675115596722,
+			End:   1675115596722 + 120*60*1000,
+			Step:  60,
+			CompositeQuery: &v3.CompositeQuery{
+				QueryType: v3.QueryTypePromQL,
+				PanelType: v3.PanelTypeGraph,
+				PromQueries: map[string]*v3.PromQuery{
+					"A": {Query: query},
+				},
+			},
+		}
+	}
+	q := NewQuerier(QuerierOptions{
+		Cache:        inmemory.New(&inmemory.Options{TTL: 60 * time.Minute, CleanupInterval: 10 * time.Minute}),
+		KeyGenerator: constantKeyGenerator{},
+		FluxInterval: 5 * time.Minute,
+		TestingMode:  true,
+		ReturnedSeries: []*v3.Series{
+			{
+				Labels: map[string]string{"__name__": "signoz_latency"},
+				Points: []v3.Point{{Timestamp: 1675115596722, Value: 1}},
+			},
+		},
+	})
+
+	if _, _, err := q.QueryRange(context.Background(), newParams("signoz_latency"), nil); err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+	// the same query is served from the cache
+	if _, _, err := q.QueryRange(context.Background(), newParams("signoz_latency"), nil); err != nil {
+		t.Fatalf("expected no error for the same query, got %s", err)
+	}
+
+	_, errQueriesByName, err := q.QueryRange(context.Background(), newParams("signoz_calls_total"), nil)
+	if err == nil || !strings.Contains(err.Error(), "cache key collision") {
+		t.Fatalf("expected a cache key collision error, got %v", err)
+	}
+	if _, ok := errQueriesByName["A"]; !ok {
+		t.Errorf("expected the collision to be reported for query A, got %v", errQueriesByName)
+	}
+}
+
+func TestQueryRangeCacheKeyCollisionPostProcessingOptions(t *testing.T) {
+	end := int64(1675115596722)
+	newParams := func(builderQuery *v3.BuilderQuery) *v3.QueryRangeParamsV3 {
+		return &v3.QueryRangeParamsV3{
+			Start: end - 60*60*1000,
+			End:   end,
+			Step:  60,
+			CompositeQuery: &v3.CompositeQuery{
+				QueryType:      v3.QueryTypeBuilder,
+				PanelType:      v3.PanelTypeGraph,
+				BuilderQueries: map[string]*v3.BuilderQuery{"A": builderQuery},
+			},
+		}
+	}
+	newBuilderQuery := func() *v3.BuilderQuery {
+		return &v3.BuilderQuery{
+			QueryName:          "A",
+			DataSource:         v3.DataSourceMetrics,
+			StepInterval:       60,
+			AggregateAttribute: v3.AttributeKey{Key: "signoz_calls_total", DataType: "float64", IsColumn: true},
+			AggregateOperator:  v3.AggregateOperatorSumRate,
+			GroupBy:            []v3.AttributeKey{{Key: "service_name", DataType: v3.AttributeKeyDataTypeString, Type: v3.AttributeKeyTypeTag}},
+			Expression:         "A",
+		}
+	}
+	q := NewQuerier(QuerierOptions{
+		Cache:         inmemory.New(&inmemory.Options{TTL: 60 * time.Minute, CleanupInterval: 10 * time.Minute}),
+		KeyGenerator:  queryBuilder.NewKeyGenerator(),
+		FeatureLookup: featureManager.StartManager(),
+		FluxInterval:  5 * time.Minute,
+		TestingMode:   true,
+		ReturnedSeries: []*v3.Series{
+			{Labels: map[string]string{"service_name": "cart"}, Points: []v3.Point{{Timestamp: end - 30*60*1000, Value: 1}}},
+		},
+	})
+	if _, _, err := q.QueryRange(context.Background(), newParams(newBuilderQuery()), nil); err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+
+	// the options applied to the series after the cache share the cached series
+	for name, option := range map[string]func(*v3.BuilderQuery){
+		"drop zero series": func(query *v3.BuilderQuery) { query.DropZeroSeries = true },
+		"percent of total": func(query *v3.BuilderQuery) { query.PercentOfTotal = true },
+		"moving average":   func(query *v3.BuilderQuery) { query.MovingAvg = &v3.MovingAvg{Window: 3} },
+		"value encoding":   func(query *v3.BuilderQuery) { query.ValueEncoding = v3.ValueEncodingInt64 },
+		"legend format":    func(query *v3.BuilderQuery) { query.LegendFormat = "{service_name}" },
+		"compare shift":    func(query *v3.BuilderQuery) { query.CompareShift = 86400 },
+		"trailing window":  func(query *v3.BuilderQuery) { query.TrailingWindow = 300 },
+	} {
+		builderQuery := newBuilderQuery()
+		option(builderQuery)
+		if _, _, err := q.QueryRange(context.Background(), newParams(builderQuery), nil); err != nil {
+			t.Errorf("expected no cache key collision with the %s option, got %s", name, err)
+		}
+	}
+
+	// a query param the cache key is generated from is still checked
+	q = NewQuerier(QuerierOptions{
+		Cache:         inmemory.New(&inmemory.Options{TTL: 60 * time.Minute, CleanupInterval: 10 * time.Minute}),
+		KeyGenerator:  constantKeyGenerator{},
+		FeatureLookup: featureManager.StartManager(),
+		FluxInterval:  5 * time.Minute,
+		TestingMode:   true,
+		ReturnedSeries: []*v3.Series{
+			{Labels: map[string]string{"service_name": "cart"}, Points: []v3.Point{{Timestamp: end - 30*60*1000, Value: 1}}},
+		},
+	})
+	if _, _, err := q.QueryRange(context.Background(), newParams(newBuilderQuery()), nil); err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+	builderQuery := newBuilderQuery()
+	builderQuery.GroupBy = nil
+	if _, _, err := q.QueryRange(context.Background(), newParams(builderQuery), nil); err == nil || !strings.Contains(err.Error(), "cache key collision") {
+		t.Errorf("expected a cache key collision error, got %v", err)
+	}
+}
+
+func TestMergeSerieses(t *testing.T) {
+	cachedSeries := []*v3.Series{
+		{
+			Labels: map[string]string{"service_name": "frontend", "operation": "GET /"},
+			Points: []v3.Point{{Timestamp: 1, Value: 1}, {Timestamp: 2, Value: 2}},
+		},
+		{
+			Labels: map[string]string{"service_name": "route"},
+			Points: []v3.Point{{Timestamp: 1, Value: 10}},
+		},
+	}
+	missedSeries := []*v3.Series{
+		{
+			Labels: map[string]string{"operation": "GET /", "service_name": "frontend"},
+			Points: []v3.Point{{Timestamp: 3, Value: 3}, {Timestamp: 2, Value: 20}},
+		},
+		{
+			Labels: map[string]string{"service_name": "driver"},
+			Points: []v3.Point{{Timestamp: 3, Value: 30}},
+		},
+	}
+
+	// the merged series are ordered by their labels
+	merged := mergeSerieses(cachedSeries, missedSeries)
+
+	expected := []struct {
+		labels string
+		points []v3.Point
+	}{
+		{labels: "{operation=GET /,service_name=frontend}", points: []v3.Point{{Timestamp: 1, Value: 1}, {Timestamp: 2, Value: 20}, {Timestamp: 3, Value: 3}}},
+		{labels: "{service_name=driver}", points: []v3.Point{{Timestamp: 3, Value: 30}}},
+		{labels: "{service_name=route}", points: []v3.Point{{Timestamp: 1, Value: 10}}},
+	}
+	if len(merged) != len(expected) {
+		t.Fatalf("expected %d series, got %d", len(expected), len(merged))
+	}
+	for idx, series := range merged {
+		if labelsToString(series.Labels) != expected[idx].labels {
+			t.Errorf("expected labels %s, got %s", expected[idx].labels, labelsToString(series.Labels))
+		}
+		if len(series.Points) != len(expected[idx].points) {
+			t.Fatalf("expected points %v, got %v", expected[idx].points, series.Points)
+		}
+		for pointIdx, point := range series.Points {
+			if point != expected[idx].points[pointIdx] {
+				t.Errorf("expected points %v, got %v", expected[idx].points, series.Points)
+				break
+			}
+		}
+	}
+}
+
+func TestMergeSeriesesOrderIsStable(t *testing.T) {
+	newSerieses := func(timestamp int64) []*v3.Series {
+		serieses := make([]*v3.Series, 0, 50)
+		for idx := 0; idx < 50; idx++ {
+			serieses = append(serieses, &v3.Series{
+				Labels: map[string]string{"service_name": fmt.Sprintf("service-%d", idx)},
+				Points: []v3.Point{{Timestamp: timestamp, Value: float64(idx)}},
+			})
+		}
+		return serieses
+	}
+	order := func(serieses []*v3.Series) []string {
+		labels := make([]string, 0, len(serieses))
+		for _, series := range serieses {
+			labels = append(labels, labelsToString(series.Labels))
+		}
+		return labels
+	}
+
+	// the map iteration order changes between the calls, the order of the merged series doesn't
+	expected := order(mergeSerieses(newSerieses(1), newSerieses(2)))
+	if !sort.StringsAreSorted(expected) {
+		t.Fatalf("expected the series to be sorted by their labels, got %v", expected)
+	}
+	for idx := 0; idx < 20; idx++ {
+		if got := order(mergeSerieses(newSerieses(1), newSerieses(2))); !reflect.DeepEqual(got, expected) {
+			t.Fatalf("expected the same order of the series across the calls, got %v and %v", expected, got)
+		}
+	}
+}
+
+func TestQueryRangeSeriesOrderWithoutCache(t *testing.T) {
+	end := int64(1675115580000)
+	start := end - time.Hour.Milliseconds()
+	services := []string{"redis", "frontend", "mysql", "cart"}
+	reader := &mockReader{
+		timeSeriesFn: func(query string) ([]*v3.Series, error) {
+			seriesList := make([]*v3.Series, 0, len(services))
+			for idx, service := range services {
+				seriesList = append(seriesList, &v3.Series{
+					Labels: map[string]string{"service_name": service},
+					Points: []v3.Point{{Timestamp: end, Value: float64(idx)}},
+				})
+			}
+			return seriesList, nil
+		},
+		promRangeFn: func(_ context.Context, _ *model.QueryRangeParams) (*promql.Result, *model.ApiError) {
+			matrix := make(promql.Matrix, 0, len(services))
+			for idx, service := range services {
+				matrix = append(matrix, promql.Series{
+					Metric: labels.FromStrings("service_name", service),
+					Floats: []promql.FPoint{{T: end, F: float64(idx)}},
+				})
+			}
+			return &promql.Result{Value: matrix}, nil
+		},
+	}
+	// no cache, the series are not merged with cached series
+	q := NewQuerier(QuerierOptions{
+		Reader:        reader,
+		KeyGenerator:  queryBuilder.NewKeyGenerator(),
+		FeatureLookup: featureManager.StartManager(),
+	})
+
+	for _, compositeQuery := range []*v3.CompositeQuery{
+		{
+			QueryType: v3.QueryTypeBuilder,
+			PanelType: v3.PanelTypeGraph,
+			BuilderQueries: map[string]*v3.BuilderQuery{
+				"A": {
+					QueryName:          "A",
+					DataSource:         v3.DataSourceMetrics,
+					StepInterval:       60,
+					AggregateAttribute: v3.AttributeKey{Key: "signoz_calls_total", DataType: "float64", IsColumn: true},
+					AggregateOperator:  v3.AggregateOperatorSumRate,
+					GroupBy:            []v3.AttributeKey{{Key: "service_name", DataType: v3.AttributeKeyDataTypeString, Type: v3.AttributeKeyTypeTag}},
+					Expression:         "A",
+				},
+			},
+		},
+		{
+			QueryType:         v3.QueryTypeClickHouseSQL,
+			PanelType:         v3.PanelTypeGraph,
+			ClickHouseQueries: map[string]*v3.ClickHouseQuery{"A": {Query: "SELECT service_name, ts, value FROM metrics"}},
+		},
+		{
+			QueryType:   v3.QueryTypePromQL,
+			PanelType:   v3.PanelTypeGraph,
+			PromQueries: map[string]*v3.PromQuery{"A": {Query: "signoz_calls_total"}},
+		},
+	} {
+		t.Run(string(compositeQuery.QueryType), func(t *testing.T) {
+			params := &v3.QueryRangeParamsV3{
+				Start:          start,
+				End:            end,
+				Step:           60,
+				CompositeQuery: compositeQuery,
+			}
+			results, _, err := q.QueryRange(context.Background(), params, nil)
+			if err != nil {
+				t.Fatalf("expected no error, got %s", err)
+			}
+			var got []string
+			for _, series := range results[0].Series {
+				got = append(got, series.Labels["service_name"])
+			}
+			if expected := []string{"cart", "frontend", "mysql", "redis"}; !reflect.DeepEqual(got, expected) {
+				t.Errorf("expected the series ordered by their labels %v, got %v", expected, got)
+			}
+		})
+	}
+}
+
+func BenchmarkMergeSerieses(b *testing.B) {
+	newSerieses := func(n int, timestamp int64) []*v3.Series {
+		serieses := make([]*v3.Series, 0, n)
+		for idx := 0; idx < n; idx++ {
+			serieses = append(serieses, &v3.Series{
+				Labels: map[string]string{
+					"service_name": fmt.Sprintf("service-%d", idx%50),
+					"operation":    fmt.Sprintf("operation-%d", idx),
+					"status_code":  "200",
+				},
+				Points: []v3.Point{{Timestamp: timestamp, Value: float64(idx)}},
+			})
+		}
+		return serieses
+	}
+
+	for _, tc := range []struct {
+		name  string
+		merge func(cachedSeries, missedSeries []*v3.Series) []*v3.Series
+	}{
+		{name: "string", merge: mergeSerieses},
+		{name: "hash", merge: mergeSeriesesByHash},
+	} {
+		b.Run(tc.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				cachedSeries := newSerieses(5000, 1)
+				missedSeries := newSerieses(5000, 2)
+				b.StartTimer()
+				tc.merge(cachedSeries, missedSeries)
+			}
+		})
+	}
+}
+
+func TestLabelsHashDistinct(t *testing.T) {
+	labelSets := []map[string]string{
+		{},
+		{"a": ""},
+		{"a": "b"},
+		{"b": "a"},
+		{"ab": ""},
+		{"a": "", "b": ""},
+		{"a": "b,c=d"},
+		{"a": "b", "c": "d"},
+		{"a": "b=c"},
+		{"a=b": "c"},
+	}
+	for idx := 0; idx < 1000; idx++ {
+		labelSets = append(labelSets, map[string]string{
+			"service_name": fmt.Sprintf("service-%d", idx%10),
+			"operation":    fmt.Sprintf("operation-%d", idx),
+		})
+	}
+
+	seen := make(map[uint64]map[string]string, len(labelSets))
+	for _, labels := range labelSets {
+		hash, _ := labelsHash(labels, nil)
+		if other, ok := seen[hash]; ok {
+			t.Fatalf("expected distinct hashes, got the same for %v and %v", other, labels)
+		}
+		seen[hash] = labels
+	}
+
+	// the hash doesn't depend on the insertion order of the labels
+	first, _ := labelsHash(map[string]string{"a": "1", "b": "2", "c": "3"}, nil)
+	second, _ := labelsHash(map[string]string{"c": "3", "a": "1", "b": "2"}, nil)
+	if first != second {
+		t.Errorf("expected the same hash for the same labels, got %d and %d", first, second)
+	}
+}
+
+func TestMergeSeriesesByHash(t *testing.T) {
+	newSerieses := func(timestamp int64) []*v3.Series {
+		serieses := make([]*v3.Series, 0, 100)
+		for idx := 0; idx < 100; idx++ {
+			serieses = append(serieses, &v3.Series{
+				Labels: map[string]string{
+					"service_name": fmt.Sprintf("service-%d", idx%7),
+					"operation":    fmt.Sprintf("operation-%d", idx%30),
+				},
+				Points: []v3.Point{{Timestamp: timestamp + int64(idx), Value: float64(idx)}},
+			})
+		}
+		return serieses
+	}
+
+	expected := mergeSerieses(newSerieses(1), newSerieses(2))
+	merged := mergeSeriesesByHash(newSerieses(1), newSerieses(2))
+	if len(merged) != len(expected) {
+		t.Fatalf("expected %d series, got %d", len(expected), len(merged))
+	}
+	byLabels := make(map[string]*v3.Series, len(expected))
+	for _, series := range expected {
+		byLabels[labelsToString(series.Labels)] = series
+	}
+	for _, series := range merged {
+		want, ok := byLabels[labelsToString(series.Labels)]
+		if !ok {
+			t.Fatalf("unexpected series %v", series.Labels)
+		}
+		if !reflect.DeepEqual(series.Points, want.Points) {
+			t.Errorf("expected points %v for %v, got %v", want.Points, series.Labels, series.Points)
+		}
+	}
+}
+
+func TestQueryRangeReportsSeriesWindow(t *testing.T) {
+	start := int64(1675115596722)
+	minute := time.Minute.Milliseconds()
+	params := &v3.QueryRangeParamsV3{
+		Start: start,
+		End:   start + 60*minute,
+		Step:  60,
+		CompositeQuery: &v3.CompositeQuery{
+			QueryType: v3.QueryTypePromQL,
+			PanelType: v3.PanelTypeGraph,
+			PromQueries: map[string]*v3.PromQuery{
+				"A": {Query: "signoz_latency"},
+			},
+		},
+	}
+	q := NewQuerier(QuerierOptions{
+		Cache:        inmemory.New(&inmemory.Options{TTL: 60 * time.Minute, CleanupInterval: 10 * time.Minute}),
+		KeyGenerator: queryBuilder.NewKeyGenerator(),
+		FluxInterval: 5 * time.Minute,
+		TestingMode:  true,
+		ReturnedSeries: []*v3.Series{
+			{
+				Labels: map[string]string{"__name__": "signoz_latency"},
+				Points: []v3.Point{{Timestamp: start + 10*minute, Value: 1}, {Timestamp: start + 50*minute, Value: 2}},
+			},
+		},
+	})
+
+	results, _, err := q.QueryRange(context.Background(), params, nil)
+	if err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+	if results[0].MinTimestamp != start+10*minute || results[0].MaxTimestamp != start+50*minute {
+		t.Errorf("expected window [%d, %d], got [%d, %d]", start+10*minute, start+50*minute, results[0].MinTimestamp, results[0].MaxTimestamp)
+	}
+
+	// the cached series extend before the start of the narrower request
+	params.Start = start + 20*minute
+	results, _, err = q.QueryRange(context.Background(), params, nil)
+	if err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+	minTimestamp, maxTimestamp := seriesWindow(results[0].Series)
+	if results[0].MinTimestamp != minTimestamp || results[0].MaxTimestamp != maxTimestamp {
+		t.Errorf("expected window [%d, %d], got [%d, %d]", minTimestamp, maxTimestamp, results[0].MinTimestamp, results[0].MaxTimestamp)
+	}
+	if results[0].MinTimestamp >= params.Start {
+		t.Errorf("expected the window to start before the requested start %d, got %d", params.Start, results[0].MinTimestamp)
+	}
+}
+
+func TestQueryRangeFluxTailWithFixedClock(t *testing.T) {
+	minute := time.Minute.Milliseconds()
+	// the clock is 30s past the minute, the flux interval is measured
+	// back from the start of the minute
+	end := int64(1675115580000)
+	now := time.UnixMilli(end + 30*1000)
+
+	points := make([]v3.Point, 0, 61)
+	for ts := end - 60*minute; ts <= end; ts += minute {
+		points = append(points, v3.Point{Timestamp: ts, Value: 1})
+	}
+	params := &v3.QueryRangeParamsV3{
+		Start: end - 60*minute,
+		End:   end,
+		Step:  60,
+		CompositeQuery: &v3.CompositeQuery{
+			QueryType: v3.QueryTypePromQL,
+			PanelType: v3.PanelTypeGraph,
+			PromQueries: map[string]*v3.PromQuery{
+				"A": {Query: "signoz_latency"},
+			},
+		},
+	}
+	q := NewQuerier(QuerierOptions{
+		Cache:          inmemory.New(&inmemory.Options{TTL: 60 * time.Minute, CleanupInterval: 10 * time.Minute}),
+		KeyGenerator:   queryBuilder.NewKeyGenerator(),
+		FluxInterval:   5 * time.Minute,
+		NowFunc:        func() time.Time { return now },
+		TestingMode:    true,
+		ReturnedSeries: []*v3.Series{{Labels: map[string]string{"__name__": "signoz_latency"}, Points: points}},
+	})
+
+	for i := 0; i < 2; i++ {
+		if _, _, err := q.QueryRange(context.Background(), params, nil); err != nil {
+			t.Fatalf("expected no error, got %s", err)
+		}
+	}
+
+	expectedTimeRanges := [][]int{
+		{int(end - 60*minute), int(end)},
+		// only the flux tail is refetched on the second query
+		{int(end - 5*minute + 1), int(end)},
+	}
+	timeRanges := q.(*querier).TimeRanges()
+	if len(timeRanges) != len(expectedTimeRanges) {
+		t.Fatalf("expected %d time ranges, got %v", len(expectedTimeRanges), timeRanges)
+	}
+	for i := range expectedTimeRanges {
+		if timeRanges[i][0] != expectedTimeRanges[i][0] || timeRanges[i][1] != expectedTimeRanges[i][1] {
+			t.Errorf("expected time range %d to be %v, got %v", i, expectedTimeRanges[i], timeRanges[i])
+		}
+	}
+}
+
+func TestQueryRangeDisableFluxRefetch(t *testing.T) {
+	minute := time.Minute.Milliseconds()
+	end := int64(1675115580000)
+	now := time.UnixMilli(end + 30*1000)
+
+	points := make([]v3.Point, 0, 61)
+	for ts := end - 60*minute; ts <= end; ts += minute {
+		points = append(points, v3.Point{Timestamp: ts, Value: 1})
+	}
+	for _, tc := range []struct {
+		name               string
+		disableFluxRefetch bool
+		expectedTimeRanges [][]int
+	}{
+		{
+			name:               "flux refetch enabled",
+			disableFluxRefetch: false,
+			expectedTimeRanges: [][]int{{int(end - 60*minute), int(end)}, {int(end - 5*minute + 1), int(end)}},
+		},
+		{
+			name:               "flux refetch disabled",
+			disableFluxRefetch: true,
+			// the second query is a complete hit
+			expectedTimeRanges: [][]int{{int(end - 60*minute), int(end)}},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			params := &v3.QueryRangeParamsV3{
+				Start: end - 60*minute,
+				End:   end,
+				Step:  60,
+				CompositeQuery: &v3.CompositeQuery{
+					QueryType:   v3.QueryTypePromQL,
+					PanelType:   v3.PanelTypeGraph,
+					PromQueries: map[string]*v3.PromQuery{"A": {Query: "signoz_latency"}},
+				},
+			}
+			q := NewQuerier(QuerierOptions{
+				Cache:              inmemory.New(&inmemory.Options{TTL: 60 * time.Minute, CleanupInterval: 10 * time.Minute}),
+				KeyGenerator:       queryBuilder.NewKeyGenerator(),
+				FluxInterval:       5 * time.Minute,
+				DisableFluxRefetch: tc.disableFluxRefetch,
+				NowFunc:            func() time.Time { return now },
+				TestingMode:        true,
+				ReturnedSeries:     []*v3.Series{{Labels: map[string]string{"__name__": "signoz_latency"}, Points: points}},
+			})
+
+			for i := 0; i < 2; i++ {
+				if _, _, err := q.QueryRange(context.Background(), params, nil); err != nil {
+					t.Fatalf("expected no error, got %s", err)
+				}
+			}
+			if !reflect.DeepEqual(q.TimeRanges(), tc.expectedTimeRanges) {
+				t.Errorf("expected time ranges %v, got %v", tc.expectedTimeRanges, q.TimeRanges())
+			}
+		})
+	}
+}
+
+func TestMergeCachedSeriesAlignSeams(t *testing.T) {
+	labels := map[string]string{"__name__": "signoz_latency"}
+	testCases := []struct {
+		name            string
+		alignCacheSeams bool
+		missedPoints    []v3.Point
+		expectedPoints  []v3.Point
+	}{
+		{
+			name:            "misaligned boundary point is aligned to the nearest grid point",
+			alignCacheSeams: true,
+			missedPoints:    []v3.Point{{Timestamp: 180010, Value: 3}, {Timestamp: 240000, Value: 4}},
+			expectedPoints:  []v3.Point{{Timestamp: 60000, Value: 1}, {Timestamp: 120000, Value: 2}, {Timestamp: 180000, Value: 3}, {Timestamp: 240000, Value: 4}},
+		},
+		{
+			name:            "boundary point less than one step after the seam is aligned to the next grid point",
+			alignCacheSeams: true,
+			missedPoints:    []v3.Point{{Timestamp: 125000, Value: 3}},
+			expectedPoints:  []v3.Point{{Timestamp: 60000, Value: 1}, {Timestamp: 120000, Value: 2}, {Timestamp: 180000, Value: 3}},
+		},
+		{
+			name:            "misaligned boundary point is dropped when the grid point exists",
+			alignCacheSeams: true,
+			missedPoints:    []v3.Point{{Timestamp: 150000, Value: 5}, {Timestamp: 180000, Value: 3}},
+			expectedPoints:  []v3.Point{{Timestamp: 60000, Value: 1}, {Timestamp: 120000, Value: 2}, {Timestamp: 180000, Value: 3}},
+		},
+		{
+			name:            "only the boundary point is aligned",
+			alignCacheSeams: true,
+			missedPoints:    []v3.Point{{Timestamp: 180000, Value: 3}, {Timestamp: 240010, Value: 4}},
+			expectedPoints:  []v3.Point{{Timestamp: 60000, Value: 1}, {Timestamp: 120000, Value: 2}, {Timestamp: 180000, Value: 3}, {Timestamp: 240010, Value: 4}},
+		},
+		{
+			name:            "seams are not aligned when disabled",
+			alignCacheSeams: false,
+			missedPoints:    []v3.Point{{Timestamp: 180010, Value: 3}},
+			expectedPoints:  []v3.Point{{Timestamp: 60000, Value: 1}, {Timestamp: 120000, Value: 2}, {Timestamp: 180010, Value: 3}},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			q := NewQuerier(QuerierOptions{AlignCacheSeams: tc.alignCacheSeams, TestingMode: true}).(*querier)
+			cachedSeries := []*v3.Series{{Labels: labels, Points: []v3.Point{{Timestamp: 60000, Value: 1}, {Timestamp: 120000, Value: 2}}}}
+			missedSeries := []*v3.Series{{Labels: labels, Points: tc.missedPoints}}
+
+			merged := q.mergeCachedSeries(cachedSeries, missedSeries, 60)
+			if len(merged) != 1 {
+				t.Fatalf("expected 1 series, got %d", len(merged))
+			}
+			if !reflect.DeepEqual(merged[0].Points, tc.expectedPoints) {
+				t.Errorf("expected points %v, got %v", tc.expectedPoints, merged[0].Points)
+			}
+		})
+	}
+}
+
+// ttlRecordingCache records the ttl of the stored entries
+type ttlRecordingCache struct {
+	cache.Cache
+	mu   sync.Mutex
+	ttls map[string]time.Duration
+}
+
+func (c *ttlRecordingCache) Store(cacheKey string, data []byte, ttl time.Duration) error {
+	c.mu.Lock()
+	c.ttls[cacheKey] = ttl
+	c.mu.Unlock()
+	return c.Cache.Store(cacheKey, data, ttl)
+}
+
+// failingCache fails the first failures retrievals with a backend error
+type failingCache struct {
+	cache.Cache
+	mu         sync.Mutex
+	failures   int
+	retrievals int
+}
+
+func (c *failingCache) Retrieve(cacheKey string, allowExpired bool) ([]byte, status.RetrieveStatus, error) {
+	c.mu.Lock()
+	c.retrievals++
+	fail := c.failures != 0
+	if c.failures > 0 {
+		c.failures--
+	}
+	c.mu.Unlock()
+	if fail {
+		return nil, status.RetrieveStatusError, errors.New("connection refused")
+	}
+	return c.Cache.Retrieve(cacheKey, allowExpired)
+}
+
+func TestQueryRangeCacheErrorWarning(t *testing.T) {
+	params := &v3.QueryRangeParamsV3{
+		Start: 1675115596722,
+		End:   1675115596722 + 120*60*1000,
+		Step:  60,
+		CompositeQuery: &v3.CompositeQuery{
+			QueryType: v3.QueryTypePromQL,
+			PanelType: v3.PanelTypeGraph,
+			PromQueries: map[string]*v3.PromQuery{
+				"A": {Query: "signoz_latency"},
+			},
+		},
+	}
+	matrix := promql.Matrix{
+		{
+			Metric: labels.FromStrings("__name__", "signoz_latency", "service_name", "test"),
+			Floats: []promql.FPoint{{T: 1675115596722, F: 1}},
+		},
+	}
+	newQuerier := func(c cache.Cache, warnings bool, retries int) interfaces.Querier {
+		return NewQuerier(QuerierOptions{
+			Cache:                c,
+			Reader:               &mockReader{promResult: &promql.Result{Value: matrix}},
+			FluxInterval:         5 * time.Minute,
+			KeyGenerator:         queryBuilder.NewKeyGenerator(),
+			CacheErrorWarnings:   warnings,
+			CacheRetrieveRetries: retries,
+			CacheRetrieveBackoff: time.Millisecond,
+		})
+	}
+	newCache := func(failures int) *failingCache {
+		return &failingCache{
+			Cache:    inmemory.New(&inmemory.Options{TTL: 60 * time.Minute, CleanupInterval: 10 * time.Minute}),
+			failures: failures,
+		}
+	}
+
+	testCases := []struct {
+		name       string
+		failures   int
+		warnings   bool
+		retries    int
+		expectNote bool
+		retrievals int
+	}{
+		{name: "failing cache is noted", failures: -1, warnings: true, expectNote: true, retrievals: 2},
+		{name: "failing cache is not noted without the option", failures: -1, retrievals: 2},
+		{name: "failing cache is retried", failures: -1, warnings: true, retries: 2, expectNote: true, retrievals: 4},
+		{name: "transient failure is recovered by a retry", failures: 1, warnings: true, retries: 2, retrievals: 3},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := newCache(tc.failures)
+			results, _, err := newQuerier(c, tc.warnings, tc.retries).QueryRange(context.Background(), params, nil)
+			if err != nil {
+				t.Fatalf("expected no error, got %s", err)
+			}
+			// the fresh data is returned despite the cache
+			if len(results) != 1 || len(results[0].Series) != 1 || len(results[0].Series[0].Points) != 1 {
+				t.Fatalf("expected one result with the fetched series, got %v", results)
+			}
+			noted := false
+			for _, note := range results[0].Notes {
+				if strings.Contains(note, "the cache is unavailable") && strings.Contains(note, "connection refused") {
+					noted = true
+				}
+			}
+			if noted != tc.expectNote {
+				t.Errorf("expected the cache error noted %t, got notes %v", tc.expectNote, results[0].Notes)
+			}
+			// the retrievals of the series and the read-modify-write of the store
+			c.mu.Lock()
+			retrievals := c.retrievals
+			c.mu.Unlock()
+			if retrievals != tc.retrievals {
+				t.Errorf("expected %d retrievals, got %d", tc.retrievals, retrievals)
+			}
+		})
+	}
+}
+
+func TestQueryRangeCacheTTLFromTimeRange(t *testing.T) {
+	end := int64(1675115580000)
+	testCases := []struct {
+		name        string
+		start       int64
+		step        int64
+		expectedTTL time.Duration
+	}{
+		{
+			name:        "short range is cached briefly",
+			start:       end - 5*time.Minute.Milliseconds(),
+			step:        60,
+			expectedTTL: time.Minute,
+		},
+		{
+			name:        "a day is cached for hours",
+			start:       end - 24*time.Hour.Milliseconds(),
+			step:        300,
+			expectedTTL: 4 * time.Hour,
+		},
+		{
+			name:        "long range is capped",
+			start:       end - 30*24*time.Hour.Milliseconds(),
+			step:        3600,
+			expectedTTL: 6 * time.Hour,
+		},
+		{
+			name:        "ttl is at least one step",
+			start:       end - 30*time.Minute.Milliseconds(),
+			step:        1800,
+			expectedTTL: 30 * time.Minute,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := &ttlRecordingCache{
+				Cache: inmemory.New(&inmemory.Options{TTL: 60 * time.Minute, CleanupInterval: 10 * time.Minute}),
+				ttls:  map[string]time.Duration{},
+			}
+			q := NewQuerier(QuerierOptions{
+				Cache:          c,
+				KeyGenerator:   queryBuilder.NewKeyGenerator(),
+				TestingMode:    true,
+				ReturnedSeries: []*v3.Series{{Labels: map[string]string{"__name__": "signoz_latency"}, Points: []v3.Point{{Timestamp: tc.start, Value: 1}}}},
+			})
+			params := &v3.QueryRangeParamsV3{
+				Start: tc.start,
+				End:   end,
+				Step:  tc.step,
+				CompositeQuery: &v3.CompositeQuery{
+					QueryType:   v3.QueryTypePromQL,
+					PanelType:   v3.PanelTypeGraph,
+					PromQueries: map[string]*v3.PromQuery{"A": {Query: "signoz_latency"}},
+				},
+			}
+			if _, _, err := q.QueryRange(context.Background(), params, nil); err != nil {
+				t.Fatalf("expected no error, got %s", err)
+			}
+
+			cacheKey := queryBuilder.NewKeyGenerator().GenerateKeys(params)["A"]
+			if ttl := c.ttls[cacheKey]; ttl != tc.expectedTTL {
+				t.Errorf("expected ttl %s, got %s", tc.expectedTTL, ttl)
+			}
+		})
+	}
+}
+
+func TestQueryRangeCustomCacheTTLPolicy(t *testing.T) {
+	c := &ttlRecordingCache{
+		Cache: inmemory.New(&inmemory.Options{TTL: 60 * time.Minute, CleanupInterval: 10 * time.Minute}),
+		ttls:  map[string]time.Duration{},
+	}
+	q := NewQuerier(QuerierOptions{
+		Cache:          c,
+		KeyGenerator:   queryBuilder.NewKeyGenerator(),
+		CacheTTLPolicy: func(start, end, step int64) time.Duration { return time.Duration(step) * time.Minute },
+		TestingMode:    true,
+		ReturnedSeries: []*v3.Series{{Labels: map[string]string{"__name__": "signoz_latency"}, Points: []v3.Point{{Timestamp: 1675115580000, Value: 1}}}},
+	})
+	params := &v3.QueryRangeParamsV3{
+		Start: 1675115580000 - time.Hour.Milliseconds(),
+		End:   1675115580000,
+		Step:  60,
+		CompositeQuery: &v3.CompositeQuery{
+			QueryType:   v3.QueryTypePromQL,
+			PanelType:   v3.PanelTypeGraph,
+			PromQueries: map[string]*v3.PromQuery{"A": {Query: "signoz_latency"}},
+		},
+	}
+	if _, _, err := q.QueryRange(context.Background(), params, nil); err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+	cacheKey := queryBuilder.NewKeyGenerator().GenerateKeys(params)["A"]
+	if ttl := c.ttls[cacheKey]; ttl != time.Hour {
+		t.Errorf("expected ttl %s, got %s", time.Hour, ttl)
+	}
+}
+
+func TestQueryRangeFluxTailNotCached(t *testing.T) {
+	minute := time.Minute.Milliseconds()
+	end := int64(1675115580000)
+	now := time.UnixMilli(end)
+
+	points := make([]v3.Point, 0, 61)
+	for ts := end - 60*minute; ts <= end; ts += minute {
+		points = append(points, v3.Point{Timestamp: ts, Value: 1})
+	}
+	c := inmemory.New(&inmemory.Options{TTL: 60 * time.Minute, CleanupInterval: 10 * time.Minute})
+	q := NewQuerier(QuerierOptions{
+		Cache:          c,
+		KeyGenerator:   queryBuilder.NewKeyGenerator(),
+		FluxInterval:   5 * time.Minute,
+		NowFunc:        func() time.Time { return now },
+		TestingMode:    true,
+		ReturnedSeries: []*v3.Series{{Labels: map[string]string{"__name__": "signoz_latency"}, Points: points}},
+	})
+	params := &v3.QueryRangeParamsV3{
+		Start: end - 60*minute,
+		End:   end,
+		Step:  60,
+		CompositeQuery: &v3.CompositeQuery{
+			QueryType:   v3.QueryTypePromQL,
+			PanelType:   v3.PanelTypeGraph,
+			PromQueries: map[string]*v3.PromQuery{"A": {Query: "signoz_latency"}},
+		},
+	}
+
+	results, _, err := q.QueryRange(context.Background(), params, nil)
+	if err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+	// the response includes the flux tail
+	if len(results) != 1 || len(results[0].Series) != 1 || len(results[0].Series[0].Points) != len(points) {
+		t.Fatalf("expected the response to include all the %d points, got %v", len(points), results)
+	}
+
+	// the stored series end at the flux boundary
+	data, _, err := c.Retrieve(queryBuilder.NewKeyGenerator().GenerateKeys(params)["A"], true)
+	if err != nil {
+		t.Fatalf("error retrieving cached series: %s", err)
+	}
+	var cachedSeries []*v3.Series
+	if err := json.Unmarshal(data, &cachedSeries); err != nil {
+		t.Fatalf("error unmarshalling cached series: %s", err)
+	}
+	if len(cachedSeries) != 1 {
+		t.Fatalf("expected one cached series, got %d", len(cachedSeries))
+	}
+	cachedPoints := cachedSeries[0].Points
+	if len(cachedPoints) != len(points)-5 || cachedPoints[len(cachedPoints)-1].Timestamp != end-5*minute {
+		t.Errorf("expected the cached series to end at %d, got %v", end-5*minute, cachedPoints[len(cachedPoints)-1])
+	}
+}
+
+func TestQueryRangeTruncateLabelValues(t *testing.T) {
+	command := "/usr/bin/java -Xmx4g -Dconfig=/etc/app/config.yaml -jar /opt/app/app.jar --port 8080"
+	reader := &mockReader{timeSeriesFn: func(query string) ([]*v3.Series, error) {
+		// the filter is matched against the full value
+		if !strings.Contains(query, "'"+command+"'") {
+			return nil, nil
+		}
+		return []*v3.Series{{
+			Labels:      map[string]string{"process_command_line": command, "service_name": "app"},
+			LabelsArray: []map[string]string{{"process_command_line": command}, {"service_name": "app"}},
+			Points:      []v3.Point{{Timestamp: 1675115580000, Value: 1}},
+		}}, nil
+	}}
+	q := NewQuerier(QuerierOptions{
+		Reader:              reader,
+		MaxLabelValueLength: 16,
+	})
+	params := &v3.QueryRangeParamsV3{
+		Start: 1675115580000 - time.Hour.Milliseconds(),
+		End:   1675115580000,
+		Step:  60,
+		CompositeQuery: &v3.CompositeQuery{
+			QueryType: v3.QueryTypeClickHouseSQL,
+			PanelType: v3.PanelTypeGraph,
+			ClickHouseQueries: map[string]*v3.ClickHouseQuery{
+				"A": {Query: "SELECT ts, value, process_command_line FROM metrics WHERE process_command_line = '" + command + "'"},
+			},
+		},
+	}
+
+	results, _, err := q.QueryRange(context.Background(), params, nil)
+	if err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+	if len(results) != 1 || len(results[0].Series) != 1 {
+		t.Fatalf("expected the filter to match the full value, got %v", results)
+	}
+	series := results[0].Series[0]
+	expected := "/usr/bin/java -X..."
+	if series.Labels["process_command_line"] != expected {
+		t.Errorf("expected the label value to be truncated to %q, got %q", expected, series.Labels["process_command_line"])
+	}
+	if series.LabelsArray[0]["process_command_line"] != expected {
+		t.Errorf("expected the labels array value to be truncated to %q, got %q", expected, series.LabelsArray[0]["process_command_line"])
+	}
+	if series.Labels["service_name"] != "app" {
+		t.Errorf("expected the short label value to be kept, got %q", series.Labels["service_name"])
+	}
+}
+
+func TestTruncateLabelValue(t *testing.T) {
+	testCases := []struct {
+		value     string
+		maxLength int
+		expected  string
+	}{
+		{value: "frontend", maxLength: 8, expected: "frontend"},
+		{value: "frontend-proxy", maxLength: 8, expected: "frontend..."},
+		// the value is not cut in the middle of a rune
+		{value: "café-au-lait", maxLength: 4, expected: "caf..."},
+	}
+	for _, tc := range testCases {
+		if got := truncateLabelValue(tc.value, tc.maxLength); got != tc.expected {
+			t.Errorf("expected truncateLabelValue(%q, %d) to be %q, got %q", tc.value, tc.maxLength, tc.expected, got)
+		}
+	}
+}
+
+func TestQueryRangeSeriesSort(t *testing.T) {
+	end := int64(1675115580000)
+	minute := time.Minute.Milliseconds()
+	reader := &mockReader{timeSeriesFn: func(query string) ([]*v3.Series, error) {
+		return []*v3.Series{
+			{
+				Labels: map[string]string{"service_name": "cart"},
+				Points: []v3.Point{{Timestamp: end - 2*minute, Value: 50}, {Timestamp: end - minute, Value: 5}},
+			},
+			{
+				Labels: map[string]string{"service_name": "idle"},
+				Points: []v3.Point{},
+			},
+			{
+				Labels: map[string]string{"service_name": "frontend"},
+				Points: []v3.Point{{Timestamp: end - 2*minute, Value: 1}, {Timestamp: end - minute, Value: 30}},
+			},
+			{
+				Labels: map[string]string{"service_name": "checkout"},
+				Points: []v3.Point{{Timestamp: end - 2*minute, Value: 2}, {Timestamp: end - minute, Value: 10}},
+			},
+		}, nil
+	}}
+	q := NewQuerier(QuerierOptions{Reader: reader})
+	params := &v3.QueryRangeParamsV3{
+		Start:      end - time.Hour.Milliseconds(),
+		End:        end,
+		Step:       60,
+		SeriesSort: &v3.SeriesSort{Metric: v3.SeriesSortMetricLast, Order: "desc"},
+		CompositeQuery: &v3.CompositeQuery{
+			QueryType: v3.QueryTypeClickHouseSQL,
+			PanelType: v3.PanelTypeGraph,
+			ClickHouseQueries: map[string]*v3.ClickHouseQuery{
+				"A": {Query: "SELECT ts, value, service_name FROM metrics"},
+			},
+		},
+	}
+
+	results, _, err := q.QueryRange(context.Background(), params, nil)
+	if err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+	var got []string
+	for _, series := range results[0].Series {
+		got = append(got, series.Labels["service_name"])
+	}
+	// the series without points are last
+	expected := []string{"frontend", "checkout", "cart", "idle"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected the series to be sorted by the last value as %v, got %v", expected, got)
+	}
+}
+
+func TestQueryRangeSeriesSortWithMaxSeries(t *testing.T) {
+	end := int64(1675115580000)
+	minute := time.Minute.Milliseconds()
+	reader := &mockReader{timeSeriesFn: func(query string) ([]*v3.Series, error) {
+		var seriesList []*v3.Series
+		for idx, service := range []string{"cart", "frontend", "checkout", "payment"} {
+			seriesList = append(seriesList, &v3.Series{
+				Labels: map[string]string{"service_name": service},
+				Points: []v3.Point{{Timestamp: end - minute, Value: float64(10 * (idx + 1))}},
+			})
+		}
+		return seriesList, nil
+	}}
+	q := NewQuerier(QuerierOptions{Reader: reader, MaxSeries: 2})
+	params := &v3.QueryRangeParamsV3{
+		Start:      end - time.Hour.Milliseconds(),
+		End:        end,
+		Step:       60,
+		SeriesSort: &v3.SeriesSort{Metric: v3.SeriesSortMetricAvg, Order: "asc"},
+		CompositeQuery: &v3.CompositeQuery{
+			QueryType: v3.QueryTypeClickHouseSQL,
+			PanelType: v3.PanelTypeGraph,
+			ClickHouseQueries: map[string]*v3.ClickHouseQuery{
+				"A": {Query: "SELECT ts, value, service_name FROM metrics"},
+			},
+		},
+	}
+
+	results, _, err := q.QueryRange(context.Background(), params, nil)
+	if err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+	var got []string
+	for _, series := range results[0].Series {
+		got = append(got, series.Labels["service_name"])
+	}
+	// the top series by the sort metric are kept, not the top series by the rank
+	expected := []string{"cart", "frontend"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected the first %v series in the sort order, got %v", expected, got)
+	}
+	if !results[0].Truncated {
+		t.Errorf("expected the result to be marked as truncated")
+	}
+}
+
+func TestQueryRangeDetectCounterResets(t *testing.T) {
+	end := int64(1675115580000)
+	minute := time.Minute.Milliseconds()
+	points := []v3.Point{
+		{Timestamp: end - 6*minute, Value: 10},
+		{Timestamp: end - 5*minute, Value: 25},
+		// the process restarted
+		{Timestamp: end - 4*minute, Value: 3},
+		{Timestamp: end - 3*minute, Value: math.NaN()},
+		{Timestamp: end - 2*minute, Value: 8},
+		// and again
+		{Timestamp: end - minute, Value: 1},
+		{Timestamp: end, Value: 1},
+	}
+	reader := &mockReader{timeSeriesFn: func(query string) ([]*v3.Series, error) {
+		return []*v3.Series{{
+			Labels: map[string]string{"service_name": "cart"},
+			Points: append([]v3.Point(nil), points...),
+		}}, nil
+	}}
+	q := NewQuerier(QuerierOptions{Reader: reader})
+	params := &v3.QueryRangeParamsV3{
+		Start:               end - time.Hour.Milliseconds(),
+		End:                 end,
+		Step:                60,
+		DetectCounterResets: true,
+		CompositeQuery: &v3.CompositeQuery{
+			QueryType: v3.QueryTypeClickHouseSQL,
+			PanelType: v3.PanelTypeGraph,
+			ClickHouseQueries: map[string]*v3.ClickHouseQuery{
+				"A": {Query: "SELECT ts, value, service_name FROM signoz_calls_total"},
+			},
+		},
+	}
+
+	results, _, err := q.QueryRange(context.Background(), params, nil)
+	if err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+	series := results[0].Series[0]
+	expected := []int64{end - 4*minute, end - minute}
+	if !reflect.DeepEqual(series.ResetTimestamps, expected) {
+		t.Errorf("expected the resets at %v, got %v", expected, series.ResetTimestamps)
+	}
+	// the data is not altered
+	if len(series.Points) != len(points) || series.Points[2].Value != 3 {
+		t.Errorf("expected the points to be unchanged, got %v", series.Points)
+	}
+
+	params.DetectCounterResets = false
+	results, _, err = q.QueryRange(context.Background(), params, nil)
+	if err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+	if results[0].Series[0].ResetTimestamps != nil {
+		t.Errorf("expected no resets unless requested, got %v", results[0].Series[0].ResetTimestamps)
+	}
+}
+
+func TestQueryRangeResultStep(t *testing.T) {
+	end := int64(1675115580000)
+	q := NewQuerier(QuerierOptions{
+		KeyGenerator:  queryBuilder.NewKeyGenerator(),
+		FeatureLookup: featureManager.StartManager(),
+		TestingMode:   true,
+		ReturnedSeries: []*v3.Series{{
+			Labels: map[string]string{"service_name": "cart"},
+			Points: []v3.Point{{Timestamp: end - 5*time.Minute.Milliseconds(), Value: 1}},
+		}},
+	})
+	// the step of the builder query was raised from the requested step
+	params := &v3.QueryRangeParamsV3{
+		Start: end - 24*time.Hour.Milliseconds(),
+		End:   end,
+		Step:  60,
+		CompositeQuery: &v3.CompositeQuery{
+			QueryType: v3.QueryTypeBuilder,
+			PanelType: v3.PanelTypeGraph,
+			BuilderQueries: map[string]*v3.BuilderQuery{
+				"A": {
+					QueryName:          "A",
+					DataSource:         v3.DataSourceMetrics,
+					StepInterval:       300,
+					AggregateAttribute: v3.AttributeKey{Key: "signoz_calls_total", DataType: "float64", IsColumn: true},
+					AggregateOperator:  v3.AggregateOperatorSumRate,
+					Expression:         "A",
+				},
+			},
+		},
+	}
+
+	results, _, err := q.QueryRange(context.Background(), params, nil)
+	if err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+	if results[0].Step != 300 {
+		t.Errorf("expected the result step to be 300, got %d", results[0].Step)
+	}
+	executed := q.QueriesExecuted()
+	if len(executed) != 1 || !strings.Contains(executed[0], "INTERVAL 300 SECOND") {
+		t.Errorf("expected the query to be executed with the reported step, got %v", executed)
+	}
+
+	params.CompositeQuery = &v3.CompositeQuery{
+		QueryType:   v3.QueryTypePromQL,
+		PanelType:   v3.PanelTypeGraph,
+		PromQueries: map[string]*v3.PromQuery{"A": {Query: "signoz_calls_total"}},
+	}
+	results, _, err = q.QueryRange(context.Background(), params, nil)
+	if err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+	if results[0].Step != 60 {
+		t.Errorf("expected the result step of the prom query to be 60, got %d", results[0].Step)
+	}
+}
+
+func TestSortSeries(t *testing.T) {
+	newSeries := func(name string, values ...float64) *v3.Series {
+		series := &v3.Series{Labels: map[string]string{"service_name": name}}
+		for idx, value := range values {
+			series.Points = append(series.Points, v3.Point{Timestamp: int64(idx) * 60000, Value: value})
+		}
+		return series
+	}
+	testCases := []struct {
+		name       string
+		seriesSort *v3.SeriesSort
+		expected   []string
+	}{
+		{
+			name:       "avg ascending",
+			seriesSort: &v3.SeriesSort{Metric: v3.SeriesSortMetricAvg, Order: "asc"},
+			expected:   []string{"a", "c", "b"},
+		},
+		{
+			name:       "max defaults to descending",
+			seriesSort: &v3.SeriesSort{Metric: v3.SeriesSortMetricMax},
+			expected:   []string{"b", "a", "c"},
+		},
+		{
+			name:       "last ascending ignores NaN",
+			seriesSort: &v3.SeriesSort{Metric: v3.SeriesSortMetricLast, Order: "asc"},
+			expected:   []string{"b", "a", "c"},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			seriesList := []*v3.Series{
+				newSeries("a", 1, 9, 2),
+				newSeries("b", 20, 1, 1, math.NaN()),
+				newSeries("c", 4, 4, 4),
+			}
+			sortSeries(seriesList, tc.seriesSort)
+			var got []string
+			for _, series := range seriesList {
+				got = append(got, series.Labels["service_name"])
+			}
+			if !reflect.DeepEqual(got, tc.expected) {
+				t.Errorf("expected %v, got %v", tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestQueryRangeCacheStats(t *testing.T) {
+	minute := time.Minute.Milliseconds()
+	end := int64(1675115580000)
+	q := NewQuerier(QuerierOptions{
+		Cache:        inmemory.New(&inmemory.Options{TTL: 60 * time.Minute, CleanupInterval: 10 * time.Minute}),
+		KeyGenerator: queryBuilder.NewKeyGenerator(),
+		NowFunc:      func() time.Time { return time.UnixMilli(end).Add(time.Hour) },
+		TestingMode:  true,
+		ReturnedSeries: []*v3.Series{{
+			Labels: map[string]string{"__name__": "signoz_latency"},
+			Points: []v3.Point{{Timestamp: end - 60*minute, Value: 1}, {Timestamp: end - 30*minute, Value: 1}},
+		}},
+	})
+	params := &v3.QueryRangeParamsV3{
+		Start:      end - 60*minute,
+		End:        end - 30*minute,
+		Step:       60,
+		CacheStats: true,
+		CompositeQuery: &v3.CompositeQuery{
+			QueryType:   v3.QueryTypePromQL,
+			PanelType:   v3.PanelTypeGraph,
+			PromQueries: map[string]*v3.PromQuery{"A": {Query: "signoz_latency"}},
+		},
+	}
+
+	results, _, err := q.QueryRange(context.Background(), params, nil)
+	if err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+	expected := &v3.CacheStats{RetrieveStatus: "key miss", CachedMillis: 0, FetchedMillis: 30 * minute}
+	if !reflect.DeepEqual(results[0].CacheStats, expected) {
+		t.Errorf("expected cache stats %+v, got %+v", expected, results[0].CacheStats)
+	}
+
+	// the first half of the range is served from the cache
+	params.End = end
+	results, _, err = q.QueryRange(context.Background(), params, nil)
+	if err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+	expected = &v3.CacheStats{RetrieveStatus: "partial hit", CachedMillis: 30*minute + 1, FetchedMillis: 30*minute - 1}
+	if !reflect.DeepEqual(results[0].CacheStats, expected) {
+		t.Errorf("expected cache stats %+v, got %+v", expected, results[0].CacheStats)
+	}
+
+	// the stats are only reported when requested
+	params.CacheStats = false
+	results, _, err = q.QueryRange(context.Background(), params, nil)
+	if err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+	if results[0].CacheStats != nil {
+		t.Errorf("expected no cache stats, got %+v", results[0].CacheStats)
+	}
+}
+
+func TestQueryRangeRateLimitBackoff(t *testing.T) {
+	rateLimited := &clickhouse.Exception{Code: 202, Name: "TOO_MANY_SIMULTANEOUS_QUERIES", Message: "Too many simultaneous queries"}
+	testCases := []struct {
+		name          string
+		rateLimits    int64
+		err           error
+		maxRetries    int
+		expectedCalls int64
+		expectedErr   bool
+	}{
+		{name: "rate limited then succeeds", rateLimits: 2, err: rateLimited, maxRetries: 5, expectedCalls: 3},
+		{name: "rate limited past the max retries", rateLimits: 10, err: rateLimited, maxRetries: 2, expectedCalls: 3, expectedErr: true},
+		{name: "other errors are not retried", rateLimits: 10, err: errors.New("syntax error"), maxRetries: 5, expectedCalls: 1, expectedErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var calls atomic.Int64
+			reader := &mockReader{timeSeriesFn: func(query string) ([]*v3.Series, error) {
+				if calls.Add(1) <= tc.rateLimits {
+					return nil, tc.err
+				}
+				return []*v3.Series{{Labels: map[string]string{"service_name": "frontend"}, Points: []v3.Point{{Timestamp: 1675115580000, Value: 1}}}}, nil
+			}}
+			q := NewQuerier(QuerierOptions{
+				Reader:              reader,
+				RateLimitBackoff:    time.Millisecond,
+				RateLimitMaxRetries: tc.maxRetries,
+			})
+			params := &v3.QueryRangeParamsV3{
+				Start: 1675115580000 - time.Hour.Milliseconds(),
+				End:   1675115580000,
+				Step:  60,
+				CompositeQuery: &v3.CompositeQuery{
+					QueryType:         v3.QueryTypeClickHouseSQL,
+					PanelType:         v3.PanelTypeGraph,
+					ClickHouseQueries: map[string]*v3.ClickHouseQuery{"A": {Query: "SELECT 1"}},
+				},
+			}
+
+			results, _, err := q.QueryRange(context.Background(), params, nil)
+			if tc.expectedErr != (err != nil) {
+				t.Fatalf("expected error %v, got %v", tc.expectedErr, err)
+			}
+			if !tc.expectedErr && (len(results) != 1 || len(results[0].Series) != 1) {
+				t.Errorf("expected one series, got %v", results)
+			}
+			if calls.Load() != tc.expectedCalls {
+				t.Errorf("expected %d calls, got %d", tc.expectedCalls, calls.Load())
+			}
+		})
+	}
+}
+
+func TestQueryRangeRateLimitBackoffContextDeadline(t *testing.T) {
+	var calls atomic.Int64
+	reader := &mockReader{timeSeriesFn: func(query string) ([]*v3.Series, error) {
+		calls.Add(1)
+		return nil, &clickhouse.Exception{Code: 202, Name: "TOO_MANY_SIMULTANEOUS_QUERIES"}
+	}}
+	q := NewQuerier(QuerierOptions{
+		Reader:           reader,
+		RateLimitBackoff: time.Hour,
+	})
+	params := &v3.QueryRangeParamsV3{
+		Start: 1675115580000 - time.Hour.Milliseconds(),
+		End:   1675115580000,
+		Step:  60,
+		CompositeQuery: &v3.CompositeQuery{
+			QueryType:         v3.QueryTypeClickHouseSQL,
+			PanelType:         v3.PanelTypeGraph,
+			ClickHouseQueries: map[string]*v3.ClickHouseQuery{"A": {Query: "SELECT 1"}},
+		},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	// the backoff would outlive the deadline, the error is returned right away
+	if _, _, err := q.QueryRange(ctx, params, nil); err == nil {
+		t.Fatalf("expected an error")
+	}
+	if calls.Load() != 1 {
+		t.Errorf("expected 1 call, got %d", calls.Load())
+	}
+}
+
+func TestQueryRangeRateLimitBackoffListAndPromQueries(t *testing.T) {
+	end := int64(1675115580000)
+	rateLimited := &clickhouse.Exception{Code: 202, Name: "TOO_MANY_SIMULTANEOUS_QUERIES", Message: "Too many simultaneous queries"}
+	var listCalls, promCalls atomic.Int64
+	reader := &mockReader{
+		listFn: func(query string) ([]*v3.Row, error) {
+			if listCalls.Add(1) <= 2 {
+				return nil, rateLimited
+			}
+			return []*v3.Row{{Timestamp: time.UnixMilli(end), Data: map[string]interface{}{"body": "log line"}}}, nil
+		},
+		promRangeFn: func(_ context.Context, params *model.QueryRangeParams) (*promql.Result, *model.ApiError) {
+			if promCalls.Add(1) <= 2 {
+				return nil, &model.ApiError{Typ: model.ErrorExec, Err: rateLimited}
+			}
+			return &promql.Result{Value: promql.Matrix{
+				{Metric: labels.FromStrings("__name__", "signoz_latency"), Floats: []promql.FPoint{{T: end, F: 1}}},
+			}}, nil
+		},
+	}
+	q := NewQuerier(QuerierOptions{
+		Reader:           reader,
+		KeyGenerator:     queryBuilder.NewKeyGenerator(),
+		FeatureLookup:    featureManager.StartManager(),
+		RateLimitBackoff: time.Millisecond,
+	})
+
+	listParams := &v3.QueryRangeParamsV3{
+		Start: end - time.Hour.Milliseconds(),
+		End:   end,
+		CompositeQuery: &v3.CompositeQuery{
+			QueryType: v3.QueryTypeBuilder,
+			PanelType: v3.PanelTypeList,
+			BuilderQueries: map[string]*v3.BuilderQuery{
+				"A": {
+					QueryName:         "A",
+					DataSource:        v3.DataSourceLogs,
+					AggregateOperator: v3.AggregateOperatorNoOp,
+					Expression:        "A",
+					OrderBy:           []v3.OrderBy{{ColumnName: "timestamp", Order: "desc"}},
+					PageSize:          10,
+				},
+			},
+		},
+	}
+	results, _, err := q.QueryRange(context.Background(), listParams, nil)
+	if err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+	if len(results) != 1 || len(results[0].List) != 1 {
+		t.Errorf("expected one row, got %v", results)
+	}
+	if listCalls.Load() != 3 {
+		t.Errorf("expected the list query to be retried twice, got %d calls", listCalls.Load())
+	}
+
+	promParams := &v3.QueryRangeParamsV3{
+		Start: end - time.Hour.Milliseconds(),
+		End:   end,
+		Step:  60,
+		CompositeQuery: &v3.CompositeQuery{
+			QueryType:   v3.QueryTypePromQL,
+			PanelType:   v3.PanelTypeGraph,
+			PromQueries: map[string]*v3.PromQuery{"A": {Query: "signoz_latency"}},
+		},
+	}
+	results, _, err = q.QueryRange(context.Background(), promParams, nil)
+	if err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+	if len(results) != 1 || len(results[0].Series) != 1 {
+		t.Errorf("expected one series, got %v", results)
+	}
+	if promCalls.Load() != 3 {
+		t.Errorf("expected the prom query to be retried twice, got %d calls", promCalls.Load())
+	}
+}
+
+func TestQueryRangeNegativeTimestampsWithCountsAndBand(t *testing.T) {
+	end := int64(1675115580000)
+	reader := &mockReader{timeSeriesFn: func(query string) ([]*v3.Series, error) {
+		return []*v3.Series{{
+			Labels:  map[string]string{"service_name": "cart"},
+			Points:  []v3.Point{{Timestamp: -60000, Value: 1}, {Timestamp: end - 60000, Value: 2}, {Timestamp: -1, Value: 3}, {Timestamp: end, Value: 4}},
+			Counts:  []int64{10, 20, 30, 40},
+			BandMin: []float64{0.1, 0.2, 0.3, 0.4},
+			BandMax: []float64{1.1, 1.2, 1.3, 1.4},
+		}}, nil
+	}}
+	q := NewQuerier(QuerierOptions{Reader: reader})
+	params := &v3.QueryRangeParamsV3{
+		Start: end - time.Hour.Milliseconds(),
+		End:   end,
+		Step:  60,
+		CompositeQuery: &v3.CompositeQuery{
+			QueryType:         v3.QueryTypeClickHouseSQL,
+			PanelType:         v3.PanelTypeGraph,
+			ClickHouseQueries: map[string]*v3.ClickHouseQuery{"A": {Query: "SELECT ts, value FROM metrics"}},
+		},
+	}
+
+	results, _, err := q.QueryRange(context.Background(), params, nil)
+	if err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+	series := results[0].Series[0]
+	// the counts and the band of the points with negative timestamps are dropped with them
+	if expected := []v3.Point{{Timestamp: end - 60000, Value: 2}, {Timestamp: end, Value: 4}}; !reflect.DeepEqual(series.Points, expected) {
+		t.Errorf("expected the points %v, got %v", expected, series.Points)
+	}
+	if expected := []int64{20, 40}; !reflect.DeepEqual(series.Counts, expected) {
+		t.Errorf("expected the counts %v, got %v", expected, series.Counts)
+	}
+	if !reflect.DeepEqual(series.BandMin, []float64{0.2, 0.4}) || !reflect.DeepEqual(series.BandMax, []float64{1.2, 1.4}) {
+		t.Errorf("expected the band [0.2 0.4] [1.2 1.4], got %v %v", series.BandMin, series.BandMax)
+	}
+}
+
+func TestQueryRangeCountsAndBandWithPostProcessing(t *testing.T) {
+	end := int64(1675115580000)
+	minute := time.Minute.Milliseconds()
+	start := end - 39*minute
+	// a point with a negative timestamp, a NaN and an Inf value and no values at the steps 20 to 24
+	series := &v3.Series{
+		Labels:  map[string]string{"service_name": "cart"},
+		Points:  []v3.Point{{Timestamp: -minute, Value: 1}},
+		Counts:  []int64{1},
+		BandMin: []float64{1},
+		BandMax: []float64{1},
+	}
+	for step := int64(0); step < 40; step++ {
+		if step >= 20 && step <= 24 {
+			continue
+		}
+		value := float64(step) + 0.25
+		switch step {
+		case 5:
+			value = math.NaN()
+		case 30:
+			value = math.Inf(1)
+		}
+		series.Points = append(series.Points, v3.Point{Timestamp: start + step*minute, Value: value})
+		series.Counts = append(series.Counts, step*10)
+		series.BandMin = append(series.BandMin, float64(step)-0.5)
+		series.BandMax = append(series.BandMax, float64(step)+0.5)
+	}
+	reader := &mockReader{timeSeriesFn: func(query string) ([]*v3.Series, error) {
+		return []*v3.Series{series}, nil
+	}}
+	q := NewQuerier(QuerierOptions{
+		Reader:          reader,
+		KeyGenerator:    queryBuilder.NewKeyGenerator(),
+		FeatureLookup:   featureManager.StartManager(),
+		NonFiniteValues: NonFiniteValuesDrop,
+		TestingMode:     true,
+	})
+	roundDecimals := 1
+	builderQuery := &v3.BuilderQuery{
+		QueryName:          "A",
+		DataSource:         v3.DataSourceLogs,
+		StepInterval:       60,
+		AggregateAttribute: v3.AttributeKey{Key: "duration", DataType: "float64", IsColumn: true},
+		AggregateOperator:  v3.AggregateOperatorAvg,
+		Expression:         "A",
+		IncludeCount:       true,
+		IncludeBand:        true,
+		MovingAvg:          &v3.MovingAvg{Window: 3},
+	}
+	params := &v3.QueryRangeParamsV3{
+		Start:         start,
+		End:           end,
+		Step:          60,
+		MarkGaps:      true,
+		SampleTo:      16,
+		RoundDecimals: &roundDecimals,
+		CompositeQuery: &v3.CompositeQuery{
+			QueryType:      v3.QueryTypeBuilder,
+			PanelType:      v3.PanelTypeGraph,
+			BuilderQueries: map[string]*v3.BuilderQuery{"A": builderQuery},
+		},
+	}
+
+	results, _, err := q.QueryRange(context.Background(), params, nil)
+	if err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+	processed := results[0].Series[0]
+	if len(processed.Points) == 0 || len(processed.Points) > 16 {
+		t.Fatalf("expected at most 16 sampled points, got %d", len(processed.Points))
+	}
+	if len(processed.Counts) != len(processed.Points) || len(processed.BandMin) != len(processed.Points) || len(processed.BandMax) != len(processed.Points) {
+		t.Fatalf("expected the counts and the band aligned with the %d points, got %d, %d and %d",
+			len(processed.Points), len(processed.Counts), len(processed.BandMin), len(processed.BandMax))
+	}
+	for idx, point := range processed.Points {
+		step := (point.Timestamp - start) / minute
+		if point.Timestamp < start {
+			t.Errorf("expected no point before the start, got %+v", point)
+		}
+		if point.Null {
+			if processed.Counts[idx] != 0 || processed.BandMin[idx] != 0 || processed.BandMax[idx] != 0 {
+				t.Errorf("expected a zero count and band at the gap step %d, got %d, %v and %v", step, processed.Counts[idx], processed.BandMin[idx], processed.BandMax[idx])
+			}
+			continue
+		}
+		// each point keeps the count and the band of its step
+		if processed.Counts[idx] != step*10 || processed.BandMin[idx] != float64(step)-0.5 || processed.BandMax[idx] != float64(step)+0.5 {
+			t.Errorf("expected the count %d and the band [%v, %v] at the step %d, got %d and [%v, %v]",
+				step*10, float64(step)-0.5, float64(step)+0.5, step, processed.Counts[idx], processed.BandMin[idx], processed.BandMax[idx])
+		}
+	}
+
+	// the band of the values is dropped with the percentages, the counts stay aligned
+	builderQuery.PercentOfTotal = true
+	results, _, err = q.QueryRange(context.Background(), params, nil)
+	if err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+	processed = results[0].Series[0]
+	if processed.BandMin != nil || processed.BandMax != nil {
+		t.Errorf("expected no band with the percentages, got %v and %v", processed.BandMin, processed.BandMax)
+	}
+	if len(processed.Counts) != len(processed.Points) {
+		t.Errorf("expected the counts aligned with the %d points, got %d", len(processed.Points), len(processed.Counts))
+	}
+}
+
+func TestEstimateCost(t *testing.T) {
+	estimates := map[string]*v3.QueryCostEstimate{
+		"SELECT count() FROM signoz_logs.distributed_logs":           {Rows: 1000, Parts: 4, Marks: 10},
+		"SELECT count() FROM signoz_traces.distributed_signoz_index": {Rows: 500, Parts: 2, Marks: 5},
+	}
+	reader := &mockReader{estimateFn: func(query string) (*v3.QueryCostEstimate, error) {
+		estimate, ok := estimates[query]
+		if !ok {
+			return nil, fmt.Errorf("unexpected query %s", query)
+		}
+		return estimate, nil
+	}}
+	q := NewQuerier(QuerierOptions{Reader: reader})
+
+	params := &v3.QueryRangeParamsV3{
+		Start: 1675115580000 - time.Hour.Milliseconds(),
+		End:   1675115580000,
+		Step:  60,
+		CompositeQuery: &v3.CompositeQuery{
+			QueryType: v3.QueryTypeClickHouseSQL,
+			PanelType: v3.PanelTypeGraph,
+			ClickHouseQueries: map[string]*v3.ClickHouseQuery{
+				"A": {Query: "SELECT count() FROM signoz_logs.distributed_logs"},
+				"B": {Query: "SELECT count() FROM signoz_traces.distributed_signoz_index"},
+				// disabled queries are not estimated
+				"C": {Query: "SELECT count() FROM signoz_metrics.distributed_samples_v4", Disabled: true},
+			},
+		},
+	}
+	estimate, err := q.EstimateCost(context.Background(), params, nil)
+	if err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+	expected := &v3.QueryCostEstimate{Rows: 1500, Parts: 6, Marks: 15}
+	if !reflect.DeepEqual(estimate, expected) {
+		t.Errorf("expected estimate %+v, got %+v", expected, estimate)
+	}
+
+	params.CompositeQuery.QueryType = v3.QueryTypePromQL
+	params.CompositeQuery.PromQueries = map[string]*v3.PromQuery{"A": {Query: "signoz_latency"}}
+	if _, err := q.EstimateCost(context.Background(), params, nil); err == nil {
+		t.Errorf("expected an error for promql queries")
+	}
+}
+
+func TestValidateClickHouseQueries(t *testing.T) {
+	syntaxErr := errors.New("code: 62, message: Syntax error: failed at position 8 ('FORM')")
+	reader := &mockReader{
+		validateFn: func(query string) error {
+			if strings.Contains(query, "FORM") {
+				return syntaxErr
+			}
+			return nil
+		},
+		timeSeriesFn: func(query string) ([]*v3.Series, error) {
+			t.Errorf("expected the query not to be executed, got %s", query)
+			return nil, nil
+		},
+	}
+	q := NewQuerier(QuerierOptions{Reader: reader})
+
+	params := &v3.QueryRangeParamsV3{
+		Start: 1675115580000 - time.Hour.Milliseconds(),
+		End:   1675115580000,
+		Step:  60,
+		CompositeQuery: &v3.CompositeQuery{
+			QueryType: v3.QueryTypeClickHouseSQL,
+			PanelType: v3.PanelTypeGraph,
+			ClickHouseQueries: map[string]*v3.ClickHouseQuery{
+				"A": {Query: "SELECT count() FROM signoz_logs.distributed_logs"},
+				"B": {Query: "SELECT count() FORM signoz_logs.distributed_logs"},
+			},
+		},
+	}
+	errQueriesByName, err := q.ValidateClickHouseQueries(context.Background(), params)
+	if err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+	if len(errQueriesByName) != 1 || !errors.Is(errQueriesByName["B"], syntaxErr) {
+		t.Errorf("expected only the syntax error of query B, got %v", errQueriesByName)
+	}
+
+	params.CompositeQuery.ClickHouseQueries = map[string]*v3.ClickHouseQuery{
+		"A": {Query: "SELECT count() FROM signoz_logs.distributed_logs"},
+	}
+	errQueriesByName, err = q.ValidateClickHouseQueries(context.Background(), params)
+	if err != nil || len(errQueriesByName) != 0 {
+		t.Errorf("expected the valid query to have no errors, got %v, %v", errQueriesByName, err)
+	}
+
+	params.CompositeQuery.QueryType = v3.QueryTypePromQL
+	if _, err := q.ValidateClickHouseQueries(context.Background(), params); err == nil {
+		t.Errorf("expected an error for promql queries")
+	}
+}
+
+func TestEstimateCostBuilderQueries(t *testing.T) {
+	var estimatedQueries []string
+	var mu sync.Mutex
+	reader := &mockReader{estimateFn: func(query string) (*v3.QueryCostEstimate, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		estimatedQueries = append(estimatedQueries, query)
+		return &v3.QueryCostEstimate{Rows: 100, Parts: 1, Marks: 2}, nil
+	}}
+	q := NewQuerier(QuerierOptions{Reader: reader, FeatureLookup: featureManager.StartManager()})
+
+	params := &v3.QueryRangeParamsV3{
+		Start: 1675115580000 - time.Hour.Milliseconds(),
+		End:   1675115580000,
+		Step:  60,
+		CompositeQuery: &v3.CompositeQuery{
+			QueryType: v3.QueryTypeBuilder,
+			PanelType: v3.PanelTypeGraph,
+			BuilderQueries: map[string]*v3.BuilderQuery{
+				"A": {
+					QueryName:         "A",
+					StepInterval:      60,
+					DataSource:        v3.DataSourceLogs,
+					AggregateOperator: v3.AggregateOperatorCount,
+					Expression:        "A",
+				},
+				"B": {
+					QueryName:         "B",
+					StepInterval:      60,
+					DataSource:        v3.DataSourceLogs,
+					AggregateOperator: v3.AggregateOperatorCount,
+					Expression:        "B",
+				},
+			},
+		},
+	}
+	estimate, err := q.EstimateCost(context.Background(), params, nil)
+	if err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+	expected := &v3.QueryCostEstimate{Rows: 200, Parts: 2, Marks: 4}
+	if !reflect.DeepEqual(estimate, expected) {
+		t.Errorf("expected estimate %+v, got %+v", expected, estimate)
+	}
+	// the prepared queries are estimated
+	for _, query := range estimatedQueries {
+		if !strings.Contains(query, "signoz_logs.distributed_logs") {
+			t.Errorf("expected a logs query, got %s", query)
+		}
+	}
+}
+
+func TestQueryRangeSkipsCacheStoreOnCancel(t *testing.T) {
+	end := int64(1675115580000)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// the request is cancelled after the series are fetched
+	reader := &mockReader{promResultFn: func() *promql.Result {
+		cancel()
+		return &promql.Result{Value: promql.Matrix{
+			{Metric: labels.FromStrings("__name__", "signoz_latency"), Floats: []promql.FPoint{{T: end - time.Hour.Milliseconds(), F: 1}}},
+		}}
+	}}
+	c := &ttlRecordingCache{
+		Cache: inmemory.New(&inmemory.Options{TTL: 60 * time.Minute, CleanupInterval: 10 * time.Minute}),
+		ttls:  map[string]time.Duration{},
+	}
+	q := NewQuerier(QuerierOptions{
+		Cache:        c,
+		Reader:       reader,
+		KeyGenerator: queryBuilder.NewKeyGenerator(),
+	})
+	params := &v3.QueryRangeParamsV3{
+		Start: end - time.Hour.Milliseconds(),
+		End:   end,
+		Step:  60,
+		CompositeQuery: &v3.CompositeQuery{
+			QueryType:   v3.QueryTypePromQL,
+			PanelType:   v3.PanelTypeGraph,
+			PromQueries: map[string]*v3.PromQuery{"A": {Query: "signoz_latency"}},
+		},
+	}
+	results, _, err := q.QueryRange(ctx, params, nil)
+	if err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+	if len(results) != 1 || len(results[0].Series) != 1 {
+		t.Fatalf("expected the fetched series to be returned, got %v", results)
+	}
+	if len(c.ttls) != 0 {
+		t.Errorf("expected no cache store after cancellation, got %d", len(c.ttls))
+	}
+}
+
+func TestQueryRangeExecutionMillis(t *testing.T) {
+	end := int64(1675115580000)
+	reader := &mockReader{
+		timeSeriesFn: func(query string) ([]*v3.Series, error) {
+			time.Sleep(5 * time.Millisecond)
+			return []*v3.Series{{Labels: map[string]string{"query": query}, Points: []v3.Point{{Timestamp: end, Value: 1}}}}, nil
+		},
+		promResultFn: func() *promql.Result {
+			time.Sleep(5 * time.Millisecond)
+			return &promql.Result{Value: promql.Matrix{
+				{Metric: labels.FromStrings("__name__", "signoz_latency"), Floats: []promql.FPoint{{T: end, F: 1}}},
+			}}
+		},
+	}
+	q := NewQuerier(QuerierOptions{
+		Reader:       reader,
+		KeyGenerator: queryBuilder.NewKeyGenerator(),
+	})
+
+	for _, compositeQuery := range []*v3.CompositeQuery{
+		{
+			QueryType: v3.QueryTypeClickHouseSQL,
+			PanelType: v3.PanelTypeGraph,
+			ClickHouseQueries: map[string]*v3.ClickHouseQuery{
+				"A": {Query: "SELECT 1"},
+				"B": {Query: "SELECT 2"},
+			},
+		},
+		{
+			QueryType: v3.QueryTypePromQL,
+			PanelType: v3.PanelTypeGraph,
+			PromQueries: map[string]*v3.PromQuery{
+				"A": {Query: "signoz_latency"},
+				"B": {Query: "signoz_calls_total"},
+			},
+		},
+	} {
+		params := &v3.QueryRangeParamsV3{
+			Start:          end - time.Hour.Milliseconds(),
+			End:            end,
+			Step:           60,
+			NoCache:        true,
+			CompositeQuery: compositeQuery,
+		}
+		results, _, err := q.QueryRange(context.Background(), params, nil)
+		if err != nil {
+			t.Fatalf("expected no error, got %s", err)
+		}
+		if len(results) != 2 {
+			t.Fatalf("expected 2 results, got %d", len(results))
+		}
+		for _, result := range results {
+			if result.ExecutionMillis < 5 {
+				t.Errorf("expected the execution time of %s query %s to be at least 5ms, got %d", compositeQuery.QueryType, result.QueryName, result.ExecutionMillis)
+			}
+		}
+	}
+}
+
+func TestQueryRangeScanBudget(t *testing.T) {
+	params := &v3.QueryRangeParamsV3{
+		Start: 1675115596722,
+		End:   1675115596722 + 120*60*1000,
+		Step:  60,
+		CompositeQuery: &v3.CompositeQuery{
+			QueryType: v3.QueryTypeClickHouseSQL,
+			PanelType: v3.PanelTypeGraph,
+			ClickHouseQueries: map[string]*v3.ClickHouseQuery{
+				"A": {Query: "SELECT 1"},
+				"B": {Query: "SELECT 2"},
+				"C": {Query: "SELECT 3"},
+			},
+		},
+	}
+	newQuerier := func(maxScannedRows uint64) interfaces.Querier {
+		return NewQuerier(QuerierOptions{
+			// each query reads 100 rows
+			Reader:         &mockReader{scannedRows: 100},
+			KeyGenerator:   queryBuilder.NewKeyGenerator(),
+			MaxScannedRows: maxScannedRows,
+		})
+	}
+
+	// the queries are within a budget of their combined cost
+	if _, _, err := newQuerier(300).QueryRange(context.Background(), params, nil); err != nil {
+		t.Fatalf("expected no error within the budget, got %s", err)
+	}
+
+	// each query is within the budget, their combined cost is not
+	_, errQueriesByName, err := newQuerier(250).QueryRange(context.Background(), params, nil)
+	if !chErrors.IsResourceLimitError(err) {
+		t.Fatalf("expected a resource limit error, got %v", err)
+	}
+	if len(errQueriesByName) == 0 {
+		t.Fatalf("expected the aborted queries to be reported, got none")
+	}
+	for name, err := range errQueriesByName {
+		if !chErrors.IsResourceLimitError(err) {
+			t.Errorf("expected a resource limit error for query %s, got %v", name, err)
+		}
+	}
+}
+
+func TestScanBudget(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	budget := newScanBudget(0, 1000, cancel)
+
+	budget.AddScanned(10, 600)
+	if err := budget.exceeded(); err != nil {
+		t.Fatalf("expected the budget not to be exceeded, got %s", err)
+	}
+	budget.AddScanned(10, 600)
+	if err := budget.exceeded(); !chErrors.IsResourceLimitError(err) {
+		t.Fatalf("expected a resource limit error, got %v", err)
+	}
+	// the running queries of the request are aborted
+	if ctx.Err() == nil {
+		t.Errorf("expected the context to be cancelled once the budget is exceeded")
+	}
+	var noBudget *scanBudget
+	if err := noBudget.exceeded(); err != nil {
+		t.Errorf("expected no error without a budget, got %s", err)
+	}
+}
+
+func TestQueryRangeMaxTimeRanges(t *testing.T) {
+	end := int64(1675115580000)
+	day := 24 * time.Hour
+	q := NewQuerier(QuerierOptions{
+		KeyGenerator:  queryBuilder.NewKeyGenerator(),
+		FeatureLookup: featureManager.StartManager(),
+		MaxTimeRanges: map[v3.DataSource]time.Duration{
+			v3.DataSourceLogs:    90 * day,
+			v3.DataSourceMetrics: 400 * day,
+		},
+		TestingMode: true,
+		ReturnedSeries: []*v3.Series{{
+			Labels: map[string]string{"service_name": "cart"},
+			Points: []v3.Point{{Timestamp: end - 5*time.Minute.Milliseconds(), Value: 1}},
+		}},
+	})
+
+	// logs over their max time range are rejected before running
+	logsParams := &v3.QueryRangeParamsV3{
+		Start: end - (91 * day).Milliseconds(),
+		End:   end,
+		Step:  3600,
+		CompositeQuery: &v3.CompositeQuery{
+			QueryType: v3.QueryTypeBuilder,
+			PanelType: v3.PanelTypeGraph,
+			BuilderQueries: map[string]*v3.BuilderQuery{
+				"A": {
+					QueryName:         "A",
+					DataSource:        v3.DataSourceLogs,
+					StepInterval:      3600,
+					AggregateOperator: v3.AggregateOperatorCount,
+					Expression:        "A",
+				},
+			},
+		},
+	}
+	_, errQueriesByName, err := q.QueryRange(context.Background(), logsParams, nil)
+	if !chErrors.IsResourceLimitError(err) {
+		t.Fatalf("expected a resource limit error, got %v", err)
+	}
+	if !chErrors.IsResourceLimitError(errQueriesByName["A"]) {
+		t.Errorf("expected the error to be reported for query A, got %v", errQueriesByName)
+	}
+	if executed := q.QueriesExecuted(); len(executed) != 0 {
+		t.Errorf("expected no query to be executed, got %v", executed)
+	}
+
+	// metrics over a year are within their larger max time range
+	metricsParams := &v3.QueryRangeParamsV3{
+		Start: end - (365 * day).Milliseconds(),
+		End:   end,
+		Step:  3600,
+		CompositeQuery: &v3.CompositeQuery{
+			QueryType: v3.QueryTypeBuilder,
+			PanelType: v3.PanelTypeGraph,
+			BuilderQueries: map[string]*v3.BuilderQuery{
+				"A": {
+					QueryName:          "A",
+					DataSource:         v3.DataSourceMetrics,
+					StepInterval:       3600,
+					AggregateAttribute: v3.AttributeKey{Key: "signoz_calls_total", DataType: "float64", IsColumn: true},
+					AggregateOperator:  v3.AggregateOperatorSumRate,
+					Expression:         "A",
+				},
+			},
+		},
+	}
+	results, _, err := q.QueryRange(context.Background(), metricsParams, nil)
+	if err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+	if len(results) != 1 || len(results[0].Series) != 1 {
+		t.Errorf("expected the metrics series to be returned, got %v", results)
+	}
+
+	// prom queries are metrics queries
+	metricsParams.Start = end - (401 * day).Milliseconds()
+	metricsParams.CompositeQuery = &v3.CompositeQuery{
+		QueryType:   v3.QueryTypePromQL,
+		PanelType:   v3.PanelTypeGraph,
+		PromQueries: map[string]*v3.PromQuery{"A": {Query: "signoz_calls_total"}},
+	}
+	if _, _, err := q.QueryRange(context.Background(), metricsParams, nil); !chErrors.IsResourceLimitError(err) {
+		t.Errorf("expected a resource limit error for the prom query, got %v", err)
+	}
+}
+
+func TestMergeListResults(t *testing.T) {
+	base := time.Unix(1675115580, 0)
+	newRows := func(field string, offsets ...int) []*v3.Row {
+		rows := make([]*v3.Row, 0, len(offsets))
+		for _, offset := range offsets {
+			// the field is ordered the other way round from the row timestamp,
+			// and the rows hold pointers to the scanned values
+			timestamp := base.Add(-time.Duration(offset) * time.Second)
+			nanos := uint64(base.Add(time.Duration(offset) * time.Second).UnixNano())
+			rows = append(rows, &v3.Row{Timestamp: timestamp, Data: map[string]interface{}{field: &nanos, "id": offset}})
+		}
+		return rows
+	}
+	type mergedRow struct {
+		queryName string
+		id        int
+	}
+
+	testCases := []struct {
+		name          string
+		merge         *v3.ListMerge
+		expectedName  string
+		expectedOrder []mergedRow
+	}{
+		{
+			name:         "interleaved by the row timestamp, newest first",
+			merge:        &v3.ListMerge{Limit: 4},
+			expectedName: "A,B",
+			expectedOrder: []mergedRow{
+				{"A", 1}, {"B", 2}, {"A", 3}, {"B", 4},
+			},
+		},
+		{
+			name:         "ordered by a timestamp field, newest first",
+			merge:        &v3.ListMerge{TimestampField: "observed", Order: "desc", QueryName: "streams"},
+			expectedName: "streams",
+			expectedOrder: []mergedRow{
+				{"B", 6}, {"A", 5}, {"B", 4}, {"A", 3}, {"B", 2}, {"A", 1},
+			},
+		},
+		{
+			name:         "interleaved by the row timestamp, oldest first",
+			merge:        &v3.ListMerge{Order: "asc", Limit: 2},
+			expectedName: "A,B",
+			expectedOrder: []mergedRow{
+				{"B", 6}, {"A", 5},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			results := []*v3.Result{
+				{QueryName: "B", List: newRows("observed", 6, 4, 2)},
+				{QueryName: "A", List: newRows("observed", 5, 3, 1)},
+			}
+			merged := mergeListResults(results, tc.merge)
+			if merged.QueryName != tc.expectedName {
+				t.Errorf("expected the merged result to be named %s, got %s", tc.expectedName, merged.QueryName)
+			}
+			if len(merged.List) != len(tc.expectedOrder) {
+				t.Fatalf("expected %d rows, got %d", len(tc.expectedOrder), len(merged.List))
+			}
+			for idx, row := range merged.List {
+				got := mergedRow{queryName: row.QueryName, id: row.Data["id"].(int)}
+				if got != tc.expectedOrder[idx] {
+					t.Errorf("expected row %d to be %v, got %v", idx, tc.expectedOrder[idx], got)
+				}
+			}
+		})
+	}
+}
+
+func TestQueryRangeMissingGroupByPlaceholder(t *testing.T) {
+	end := int64(1675115580000)
+	c := inmemory.New(&inmemory.Options{TTL: 60 * time.Minute, CleanupInterval: 10 * time.Minute})
+	q := NewQuerier(QuerierOptions{
+		Cache:         c,
+		KeyGenerator:  queryBuilder.NewKeyGenerator(),
+		FeatureLookup: featureManager.StartManager(),
+		TestingMode:   true,
+		ReturnedSeries: []*v3.Series{
+			{
+				Labels:      map[string]string{"service_name": "cart", "status_code": "200"},
+				LabelsArray: []map[string]string{{"service_name": "cart"}, {"status_code": "200"}},
+				Points:      []v3.Point{{Timestamp: end - 5*time.Minute.Milliseconds(), Value: 1}},
+			},
+			{
+				Labels:      map[string]string{"status_code": "500"},
+				LabelsArray: []map[string]string{{"status_code": "500"}},
+				Points:      []v3.Point{{Timestamp: end - 5*time.Minute.Milliseconds(), Value: 2}},
+			},
+		},
+	})
+	params := &v3.QueryRangeParamsV3{
+		Start: end - time.Hour.Milliseconds(),
+		End:   end,
+		Step:  60,
+		CompositeQuery: &v3.CompositeQuery{
+			QueryType: v3.QueryTypeBuilder,
+			PanelType: v3.PanelTypeGraph,
+			BuilderQueries: map[string]*v3.BuilderQuery{
+				"A": {
+					QueryName:          "A",
+					DataSource:         v3.DataSourceMetrics,
+					StepInterval:       60,
+					AggregateAttribute: v3.AttributeKey{Key: "signoz_calls_total", DataType: "float64", IsColumn: true},
+					AggregateOperator:  v3.AggregateOperatorSumRate,
+					GroupBy: []v3.AttributeKey{
+						{Key: "service_name", DataType: v3.AttributeKeyDataTypeString, Type: v3.AttributeKeyTypeTag},
+						{Key: "status_code", DataType: v3.AttributeKeyDataTypeString, Type: v3.AttributeKeyTypeTag},
+					},
+					Expression: "A",
+				},
+			},
+		},
+		MissingGroupByPlaceholder: "(none)",
+	}
+
+	results, _, err := q.QueryRange(context.Background(), params, nil)
+	if err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+	if len(results) != 1 || len(results[0].Series) != 2 {
+		t.Fatalf("expected 2 series, got %v", results)
+	}
+	for _, series := range results[0].Series {
+		switch series.Labels["status_code"] {
+		case "200":
+			if series.Labels["service_name"] != "cart" {
+				t.Errorf("expected the present label to be kept, got %v", series.Labels)
+			}
+		case "500":
+			if series.Labels["service_name"] != "(none)" {
+				t.Errorf("expected the placeholder for the missing label, got %v", series.Labels)
+			}
+			if !slices.ContainsFunc(series.LabelsArray, func(item map[string]string) bool {
+				return item["service_name"] == "(none)"
+			}) {
+				t.Errorf("expected the placeholder in the labels array, got %v", series.LabelsArray)
+			}
+		default:
+			t.Errorf("unexpected series %v", series.Labels)
+		}
+	}
+
+	// the cached series don't have the placeholder
+	cacheKey := queryBuilder.NewKeyGenerator().GenerateKeys(params)["A"]
+	data, _, err := c.Retrieve(cacheKey, true)
+	if err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+	if len(data) == 0 || strings.Contains(string(data), "(none)") {
+		t.Errorf("expected the cached series without the placeholder, got %s", data)
+	}
+}
+
+func TestQueryRangeClickHouseQueryID(t *testing.T) {
+	end := int64(1675115580000)
+	reader := &mockReader{}
+	q := NewQuerier(QuerierOptions{
+		Reader:       reader,
+		KeyGenerator: queryBuilder.NewKeyGenerator(),
+	})
+	params := &v3.QueryRangeParamsV3{
+		Start: end - time.Hour.Milliseconds(),
+		End:   end,
+		Step:  60,
+		CompositeQuery: &v3.CompositeQuery{
+			QueryType: v3.QueryTypeClickHouseSQL,
+			PanelType: v3.PanelTypeGraph,
+			ClickHouseQueries: map[string]*v3.ClickHouseQuery{
+				"A": {Query: "SELECT 1"},
+				"B": {Query: "SELECT 2"},
+			},
+		},
+	}
+	userCtx := func(userID string) context.Context {
+		user := &model.UserPayload{User: model.User{Id: userID, OrgId: "org"}}
+		return context.WithValue(context.Background(), constants.ContextUserKey, user)
+	}
+	results, _, err := q.QueryRange(userCtx("user-a"), params, nil)
+	if err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("expected 2 results, got %d", len(results))
+	}
+
+	// each query is run with its own query id, which is returned with its result
+	queryIDs := map[string]bool{}
+	for _, result := range results {
+		query := params.CompositeQuery.ClickHouseQueries[result.QueryName].Query
+		if result.QueryID == "" || result.QueryID != reader.queryIDs[query] {
+			t.Errorf("expected the query id %q of %s to be returned, got %q", reader.queryIDs[query], result.QueryName, result.QueryID)
+		}
+		queryIDs[result.QueryID] = true
+	}
+	if len(queryIDs) != 2 {
+		t.Errorf("expected distinct query ids, got %v", queryIDs)
+	}
+
+	// only the query ids issued for the queries of the user can be cancelled
+	for _, tc := range []struct {
+		name    string
+		ctx     context.Context
+		queryID string
+	}{
+		{name: "another user", ctx: userCtx("user-b"), queryID: results[0].QueryID},
+		{name: "no user", ctx: context.Background(), queryID: results[0].QueryID},
+		{name: "not issued", ctx: userCtx("user-a"), queryID: "4f0c2a7e-8c1d-4a55-9d2e-1b6f0e3c9a10"},
+		{name: "not a uuid", ctx: userCtx("user-a"), queryID: "x' OR 1=1"},
+		{name: "empty", ctx: userCtx("user-a"), queryID: ""},
+	} {
+		if err := q.CancelQuery(tc.ctx, tc.queryID); err == nil {
+			t.Errorf("expected an error cancelling the query id of %s", tc.name)
+		}
+	}
+	if len(reader.cancelledQueryIDs) != 0 {
+		t.Fatalf("expected no query to be cancelled, got %v", reader.cancelledQueryIDs)
+	}
+
+	if err := q.CancelQuery(userCtx("user-a"), results[0].QueryID); err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+	if len(reader.cancelledQueryIDs) != 1 || reader.cancelledQueryIDs[0] != results[0].QueryID {
+		t.Errorf("expected query %s to be cancelled, got %v", results[0].QueryID, reader.cancelledQueryIDs)
+	}
+}
+
+func TestComputeRatios(t *testing.T) {
+	newSeries := func(service string, values map[int64]float64) *v3.Series {
+		series := &v3.Series{Labels: map[string]string{"service_name": service}}
+		for timestamp, value := range values {
+			series.Points = append(series.Points, v3.Point{Timestamp: timestamp, Value: value})
+		}
+		series.SortPoints()
+		return series
+	}
+	results := []*v3.Result{
+		{
+			QueryName: "A",
+			Series: []*v3.Series{
+				newSeries("cart", map[int64]float64{1: 1, 2: 3, 3: 5}),
+				newSeries("checkout", map[int64]float64{1: 2}),
+			},
+		},
+		{
+			QueryName: "B",
+			Series: []*v3.Series{
+				// no point at 3 and a zero at 2
+				newSeries("cart", map[int64]float64{1: 4, 2: 0}),
+				newSeries("payment", map[int64]float64{1: 10}),
+			},
+		},
+	}
+
+	results = computeRatios(results, []*v3.Ratio{{QueryName: "error_rate", Numerator: "A", Denominator: "B"}})
+	if len(results) != 3 {
+		t.Fatalf("expected the ratio result to be added, got %d results", len(results))
+	}
+	ratio := results[2]
+	if ratio.QueryName != "error_rate" {
+		t.Errorf("expected the ratio result to be named error_rate, got %s", ratio.QueryName)
+	}
+	// only the series with the same labels in both queries are divided
+	if len(ratio.Series) != 1 || ratio.Series[0].Labels["service_name"] != "cart" {
+		t.Fatalf("expected only the cart series, got %v", ratio.Series)
+	}
+	// the division by zero is a null point, the timestamps without a denominator are left out
+	points := ratio.Series[0].Points
+	if len(points) != 2 || points[0] != (v3.Point{Timestamp: 1, Value: 0.25}) {
+		t.Fatalf("expected the points at 1 and 2, got %v", points)
+	}
+	if points[1].Timestamp != 2 || !points[1].Null {
+		t.Errorf("expected a null point at 2 for the zero denominator, got %+v", points[1])
+	}
+	data, err := json.Marshal(&points[1])
+	if err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+	if expected := `{"timestamp":2,"value":null}`; string(data) != expected {
+		t.Errorf("expected the point encoded as %s, got %s", expected, data)
+	}
+}
+
+func TestQueryRangeRoundDecimals(t *testing.T) {
+	end := int64(1675115580000)
+	c := inmemory.New(&inmemory.Options{TTL: 60 * time.Minute, CleanupInterval: 10 * time.Minute})
+	reader := &mockReader{promResultFn: func() *promql.Result {
+		return &promql.Result{Value: promql.Matrix{
+			{Metric: labels.FromStrings("__name__", "signoz_latency"), Floats: []promql.FPoint{
+				{T: end - 2*time.Minute.Milliseconds(), F: 0.123456789},
+				{T: end - time.Minute.Milliseconds(), F: 1234.5},
+			}},
+		}}
+	}}
+	q := NewQuerier(QuerierOptions{
+		Cache:        c,
+		Reader:       reader,
+		KeyGenerator: queryBuilder.NewKeyGenerator(),
+	})
+	decimals := 2
+	params := &v3.QueryRangeParamsV3{
+		Start: end - time.Hour.Milliseconds(),
+		End:   end,
+		Step:  60,
+		CompositeQuery: &v3.CompositeQuery{
+			QueryType:   v3.QueryTypePromQL,
+			PanelType:   v3.PanelTypeGraph,
+			PromQueries: map[string]*v3.PromQuery{"A": {Query: "signoz_latency"}},
+		},
+		RoundDecimals: &decimals,
+	}
+
+	results, _, err := q.QueryRange(context.Background(), params, nil)
+	if err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+	expected := []v3.Point{
+		{Timestamp: end - 2*time.Minute.Milliseconds(), Value: 0.12},
+		{Timestamp: end - time.Minute.Milliseconds(), Value: 1234.5},
+	}
+	if !reflect.DeepEqual(results[0].Series[0].Points, expected) {
+		t.Errorf("expected the rounded points %v, got %v", expected, results[0].Series[0].Points)
+	}
+
+	// the cached series keep the full precision
+	cacheKey := queryBuilder.NewKeyGenerator().GenerateKeys(params)["A"]
+	data, _, err := c.Retrieve(cacheKey, true)
+	if err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+	var cachedSeries []*v3.Series
+	if err := json.Unmarshal(data, &cachedSeries); err != nil {
+		t.Fatalf("expected the cached series, got %s", err)
+	}
+	if len(cachedSeries) != 1 || len(cachedSeries[0].Points) == 0 || cachedSeries[0].Points[0].Value != 0.123456789 {
+		t.Errorf("expected the cached value to keep its precision, got %v", cachedSeries)
+	}
+}
+
+func TestQueryRangeSampleTo(t *testing.T) {
+	end := int64(1675115580000)
+	start := end - 2*time.Hour.Milliseconds()
+	// a sine wave with a spike and a dip, at a point per minute
+	var floats []promql.FPoint
+	for idx := int64(0); idx < 120; idx++ {
+		value := math.Sin(float64(idx) / 10)
+		switch idx {
+		case 37:
+			value = 100
+		case 83:
+			value = -100
+		}
+		floats = append(floats, promql.FPoint{T: start + idx*time.Minute.Milliseconds(), F: value})
+	}
+	c := inmemory.New(&inmemory.Options{TTL: 60 * time.Minute, CleanupInterval: 10 * time.Minute})
+	reader := &mockReader{promResultFn: func() *promql.Result {
+		return &promql.Result{Value: promql.Matrix{
+			{Metric: labels.FromStrings("__name__", "signoz_latency"), Floats: floats},
+		}}
+	}}
+	q := NewQuerier(QuerierOptions{
+		Cache:        c,
+		Reader:       reader,
+		KeyGenerator: queryBuilder.NewKeyGenerator(),
+	})
+	params := &v3.QueryRangeParamsV3{
+		Start: start,
+		End:   end,
+		Step:  60,
+		CompositeQuery: &v3.CompositeQuery{
+			QueryType:   v3.QueryTypePromQL,
+			PanelType:   v3.PanelTypeGraph,
+			PromQueries: map[string]*v3.PromQuery{"A": {Query: "signoz_latency"}},
+		},
+		SampleTo: 11,
+	}
+
+	results, _, err := q.QueryRange(context.Background(), params, nil)
+	if err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+	points := results[0].Series[0].Points
+	if len(points) == 0 || len(points) > params.SampleTo {
+		t.Fatalf("expected at most %d sampled points, got %d", params.SampleTo, len(points))
+	}
+	var hasSpike, hasDip bool
+	for idx, point := range points {
+		if idx > 0 && point.Timestamp <= points[idx-1].Timestamp {
+			t.Errorf("expected the sampled points in order, got %v", points)
+		}
+		hasSpike = hasSpike || point.Value == 100
+		hasDip = hasDip || point.Value == -100
+	}
+	if !hasSpike || !hasDip {
+		t.Errorf("expected the sampled points to keep the extremes, got %v", points)
+	}
+
+	// the cached series keep the full resolution
+	cacheKey := queryBuilder.NewKeyGenerator().GenerateKeys(params)["A"]
+	data, _, err := c.Retrieve(cacheKey, true)
+	if err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+	var cachedSeries []*v3.Series
+	if err := json.Unmarshal(data, &cachedSeries); err != nil {
+		t.Fatalf("expected the cached series, got %s", err)
+	}
+	if len(cachedSeries) != 1 || len(cachedSeries[0].Points) != len(floats) {
+		t.Errorf("expected the cached series to keep all the %d points, got %v", len(floats), cachedSeries)
+	}
+}
+
+func TestQueryRangeDropZeroSeries(t *testing.T) {
+	end := int64(1675115580000)
+	q := NewQuerier(QuerierOptions{
+		KeyGenerator:  queryBuilder.NewKeyGenerator(),
+		FeatureLookup: featureManager.StartManager(),
+		TestingMode:   true,
+		ReturnedSeries: []*v3.Series{
+			{
+				Labels: map[string]string{"service_name": "cart"},
+				Points: []v3.Point{
+					{Timestamp: end - 6*time.Minute.Milliseconds(), Value: 0},
+					{Timestamp: end - 5*time.Minute.Milliseconds(), Value: 3},
+				},
+			},
+			{
+				Labels: map[string]string{"service_name": "idle"},
+				Points: []v3.Point{
+					{Timestamp: end - 6*time.Minute.Milliseconds(), Value: 0},
+					{Timestamp: end - 5*time.Minute.Milliseconds(), Value: math.NaN()},
+				},
+			},
+			{
+				Labels: map[string]string{"service_name": "empty"},
+			},
+		},
+	})
+	builderQuery := func(dropZeroSeries bool) *v3.BuilderQuery {
+		return &v3.BuilderQuery{
+			QueryName:          "A",
+			DataSource:         v3.DataSourceMetrics,
+			StepInterval:       60,
+			AggregateAttribute: v3.AttributeKey{Key: "signoz_calls_total", DataType: "float64", IsColumn: true},
+			AggregateOperator:  v3.AggregateOperatorSumRate,
+			GroupBy:            []v3.AttributeKey{{Key: "service_name", DataType: v3.AttributeKeyDataTypeString, Type: v3.AttributeKeyTypeTag}},
+			Expression:         "A",
+			DropZeroSeries:     dropZeroSeries,
+		}
+	}
+
+	for _, tc := range []struct {
+		name           string
+		dropZeroSeries bool
+		expected       []string
+	}{
+		{name: "drop zero series", dropZeroSeries: true, expected: []string{"cart"}},
+		{name: "keep zero series", dropZeroSeries: false, expected: []string{"cart", "empty", "idle"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			params := &v3.QueryRangeParamsV3{
+				Start: end - time.Hour.Milliseconds(),
+				End:   end,
+				Step:  60,
+				CompositeQuery: &v3.CompositeQuery{
+					QueryType:      v3.QueryTypeBuilder,
+					PanelType:      v3.PanelTypeGraph,
+					BuilderQueries: map[string]*v3.BuilderQuery{"A": builderQuery(tc.dropZeroSeries)},
+				},
+			}
+			results, _, err := q.QueryRange(context.Background(), params, nil)
+			if err != nil {
+				t.Fatalf("expected no error, got %s", err)
+			}
+			var services []string
+			for _, series := range results[0].Series {
+				services = append(services, series.Labels["service_name"])
+			}
+			if !reflect.DeepEqual(services, tc.expected) {
+				t.Errorf("expected the series %v, got %v", tc.expected, services)
+			}
+		})
+	}
+}
+
+func TestQueryRangeCompareShift(t *testing.T) {
+	end := int64(1675115580000)
+	shift := int64(24 * time.Hour.Seconds())
+	minute := time.Minute.Milliseconds()
+	c := inmemory.New(&inmemory.Options{TTL: 60 * time.Minute, CleanupInterval: 10 * time.Minute})
+	reader := &mockReader{timeSeriesFn: func(query string) ([]*v3.Series, error) {
+		// the shifted query returns the points of the previous day
+		if strings.Contains(query, fmt.Sprintf("unix_milli < %d", end-shift*1000)) {
+			shiftedEnd := end - shift*1000
+			return []*v3.Series{{Points: []v3.Point{
+				{Timestamp: shiftedEnd - 10*minute, Value: 1}, {Timestamp: shiftedEnd - 9*minute, Value: 2},
+			}}}, nil
+		}
+		return []*v3.Series{{Points: []v3.Point{
+			{Timestamp: end - 10*minute, Value: 3}, {Timestamp: end - 9*minute, Value: 4},
+		}}}, nil
+	}}
+	q := NewQuerier(QuerierOptions{
+		Cache:         c,
+		Reader:        reader,
+		FluxInterval:  5 * time.Minute,
+		KeyGenerator:  queryBuilder.NewKeyGenerator(),
+		FeatureLookup: featureManager.StartManager(),
+	})
+	params := &v3.QueryRangeParamsV3{
+		Start: end - time.Hour.Milliseconds(),
+		End:   end,
+		Step:  60,
+		CompositeQuery: &v3.CompositeQuery{
+			QueryType: v3.QueryTypeBuilder,
+			PanelType: v3.PanelTypeGraph,
+			BuilderQueries: map[string]*v3.BuilderQuery{
+				"A": {
+					QueryName:          "A",
+					DataSource:         v3.DataSourceMetrics,
+					StepInterval:       60,
+					AggregateAttribute: v3.AttributeKey{Key: "signoz_calls_total", DataType: "float64", IsColumn: true},
+					AggregateOperator:  v3.AggregateOperatorSumRate,
+					Expression:         "A",
+					CompareShift:       shift,
+				},
+			},
+		},
+	}
+
+	results, _, err := q.QueryRange(context.Background(), params, nil)
+	if err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("expected the result and the shifted result, got %d results", len(results))
+	}
+	resultsByShift := make(map[int64]*v3.Result)
+	for _, result := range results {
+		if result.QueryName != "A" {
+			t.Errorf("expected the results of the query A, got %s", result.QueryName)
+		}
+		resultsByShift[result.CompareShift] = result
+	}
+	expectedPoints := map[int64][]v3.Point{
+		0:     {{Timestamp: end - 10*minute, Value: 3}, {Timestamp: end - 9*minute, Value: 4}},
+		shift: {{Timestamp: end - 10*minute, Value: 1}, {Timestamp: end - 9*minute, Value: 2}},
+	}
+	for compareShift, expected := range expectedPoints {
+		result, ok := resultsByShift[compareShift]
+		if !ok || len(result.Series) != 1 {
+			t.Fatalf("expected a series for the compare shift %d, got %v", compareShift, result)
+		}
+		if !reflect.DeepEqual(result.Series[0].Points, expected) {
+			t.Errorf("expected the points %v for the compare shift %d, got %v", expected, compareShift, result.Series[0].Points)
+		}
+	}
+
+	// the shifted query is cached under the key of its shift
+	shiftedParams := *params
+	shiftedQuery := *params.CompositeQuery.BuilderQueries["A"]
+	shiftedQuery.ShiftBy = shift
+	shiftedParams.CompositeQuery = &v3.CompositeQuery{
+		QueryType:      v3.QueryTypeBuilder,
+		PanelType:      v3.PanelTypeGraph,
+		BuilderQueries: map[string]*v3.BuilderQuery{"A": &shiftedQuery},
+	}
+	keys := queryBuilder.NewKeyGenerator()
+	cacheKey, shiftedCacheKey := keys.GenerateKeys(params)["A"], keys.GenerateKeys(&shiftedParams)["A"]
+	if cacheKey == shiftedCacheKey {
+		t.Fatalf("expected the shifted query to have its own cache key")
+	}
+	data, _, err := c.Retrieve(shiftedCacheKey, true)
+	if err != nil || len(data) == 0 {
+		t.Errorf("expected the shifted series to be cached, got %s", err)
+	}
+}
+
+func TestQueryRangeAuditFilters(t *testing.T) {
+	end := int64(1675115580000)
+	// the queries run concurrently and merge their series, each gets its own series
+	reader := &mockReader{timeSeriesFn: func(query string) ([]*v3.Series, error) {
+		return []*v3.Series{
+			{Points: []v3.Point{{Timestamp: end - 5*time.Minute.Milliseconds(), Value: 1}}},
+		}, nil
+	}}
+	q := NewQuerier(QuerierOptions{
+		Reader:        reader,
+		KeyGenerator:  queryBuilder.NewKeyGenerator(),
+		FeatureLookup: featureManager.StartManager(),
+		TestingMode:   true,
+	})
+	filters := &v3.FilterSet{Operator: "AND", Items: []v3.FilterItem{
+		{Key: v3.AttributeKey{Key: "tenant_id", DataType: v3.AttributeKeyDataTypeString, Type: v3.AttributeKeyTypeResource}, Operator: v3.FilterOperatorEqual, Value: "acme"},
+		{Key: v3.AttributeKey{Key: "service_name", DataType: v3.AttributeKeyDataTypeString, Type: v3.AttributeKeyTypeTag}, Operator: v3.FilterOperatorIn, Value: []interface{}{"cart", "checkout"}},
+	}}
+	builderQuery := func(name string, filters *v3.FilterSet) *v3.BuilderQuery {
+		return &v3.BuilderQuery{
+			QueryName:          name,
+			DataSource:         v3.DataSourceMetrics,
+			StepInterval:       60,
+			AggregateAttribute: v3.AttributeKey{Key: "signoz_calls_total", DataType: "float64", IsColumn: true},
+			AggregateOperator:  v3.AggregateOperatorSumRate,
+			Filters:            filters,
+			Expression:         name,
+		}
+	}
+
+	for _, auditFilters := range []bool{true, false} {
+		params := &v3.QueryRangeParamsV3{
+			Start: end - time.Hour.Milliseconds(),
+			End:   end,
+			Step:  60,
+			CompositeQuery: &v3.CompositeQuery{
+				QueryType: v3.QueryTypeBuilder,
+				PanelType: v3.PanelTypeGraph,
+				BuilderQueries: map[string]*v3.BuilderQuery{
+					"A": builderQuery("A", filters),
+					"B": builderQuery("B", nil),
+				},
+			},
+			AuditFilters: auditFilters,
+		}
+		results, _, err := q.QueryRange(context.Background(), params, nil)
+		if err != nil {
+			t.Fatalf("expected no error, got %s", err)
+		}
+		if len(results) != 2 {
+			t.Fatalf("expected 2 results, got %d", len(results))
+		}
+		for _, result := range results {
+			if !auditFilters {
+				if result.AppliedFilters != nil {
+					t.Errorf("expected no applied filters without the audit, got %v", result.AppliedFilters)
+				}
+				continue
+			}
+			switch result.QueryName {
+			case "A":
+				if !reflect.DeepEqual(result.AppliedFilters, filters) {
+					t.Errorf("expected the applied filters %v, got %v", filters, result.AppliedFilters)
+				}
+			case "B":
+				if result.AppliedFilters == nil || len(result.AppliedFilters.Items) != 0 {
+					t.Errorf("expected empty applied filters, got %v", result.AppliedFilters)
+				}
+			}
+		}
+	}
+}
+
+func TestQueryRangeTopNWithOther(t *testing.T) {
+	end := int64(1675115580000)
+	minute := time.Minute.Milliseconds()
+	reader := &mockReader{timeSeriesFn: func(query string) ([]*v3.Series, error) {
+		return []*v3.Series{
+			{
+				Labels: map[string]string{"service_name": "redis"},
+				Points: []v3.Point{{Timestamp: end - 2*minute, Value: 1}, {Timestamp: end - minute, Value: 2}},
+			},
+			{
+				Labels: map[string]string{"service_name": "cart"},
+				Points: []v3.Point{{Timestamp: end - 2*minute, Value: 50}, {Timestamp: end - minute, Value: 60}},
+			},
+			{
+				Labels: map[string]string{"service_name": "mysql"},
+				Points: []v3.Point{{Timestamp: end - minute, Value: 4}, {Timestamp: end, Value: math.NaN()}},
+			},
+			{
+				Labels: map[string]string{"service_name": "frontend"},
+				Points: []v3.Point{{Timestamp: end - 2*minute, Value: 20}, {Timestamp: end - minute, Value: 30}},
+			},
+			{
+				Labels: map[string]string{"service_name": "idle"},
+				Points: []v3.Point{},
+			},
+		}, nil
+	}}
+	q := NewQuerier(QuerierOptions{Reader: reader})
+	params := &v3.QueryRangeParamsV3{
+		Start:         end - time.Hour.Milliseconds(),
+		End:           end,
+		Step:          60,
+		TopNWithOther: &v3.TopNWithOther{N: 2, Metric: v3.SeriesSortMetricMax},
+		CompositeQuery: &v3.CompositeQuery{
+			QueryType: v3.QueryTypeClickHouseSQL,
+			PanelType: v3.PanelTypeGraph,
+			ClickHouseQueries: map[string]*v3.ClickHouseQuery{
+				"A": {Query: "SELECT ts, value, service_name FROM metrics"},
+			},
+		},
+	}
+
+	results, _, err := q.QueryRange(context.Background(), params, nil)
+	if err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+	seriesList := results[0].Series
+	if len(seriesList) != 3 {
+		t.Fatalf("expected the top 2 series and the other series, got %d series", len(seriesList))
+	}
+	var got []string
+	for _, series := range seriesList {
+		got = append(got, series.Labels["service_name"])
+	}
+	expected := []string{"cart", "frontend", v3.OtherSeriesLabelValue}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected the series %v, got %v", expected, got)
+	}
+	// the other series sums redis, mysql and idle
+	expectedOther := []v3.Point{
+		{Timestamp: end - 2*minute, Value: 1},
+		{Timestamp: end - minute, Value: 6},
+	}
+	if !reflect.DeepEqual(seriesList[2].Points, expectedOther) {
+		t.Errorf("expected the other points %v, got %v", expectedOther, seriesList[2].Points)
+	}
+	expectedLabelsArray := []map[string]string{{"service_name": v3.OtherSeriesLabelValue}}
+	if !reflect.DeepEqual(seriesList[2].LabelsArray, expectedLabelsArray) {
+		t.Errorf("expected the other labels array %v, got %v", expectedLabelsArray, seriesList[2].LabelsArray)
+	}
+}
+
+func TestQueryRangeDoesNotModifyParams(t *testing.T) {
+	end := int64(1675115580000)
+	reader := &mockReader{timeSeriesFn: func(query string) ([]*v3.Series, error) {
+		return []*v3.Series{{Points: []v3.Point{{Timestamp: end - time.Minute.Milliseconds(), Value: 1}}}}, nil
+	}}
+	q := NewQuerier(QuerierOptions{
+		Reader:        reader,
+		KeyGenerator:  queryBuilder.NewKeyGenerator(),
+		FeatureLookup: featureManager.StartManager(),
+	})
+	params := &v3.QueryRangeParamsV3{
+		Start: end - time.Hour.Milliseconds(),
+		End:   end,
+		Step:  60,
+		CompositeQuery: &v3.CompositeQuery{
+			QueryType: v3.QueryTypeBuilder,
+			PanelType: v3.PanelTypeGraph,
+			BuilderQueries: map[string]*v3.BuilderQuery{
+				// the quantile queries are completed with the le group by
+				"A": {
+					QueryName:          "A",
+					DataSource:         v3.DataSourceMetrics,
+					StepInterval:       60,
+					AggregateAttribute: v3.AttributeKey{Key: "signoz_latency_bucket", DataType: "float64", IsColumn: true},
+					AggregateOperator:  v3.AggregateOperatorHistQuant95,
+					GroupBy:            []v3.AttributeKey{{Key: "service_name", DataType: v3.AttributeKeyDataTypeString, Type: v3.AttributeKeyTypeTag}},
+					Expression:         "A",
+				},
+				// the delta queries are completed with the temporality filter
+				"B": {
+					QueryName:          "B",
+					DataSource:         v3.DataSourceMetrics,
+					StepInterval:       60,
+					AggregateAttribute: v3.AttributeKey{Key: "signoz_calls_total", DataType: "float64", IsColumn: true},
+					AggregateOperator:  v3.AggregateOperatorSumRate,
+					Temporality:        v3.Delta,
+					Filters: &v3.FilterSet{Operator: "AND", Items: []v3.FilterItem{
+						{Key: v3.AttributeKey{Key: "service_name", DataType: v3.AttributeKeyDataTypeString, Type: v3.AttributeKeyTypeTag}, Operator: v3.FilterOperatorEqual, Value: "cart"},
+					}},
+					Expression: "B",
+				},
+			},
+		},
+	}
+	expected := params.Clone()
+
+	var wg sync.WaitGroup
+	for idx := 0; idx < 8; idx++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, _, err := q.QueryRange(context.Background(), params, nil); err != nil {
+				t.Errorf("expected no error, got %s", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if !reflect.DeepEqual(params, expected) {
+		t.Errorf("expected the params to be unmodified, got group by %v and filters %v",
+			params.CompositeQuery.BuilderQueries["A"].GroupBy, params.CompositeQuery.BuilderQueries["B"].Filters.Items)
+	}
+}
+
+func TestQueryRangePromCachedSeriesOnMissError(t *testing.T) {
+	minute := time.Minute.Milliseconds()
+	end := int64(1675115580000)
+	start := end - 60*minute
+	var fail atomic.Bool
+	reader := &mockReader{promRangeFn: func(_ context.Context, params *model.QueryRangeParams) (*promql.Result, *model.ApiError) {
+		if fail.Load() {
+			return nil, &model.ApiError{Typ: model.ErrorExec, Err: fmt.Errorf("prometheus is unavailable")}
+		}
+		return &promql.Result{Value: promql.Matrix{
+			{Metric: labels.FromStrings("__name__", "signoz_latency"), Floats: []promql.FPoint{{T: params.Start.UnixMilli(), F: 1}}},
+		}}, nil
+	}}
+	q := NewQuerier(QuerierOptions{
+		Cache:        inmemory.New(&inmemory.Options{TTL: 60 * time.Minute, CleanupInterval: 10 * time.Minute}),
+		Reader:       reader,
+		KeyGenerator: queryBuilder.NewKeyGenerator(),
+		FluxInterval: 5 * time.Minute,
+		NowFunc:      func() time.Time { return time.UnixMilli(end).Add(time.Hour) },
+	})
+	newParams := func(start, end int64) *v3.QueryRangeParamsV3 {
+		return &v3.QueryRangeParamsV3{
+			Start:      start,
+			End:        end,
+			Step:       60,
+			CacheStats: true,
+			CompositeQuery: &v3.CompositeQuery{
+				QueryType:   v3.QueryTypePromQL,
+				PanelType:   v3.PanelTypeGraph,
+				PromQueries: map[string]*v3.PromQuery{"A": {Query: "signoz_latency"}},
+			},
+		}
+	}
+
+	// cache the middle of the range, then fail the fetches of the misses around it
+	if _, _, err := q.QueryRange(context.Background(), newParams(start+20*minute, start+40*minute), nil); err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+	fail.Store(true)
+	results, errQueriesByName, err := q.QueryRange(context.Background(), newParams(start, end), nil)
+	if err == nil || errQueriesByName["A"] == nil {
+		t.Fatalf("expected the error of the query A, got %v, %v", err, errQueriesByName)
+	}
+	if len(results) != 1 || len(results[0].Series) != 1 {
+		t.Fatalf("expected the cached series with the error, got %v", results)
+	}
+	expected := []v3.Point{{Timestamp: start + 20*minute, Value: 1}}
+	if !reflect.DeepEqual(results[0].Series[0].Points, expected) {
+		t.Errorf("expected the cached points %v, got %v", expected, results[0].Series[0].Points)
+	}
+	cacheStats := results[0].CacheStats
+	if cacheStats == nil || cacheStats.RetrieveStatus != "partial hit" || cacheStats.CachedMillis == 0 || cacheStats.FetchedMillis != 0 {
+		t.Errorf("expected the cache stats of the cached part, got %+v", cacheStats)
+	}
+}
+
+func TestQueryRangeFluxIntervalSteps(t *testing.T) {
+	hour := time.Hour.Milliseconds()
+	// the latest 1h bucket started half an hour ago and is still partial
+	lastBucket := int64(1675112400000)
+	end := lastBucket + 30*time.Minute.Milliseconds()
+	now := time.UnixMilli(end)
+
+	points := make([]v3.Point, 0, 25)
+	for ts := lastBucket - 24*hour; ts <= lastBucket; ts += hour {
+		points = append(points, v3.Point{Timestamp: ts, Value: 1})
+	}
+	for _, tc := range []struct {
+		name              string
+		fluxIntervalSteps int
+		// the start of the refetched flux tail
+		expectedFluxStart int64
+	}{
+		{
+			// the partial last bucket is cached as settled and never refetched
+			name:              "fixed flux interval",
+			fluxIntervalSteps: 0,
+			expectedFluxStart: lastBucket + 1,
+		},
+		{
+			// the last bucket is in flux, the tail is refetched from the bucket before it
+			name:              "flux interval of a step",
+			fluxIntervalSteps: 1,
+			expectedFluxStart: lastBucket - hour + 1,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			params := &v3.QueryRangeParamsV3{
+				Start: lastBucket - 24*hour,
+				End:   end,
+				Step:  3600,
+				CompositeQuery: &v3.CompositeQuery{
+					QueryType:   v3.QueryTypePromQL,
+					PanelType:   v3.PanelTypeGraph,
+					PromQueries: map[string]*v3.PromQuery{"A": {Query: "signoz_latency"}},
+				},
+			}
+			q := NewQuerier(QuerierOptions{
+				Cache:             inmemory.New(&inmemory.Options{TTL: 60 * time.Minute, CleanupInterval: 10 * time.Minute}),
+				KeyGenerator:      queryBuilder.NewKeyGenerator(),
+				FluxInterval:      time.Minute,
+				FluxIntervalSteps: tc.fluxIntervalSteps,
+				NowFunc:           func() time.Time { return now },
+				TestingMode:       true,
+				ReturnedSeries:    []*v3.Series{{Labels: map[string]string{"__name__": "signoz_latency"}, Points: points}},
+			})
+
+			for i := 0; i < 2; i++ {
+				if _, _, err := q.QueryRange(context.Background(), params, nil); err != nil {
+					t.Fatalf("expected no error, got %s", err)
+				}
+			}
+			timeRanges := q.TimeRanges()
+			if len(timeRanges) != 2 {
+				t.Fatalf("expected the flux tail to be refetched, got %v", timeRanges)
+			}
+			if int64(timeRanges[1][0]) != tc.expectedFluxStart || int64(timeRanges[1][1]) != end {
+				t.Errorf("expected the flux tail [%d, %d], got %v", tc.expectedFluxStart, end, timeRanges[1])
+			}
+			if tc.fluxIntervalSteps > 0 && int64(timeRanges[1][0]) > lastBucket {
+				t.Errorf("expected the flux tail to cover the last bucket at %d, got %v", lastBucket, timeRanges[1])
+			}
+		})
+	}
+}
+
+func TestQueryRangeHeatmap(t *testing.T) {
+	end := int64(1675115580000)
+	q := NewQuerier(QuerierOptions{
+		KeyGenerator:  queryBuilder.NewKeyGenerator(),
+		FeatureLookup: featureManager.StartManager(),
+		TestingMode:   true,
+		ReturnedSeries: []*v3.Series{
+			{
+				Labels: map[string]string{"__bucket": "2"},
+				Points: []v3.Point{
+					{Timestamp: end - 5*time.Minute.Milliseconds(), Value: 4},
+				},
+			},
+			{
+				Labels: map[string]string{"__bucket": "0"},
+				Points: []v3.Point{
+					{Timestamp: end - 6*time.Minute.Milliseconds(), Value: 1},
+					{Timestamp: end - 5*time.Minute.Milliseconds(), Value: 3},
+				},
+			},
+		},
+	})
+	params := &v3.QueryRangeParamsV3{
+		Start: end - time.Hour.Milliseconds(),
+		End:   end,
+		Step:  60,
+		CompositeQuery: &v3.CompositeQuery{
+			QueryType: v3.QueryTypeBuilder,
+			PanelType: v3.PanelTypeGraph,
+			BuilderQueries: map[string]*v3.BuilderQuery{
+				"A": {
+					QueryName:          "A",
+					DataSource:         v3.DataSourceLogs,
+					StepInterval:       60,
+					AggregateAttribute: v3.AttributeKey{Key: "bytes", DataType: v3.AttributeKeyDataTypeFloat64, Type: v3.AttributeKeyTypeTag},
+					AggregateOperator:  v3.AggregateOperatorCount,
+					Expression:         "A",
+					Heatmap:            &v3.HeatmapBuckets{Min: 0, Max: 30, Count: 3},
+				},
+			},
+		},
+	}
+	results, _, err := q.QueryRange(context.Background(), params, nil)
+	if err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+	if len(results) != 1 || results[0].Heatmap == nil {
+		t.Fatalf("expected a heatmap result, got %v", results)
+	}
+	if len(results[0].Series) != 0 {
+		t.Errorf("expected no series with the heatmap, got %d", len(results[0].Series))
+	}
+	expected := &v3.Heatmap{
+		Timestamps: []int64{end - 6*time.Minute.Milliseconds(), end - 5*time.Minute.Milliseconds()},
+		Bounds:     []float64{0, 10, 20, 30},
+		Counts:     [][]int64{{1, 0, 0}, {3, 0, 4}},
+	}
+	if !reflect.DeepEqual(results[0].Heatmap, expected) {
+		t.Errorf("expected the heatmap %v, got %v", expected, results[0].Heatmap)
+	}
+}
+
+func TestQueryRangeLabelKeys(t *testing.T) {
+	end := int64(1675115580000)
+	q := NewQuerier(QuerierOptions{
+		KeyGenerator:  queryBuilder.NewKeyGenerator(),
+		FeatureLookup: featureManager.StartManager(),
+		TestingMode:   true,
+		ReturnedSeries: []*v3.Series{
+			{
+				Labels: map[string]string{"service_name": "cart", "operation": "checkout"},
+				Points: []v3.Point{{Timestamp: end - 5*time.Minute.Milliseconds(), Value: 1}},
+			},
+			{
+				Labels: map[string]string{"service_name": "cart", "status_code": "500"},
+				Points: []v3.Point{{Timestamp: end - 5*time.Minute.Milliseconds(), Value: 2}},
+			},
+			{
+				Labels: map[string]string{"deployment_environment": "prod"},
+				Points: []v3.Point{{Timestamp: end - 5*time.Minute.Milliseconds(), Value: 3}},
+			},
+		},
+	})
+
+	for _, tc := range []struct {
+		name             string
+		includeLabelKeys bool
+		expected         []string
+	}{
+		{name: "include label keys", includeLabelKeys: true, expected: []string{"deployment_environment", "operation", "service_name", "status_code"}},
+		{name: "no label keys", includeLabelKeys: false, expected: nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			params := &v3.QueryRangeParamsV3{
+				Start: end - time.Hour.Milliseconds(),
+				End:   end,
+				Step:  60,
+				CompositeQuery: &v3.CompositeQuery{
+					QueryType: v3.QueryTypeBuilder,
+					PanelType: v3.PanelTypeGraph,
+					BuilderQueries: map[string]*v3.BuilderQuery{
+						"A": {
+							QueryName:          "A",
+							DataSource:         v3.DataSourceMetrics,
+							StepInterval:       60,
+							AggregateAttribute: v3.AttributeKey{Key: "signoz_calls_total", DataType: "float64", IsColumn: true},
+							AggregateOperator:  v3.AggregateOperatorSumRate,
+							Expression:         "A",
+						},
+					},
+				},
+				IncludeLabelKeys: tc.includeLabelKeys,
+			}
+			results, _, err := q.QueryRange(context.Background(), params, nil)
+			if err != nil {
+				t.Fatalf("expected no error, got %s", err)
+			}
+			if len(results) != 1 || len(results[0].Series) != 3 {
+				t.Fatalf("expected a result with 3 series, got %v", results)
+			}
+			if !reflect.DeepEqual(results[0].LabelKeys, tc.expected) {
+				t.Errorf("expected the label keys %v, got %v", tc.expected, results[0].LabelKeys)
+			}
+		})
+	}
+}
+
+func TestQueryRangeClickHousePriority(t *testing.T) {
+	end := int64(1675115580000)
+	for _, tc := range []struct {
+		name     string
+		priority *int
+		expected int
+	}{
+		{name: "default priority", priority: nil, expected: constants.DefaultQueryPriority},
+		{name: "requested priority", priority: func() *int { p := 1; return &p }(), expected: 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			reader := &mockReader{}
+			q := NewQuerier(QuerierOptions{
+				Reader:       reader,
+				KeyGenerator: queryBuilder.NewKeyGenerator(),
+			})
+			params := &v3.QueryRangeParamsV3{
+				Start: end - time.Hour.Milliseconds(),
+				End:   end,
+				Step:  60,
+				CompositeQuery: &v3.CompositeQuery{
+					QueryType: v3.QueryTypeClickHouseSQL,
+					PanelType: v3.PanelTypeGraph,
+					ClickHouseQueries: map[string]*v3.ClickHouseQuery{
+						"A": {Query: "SELECT 1"},
+					},
+				},
+				Priority: tc.priority,
+			}
+			if _, _, err := q.QueryRange(context.Background(), params, nil); err != nil {
+				t.Fatalf("expected no error, got %s", err)
+			}
+			if priority, ok := reader.priorities["SELECT 1"]; !ok || priority != tc.expected {
+				t.Errorf("expected the query to be run with the priority %d, got %v", tc.expected, reader.priorities)
+			}
+		})
+	}
+}
+
+func TestQueryRangeMaxMisses(t *testing.T) {
+	hour := time.Hour.Milliseconds()
+	start := int64(1675108800000)
+	end := start + 3*hour
+	now := time.UnixMilli(end + hour)
+
+	// only the middle hour is cached, the wider range misses on both sides of it
+	points := make([]v3.Point, 0, 61)
+	for ts := start + hour; ts <= start+2*hour; ts += time.Minute.Milliseconds() {
+		points = append(points, v3.Point{Timestamp: ts, Value: 1})
+	}
+	newParams := func(start, end int64) *v3.QueryRangeParamsV3 {
+		return &v3.QueryRangeParamsV3{
+			Start: start,
+			End:   end,
+			Step:  60,
+			CompositeQuery: &v3.CompositeQuery{
+				QueryType:   v3.QueryTypePromQL,
+				PanelType:   v3.PanelTypeGraph,
+				PromQueries: map[string]*v3.PromQuery{"A": {Query: "signoz_latency"}},
+			},
+		}
+	}
+
+	for _, tc := range []struct {
+		name      string
+		maxMisses int
+		// the ranges fetched for the wider range
+		expectedFetches int
+	}{
+		{name: "fetch each miss", maxMisses: 0, expectedFetches: 2},
+		{name: "refetch the whole range", maxMisses: 1, expectedFetches: 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			q := NewQuerier(QuerierOptions{
+				Cache:          inmemory.New(&inmemory.Options{TTL: 60 * time.Minute, CleanupInterval: 10 * time.Minute}),
+				KeyGenerator:   queryBuilder.NewKeyGenerator(),
+				FluxInterval:   time.Minute,
+				MaxMisses:      tc.maxMisses,
+				NowFunc:        func() time.Time { return now },
+				TestingMode:    true,
+				ReturnedSeries: []*v3.Series{{Labels: map[string]string{"__name__": "signoz_latency"}, Points: points}},
+			})
+
+			if _, _, err := q.QueryRange(context.Background(), newParams(start+hour, start+2*hour), nil); err != nil {
+				t.Fatalf("expected no error, got %s", err)
+			}
+			if _, _, err := q.QueryRange(context.Background(), newParams(start, end), nil); err != nil {
+				t.Fatalf("expected no error, got %s", err)
+			}
+
+			fetches := q.TimeRanges()[1:]
+			if len(fetches) != tc.expectedFetches {
+				t.Fatalf("expected %d fetches, got %v", tc.expectedFetches, fetches)
+			}
+			if tc.expectedFetches == 1 && (int64(fetches[0][0]) != start || int64(fetches[0][1]) != end) {
+				t.Errorf("expected the whole range [%d, %d] to be fetched, got %v", start, end, fetches[0])
+			}
+		})
+	}
+}
+
+func TestMovingAvgPoints(t *testing.T) {
+	newPoints := func(values ...float64) []v3.Point {
+		points := make([]v3.Point, 0, len(values))
+		for idx, value := range values {
+			points = append(points, v3.Point{Timestamp: int64(idx) * 60000, Value: value})
+		}
+		return points
+	}
+	for _, tc := range []struct {
+		name     string
+		values   []float64
+		window   int
+		expected []float64
+	}{
+		{
+			// the first points are averaged over the points available
+			name:     "partial window at the start",
+			values:   []float64{1, 2, 3, 4, 5},
+			window:   3,
+			expected: []float64{1, 1.5, 2, 3, 4},
+		},
+		{
+			name:     "window larger than the series",
+			values:   []float64{2, 4},
+			window:   5,
+			expected: []float64{2, 3},
+		},
+		{
+			// the NaN values are left out of the averages
+			name:     "NaN values",
+			values:   []float64{math.NaN(), 2, 4, math.NaN(), math.NaN(), math.NaN()},
+			window:   2,
+			expected: []float64{math.NaN(), 2, 3, 4, math.NaN(), math.NaN()},
+		},
+		{
+			name:     "no points",
+			values:   []float64{},
+			window:   3,
+			expected: []float64{},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			points := newPoints(tc.values...)
+			smoothed := movingAvgPoints(points, tc.window)
+			if len(smoothed) != len(tc.expected) {
+				t.Fatalf("expected %d points, got %d", len(tc.expected), len(smoothed))
+			}
+			for idx, point := range smoothed {
+				if point.Timestamp != points[idx].Timestamp {
+					t.Errorf("expected the timestamp %d, got %d", points[idx].Timestamp, point.Timestamp)
+				}
+				expected := tc.expected[idx]
+				if (math.IsNaN(expected) != math.IsNaN(point.Value)) || (!math.IsNaN(expected) && math.Abs(point.Value-expected) > 1e-9) {
+					t.Errorf("expected the value %v at %d, got %v", expected, idx, point.Value)
+				}
+			}
+			// the raw points are left as they are
+			for idx, point := range points {
+				if point.Value != tc.values[idx] && !math.IsNaN(tc.values[idx]) {
+					t.Errorf("expected the raw value %v at %d, got %v", tc.values[idx], idx, point.Value)
+				}
+			}
+		})
+	}
+}
+
+func TestQueryRangeMovingAvg(t *testing.T) {
+	end := int64(1675115580000)
+	minute := time.Minute.Milliseconds()
+	newQuerier := func(c cache.Cache) *querier {
+		return NewQuerier(QuerierOptions{
+			Cache:         c,
+			KeyGenerator:  queryBuilder.NewKeyGenerator(),
+			FeatureLookup: featureManager.StartManager(),
+			TestingMode:   true,
+			ReturnedSeries: []*v3.Series{
+				{
+					Labels:      map[string]string{"service_name": "cart"},
+					LabelsArray: []map[string]string{{"service_name": "cart"}},
+					Points: []v3.Point{
+						{Timestamp: end - 4*minute, Value: 2},
+						{Timestamp: end - 3*minute, Value: 4},
+						{Timestamp: end - 2*minute, Value: 6},
+						{Timestamp: end - minute, Value: 8},
+					},
+				},
+			},
+		}).(*querier)
+	}
+	newParams := func(movingAvg *v3.MovingAvg) *v3.QueryRangeParamsV3 {
+		return &v3.QueryRangeParamsV3{
+			Start: end - time.Hour.Milliseconds(),
+			End:   end,
+			Step:  60,
+			CompositeQuery: &v3.CompositeQuery{
+				QueryType: v3.QueryTypeBuilder,
+				PanelType: v3.PanelTypeGraph,
+				BuilderQueries: map[string]*v3.BuilderQuery{
+					"A": {
+						QueryName:          "A",
+						DataSource:         v3.DataSourceMetrics,
+						StepInterval:       60,
+						AggregateAttribute: v3.AttributeKey{Key: "signoz_calls_total", DataType: "float64", IsColumn: true},
+						AggregateOperator:  v3.AggregateOperatorSumRate,
+						GroupBy:            []v3.AttributeKey{{Key: "service_name", DataType: v3.AttributeKeyDataTypeString, Type: v3.AttributeKeyTypeTag}},
+						Expression:         "A",
+						MovingAvg:          movingAvg,
+					},
+				},
+			},
+		}
+	}
+	values := func(series *v3.Series) []float64 {
+		values := make([]float64, 0, len(series.Points))
+		for _, point := range series.Points {
+			values = append(values, point.Value)
+		}
+		return values
+	}
+	raw := []float64{2, 4, 6, 8}
+	smoothed := []float64{2, 3, 5, 7}
+
+	t.Run("replace", func(t *testing.T) {
+		c := inmemory.New(&inmemory.Options{TTL: 60 * time.Minute, CleanupInterval: 10 * time.Minute})
+		params := newParams(&v3.MovingAvg{Window: 2})
+		results, _, err := newQuerier(c).QueryRange(context.Background(), params, nil)
+		if err != nil {
+			t.Fatalf("expected no error, got %s", err)
+		}
+		if len(results) != 1 || len(results[0].Series) != 1 {
+			t.Fatalf("expected a single series, got %v", results)
+		}
+		if got := values(results[0].Series[0]); !reflect.DeepEqual(got, smoothed) {
+			t.Errorf("expected the smoothed values %v, got %v", smoothed, got)
+		}
+
+		// the cache keeps the raw values
+		data, _, err := c.Retrieve(queryBuilder.NewKeyGenerator().GenerateKeys(params)["A"], true)
+		if err != nil {
+			t.Fatalf("expected no error, got %s", err)
+		}
+		var cachedSeries []*v3.Series
+		if err := json.Unmarshal(data, &cachedSeries); err != nil {
+			t.Fatalf("expected no error, got %s", err)
+		}
+		if len(cachedSeries) != 1 || !reflect.DeepEqual(values(cachedSeries[0]), raw) {
+			t.Errorf("expected the cached raw values %v, got %v", raw, cachedSeries)
+		}
+	})
+
+	t.Run("companion", func(t *testing.T) {
+		results, _, err := newQuerier(nil).QueryRange(context.Background(), newParams(&v3.MovingAvg{Window: 2, Companion: true}), nil)
+		if err != nil {
+			t.Fatalf("expected no error, got %s", err)
+		}
+		if len(results) != 1 || len(results[0].Series) != 2 {
+			t.Fatalf("expected the raw and the smoothed series, got %v", results)
+		}
+		rawSeries, smoothedSeries := results[0].Series[0], results[0].Series[1]
+		if got := values(rawSeries); !reflect.DeepEqual(got, raw) {
+			t.Errorf("expected the raw values %v, got %v", raw, got)
+		}
+		if got := values(smoothedSeries); !reflect.DeepEqual(got, smoothed) {
+			t.Errorf("expected the smoothed values %v, got %v", smoothed, got)
+		}
+		expectedLabels := map[string]string{"service_name": "cart", v3.MovingAvgLabel: "2"}
+		if !reflect.DeepEqual(smoothedSeries.Labels, expectedLabels) {
+			t.Errorf("expected the labels %v, got %v", expectedLabels, smoothedSeries.Labels)
+		}
+		if _, ok := rawSeries.Labels[v3.MovingAvgLabel]; ok {
+			t.Errorf("expected the raw series without the moving average label, got %v", rawSeries.Labels)
+		}
+	})
+}
+
+func TestQueryRangeCorruptCacheEntry(t *testing.T) {
+	end := int64(1675115580000)
+	start := end - time.Hour.Milliseconds()
+	params := &v3.QueryRangeParamsV3{
+		Start: start,
+		End:   end,
+		Step:  60,
+		CompositeQuery: &v3.CompositeQuery{
+			QueryType:   v3.QueryTypePromQL,
+			PanelType:   v3.PanelTypeGraph,
+			PromQueries: map[string]*v3.PromQuery{"A": {Query: "signoz_latency"}},
+		},
+	}
+	c := inmemory.New(&inmemory.Options{TTL: 60 * time.Minute, CleanupInterval: 10 * time.Minute})
+	cacheKey := queryBuilder.NewKeyGenerator().GenerateKeys(params)["A"]
+	if err := c.Store(cacheKey, []byte(`[{"labels": "corrupt"`), time.Hour); err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+
+	// nothing is fetched, so nothing replaces the corrupt entry
+	q := NewQuerier(QuerierOptions{
+		Cache:        c,
+		KeyGenerator: queryBuilder.NewKeyGenerator(),
+		FluxInterval: time.Minute,
+		NowFunc:      func() time.Time { return time.UnixMilli(end + time.Hour.Milliseconds()) },
+		TestingMode:  true,
+	})
+	if _, _, err := q.QueryRange(context.Background(), params, nil); err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+
+	timeRanges := q.TimeRanges()
+	if len(timeRanges) != 1 || int64(timeRanges[0][0]) != start || int64(timeRanges[0][1]) != end {
+		t.Errorf("expected the whole range [%d, %d] to be fetched, got %v", start, end, timeRanges)
+	}
+	if _, retrieveStatus, _ := c.Retrieve(cacheKey, true); retrieveStatus != status.RetrieveStatusKeyMiss {
+		t.Errorf("expected the corrupt entry to be deleted, got %s", retrieveStatus)
+	}
+}
+
+func TestQueryRangePercentOfTotal(t *testing.T) {
+	end := int64(1675115580000)
+	minute := time.Minute.Milliseconds()
+	t1, t2, t3 := end-3*minute, end-2*minute, end-minute
+	q := NewQuerier(QuerierOptions{
+		KeyGenerator:  queryBuilder.NewKeyGenerator(),
+		FeatureLookup: featureManager.StartManager(),
+		TestingMode:   true,
+		ReturnedSeries: []*v3.Series{
+			{
+				Labels: map[string]string{"service_name": "cart"},
+				Points: []v3.Point{{Timestamp: t1, Value: 30}, {Timestamp: t2, Value: 0}, {Timestamp: t3, Value: 5}},
+			},
+			{
+				Labels: map[string]string{"service_name": "checkout"},
+				Points: []v3.Point{{Timestamp: t1, Value: 70}, {Timestamp: t2, Value: 0}},
+			},
+			{
+				Labels: map[string]string{"service_name": "search"},
+				Points: []v3.Point{{Timestamp: t3, Value: 15}},
+			},
+		},
+	})
+	params := &v3.QueryRangeParamsV3{
+		Start: end - time.Hour.Milliseconds(),
+		End:   end,
+		Step:  60,
+		CompositeQuery: &v3.CompositeQuery{
+			QueryType: v3.QueryTypeBuilder,
+			PanelType: v3.PanelTypeGraph,
+			BuilderQueries: map[string]*v3.BuilderQuery{
+				"A": {
+					QueryName:          "A",
+					DataSource:         v3.DataSourceMetrics,
+					StepInterval:       60,
+					AggregateAttribute: v3.AttributeKey{Key: "signoz_calls_total", DataType: "float64", IsColumn: true},
+					AggregateOperator:  v3.AggregateOperatorSumRate,
+					GroupBy:            []v3.AttributeKey{{Key: "service_name", DataType: v3.AttributeKeyDataTypeString, Type: v3.AttributeKeyTypeTag}},
+					Expression:         "A",
+					PercentOfTotal:     true,
+				},
+			},
+		},
+	}
+	results, _, err := q.QueryRange(context.Background(), params, nil)
+	if err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+	if len(results) != 1 || len(results[0].Series) != 3 {
+		t.Fatalf("expected a result with 3 series, got %v", results)
+	}
+
+	expected := map[string]map[int64]float64{
+		"cart":     {t1: 30, t2: math.NaN(), t3: 25},
+		"checkout": {t1: 70, t2: math.NaN()},
+		"search":   {t3: 75},
+	}
+	sums := map[int64]float64{}
+	for _, series := range results[0].Series {
+		service := series.Labels["service_name"]
+		for _, point := range series.Points {
+			want := expected[service][point.Timestamp]
+			if math.IsNaN(want) {
+				if !point.Null {
+					t.Errorf("expected a null point for %s at %d with a zero total, got %+v", service, point.Timestamp, point)
+				}
+				data, err := json.Marshal(&point)
+				if err != nil {
+					t.Fatalf("expected no error, got %s", err)
+				}
+				if expected := fmt.Sprintf(`{"timestamp":%d,"value":null}`, point.Timestamp); string(data) != expected {
+					t.Errorf("expected the point encoded as %s, got %s", expected, data)
+				}
+				continue
+			}
+			if math.Abs(point.Value-want) > 1e-9 {
+				t.Errorf("expected %v for %s at %d, got %v", want, service, point.Timestamp, point.Value)
+			}
+			sums[point.Timestamp] += point.Value
+		}
+	}
+	for _, timestamp := range []int64{t1, t3} {
+		if math.Abs(sums[timestamp]-100) > 1e-9 {
+			t.Errorf("expected the percentages at %d to sum to 100, got %v", timestamp, sums[timestamp])
+		}
+	}
+}
+
+func TestQueryRangeMinStep(t *testing.T) {
+	end := int64(1675115580000)
+	// the queries run concurrently and merge their series, each gets its own series
+	reader := &mockReader{timeSeriesFn: func(query string) ([]*v3.Series, error) {
+		return []*v3.Series{
+			{
+				Labels: map[string]string{"service_name": "cart"},
+				Points: []v3.Point{{Timestamp: end - time.Minute.Milliseconds(), Value: 1}},
+			},
+		}, nil
+	}}
+	q := NewQuerier(QuerierOptions{
+		Reader:        reader,
+		KeyGenerator:  queryBuilder.NewKeyGenerator(),
+		FeatureLookup: featureManager.StartManager(),
+		MinStep:       time.Minute,
+		TestingMode:   true,
+	})
+	builderQuery := func(queryName string, step int64) *v3.BuilderQuery {
+		return &v3.BuilderQuery{
+			QueryName:          queryName,
+			DataSource:         v3.DataSourceMetrics,
+			StepInterval:       step,
+			AggregateAttribute: v3.AttributeKey{Key: "signoz_calls_total", DataType: "float64", IsColumn: true},
+			AggregateOperator:  v3.AggregateOperatorSumRate,
+			Expression:         queryName,
+		}
+	}
+	params := &v3.QueryRangeParamsV3{
+		Start: end - time.Hour.Milliseconds(),
+		End:   end,
+		Step:  10,
+		CompositeQuery: &v3.CompositeQuery{
+			QueryType: v3.QueryTypeBuilder,
+			PanelType: v3.PanelTypeGraph,
+			BuilderQueries: map[string]*v3.BuilderQuery{
+				"A": builderQuery("A", 10),
+				"B": builderQuery("B", 120),
+			},
+		},
+	}
+	results, _, err := q.QueryRange(context.Background(), params, nil)
+	if err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+	if params.CompositeQuery.BuilderQueries["A"].StepInterval != 10 {
+		t.Errorf("expected the step of the requested params to be left as is, got %d", params.CompositeQuery.BuilderQueries["A"].StepInterval)
+	}
+
+	expected := map[string]struct {
+		step  int64
+		notes []string
+	}{
+		"A": {step: 60, notes: []string{"the step of 10s was raised to the min step of 60s"}},
+		"B": {step: 120},
+	}
+	if len(results) != len(expected) {
+		t.Fatalf("expected %d results, got %d", len(expected), len(results))
+	}
+	for _, result := range results {
+		want := expected[result.QueryName]
+		if result.Step != want.step {
+			t.Errorf("expected step %d for query %s, got %d", want.step, result.QueryName, result.Step)
+		}
+		if !reflect.DeepEqual(result.Notes, want.notes) {
+			t.Errorf("expected notes %v for query %s, got %v", want.notes, result.QueryName, result.Notes)
+		}
+	}
+}
+
+func TestEnforceMinStepPromQL(t *testing.T) {
+	q := NewQuerier(QuerierOptions{MinStep: time.Minute, TestingMode: true}).(*querier)
+	params := &v3.QueryRangeParamsV3{
+		Step: 15,
+		CompositeQuery: &v3.CompositeQuery{
+			QueryType: v3.QueryTypePromQL,
+			PanelType: v3.PanelTypeGraph,
+			PromQueries: map[string]*v3.PromQuery{
+				"A": {Query: "up"},
+			},
+		},
+	}
+	notes := q.enforceMinStep(params)
+	if params.Step != 60 {
+		t.Errorf("expected the step to be floored to 60, got %d", params.Step)
+	}
+	if notes["A"] != "the step of 15s was raised to the min step of 60s" {
+		t.Errorf("expected a note for query A, got %v", notes)
+	}
+
+	params.Step = 300
+	if notes := q.enforceMinStep(params); len(notes) != 0 || params.Step != 300 {
+		t.Errorf("expected a step above the min step to be left as is, got step %d and notes %v", params.Step, notes)
+	}
+}
+
+func TestQueryRangeAnnotations(t *testing.T) {
+	end := int64(1675115580000)
+	start := end - time.Hour.Milliseconds()
+	reader := &mockReader{annotations: []v3.Annotation{
+		{ID: 1, Timestamp: start - time.Minute.Milliseconds(), Title: "deploy v1.0.0"},
+		{ID: 2, Timestamp: start, Title: "deploy v1.1.0"},
+		{ID: 3, Timestamp: end - time.Minute.Milliseconds(), Title: "deploy v1.2.0", Text: "rollback of the cart service"},
+		{ID: 4, Timestamp: end + time.Minute.Milliseconds(), Title: "deploy v1.3.0"},
+	}}
+	q := NewQuerier(QuerierOptions{
+		Reader:        reader,
+		KeyGenerator:  queryBuilder.NewKeyGenerator(),
+		FeatureLookup: featureManager.StartManager(),
+		TestingMode:   true,
+		ReturnedSeries: []*v3.Series{
+			{
+				Labels: map[string]string{"service_name": "cart"},
+				Points: []v3.Point{{Timestamp: end - time.Minute.Milliseconds(), Value: 1}},
+			},
+		},
+	})
+	params := &v3.QueryRangeParamsV3{
+		Start: start,
+		End:   end,
+		Step:  60,
+		CompositeQuery: &v3.CompositeQuery{
+			QueryType: v3.QueryTypeBuilder,
+			PanelType: v3.PanelTypeGraph,
+			BuilderQueries: map[string]*v3.BuilderQuery{
+				"A": {
+					QueryName:          "A",
+					DataSource:         v3.DataSourceMetrics,
+					StepInterval:       60,
+					AggregateAttribute: v3.AttributeKey{Key: "signoz_calls_total", DataType: "float64", IsColumn: true},
+					AggregateOperator:  v3.AggregateOperatorSumRate,
+					Expression:         "A",
+				},
+			},
+		},
+	}
+
+	results, _, err := q.QueryRange(context.Background(), params, nil)
+	if err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+	if results[0].Annotations != nil {
+		t.Errorf("expected no annotations when not requested, got %v", results[0].Annotations)
+	}
+
+	params.IncludeAnnotations = true
+	results, _, err = q.QueryRange(context.Background(), params, nil)
+	if err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+	expected := []v3.Annotation{reader.annotations[1], reader.annotations[2]}
+	if !reflect.DeepEqual(results[0].Annotations, expected) {
+		t.Errorf("expected the annotations within the window %v, got %v", expected, results[0].Annotations)
+	}
+}
+
+func TestPointIntValueJSONRoundTrip(t *testing.T) {
+	const exact = int64(1700000000000000123)
+	testCases := []struct {
+		name       string
+		intEncoded bool
+		expected   string
+	}{
+		{name: "string", expected: `{"timestamp":1675115580000,"value":"1700000000000000123"}`},
+		{name: "int64", intEncoded: true, expected: `{"timestamp":1675115580000,"value":1700000000000000123}`},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			point := v3.Point{Timestamp: 1675115580000, IntEncoded: tc.intEncoded}
+			point.SetIntValue(exact)
+			data, err := json.Marshal(&point)
+			if err != nil {
+				t.Fatalf("expected no error, got %s", err)
+			}
+			if string(data) != tc.expected {
+				t.Errorf("expected %s, got %s", tc.expected, data)
+			}
+			var decoded v3.Point
+			if err := json.Unmarshal(data, &decoded); err != nil {
+				t.Fatalf("expected no error, got %s", err)
+			}
+			if decoded.IntValue == nil || *decoded.IntValue != exact {
+				t.Errorf("expected the exact value %d, got %v", exact, decoded.IntValue)
+			}
+			if decoded.IntEncoded != tc.intEncoded {
+				t.Errorf("expected int encoded %v, got %v", tc.intEncoded, decoded.IntEncoded)
+			}
+		})
+	}
+
+	// the values a float64 represents exactly are not kept as integers
+	for _, value := range []string{`"42"`, `"1.5"`, `"NaN"`} {
+		var point v3.Point
+		if err := json.Unmarshal([]byte(`{"timestamp":1675115580000,"value":`+value+`}`), &point); err != nil {
+			t.Fatalf("expected no error for %s, got %s", value, err)
+		}
+		if point.IntValue != nil {
+			t.Errorf("expected no exact value for %s, got %d", value, *point.IntValue)
+		}
+	}
+}
+
+func TestQueryRangeValueEncodingInt64(t *testing.T) {
+	const exact = int64(1700000000000000123)
+	end := int64(1675115580000)
+	point := v3.Point{Timestamp: end - time.Minute.Milliseconds()}
+	point.SetIntValue(exact)
+	reader := &mockReader{timeSeriesFn: func(query string) ([]*v3.Series, error) {
+		return []*v3.Series{{
+			Labels: map[string]string{"service_name": "cart"},
+			Points: []v3.Point{point, {Timestamp: end, Value: 7}},
+		}}, nil
+	}}
+	c := inmemory.New(&inmemory.Options{TTL: 60 * time.Minute, CleanupInterval: 10 * time.Minute})
+	q := NewQuerier(QuerierOptions{
+		Reader:        reader,
+		Cache:         c,
+		KeyGenerator:  queryBuilder.NewKeyGenerator(),
+		FeatureLookup: featureManager.StartManager(),
+	})
+	params := &v3.QueryRangeParamsV3{
+		Start: end - time.Hour.Milliseconds(),
+		End:   end,
+		Step:  60,
+		CompositeQuery: &v3.CompositeQuery{
+			QueryType: v3.QueryTypeBuilder,
+			PanelType: v3.PanelTypeGraph,
+			BuilderQueries: map[string]*v3.BuilderQuery{
+				"A": {
+					QueryName:         "A",
+					StepInterval:      60,
+					DataSource:        v3.DataSourceLogs,
+					AggregateOperator: v3.AggregateOperatorCount,
+					Expression:        "A",
+					ValueEncoding:     v3.ValueEncodingInt64,
+				},
+			},
+		},
+	}
+
+	// the second run is served from the cache
+	for run := 0; run < 2; run++ {
+		results, _, err := q.QueryRange(context.Background(), params, nil)
+		if err != nil {
+			t.Fatalf("expected no error, got %s", err)
+		}
+		if len(results) != 1 || len(results[0].Series) != 1 {
+			t.Fatalf("expected a result with one series, got %v", results)
+		}
+		data, err := json.Marshal(results[0].Series[0].Points)
+		if err != nil {
+			t.Fatalf("expected no error, got %s", err)
+		}
+		expected := fmt.Sprintf(`[{"timestamp":%d,"value":%d},{"timestamp":%d,"value":7}]`, point.Timestamp, exact, end)
+		if string(data) != expected {
+			t.Errorf("run %d: expected %s, got %s", run, expected, data)
+		}
+	}
+
+	// the cached points keep the exact value
+	data, retrieveStatus, err := c.Retrieve(queryBuilder.NewKeyGenerator().GenerateKeys(params)["A"], true)
+	if err != nil || retrieveStatus != status.RetrieveStatusHit {
+		t.Fatalf("expected a cache hit, got %s, %v", retrieveStatus, err)
+	}
+	var cachedSeries []*v3.Series
+	if err := json.Unmarshal(data, &cachedSeries); err != nil {
+		t.Fatalf("error unmarshalling cached series: %s", err)
+	}
+	cachedPoint := cachedSeries[0].Points[0]
+	if cachedPoint.IntValue == nil || *cachedPoint.IntValue != exact {
+		t.Errorf("expected the cached value %d, got %v", exact, cachedPoint.IntValue)
+	}
+}
+
+func TestQueryRangeContradictoryFilters(t *testing.T) {
+	end := int64(1675115580000)
+	reader := &mockReader{
+		timeSeriesFn: func(query string) ([]*v3.Series, error) {
+			t.Errorf("expected the contradictory query not to be run, got %s", query)
+			return nil, nil
+		},
+		listFn: func(query string) ([]*v3.Row, error) {
+			t.Errorf("expected the contradictory list query not to be run, got %s", query)
+			return nil, nil
+		},
+	}
+	q := NewQuerier(QuerierOptions{
+		Reader:        reader,
+		KeyGenerator:  queryBuilder.NewKeyGenerator(),
+		FeatureLookup: featureManager.StartManager(),
+	})
+	service := v3.AttributeKey{Key: "service.name", DataType: v3.AttributeKeyDataTypeString, Type: v3.AttributeKeyTypeResource}
+	filters := &v3.FilterSet{Operator: "AND", Items: []v3.FilterItem{
+		{Key: service, Value: "cart", Operator: v3.FilterOperatorEqual},
+		{Key: service, Value: "checkout", Operator: v3.FilterOperatorEqual},
+	}}
+
+	for _, panelType := range []v3.PanelType{v3.PanelTypeGraph, v3.PanelTypeList} {
+		t.Run(string(panelType), func(t *testing.T) {
+			params := &v3.QueryRangeParamsV3{
+				Start: end - time.Hour.Milliseconds(),
+				End:   end,
+				Step:  60,
+				CompositeQuery: &v3.CompositeQuery{
+					QueryType: v3.QueryTypeBuilder,
+					PanelType: panelType,
+					BuilderQueries: map[string]*v3.BuilderQuery{
+						"A": {
+							QueryName:         "A",
+							StepInterval:      60,
+							DataSource:        v3.DataSourceLogs,
+							AggregateOperator: v3.AggregateOperatorCount,
+							Filters:           filters,
+							Expression:        "A",
+							PageSize:          10,
+						},
+					},
+				},
+			}
+			results, _, err := q.QueryRange(context.Background(), params, nil)
+			if err != nil {
+				t.Fatalf("expected no error, got %s", err)
+			}
+			if len(results) != 1 || results[0].QueryName != "A" {
+				t.Fatalf("expected a result for query A, got %v", results)
+			}
+			if len(results[0].Series) != 0 || len(results[0].List) != 0 {
+				t.Errorf("expected an empty result, got %v", results[0])
+			}
+		})
+	}
+}
+
+func TestQueryRangeTrailingWindow(t *testing.T) {
+	start := int64(1675115580000)
+	minute := time.Minute.Milliseconds()
+	q := NewQuerier(QuerierOptions{
+		KeyGenerator:  queryBuilder.NewKeyGenerator(),
+		FeatureLookup: featureManager.StartManager(),
+		TestingMode:   true,
+		ReturnedSeries: []*v3.Series{
+			{
+				Labels: map[string]string{"service_name": "cart"},
+				Points: []v3.Point{
+					{Timestamp: start - 4*minute, Value: 1},
+					{Timestamp: start - minute, Value: 2},
+					{Timestamp: start, Value: 3},
+					{Timestamp: start + 2*minute, Value: 4},
+					{Timestamp: start + 9*minute, Value: 5},
+				},
+			},
+		},
+	}).(*querier)
+	params := &v3.QueryRangeParamsV3{
+		Start: start,
+		End:   start + 10*minute,
+		Step:  60,
+		CompositeQuery: &v3.CompositeQuery{
+			QueryType: v3.QueryTypeBuilder,
+			PanelType: v3.PanelTypeGraph,
+			BuilderQueries: map[string]*v3.BuilderQuery{
+				"A": {
+					QueryName:         "A",
+					StepInterval:      60,
+					DataSource:        v3.DataSourceLogs,
+					AggregateOperator: v3.AggregateOperatorCount,
+					Expression:        "A",
+					TrailingWindow:    300,
+				},
+			},
+		},
+	}
+	results, _, err := q.QueryRange(context.Background(), params, nil)
+	if err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+
+	// the step intervals of the window of the first point are fetched
+	queries := q.QueriesExecuted()
+	lookBack := fmt.Sprintf("timestamp >= %d", (start-4*minute)*int64(time.Millisecond))
+	if len(queries) != 1 || !strings.Contains(queries[0], lookBack) {
+		t.Errorf("expected the query to filter %s, got %v", lookBack, queries)
+	}
+
+	if len(results) != 1 || len(results[0].Series) != 1 {
+		t.Fatalf("expected a result with one series, got %v", results)
+	}
+	expected := []v3.Point{
+		{Timestamp: start, Value: 6},
+		{Timestamp: start + minute, Value: 5},
+		{Timestamp: start + 2*minute, Value: 9},
+		{Timestamp: start + 3*minute, Value: 9},
+		{Timestamp: start + 4*minute, Value: 7},
+		{Timestamp: start + 5*minute, Value: 4},
+		{Timestamp: start + 6*minute, Value: 4},
+		{Timestamp: start + 9*minute, Value: 5},
+		{Timestamp: start + 10*minute, Value: 5},
+	}
+	if !reflect.DeepEqual(results[0].Series[0].Points, expected) {
+		t.Errorf("expected the trailing window points %v, got %v", expected, results[0].Series[0].Points)
+	}
+}
+
+func TestTrailingWindowPointsMax(t *testing.T) {
+	minute := time.Minute.Milliseconds()
+	builderQuery := &v3.BuilderQuery{StepInterval: 60, TrailingWindow: 180, AggregateOperator: v3.AggregateOperatorMax}
+	points := []v3.Point{
+		{Timestamp: 0, Value: 8},
+		{Timestamp: minute, Value: math.NaN()},
+		{Timestamp: 2 * minute, Value: 3},
+		{Timestamp: 3 * minute, Value: 1},
+	}
+	expected := []v3.Point{
+		{Timestamp: 2 * minute, Value: 8},
+		{Timestamp: 3 * minute, Value: 3},
+		{Timestamp: 4 * minute, Value: 3},
+	}
+	got := trailingWindowPoints(points, builderQuery, 2*minute, 4*minute)
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+}
+
+func TestQueryRangeUnits(t *testing.T) {
+	end := int64(1675115580000)
+	reader := &mockReader{metricUnits: map[string]string{
+		"system_memory_usage":  "By",
+		"http_server_duration": "ms",
+	}}
+	q := NewQuerier(QuerierOptions{
+		Reader:        reader,
+		KeyGenerator:  queryBuilder.NewKeyGenerator(),
+		FeatureLookup: featureManager.StartManager(),
+		TestingMode:   true,
+		ReturnedSeries: []*v3.Series{
+			{
+				Labels: map[string]string{"host_name": "host-1"},
+				Points: []v3.Point{{Timestamp: end - time.Minute.Milliseconds(), Value: 1}},
+			},
+		},
+	})
+	metricsQuery := func(queryName, metricName string, operator v3.AggregateOperator) *v3.BuilderQuery {
+		return &v3.BuilderQuery{
+			QueryName:          queryName,
+			DataSource:         v3.DataSourceMetrics,
+			StepInterval:       60,
+			AggregateAttribute: v3.AttributeKey{Key: metricName, DataType: "float64", IsColumn: true},
+			AggregateOperator:  operator,
+			Expression:         queryName,
+		}
+	}
+	params := &v3.QueryRangeParamsV3{
+		Start: end - time.Hour.Milliseconds(),
+		End:   end,
+		Step:  60,
+		CompositeQuery: &v3.CompositeQuery{
+			QueryType: v3.QueryTypeBuilder,
+			PanelType: v3.PanelTypeGraph,
+			BuilderQueries: map[string]*v3.BuilderQuery{
+				"A": metricsQuery("A", "system_memory_usage", v3.AggregateOperatorAvg),
+				"B": metricsQuery("B", "system_memory_usage", v3.AggregateOperatorMax),
+				"C": metricsQuery("C", "http_server_duration", v3.AggregateOperatorCount),
+			},
+		},
+	}
+
+	results, _, err := q.QueryRange(context.Background(), params, nil)
+	if err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+	for _, result := range results {
+		if result.Unit != "" {
+			t.Errorf("expected no unit when not requested, got %q for query %s", result.Unit, result.QueryName)
+		}
+	}
+	if len(reader.metadataFetches) != 0 {
+		t.Errorf("expected no metadata fetched when not requested, got %v", reader.metadataFetches)
+	}
+
+	params.IncludeUnits = true
+	results, _, err = q.QueryRange(context.Background(), params, nil)
+	if err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+	// the count of the samples has no unit
+	expected := map[string]string{"A": "By", "B": "By", "C": ""}
+	if len(results) != len(expected) {
+		t.Fatalf("expected %d results, got %d", len(expected), len(results))
+	}
+	for _, result := range results {
+		if result.Unit != expected[result.QueryName] {
+			t.Errorf("expected unit %q for query %s, got %q", expected[result.QueryName], result.QueryName, result.Unit)
+		}
+	}
+	if !reflect.DeepEqual(reader.metadataFetches, []string{"system_memory_usage"}) {
+		t.Errorf("expected the metadata of system_memory_usage to be fetched once, got %v", reader.metadataFetches)
+	}
+}
+
+func TestQueryRangeCompleteness(t *testing.T) {
+	end := int64(1675115580000)
+	minute := time.Minute.Milliseconds()
+	// the hour ending at end has 60 steps of a minute
+	start := end - 59*minute
+	var gappy, complete []v3.Point
+	for ts := start; ts <= end; ts += minute {
+		complete = append(complete, v3.Point{Timestamp: ts, Value: 1})
+		switch step := (ts - start) / minute; {
+		case step >= 20 && step < 35:
+			// a gap of 15 steps
+		case step == 40:
+			// a NaN is no value, the duplicate doesn't count twice
+			gappy = append(gappy, v3.Point{Timestamp: ts, Value: math.NaN()}, v3.Point{Timestamp: ts, Value: 1})
+		default:
+			gappy = append(gappy, v3.Point{Timestamp: ts, Value: 1})
+		}
+	}
+	reader := &mockReader{
+		timeSeriesFn: func(query string) ([]*v3.Series, error) {
+			return []*v3.Series{
+				{Labels: map[string]string{"host_name": "host-1"}, Points: gappy},
+				{Labels: map[string]string{"host_name": "host-2"}, Points: complete},
+			}, nil
+		},
+	}
+	q := NewQuerier(QuerierOptions{
+		Reader:        reader,
+		KeyGenerator:  queryBuilder.NewKeyGenerator(),
+		FeatureLookup: featureManager.StartManager(),
+		TestingMode:   true,
+	})
+	params := &v3.QueryRangeParamsV3{
+		Start: start,
+		End:   end,
+		Step:  60,
+		CompositeQuery: &v3.CompositeQuery{
+			QueryType: v3.QueryTypeBuilder,
+			PanelType: v3.PanelTypeGraph,
+			BuilderQueries: map[string]*v3.BuilderQuery{
+				"A": {
+					QueryName:          "A",
+					DataSource:         v3.DataSourceMetrics,
+					StepInterval:       60,
+					AggregateAttribute: v3.AttributeKey{Key: "system_memory_usage", DataType: "float64", IsColumn: true},
+					AggregateOperator:  v3.AggregateOperatorAvg,
+					Expression:         "A",
+				},
+			},
+		},
+	}
+
+	results, _, err := q.QueryRange(context.Background(), params, nil)
+	if err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+	for _, series := range results[0].Series {
+		if series.Completeness != nil {
+			t.Errorf("expected no completeness when not requested, got %f for %v", *series.Completeness, series.Labels)
+		}
+	}
+
+	params.IncludeCompleteness = true
+	results, _, err = q.QueryRange(context.Background(), params, nil)
+	if err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+	expected := map[string]float64{"host-1": 0.75, "host-2": 1}
+	if len(results) != 1 || len(results[0].Series) != len(expected) {
+		t.Fatalf("expected 1 result with %d series, got %d results", len(expected), len(results))
+	}
+	for _, series := range results[0].Series {
+		host := series.Labels["host_name"]
+		if series.Completeness == nil {
+			t.Errorf("expected the completeness of %s, got none", host)
+			continue
+		}
+		if *series.Completeness != expected[host] {
+			t.Errorf("expected completeness %f for %s, got %f", expected[host], host, *series.Completeness)
+		}
+	}
+}
+
+func TestQueryRangeMarkGaps(t *testing.T) {
+	end := int64(1675115580000)
+	minute := time.Minute.Milliseconds()
+	start := end - 29*minute
+	// no values at the first two steps, at the steps 10 to 12 and a NaN at the step 20
+	var points []v3.Point
+	for step := int64(2); step < 30; step++ {
+		switch {
+		case step >= 10 && step <= 12:
+		case step == 20:
+			points = append(points, v3.Point{Timestamp: start + step*minute, Value: math.NaN()})
+		default:
+			points = append(points, v3.Point{Timestamp: start + step*minute, Value: float64(step)})
+		}
+	}
+	reader := &mockReader{
+		timeSeriesFn: func(query string) ([]*v3.Series, error) {
+			return []*v3.Series{{Labels: map[string]string{"host_name": "host-1"}, Points: points}}, nil
+		},
+	}
+	q := NewQuerier(QuerierOptions{
+		Reader:        reader,
+		KeyGenerator:  queryBuilder.NewKeyGenerator(),
+		FeatureLookup: featureManager.StartManager(),
+		TestingMode:   true,
+	})
+	params := &v3.QueryRangeParamsV3{
+		Start:    start,
+		End:      end,
+		Step:     60,
+		MarkGaps: true,
+		CompositeQuery: &v3.CompositeQuery{
+			QueryType: v3.QueryTypeBuilder,
+			PanelType: v3.PanelTypeGraph,
+			BuilderQueries: map[string]*v3.BuilderQuery{
+				"A": {
+					QueryName:          "A",
+					DataSource:         v3.DataSourceMetrics,
+					StepInterval:       60,
+					AggregateAttribute: v3.AttributeKey{Key: "system_memory_usage", DataType: "float64", IsColumn: true},
+					AggregateOperator:  v3.AggregateOperatorAvg,
+					Expression:         "A",
+				},
+			},
+		},
+	}
+
+	results, _, err := q.QueryRange(context.Background(), params, nil)
+	if err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+	if len(results) != 1 || len(results[0].Series) != 1 {
+		t.Fatalf("expected 1 result with 1 series, got %d results", len(results))
+	}
+	marked := results[0].Series[0].Points
+	if len(marked) != 30 {
+		t.Fatalf("expected a point at each of the 30 steps, got %d points", len(marked))
+	}
+	var nulls []int64
+	for idx, point := range marked {
+		if point.Timestamp != start+int64(idx)*minute {
+			t.Fatalf("expected the point %d at %d, got %d", idx, start+int64(idx)*minute, point.Timestamp)
+		}
+		if point.Null {
+			nulls = append(nulls, int64(idx))
+		}
+	}
+	if expected := []int64{0, 1, 10, 11, 12, 20}; !reflect.DeepEqual(nulls, expected) {
+		t.Errorf("expected null markers at the steps %v, got %v", expected, nulls)
+	}
+	// the gap markers are no data
+	if results[0].MinTimestamp != start+2*minute {
+		t.Errorf("expected the min timestamp %d, got %d", start+2*minute, results[0].MinTimestamp)
+	}
+
+	data, err := json.Marshal(&marked[0])
+	if err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+	if expected := fmt.Sprintf(`{"timestamp":%d,"value":null}`, start); string(data) != expected {
+		t.Errorf("expected the marker encoded as %s, got %s", expected, data)
+	}
+	var decoded v3.Point
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+	if !decoded.Null || !math.IsNaN(decoded.Value) {
+		t.Errorf("expected a null point with a NaN value, got %+v", decoded)
+	}
+}
+
+func TestQueryRangeMarkGapsWithSampleToAndBand(t *testing.T) {
+	end := int64(1675115580000)
+	minute := time.Minute.Milliseconds()
+	start := end - 29*minute
+	// no values at the steps 10 to 12
+	series := &v3.Series{Labels: map[string]string{"service_name": "cart"}}
+	for step := int64(0); step < 30; step++ {
+		if step >= 10 && step <= 12 {
+			continue
+		}
+		series.Points = append(series.Points, v3.Point{Timestamp: start + step*minute, Value: float64(step)})
+		series.Counts = append(series.Counts, step*10)
+		series.BandMin = append(series.BandMin, float64(step)-0.5)
+		series.BandMax = append(series.BandMax, float64(step)+0.5)
+	}
+	reader := &mockReader{timeSeriesFn: func(query string) ([]*v3.Series, error) {
+		return []*v3.Series{series}, nil
+	}}
+	q := NewQuerier(QuerierOptions{
+		Reader:        reader,
+		KeyGenerator:  queryBuilder.NewKeyGenerator(),
+		FeatureLookup: featureManager.StartManager(),
+		TestingMode:   true,
+	})
+	params := &v3.QueryRangeParamsV3{
+		Start:    start,
+		End:      end,
+		Step:     60,
+		MarkGaps: true,
+		CompositeQuery: &v3.CompositeQuery{
+			QueryType: v3.QueryTypeBuilder,
+			PanelType: v3.PanelTypeGraph,
+			BuilderQueries: map[string]*v3.BuilderQuery{
+				"A": {
+					QueryName:          "A",
+					DataSource:         v3.DataSourceLogs,
+					StepInterval:       60,
+					AggregateAttribute: v3.AttributeKey{Key: "duration", DataType: "float64", IsColumn: true},
+					AggregateOperator:  v3.AggregateOperatorAvg,
+					Expression:         "A",
+					IncludeCount:       true,
+					IncludeBand:        true,
+				},
+			},
+		},
+	}
+
+	results, _, err := q.QueryRange(context.Background(), params, nil)
+	if err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+	marked := results[0].Series[0]
+	if len(marked.Points) != 30 || len(marked.Counts) != 30 || len(marked.BandMin) != 30 || len(marked.BandMax) != 30 {
+		t.Fatalf("expected 30 points, counts and band entries, got %d, %d, %d and %d",
+			len(marked.Points), len(marked.Counts), len(marked.BandMin), len(marked.BandMax))
+	}
+	for idx, point := range marked.Points {
+		step := int64(idx)
+		if gap := step >= 10 && step <= 12; gap != point.Null {
+			t.Errorf("expected the point at the step %d to be null %t, got %+v", step, gap, point)
+		}
+		// the gap markers aggregate no rows
+		if !point.Null && (marked.Counts[idx] != step*10 || marked.BandMin[idx] != float64(step)-0.5) {
+			t.Errorf("expected the count and the band of the step %d aligned, got %d and %v", step, marked.Counts[idx], marked.BandMin[idx])
+		}
+		if point.Null && (marked.Counts[idx] != 0 || marked.BandMin[idx] != 0 || marked.BandMax[idx] != 0) {
+			t.Errorf("expected a zero count and band at the gap step %d, got %d, %v and %v", step, marked.Counts[idx], marked.BandMin[idx], marked.BandMax[idx])
+		}
+	}
+
+	// the gaps are marked at the step of the query, the sampled series are not expanded
+	params.SampleTo = 10
+	results, _, err = q.QueryRange(context.Background(), params, nil)
+	if err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+	sampled := results[0].Series[0]
+	var steps []int64
+	for idx, point := range sampled.Points {
+		step := (point.Timestamp - start) / minute
+		steps = append(steps, step)
+		if sampled.Counts[idx] != step*10 || sampled.BandMax[idx] != float64(step)+0.5 {
+			t.Errorf("expected the count and the band of the step %d aligned, got %d and %v", step, sampled.Counts[idx], sampled.BandMax[idx])
+		}
+	}
+	// the gap markers are left out of the extremes of the buckets
+	if expected := []int64{0, 5, 6, 9, 13, 17, 18, 23, 24, 29}; !reflect.DeepEqual(steps, expected) {
+		t.Errorf("expected the points at the steps %v, got %v", expected, steps)
+	}
+	if len(sampled.Counts) != len(sampled.Points) || len(sampled.BandMin) != len(sampled.Points) {
+		t.Errorf("expected the counts and the band aligned with the %d points, got %d and %d", len(sampled.Points), len(sampled.Counts), len(sampled.BandMin))
+	}
+}
+
+func TestRenderLegendFormat(t *testing.T) {
+	labels := map[string]string{"service.name": "frontend", "http.route": "/api/v1/orders"}
+	cases := []struct {
+		format   string
+		expected string
+	}{
+		{"{service.name} / {http.route}", "frontend / /api/v1/orders"},
+		{"{service.name} - {http.method}", "frontend - "},
+		{"{ service.name }", "frontend"},
+		{"orders", "orders"},
+		{"{service.name} {http.route", "frontend {http.route"},
+	}
+	for _, c := range cases {
+		if name := renderLegendFormat(c.format, labels); name != c.expected {
+			t.Errorf("expected %q rendered as %q, got %q", c.format, c.expected, name)
+		}
+	}
+}
+
+func TestQueryRangeLegendFormat(t *testing.T) {
+	end := int64(1675115580000)
+	start := end - 10*time.Minute.Milliseconds()
+	reader := &mockReader{
+		timeSeriesFn: func(query string) ([]*v3.Series, error) {
+			return []*v3.Series{
+				{Labels: map[string]string{"service.name": "frontend", "http.route": "/orders"}, Points: []v3.Point{{Timestamp: start, Value: 1}}},
+				{Labels: map[string]string{"service.name": "cart"}, Points: []v3.Point{{Timestamp: start, Value: 2}}},
+			}, nil
+		},
+	}
+	q := NewQuerier(QuerierOptions{
+		Reader:        reader,
+		KeyGenerator:  queryBuilder.NewKeyGenerator(),
+		FeatureLookup: featureManager.StartManager(),
+		TestingMode:   true,
+	})
+	params := &v3.QueryRangeParamsV3{
+		Start: start,
+		End:   end,
+		Step:  60,
+		CompositeQuery: &v3.CompositeQuery{
+			QueryType: v3.QueryTypeBuilder,
+			PanelType: v3.PanelTypeGraph,
+			BuilderQueries: map[string]*v3.BuilderQuery{
+				"A": {
+					QueryName:          "A",
+					DataSource:         v3.DataSourceMetrics,
+					StepInterval:       60,
+					AggregateAttribute: v3.AttributeKey{Key: "signoz_calls_total", DataType: "float64", IsColumn: true},
+					AggregateOperator:  v3.AggregateOperatorSumRate,
+					GroupBy:            []v3.AttributeKey{{Key: "service.name"}, {Key: "http.route"}},
+					Expression:         "A",
+					LegendFormat:       "{service.name} / {http.route}",
+				},
+			},
+		},
+	}
+
+	results, _, err := q.QueryRange(context.Background(), params, nil)
+	if err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+	if len(results) != 1 || len(results[0].Series) != 2 {
+		t.Fatalf("expected 1 result with 2 series, got %d results", len(results))
+	}
+	names := map[string]bool{}
+	for _, series := range results[0].Series {
+		names[series.Name] = true
+	}
+	for _, expected := range []string{"frontend / /orders", "cart / "} {
+		if !names[expected] {
+			t.Errorf("expected a series named %q, got %v", expected, names)
+		}
+	}
+}
+
+func TestQueryRangeSharedTimeIndex(t *testing.T) {
+	end := int64(1675115580000)
+	minute := time.Minute.Milliseconds()
+	c := inmemory.New(&inmemory.Options{TTL: 60 * time.Minute, CleanupInterval: 10 * time.Minute})
+	q := NewQuerier(QuerierOptions{
+		Cache:         c,
+		KeyGenerator:  queryBuilder.NewKeyGenerator(),
+		FeatureLookup: featureManager.StartManager(),
+		FluxInterval:  5 * time.Minute,
+		NowFunc:       func() time.Time { return time.UnixMilli(end) },
+		TestingMode:   true,
+		ReturnedSeries: []*v3.Series{
+			{
+				Labels: map[string]string{"service_name": "cart"},
+				Points: []v3.Point{{Timestamp: end - 30*minute, Value: 1}, {Timestamp: end - 10*minute, Value: 2}},
+			},
+		},
+	})
+	logsQuery := func(queryName, service string) *v3.BuilderQuery {
+		return &v3.BuilderQuery{
+			QueryName:         queryName,
+			StepInterval:      60,
+			DataSource:        v3.DataSourceLogs,
+			AggregateOperator: v3.AggregateOperatorCount,
+			Filters: &v3.FilterSet{Operator: "AND", Items: []v3.FilterItem{
+				{Key: v3.AttributeKey{Key: "service.name", DataType: v3.AttributeKeyDataTypeString, Type: v3.AttributeKeyTypeResource}, Value: service, Operator: v3.FilterOperatorEqual},
+			}},
+			Expression: queryName,
+		}
+	}
+	params := &v3.QueryRangeParamsV3{
+		Start: end - time.Hour.Milliseconds(),
+		End:   end,
+		Step:  60,
+		CompositeQuery: &v3.CompositeQuery{
+			QueryType: v3.QueryTypeBuilder,
+			PanelType: v3.PanelTypeGraph,
+			BuilderQueries: map[string]*v3.BuilderQuery{
+				"A": logsQuery("A", "cart"),
+				"B": logsQuery("B", "checkout"),
+			},
+		},
+	}
+
+	// the first request caches the series of both queries
+	if _, _, err := q.QueryRange(context.Background(), params, nil); err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+
+	// the queries are over the same window and their cached points span the same
+	// range, so their misses are computed once
+	index := newTimeIndex(time.UnixMilli(end))
+	results, _, err := q.QueryRange(withTimeIndex(context.Background(), index), params, nil)
+	if err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("expected 2 results, got %d", len(results))
+	}
+	if index.computations != 1 {
+		t.Errorf("expected the misses to be computed once, got %d computations", index.computations)
+	}
+
+	// the misses are the ones computed without the index
+	cachedSeries := []*v3.Series{{Points: []v3.Point{{Timestamp: end - 30*minute}, {Timestamp: end - 10*minute}}}}
+	expected, _ := findMissingTimeRanges(params.Start, params.End, 60, 0, cachedSeries, 5*time.Minute, time.UnixMilli(end))
+	cachedStart, cachedEnd := common.CachedSpan(cachedSeries)
+	misses, _ := index.misses(timeIndexKey{
+		start: params.Start, end: params.End, step: 60, fluxInterval: 5 * time.Minute,
+		cachedStart: cachedStart, cachedEnd: cachedEnd,
+	})
+	if !reflect.DeepEqual(misses, expected) {
+		t.Errorf("expected the shared misses %v, got %v", expected, misses)
+	}
+	if index.computations != 1 {
+		t.Errorf("expected the misses of the window to be looked up, got %d computations", index.computations)
+	}
+}
+
+func TestQueryRangeSpanTree(t *testing.T) {
+	end := int64(1675115580000)
+	span := func(traceID, spanID, parentSpanID string) *v3.Row {
+		// the values are read from clickhouse as pointers
+		return &v3.Row{Data: map[string]interface{}{"traceID": &traceID, "spanID": &spanID, "parentSpanID": &parentSpanID}}
+	}
+	rows := []*v3.Row{
+		span("t1", "root", ""),
+		span("t1", "db", "api"),
+		span("t1", "api", "root"),
+		span("t1", "cache", "api"),
+		// the parent of the span was not returned
+		span("t1", "orphan", "missing"),
+		// the same span id in another trace
+		span("t2", "root", ""),
+		span("t2", "api", "root"),
+		// spans whose parents loop
+		span("t3", "a", "b"),
+		span("t3", "b", "a"),
+	}
+	reader := &mockReader{listFn: func(query string) ([]*v3.Row, error) {
+		return rows, nil
+	}}
+	q := NewQuerier(QuerierOptions{
+		Reader:        reader,
+		KeyGenerator:  queryBuilder.NewKeyGenerator(),
+		FeatureLookup: featureManager.StartManager(),
+	})
+	params := &v3.QueryRangeParamsV3{
+		Start: end - time.Hour.Milliseconds(),
+		End:   end,
+		CompositeQuery: &v3.CompositeQuery{
+			QueryType: v3.QueryTypeBuilder,
+			PanelType: v3.PanelTypeTrace,
+			BuilderQueries: map[string]*v3.BuilderQuery{
+				"A": {
+					QueryName:         "A",
+					StepInterval:      60,
+					DataSource:        v3.DataSourceTraces,
+					AggregateOperator: v3.AggregateOperatorNoOp,
+					Expression:        "A",
+					SpanTree:          true,
+				},
+			},
+		},
+	}
+	results, _, err := q.QueryRange(context.Background(), params, nil)
+	if err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+	if len(results) != 1 {
+		t.Fatalf("expected one result, got %d", len(results))
+	}
+	if results[0].List != nil {
+		t.Errorf("expected the trees in place of the list, got %v", results[0].List)
+	}
+
+	// describe renders the tree as the span ids with their children in parentheses
+	var describe func(nodes []*v3.SpanNode) string
+	describe = func(nodes []*v3.SpanNode) string {
+		parts := make([]string, 0, len(nodes))
+		for _, node := range nodes {
+			part := rowField(node.Span, "traceID") + "/" + rowField(node.Span, "spanID")
+			if len(node.Children) > 0 {
+				part += "(" + describe(node.Children) + ")"
+			}
+			parts = append(parts, part)
+		}
+		return strings.Join(parts, " ")
+	}
+	expected := "t1/root(t1/api(t1/db t1/cache)) t1/orphan t2/root(t2/api) t3/a(t3/b)"
+	if got := describe(results[0].SpanTrees); got != expected {
+		t.Errorf("expected the span trees %s, got %s", expected, got)
+	}
+}
@@ -0,0 +1,63 @@
+package querier
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/ClickHouse/clickhouse-go/v2"
+	"go.signoz.io/signoz/pkg/query-service/model"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultRateLimitBackoff    = 100 * time.Millisecond
+	defaultRateLimitMaxBackoff = 2 * time.Second
+	defaultRateLimitMaxRetries = 5
+
+	// codeTooManySimultaneousQueries is the clickhouse error code returned when
+	// the max_concurrent_queries limit is reached
+	codeTooManySimultaneousQueries = 202
+)
+
+// isRateLimitError returns true if the reader rejected the query because
+// too many queries are already running
+func isRateLimitError(err error) bool {
+	// the prom queries return the error of the engine in an api error
+	var apiErr *model.ApiError
+	if errors.As(err, &apiErr) && apiErr.Err != nil {
+		err = apiErr.Err
+	}
+	var exception *clickhouse.Exception
+	if errors.As(err, &exception) {
+		return exception.Code == codeTooManySimultaneousQueries
+	}
+	return errors.Is(err, clickhouse.ErrAcquireConnTimeout)
+}
+
+// withRateLimitBackoff calls fn and retries it with an exponential backoff as long
+// as it is rate limited, up to the max retries. It gives up with the last error when
+// the next wait would exceed the context deadline
+func (q *querier) withRateLimitBackoff(ctx context.Context, fn func() error) error {
+	backoff := q.rateLimitBackoff
+	for retry := 0; ; retry++ {
+		err := fn()
+		if err == nil || !isRateLimitError(err) || retry >= q.rateLimitMaxRetries {
+			return err
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < backoff {
+			return err
+		}
+
+		zap.L().Warn("query rate limited, retrying", zap.Duration("backoff", backoff), zap.Int("retry", retry+1), zap.Error(err))
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > q.rateLimitMaxBackoff {
+			backoff = q.rateLimitMaxBackoff
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package querier
+
+import (
+	"math"
+
+	v3 "go.signoz.io/signoz/pkg/query-service/model/v3"
+)
+
+// computeRatios appends the result of each ratio to the results. The series of
+// the numerator are divided by the series of the denominator with the same
+// labels, at the timestamps both have a point. The series without a match in
+// the other query are left out. The points where the denominator is zero, or
+// the quotient is otherwise not finite, are null points
+func computeRatios(results []*v3.Result, ratios []*v3.Ratio) []*v3.Result {
+	resultsByName := make(map[string]*v3.Result, len(results))
+	for _, result := range results {
+		// the shifted results of the queries are not divided
+		if result.CompareShift != 0 {
+			continue
+		}
+		resultsByName[result.QueryName] = result
+	}
+
+	for _, ratio := range ratios {
+		numerator, denominator := resultsByName[ratio.Numerator], resultsByName[ratio.Denominator]
+		if numerator == nil || denominator == nil {
+			continue
+		}
+		results = append(results, &v3.Result{
+			QueryName: ratio.QueryName,
+			Series:    divideSeries(numerator.Series, denominator.Series),
+		})
+	}
+	return results
+}
+
+// divideSeries divides the numerator series by the denominator series with the same labels
+func divideSeries(numerators, denominators []*v3.Series) []*v3.Series {
+	denominatorsByLabels := make(map[string]*v3.Series, len(denominators))
+	for _, series := range denominators {
+		denominatorsByLabels[labelsToString(series.Labels)] = series
+	}
+
+	ratioSeries := make([]*v3.Series, 0, len(numerators))
+	for _, numerator := range numerators {
+		denominator, ok := denominatorsByLabels[labelsToString(numerator.Labels)]
+		if !ok {
+			continue
+		}
+		denominatorValues := make(map[int64]float64, len(denominator.Points))
+		for _, point := range denominator.Points {
+			denominatorValues[point.Timestamp] = point.Value
+		}
+
+		series := &v3.Series{Labels: numerator.Labels, LabelsArray: numerator.LabelsArray, Points: make([]v3.Point, 0, len(numerator.Points))}
+		for _, point := range numerator.Points {
+			denominatorValue, ok := denominatorValues[point.Timestamp]
+			if !ok {
+				continue
+			}
+			value := point.Value / denominatorValue
+			if math.IsNaN(value) || math.IsInf(value, 0) {
+				series.Points = append(series.Points, v3.Point{Timestamp: point.Timestamp, Value: math.NaN(), Null: true})
+				continue
+			}
+			series.Points = append(series.Points, v3.Point{Timestamp: point.Timestamp, Value: value})
+		}
+		ratioSeries = append(ratioSeries, series)
+	}
+	return ratioSeries
+}
@@ -0,0 +1,46 @@
+package querier
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrReaderHardTimeout is returned when a reader call does not return within
+// the hard timeout, whether or not the context of the request is done
+var ErrReaderHardTimeout = errors.New("reader did not return within the hard timeout")
+
+// withReaderHardTimeout calls the reader and stops waiting for it after the
+// timeout, even if the reader ignores the context. The context passed to the
+// call is cancelled on timeout so that the readers honouring it can stop.
+// A timeout <= 0 calls the reader without a hard timeout
+func withReaderHardTimeout[T any](ctx context.Context, timeout time.Duration, call func(context.Context) (T, error)) (T, error) {
+	if timeout <= 0 {
+		return call(ctx)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		value T
+		err   error
+	}
+	// buffered so that the call can complete after the timeout without blocking
+	done := make(chan result, 1)
+	go func() {
+		value, err := call(ctx)
+		done <- result{value: value, err: err}
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case r := <-done:
+		return r.value, r.err
+	case <-timer.C:
+		var zero T
+		return zero, fmt.Errorf("%w after %s", ErrReaderHardTimeout, timeout)
+	}
+}
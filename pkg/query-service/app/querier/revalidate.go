@@ -0,0 +1,119 @@
+package querier
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	metricsV3 "go.signoz.io/signoz/pkg/query-service/app/metrics/v3"
+	v3 "go.signoz.io/signoz/pkg/query-service/model/v3"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultRevalidateTimeout          = time.Minute
+	defaultMaxConcurrentRevalidations = 10
+)
+
+// fluxTailKeySuffix is appended to the cache key to store the flux tail of the
+// cached series, apart from the settled series
+const fluxTailKeySuffix = "#fluxTail"
+
+// onlyFluxTailMisses returns true if the only miss is the [End - fluxInterval, End]
+// range that is always refetched because the data might still be in flux
+func (q *querier) onlyFluxTailMisses(misses []missInterval, end, step int64) bool {
+	if len(misses) != 1 {
+		return false
+	}
+	// the flux tail starts at most one (adjusted) step before now - fluxInterval
+	fluxStart := q.nowFunc().UnixMilli() - q.fluxIntervalFor(step).Milliseconds() - time.Minute.Milliseconds()
+	return misses[0].end == end && misses[0].start >= fluxStart
+}
+
+// promAuditFetch returns the function fetching the prom query for a cache audit
+func (q *querier) promAuditFetch(promQuery *v3.PromQuery, step int64) func(context.Context, int64, int64) ([]*v3.Series, error) {
+	return func(ctx context.Context, start, end int64) ([]*v3.Series, error) {
+		return q.execPromQuery(ctx, metricsV3.BuildPromQuery(promQuery, step, start, end))
+	}
+}
+
+// revalidatePromQuery refetches the misses of the prom query in the background and
+// stores the merged series in the cache for the next load.
+// The refetch is detached from the request context so that it outlives the request,
+// but it is bounded by the revalidate timeout and the number of revalidations in flight.
+func (q *querier) revalidatePromQuery(cacheKey string, promQuery *v3.PromQuery, params *v3.QueryRangeParamsV3, misses []missInterval, cachedData []byte) {
+	select {
+	case q.revalidateSem <- struct{}{}:
+	default:
+		zap.L().Warn("skipping revalidation, too many revalidations in flight", zap.String("query", promQuery.Query))
+		return
+	}
+
+	go func() {
+		defer func() { <-q.revalidateSem }()
+		ctx, cancel := context.WithTimeout(context.Background(), q.revalidateTimeout)
+		defer cancel()
+
+		missedSeries := make([]*v3.Series, 0)
+		for _, miss := range misses {
+			query := metricsV3.BuildPromQuery(promQuery, params.Step, miss.start, miss.end)
+			series, err := q.execPromQuery(ctx, query)
+			if err != nil {
+				zap.L().Error("error revalidating prom query", zap.String("query", promQuery.Query), zap.Error(err))
+				return
+			}
+			missedSeries = append(missedSeries, series...)
+		}
+		if len(missedSeries) == 0 {
+			return
+		}
+
+		// the cached series returned to the caller are not shared with the merge
+		cachedSeries := make([]*v3.Series, 0)
+		if err := json.Unmarshal(cachedData, &cachedSeries); err != nil {
+			zap.L().Error("error unmarshalling cached data", zap.Error(err))
+			return
+		}
+		if q.cacheAuditSampled() {
+			q.auditCachedSeries(ctx, cacheKey, cachedSeries, params.Step, q.promAuditFetch(promQuery, params.Step))
+		}
+		settledSeries, tailSeries := q.splitFluxTail(q.mergeCachedSeries(cachedSeries, missedSeries, params.Step), params.Step, 0)
+		if err := q.storeSeries(cacheKey, settledSeries, cachedData, false, q.cacheTTLPolicy(params.Start, params.End, params.Step)); err != nil {
+			zap.L().Error("error storing merged series", zap.Error(err))
+			return
+		}
+		q.storeFluxTail(cacheKey, tailSeries, q.cacheTTLPolicy(params.Start, params.End, params.Step))
+	}()
+}
+
+// storeFluxTail stores the flux tail of the series of the cache key, for the stale
+// responses to serve it while it is revalidated. The tail replaces the previous tail,
+// it is only stored with stale-while-revalidate
+func (q *querier) storeFluxTail(cacheKey string, tailSeries []*v3.Series, ttl time.Duration) {
+	if !q.staleWhileRevalidate {
+		return
+	}
+	data, err := json.Marshal(tailSeries)
+	if err != nil {
+		zap.L().Error("error marshalling flux tail", zap.Error(err))
+		return
+	}
+	if err := q.cache.Store(cacheKey+fluxTailKeySuffix, data, ttl); err != nil {
+		zap.L().Error("error storing flux tail", zap.Error(err))
+	}
+}
+
+// retrieveFluxTail returns the flux tail stored for the series of the cache key,
+// false if there is none
+func (q *querier) retrieveFluxTail(cacheKey string) ([]*v3.Series, bool) {
+	data, _, err := q.cache.Retrieve(cacheKey+fluxTailKeySuffix, true)
+	if err != nil || data == nil {
+		return nil, false
+	}
+	tailSeries := make([]*v3.Series, 0)
+	if err := json.Unmarshal(data, &tailSeries); err != nil {
+		zap.L().Error("error unmarshalling flux tail", zap.Error(err))
+		return nil, false
+	}
+	return tailSeries, true
+}
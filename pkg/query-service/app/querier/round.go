@@ -0,0 +1,33 @@
+package querier
+
+import (
+	"math"
+
+	v3 "go.signoz.io/signoz/pkg/query-service/model/v3"
+)
+
+// roundPointValues rounds the point values of the series to the decimals. It is
+// applied to the returned series only, the cached series keep the full precision.
+// The points are replaced, not modified in place, as they might be shared with
+// the series being cached
+func roundPointValues(seriesList []*v3.Series, decimals int) {
+	scale := math.Pow10(decimals)
+	for _, series := range seriesList {
+		points := make([]v3.Point, len(series.Points))
+		for idx, point := range series.Points {
+			points[idx] = point
+			points[idx].Value = roundValue(point.Value, scale)
+		}
+		series.Points = points
+	}
+}
+
+// roundValue rounds the value to the multiple of 1/scale, the values too
+// large to be scaled are already precise to the decimals
+func roundValue(value, scale float64) float64 {
+	scaled := value * scale
+	if math.IsNaN(scaled) || math.IsInf(scaled, 0) {
+		return value
+	}
+	return math.Round(scaled) / scale
+}
@@ -0,0 +1,61 @@
+package querier
+
+import (
+	"math"
+
+	v3 "go.signoz.io/signoz/pkg/query-service/model/v3"
+)
+
+// samplePoints downsamples the points of each series to at most sampleTo points.
+// The points are split in sampleTo/2 evenly sized buckets and the min and max
+// points of each bucket are kept, in the order of the points, so the extremes of
+// the series are preserved. It is applied to the returned series only, the cached
+// series keep the full resolution
+func samplePoints(seriesList []*v3.Series, sampleTo int) {
+	for _, series := range seriesList {
+		if len(series.Points) <= sampleTo {
+			continue
+		}
+		// the counts and the band are aligned with the points and sampled with them
+		selectPoints(series, sampleIndices(series.Points, sampleTo/2))
+	}
+}
+
+// sampleIndices returns the indices of the min and max points of each of the
+// buckets, in increasing order. The NaN points, the gap markers, are left out of
+// the extremes, a bucket of NaN points only keeps its first point
+func sampleIndices(points []v3.Point, buckets int) []int {
+	indices := make([]int, 0, 2*buckets)
+	for bucket := 0; bucket < buckets; bucket++ {
+		start := bucket * len(points) / buckets
+		end := (bucket + 1) * len(points) / buckets
+		first := start
+		for first < end-1 && math.IsNaN(points[first].Value) {
+			first++
+		}
+		if math.IsNaN(points[first].Value) {
+			first = start
+		}
+		minIdx, maxIdx := first, first
+		for idx := first + 1; idx < end; idx++ {
+			if math.IsNaN(points[idx].Value) {
+				continue
+			}
+			if points[idx].Value < points[minIdx].Value {
+				minIdx = idx
+			}
+			if points[idx].Value > points[maxIdx].Value {
+				maxIdx = idx
+			}
+		}
+		switch {
+		case minIdx == maxIdx:
+			indices = append(indices, minIdx)
+		case minIdx < maxIdx:
+			indices = append(indices, minIdx, maxIdx)
+		default:
+			indices = append(indices, maxIdx, minIdx)
+		}
+	}
+	return indices
+}
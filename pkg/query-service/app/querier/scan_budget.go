@@ -0,0 +1,62 @@
+package querier
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"go.signoz.io/signoz/pkg/query-service/common"
+	chErrors "go.signoz.io/signoz/pkg/query-service/errors"
+)
+
+// scanBudget is the max rows and bytes the clickhouse queries of a request can
+// read in total. The queries report what they read concurrently, the request is
+// cancelled once the budget is exceeded
+type scanBudget struct {
+	maxRows, maxBytes uint64
+	cancel            context.CancelFunc
+
+	mu          sync.Mutex
+	rows, bytes uint64
+	err         error
+}
+
+func newScanBudget(maxRows, maxBytes uint64, cancel context.CancelFunc) *scanBudget {
+	return &scanBudget{maxRows: maxRows, maxBytes: maxBytes, cancel: cancel}
+}
+
+// AddScanned implements common.ScanMeter
+func (b *scanBudget) AddScanned(rows, bytes uint64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.rows += rows
+	b.bytes += bytes
+	if b.err != nil {
+		return
+	}
+	if b.maxRows > 0 && b.rows > b.maxRows {
+		b.err = chErrors.NewResourceLimitError(fmt.Errorf("the queries read %d rows, over the budget of %d rows", b.rows, b.maxRows))
+	} else if b.maxBytes > 0 && b.bytes > b.maxBytes {
+		b.err = chErrors.NewResourceLimitError(fmt.Errorf("the queries read %d bytes, over the budget of %d bytes", b.bytes, b.maxBytes))
+	}
+	if b.err != nil {
+		// the running queries are aborted
+		b.cancel()
+	}
+}
+
+// exceeded returns the resource limit error of the budget once it is exceeded
+func (b *scanBudget) exceeded() error {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.err
+}
+
+// scanBudgetFrom returns the scan budget of the request of the context, if any
+func scanBudgetFrom(ctx context.Context) *scanBudget {
+	budget, _ := ctx.Value(common.ClickHouseScanMeterKey).(*scanBudget)
+	return budget
+}
@@ -0,0 +1,85 @@
+package querier
+
+import (
+	v3 "go.signoz.io/signoz/pkg/query-service/model/v3"
+)
+
+// alignSeams aligns the first missed point after the cached points of each series
+// to the step grid of the cached points, when it is off the grid by less than
+// one step. The cached and missed series are expected to be merged after.
+// step is in seconds
+func alignSeams(cachedSeries, missedSeries []*v3.Series, step int64) {
+	stepMillis := step * 1000
+	if stepMillis <= 0 {
+		return
+	}
+
+	seams := make(map[string]int64, len(cachedSeries))
+	for _, series := range cachedSeries {
+		labels := labelsToString(series.Labels)
+		for _, point := range series.Points {
+			if seam, ok := seams[labels]; !ok || point.Timestamp > seam {
+				seams[labels] = point.Timestamp
+			}
+		}
+	}
+
+	for _, series := range missedSeries {
+		seam, ok := seams[labelsToString(series.Labels)]
+		if !ok {
+			continue
+		}
+
+		// the boundary point is the earliest missed point after the seam
+		boundaryIdx := -1
+		for idx, point := range series.Points {
+			if point.Timestamp <= seam {
+				continue
+			}
+			if boundaryIdx == -1 || point.Timestamp < series.Points[boundaryIdx].Timestamp {
+				boundaryIdx = idx
+			}
+		}
+		if boundaryIdx == -1 {
+			continue
+		}
+		offset := (series.Points[boundaryIdx].Timestamp - seam) % stepMillis
+		if offset == 0 {
+			continue
+		}
+
+		// round to the nearest grid point after the seam
+		gridTimestamp := series.Points[boundaryIdx].Timestamp - offset
+		if offset*2 >= stepMillis || gridTimestamp == seam {
+			gridTimestamp += stepMillis
+		}
+		gridTaken := false
+		for _, point := range series.Points {
+			if point.Timestamp == gridTimestamp {
+				gridTaken = true
+				break
+			}
+		}
+		if gridTaken {
+			// the grid point is already there, drop the misaligned one, with its count and band
+			indices := make([]int, 0, len(series.Points)-1)
+			for idx := range series.Points {
+				if idx != boundaryIdx {
+					indices = append(indices, idx)
+				}
+			}
+			selectPoints(series, indices)
+			continue
+		}
+		series.Points[boundaryIdx].Timestamp = gridTimestamp
+	}
+}
+
+// mergeCachedSeries merges the missed series with the cached series, aligning
+// the seams first if enabled
+func (q *querier) mergeCachedSeries(cachedSeries, missedSeries []*v3.Series, step int64) []*v3.Series {
+	if q.alignCacheSeams {
+		alignSeams(cachedSeries, missedSeries, step)
+	}
+	return q.mergeSerieses(cachedSeries, missedSeries)
+}
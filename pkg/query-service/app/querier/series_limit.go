@@ -0,0 +1,244 @@
+package querier
+
+import (
+	"math"
+	"sort"
+	"unicode/utf8"
+
+	v3 "go.signoz.io/signoz/pkg/query-service/model/v3"
+)
+
+// SeriesRankBy is the value the series are ranked by when truncating them
+type SeriesRankBy string
+
+const (
+	// SeriesRankByTotal ranks the series by the sum of their values
+	SeriesRankByTotal SeriesRankBy = "total"
+	// SeriesRankByLast ranks the series by the value of their latest point
+	SeriesRankByLast SeriesRankBy = "last"
+)
+
+// rankValue returns the value the series is ranked by, series without
+// any (non NaN) value are ranked last
+func rankValue(series *v3.Series, rankBy SeriesRankBy) float64 {
+	value := math.Inf(-1)
+	switch rankBy {
+	case SeriesRankByLast:
+		var lastTimestamp int64 = math.MinInt64
+		for _, point := range series.Points {
+			if point.Timestamp >= lastTimestamp && !math.IsNaN(point.Value) {
+				lastTimestamp = point.Timestamp
+				value = point.Value
+			}
+		}
+	default:
+		for _, point := range series.Points {
+			if math.IsNaN(point.Value) {
+				continue
+			}
+			if math.IsInf(value, -1) {
+				value = 0
+			}
+			value += point.Value
+		}
+	}
+	return value
+}
+
+// topSeries returns the n series with the highest rank value, ties are
+// broken by the labels so that the same series are kept across requests
+func topSeries(seriesList []*v3.Series, n int, rankBy SeriesRankBy) []*v3.Series {
+	type rankedSeries struct {
+		series *v3.Series
+		value  float64
+		labels string
+	}
+	ranked := make([]rankedSeries, 0, len(seriesList))
+	for _, series := range seriesList {
+		ranked = append(ranked, rankedSeries{
+			series: series,
+			value:  rankValue(series, rankBy),
+			labels: labelsToString(series.Labels),
+		})
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		if ranked[i].value != ranked[j].value {
+			return ranked[i].value > ranked[j].value
+		}
+		return ranked[i].labels < ranked[j].labels
+	})
+
+	if n > len(ranked) {
+		n = len(ranked)
+	}
+	top := make([]*v3.Series, 0, n)
+	for _, r := range ranked[:n] {
+		top = append(top, r.series)
+	}
+	return top
+}
+
+// topSeriesWithOther returns the top n series by the metric, followed by a series
+// summing the values of the other series at each timestamp. The series without
+// any value are ranked last and ties are broken by the labels
+func topSeriesWithOther(seriesList []*v3.Series, topN *v3.TopNWithOther) []*v3.Series {
+	metric := topN.Metric
+	if metric == "" {
+		metric = v3.SeriesSortMetricAvg
+	}
+	ranked := make([]*v3.Series, len(seriesList))
+	copy(ranked, seriesList)
+	sortSeries(ranked, &v3.SeriesSort{Metric: metric})
+
+	top := make([]*v3.Series, 0, topN.N+1)
+	top = append(top, ranked[:topN.N]...)
+	return append(top, sumOtherSeries(ranked[topN.N:]))
+}
+
+// sumOtherSeries sums the series into a single series, with the NaN values left
+// out. The labels of the series are all set to v3.OtherSeriesLabelValue
+func sumOtherSeries(seriesList []*v3.Series) *v3.Series {
+	labels := make(map[string]string)
+	valuesByTimestamp := make(map[int64]float64)
+	for _, series := range seriesList {
+		for key := range series.Labels {
+			labels[key] = v3.OtherSeriesLabelValue
+		}
+		for _, point := range series.Points {
+			if math.IsNaN(point.Value) {
+				continue
+			}
+			valuesByTimestamp[point.Timestamp] += point.Value
+		}
+	}
+
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	other := &v3.Series{Labels: labels, Points: make([]v3.Point, 0, len(valuesByTimestamp))}
+	for _, key := range keys {
+		other.LabelsArray = append(other.LabelsArray, map[string]string{key: v3.OtherSeriesLabelValue})
+	}
+	for timestamp, value := range valuesByTimestamp {
+		other.Points = append(other.Points, v3.Point{Timestamp: timestamp, Value: value})
+	}
+	other.SortPoints()
+	return other
+}
+
+// sortValue returns the value the series is sorted by, series without
+// any (non NaN) value are reported as NaN
+func sortValue(series *v3.Series, metric v3.SeriesSortMetric) float64 {
+	if metric == v3.SeriesSortMetricLast {
+		value := rankValue(series, SeriesRankByLast)
+		if math.IsInf(value, -1) {
+			return math.NaN()
+		}
+		return value
+	}
+
+	value := math.NaN()
+	var sum float64
+	var count int
+	for _, point := range series.Points {
+		if math.IsNaN(point.Value) {
+			continue
+		}
+		if count == 0 || point.Value > value {
+			value = point.Value
+		}
+		sum += point.Value
+		count++
+	}
+	if metric == v3.SeriesSortMetricAvg && count > 0 {
+		value = sum / float64(count)
+	}
+	return value
+}
+
+// sortSeries orders the series in place by the sort metric, series without
+// any value are placed last regardless of the order and ties are broken by
+// the labels so that the order is stable across requests
+func sortSeries(seriesList []*v3.Series, seriesSort *v3.SeriesSort) {
+	values := make(map[*v3.Series]float64, len(seriesList))
+	labels := make(map[*v3.Series]string, len(seriesList))
+	for _, series := range seriesList {
+		values[series] = sortValue(series, seriesSort.Metric)
+		labels[series] = labelsToString(series.Labels)
+	}
+	ascending := seriesSort.Order == "asc"
+	sort.SliceStable(seriesList, func(i, j int) bool {
+		vi, vj := values[seriesList[i]], values[seriesList[j]]
+		if math.IsNaN(vi) || math.IsNaN(vj) {
+			if math.IsNaN(vi) && math.IsNaN(vj) {
+				return labels[seriesList[i]] < labels[seriesList[j]]
+			}
+			return math.IsNaN(vj)
+		}
+		if vi != vj {
+			if ascending {
+				return vi < vj
+			}
+			return vi > vj
+		}
+		return labels[seriesList[i]] < labels[seriesList[j]]
+	})
+}
+
+// truncatedLabelValueMarker is appended to the truncated label values
+const truncatedLabelValueMarker = "..."
+
+// truncateLabelValue truncates the value to maxLength bytes, on a rune boundary
+func truncateLabelValue(value string, maxLength int) string {
+	if len(value) <= maxLength {
+		return value
+	}
+	cut := maxLength
+	for cut > 0 && !utf8.RuneStart(value[cut]) {
+		cut--
+	}
+	return value[:cut] + truncatedLabelValueMarker
+}
+
+// truncateLabelValues truncates the label values of the series longer than
+// maxLength. The labels are replaced, not modified in place, as they might be
+// shared with the series being cached
+func truncateLabelValues(seriesList []*v3.Series, maxLength int) {
+	for _, series := range seriesList {
+		truncated := false
+		for _, value := range series.Labels {
+			if len(value) > maxLength {
+				truncated = true
+				break
+			}
+		}
+		for _, labels := range series.LabelsArray {
+			for _, value := range labels {
+				if len(value) > maxLength {
+					truncated = true
+					break
+				}
+			}
+		}
+		if !truncated {
+			continue
+		}
+
+		labels := make(map[string]string, len(series.Labels))
+		for key, value := range series.Labels {
+			labels[key] = truncateLabelValue(value, maxLength)
+		}
+		labelsArray := make([]map[string]string, 0, len(series.LabelsArray))
+		for _, item := range series.LabelsArray {
+			truncatedItem := make(map[string]string, len(item))
+			for key, value := range item {
+				truncatedItem[key] = truncateLabelValue(value, maxLength)
+			}
+			labelsArray = append(labelsArray, truncatedItem)
+		}
+		series.Labels = labels
+		series.LabelsArray = labelsArray
+	}
+}
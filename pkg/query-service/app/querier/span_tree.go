@@ -0,0 +1,78 @@
+package querier
+
+import (
+	"fmt"
+	"reflect"
+
+	v3 "go.signoz.io/signoz/pkg/query-service/model/v3"
+)
+
+// attachSpanTrees assembles the spans of the trace panel queries with SpanTree
+// into trees, in place of the list of the spans
+func attachSpanTrees(results []*v3.Result, builderQueries map[string]*v3.BuilderQuery) {
+	for _, result := range results {
+		builderQuery, ok := builderQueries[result.QueryName]
+		if !ok || !builderQuery.SpanTree {
+			continue
+		}
+		result.SpanTrees = assembleSpanTrees(result.List)
+		result.List = nil
+	}
+}
+
+// spanKey identifies a span within the spans of several traces
+type spanKey struct {
+	traceID, spanID string
+}
+
+// assembleSpanTrees links the span rows to their parent spans by the parentSpanID
+// of the rows, keeping the order of the rows among the roots and the children. The
+// spans whose parent is not among the rows are roots, and so is a span whose parent
+// chain loops back to it, which would otherwise be unreachable
+func assembleSpanTrees(rows []*v3.Row) []*v3.SpanNode {
+	nodes := make([]*v3.SpanNode, len(rows))
+	nodesByKey := make(map[spanKey]*v3.SpanNode, len(rows))
+	for idx, row := range rows {
+		nodes[idx] = &v3.SpanNode{Span: row}
+		nodesByKey[spanKey{rowField(row, "traceID"), rowField(row, "spanID")}] = nodes[idx]
+	}
+
+	parents := make(map[*v3.SpanNode]*v3.SpanNode, len(rows))
+	for idx, row := range rows {
+		parent, ok := nodesByKey[spanKey{rowField(row, "traceID"), rowField(row, "parentSpanID")}]
+		if ok && parent != nodes[idx] && rowField(row, "parentSpanID") != "" {
+			parents[nodes[idx]] = parent
+		}
+	}
+	for _, node := range nodes {
+		for ancestor, steps := parents[node], 0; ancestor != nil && steps < len(nodes); ancestor, steps = parents[ancestor], steps+1 {
+			if ancestor == node {
+				delete(parents, node)
+				break
+			}
+		}
+	}
+
+	var roots []*v3.SpanNode
+	for _, node := range nodes {
+		if parent, ok := parents[node]; ok {
+			parent.Children = append(parent.Children, node)
+		} else {
+			roots = append(roots, node)
+		}
+	}
+	return roots
+}
+
+// rowField returns the value of the field of the row as a string, empty if unset.
+// The values read from clickhouse are pointers to the values
+func rowField(row *v3.Row, field string) string {
+	value := reflect.Indirect(reflect.ValueOf(row.Data[field]))
+	if !value.IsValid() {
+		return ""
+	}
+	if s, ok := value.Interface().(string); ok {
+		return s
+	}
+	return fmt.Sprint(value.Interface())
+}
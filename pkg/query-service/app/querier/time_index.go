@@ -0,0 +1,75 @@
+package querier
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.signoz.io/signoz/pkg/query-service/common"
+)
+
+type timeIndexCtxKey struct{}
+
+// timeIndexKey is a time window of a query and the span of its cached points,
+// which the misses of the query only depend on
+type timeIndexKey struct {
+	start, end, step, alignmentOffset int64
+	fluxInterval                      time.Duration
+	cachedStart, cachedEnd            int64
+}
+
+type timeIndexEntry struct {
+	misses           []missInterval
+	replaceCacheData bool
+}
+
+// timeIndex is the request scoped index of the misses of the cached time windows.
+// The queries of a request over the same window, whose cached points span the same
+// range, e.g. the queries of a dashboard cached together, compute their misses once.
+// The flux intervals of all the queries of the request are measured back from the
+// same now
+type timeIndex struct {
+	now time.Time
+
+	mu      sync.Mutex
+	entries map[timeIndexKey]timeIndexEntry
+	// computations is the number of miss computations, the lookups of the
+	// shared windows are not counted
+	computations int
+}
+
+func newTimeIndex(now time.Time) *timeIndex {
+	return &timeIndex{now: now, entries: make(map[timeIndexKey]timeIndexEntry)}
+}
+
+// withTimeIndex returns a context in which the misses of the queries are shared
+// through the index
+func withTimeIndex(ctx context.Context, index *timeIndex) context.Context {
+	return context.WithValue(ctx, timeIndexCtxKey{}, index)
+}
+
+// timeIndexFrom returns the time index of the context, if any
+func timeIndexFrom(ctx context.Context) *timeIndex {
+	index, _ := ctx.Value(timeIndexCtxKey{}).(*timeIndex)
+	return index
+}
+
+// misses returns the misses of the window for the cached span, computing them
+// only for the first query of the window and the span
+func (t *timeIndex) misses(key timeIndexKey) ([]missInterval, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if entry, ok := t.entries[key]; ok {
+		return entry.misses, entry.replaceCacheData
+	}
+	t.computations++
+	entry := timeIndexEntry{}
+	fluxBoundary := common.FluxBoundaryAt(key.step, key.alignmentOffset, key.fluxInterval, t.now)
+	ranges, replaceCacheData := common.ComputeMissingRangesForSpan(key.start, key.end, key.cachedStart, key.cachedEnd, fluxBoundary)
+	for _, r := range ranges {
+		entry.misses = append(entry.misses, missInterval{start: r.Start, end: r.End})
+	}
+	entry.replaceCacheData = replaceCacheData
+	t.entries[key] = entry
+	return entry.misses, entry.replaceCacheData
+}
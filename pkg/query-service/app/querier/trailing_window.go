@@ -0,0 +1,96 @@
+package querier
+
+import (
+	"math"
+	"sort"
+
+	v3 "go.signoz.io/signoz/pkg/query-service/model/v3"
+)
+
+// trailingWindowLookBack returns how far, in milliseconds, before the start of
+// the query the step intervals of its trailing window start
+func trailingWindowLookBack(builderQuery *v3.BuilderQuery) int64 {
+	if builderQuery.TrailingWindow <= builderQuery.StepInterval {
+		return 0
+	}
+	return (builderQuery.TrailingWindow - builderQuery.StepInterval) * 1000
+}
+
+// applyTrailingWindows replaces the points of the series of the builder queries
+// with a TrailingWindow by the aggregation of the step intervals in the window
+// ending at each point, and drops the look back points before the start. The
+// series are new series, the merged series are shared with the cache
+func applyTrailingWindows(results []*v3.Result, params *v3.QueryRangeParamsV3) {
+	for _, result := range results {
+		builderQuery, ok := params.CompositeQuery.BuilderQueries[result.QueryName]
+		if !ok || builderQuery.TrailingWindow == 0 {
+			continue
+		}
+		start := params.Start - builderQuery.ShiftBy*1000
+		end := params.End - builderQuery.ShiftBy*1000
+		seriesList := make([]*v3.Series, 0, len(result.Series))
+		for _, series := range result.Series {
+			windowed := *series
+			windowed.Points = trailingWindowPoints(series.Points, builderQuery, start, end)
+			// the counts and the band are those of the step intervals
+			windowed.Counts, windowed.BandMin, windowed.BandMax = nil, nil, nil
+			seriesList = append(seriesList, &windowed)
+		}
+		result.Series = seriesList
+	}
+}
+
+// trailingWindowPoints returns a point at each step in [start, end] whose trailing
+// window, (timestamp - window, timestamp], has points, with the aggregation of their
+// values. The counts and the sums add up, the mins and the maxes are the min and
+// the max of the window. The NaN values are left out
+func trailingWindowPoints(points []v3.Point, builderQuery *v3.BuilderQuery, start, end int64) []v3.Point {
+	step := builderQuery.StepInterval * 1000
+	window := builderQuery.TrailingWindow * 1000
+	if step <= 0 {
+		return points
+	}
+
+	values := make(map[int64]float64, len(points))
+	timestamps := make(map[int64]struct{})
+	for _, point := range points {
+		if math.IsNaN(point.Value) {
+			continue
+		}
+		values[point.Timestamp] = point.Value
+		// the point is in the windows of the steps up to a window after it
+		for timestamp := point.Timestamp; timestamp < point.Timestamp+window; timestamp += step {
+			if timestamp >= start && timestamp <= end {
+				timestamps[timestamp] = struct{}{}
+			}
+		}
+	}
+
+	windowed := make([]v3.Point, 0, len(timestamps))
+	for timestamp := range timestamps {
+		var aggregate float64
+		first := true
+		for bucket := timestamp; bucket > timestamp-window; bucket -= step {
+			value, ok := values[bucket]
+			if !ok {
+				continue
+			}
+			switch {
+			case first:
+				aggregate = value
+			case builderQuery.AggregateOperator == v3.AggregateOperatorMin:
+				aggregate = math.Min(aggregate, value)
+			case builderQuery.AggregateOperator == v3.AggregateOperatorMax:
+				aggregate = math.Max(aggregate, value)
+			default:
+				aggregate += value
+			}
+			first = false
+		}
+		windowed = append(windowed, v3.Point{Timestamp: timestamp, Value: aggregate})
+	}
+	sort.Slice(windowed, func(i, j int) bool {
+		return windowed[i].Timestamp < windowed[j].Timestamp
+	})
+	return windowed
+}
@@ -0,0 +1,54 @@
+package querier
+
+import (
+	"context"
+
+	v3 "go.signoz.io/signoz/pkg/query-service/model/v3"
+	"go.uber.org/zap"
+)
+
+// attachUnits sets the unit of the metric of the metrics builder queries on their
+// results. The metadata of each metric is fetched once, a failure to fetch it is
+// logged and the results of the metric are returned without a unit
+func (q *querier) attachUnits(ctx context.Context, params *v3.QueryRangeParamsV3, results []*v3.Result) {
+	units := make(map[string]string)
+	for _, result := range results {
+		builderQuery, ok := params.CompositeQuery.BuilderQueries[result.QueryName]
+		if !ok || !hasMetricUnit(builderQuery) {
+			continue
+		}
+		metricName := builderQuery.AggregateAttribute.Key
+		unit, ok := units[metricName]
+		if !ok {
+			metadata, err := q.reader.GetMetricMetadata(ctx, metricName, "")
+			if err != nil {
+				zap.L().Error("error fetching metric metadata", zap.String("metricName", metricName), zap.Error(err))
+			} else {
+				unit = metadata.Unit
+			}
+			units[metricName] = unit
+		}
+		result.Unit = unit
+	}
+}
+
+// hasMetricUnit returns true if the values of the builder query are in the unit
+// of its metric, i.e. it is a metrics query that doesn't count the samples.
+// The expressions are left out, as they can combine different units
+func hasMetricUnit(builderQuery *v3.BuilderQuery) bool {
+	if builderQuery.DataSource != v3.DataSourceMetrics || builderQuery.QueryName != builderQuery.Expression {
+		return false
+	}
+	if builderQuery.AggregateAttribute.Key == "" {
+		return false
+	}
+	switch builderQuery.AggregateOperator {
+	case v3.AggregateOperatorCount, v3.AggregateOperatorCountDistinct:
+		return false
+	}
+	switch builderQuery.TimeAggregation {
+	case v3.TimeAggregationCount, v3.TimeAggregationCountDistinct:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,13 @@
+package v2
+
+import (
+	"context"
+	"fmt"
+)
+
+// CancelQuery kills the running clickhouse query with the query id. The v2
+// querier doesn't run the queries with query ids of its own, and the query ids
+// it didn't issue are not cancelled, so no query id can be cancelled
+func (q *querier) CancelQuery(ctx context.Context, queryID string) error {
+	return fmt.Errorf("unknown query id %q", queryID)
+}
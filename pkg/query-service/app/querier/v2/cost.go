@@ -0,0 +1,43 @@
+package v2
+
+import (
+	"context"
+	"fmt"
+
+	v3 "go.signoz.io/signoz/pkg/query-service/model/v3"
+)
+
+// EstimateCost estimates the amount of data the queries of the params read, without
+// running them. The estimates of the queries are summed. PromQL queries are not
+// executed by clickhouse directly and can't be estimated
+func (q *querier) EstimateCost(ctx context.Context, params *v3.QueryRangeParamsV3, keys map[string]v3.AttributeKey) (*v3.QueryCostEstimate, error) {
+	queries := make(map[string]string)
+	switch params.CompositeQuery.QueryType {
+	case v3.QueryTypeBuilder:
+		var err error
+		queries, err = q.builder.PrepareQueries(params, keys)
+		if err != nil {
+			return nil, err
+		}
+	case v3.QueryTypeClickHouseSQL:
+		for queryName, clickHouseQuery := range params.CompositeQuery.ClickHouseQueries {
+			if !clickHouseQuery.Disabled {
+				queries[queryName] = clickHouseQuery.Query
+			}
+		}
+	default:
+		return nil, fmt.Errorf("cost estimation is not supported for %s queries", params.CompositeQuery.QueryType)
+	}
+
+	total := &v3.QueryCostEstimate{}
+	for queryName, query := range queries {
+		estimate, err := q.reader.EstimateQueryCost(ctx, query)
+		if err != nil {
+			return nil, fmt.Errorf("error estimating the cost of query-%s: %w", queryName, err)
+		}
+		total.Rows += estimate.Rows
+		total.Parts += estimate.Parts
+		total.Marks += estimate.Marks
+	}
+	return total, nil
+}
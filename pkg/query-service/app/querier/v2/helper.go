@@ -8,6 +8,7 @@ import (
 	"sync"
 	"time"
 
+	"go.opentelemetry.io/otel/trace"
 	logsV3 "go.signoz.io/signoz/pkg/query-service/app/logs/v3"
 	metricsV3 "go.signoz.io/signoz/pkg/query-service/app/metrics/v3"
 	metricsV4 "go.signoz.io/signoz/pkg/query-service/app/metrics/v4"
@@ -85,6 +86,12 @@ func (q *querier) runBuilderQuery(
 	defer wg.Done()
 	queryName := builderQuery.QueryName
 
+	ctx, span := q.tracer.Start(ctx, "runBuilderQuery", trace.WithAttributes(
+		attrQueryName.String(queryName),
+		attrQueryType.String(string(builderQuery.DataSource)),
+	))
+	defer span.End()
+
 	var preferRPM bool
 
 	if q.featureLookUp != nil {
@@ -119,13 +126,13 @@ func (q *querier) runBuilderQuery(
 			var retrieveStatus status.RetrieveStatus
 			data, retrieveStatus, err := q.cache.Retrieve(cacheKey, true)
 			zap.L().Info("cache retrieve status", zap.String("status", retrieveStatus.String()))
+			span.SetAttributes(attrCacheStatus.String(retrieveStatus.String()))
 			if err == nil {
 				cachedData = data
 			}
 		}
-		misses, replaceCachedData := q.findMissingTimeRanges(start, end, builderQuery.StepInterval, cachedData)
+		cachedSeries, misses, replaceCachedData := q.findMissingTimeRanges(cacheKey, start, end, builderQuery.StepInterval, cachedData)
 		missedSeries := make([]*v3.Series, 0)
-		cachedSeries := make([]*v3.Series, 0)
 		for _, miss := range misses {
 			query, err = prepareLogsQuery(ctx, miss.start, miss.end, builderQuery, params, preferRPM)
 			if err != nil {
@@ -144,9 +151,6 @@ func (q *querier) runBuilderQuery(
 			}
 			missedSeries = append(missedSeries, series...)
 		}
-		if err := json.Unmarshal(cachedData, &cachedSeries); err != nil && cachedData != nil {
-			zap.L().Error("error unmarshalling cached data", zap.Error(err))
-		}
 		mergedSeries := mergeSerieses(cachedSeries, missedSeries)
 		if replaceCachedData {
 			mergedSeries = missedSeries
@@ -255,13 +259,13 @@ func (q *querier) runBuilderQuery(
 		var retrieveStatus status.RetrieveStatus
 		data, retrieveStatus, err := q.cache.Retrieve(cacheKey, true)
 		zap.L().Info("cache retrieve status", zap.String("status", retrieveStatus.String()))
+		span.SetAttributes(attrCacheStatus.String(retrieveStatus.String()))
 		if err == nil {
 			cachedData = data
 		}
 	}
-	misses, replaceCachedData := q.findMissingTimeRanges(start, end, builderQuery.StepInterval, cachedData)
+	cachedSeries, misses, replaceCachedData := q.findMissingTimeRanges(cacheKey, start, end, builderQuery.StepInterval, cachedData)
 	missedSeries := make([]*v3.Series, 0)
-	cachedSeries := make([]*v3.Series, 0)
 	for _, miss := range misses {
 		query, err := metricsV4.PrepareMetricQuery(
 			miss.start,
@@ -292,9 +296,6 @@ func (q *querier) runBuilderQuery(
 		}
 		missedSeries = append(missedSeries, series...)
 	}
-	if err := json.Unmarshal(cachedData, &cachedSeries); err != nil && cachedData != nil {
-		zap.L().Error("error unmarshalling cached data", zap.Error(err))
-	}
 	mergedSeries := mergeSerieses(cachedSeries, missedSeries)
 	if replaceCachedData {
 		mergedSeries = missedSeries
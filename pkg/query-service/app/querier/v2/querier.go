@@ -4,7 +4,6 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"math"
 	"sort"
 	"strings"
 	"sync"
@@ -16,7 +15,13 @@ import (
 	tracesV3 "go.signoz.io/signoz/pkg/query-service/app/traces/v3"
 	chErrors "go.signoz.io/signoz/pkg/query-service/errors"
 
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 	"go.signoz.io/signoz/pkg/query-service/cache"
+	"go.signoz.io/signoz/pkg/query-service/common"
+	"go.signoz.io/signoz/pkg/query-service/constants"
 	"go.signoz.io/signoz/pkg/query-service/interfaces"
 	"go.signoz.io/signoz/pkg/query-service/model"
 	v3 "go.signoz.io/signoz/pkg/query-service/model/v3"
@@ -24,6 +29,17 @@ import (
 	"go.uber.org/zap"
 )
 
+const tracerName = "go.signoz.io/signoz/pkg/query-service/app/querier/v2"
+
+// span attribute keys used by the querier
+const (
+	attrQueryName   = attribute.Key("query.name")
+	attrQueryType   = attribute.Key("query.type")
+	attrPanelType   = attribute.Key("query.panel_type")
+	attrCacheStatus = attribute.Key("cache.status")
+	attrSeriesCount = attribute.Key("result.series_count")
+)
+
 type channelResult struct {
 	Series []*v3.Series
 	List   []*v3.Row
@@ -46,6 +62,8 @@ type querier struct {
 	builder       *queryBuilder.QueryBuilder
 	featureLookUp interfaces.FeatureLookup
 
+	tracer trace.Tracer
+
 	// used for testing
 	// TODO(srikanthccv): remove this once we have a proper mock
 	testingMode     bool
@@ -62,6 +80,9 @@ type QuerierOptions struct {
 	KeyGenerator  cache.KeyGenerator
 	FluxInterval  time.Duration
 	FeatureLookup interfaces.FeatureLookup
+	// TracerProvider is used to create spans around query execution
+	// if not set, the global tracer provider is used
+	TracerProvider trace.TracerProvider
 
 	// used for testing
 	TestingMode    bool
@@ -70,6 +91,11 @@ type QuerierOptions struct {
 }
 
 func NewQuerier(opts QuerierOptions) interfaces.Querier {
+	tracerProvider := opts.TracerProvider
+	if tracerProvider == nil {
+		tracerProvider = otel.GetTracerProvider()
+	}
+
 	return &querier{
 		cache:        opts.Cache,
 		reader:       opts.Reader,
@@ -82,6 +108,7 @@ func NewQuerier(opts QuerierOptions) interfaces.Querier {
 			BuildMetricQuery: metricsV4.PrepareMetricQuery,
 		}, opts.FeatureLookup),
 		featureLookUp: opts.FeatureLookup,
+		tracer:        tracerProvider.Tracer(tracerName),
 
 		testingMode:    opts.TestingMode,
 		returnedSeries: opts.ReturnedSeries,
@@ -91,12 +118,15 @@ func NewQuerier(opts QuerierOptions) interfaces.Querier {
 
 // execClickHouseQuery executes the clickhouse query and returns the series list
 // if testing mode is enabled, it returns the mocked series list
-func (q *querier) execClickHouseQuery(ctx context.Context, query string) ([]*v3.Series, error) {
+func (q *querier) execClickHouseQuery(ctx context.Context, query string) (result []*v3.Series, err error) {
+	ctx, span := q.tracer.Start(ctx, "execClickHouseQuery")
+	defer func() { endSpan(span, len(result), err) }()
+
 	if q.testingMode && q.reader == nil {
 		q.queriesExecuted = append(q.queriesExecuted, query)
 		return q.returnedSeries, q.returnedErr
 	}
-	result, err := q.reader.GetTimeSeriesResultV3(ctx, query)
+	result, err = q.reader.GetTimeSeriesResultV3(ctx, query)
 	var pointsWithNegativeTimestamps int
 	// Filter out the points with negative or zero timestamps
 	for idx := range result {
@@ -120,21 +150,23 @@ func (q *querier) execClickHouseQuery(ctx context.Context, query string) ([]*v3.
 
 // execPromQuery executes the prom query and returns the series list
 // if testing mode is enabled, it returns the mocked series list
-func (q *querier) execPromQuery(ctx context.Context, params *model.QueryRangeParams) ([]*v3.Series, error) {
+func (q *querier) execPromQuery(ctx context.Context, params *model.QueryRangeParams) (seriesList []*v3.Series, err error) {
+	ctx, span := q.tracer.Start(ctx, "execPromQuery")
+	defer func() { endSpan(span, len(seriesList), err) }()
+
 	if q.testingMode && q.reader == nil {
 		q.queriesExecuted = append(q.queriesExecuted, params.Query)
 		q.timeRanges = append(q.timeRanges, []int{int(params.Start.UnixMilli()), int(params.End.UnixMilli())})
 		return q.returnedSeries, q.returnedErr
 	}
-	promResult, _, err := q.reader.GetQueryRangeResult(ctx, params)
-	if err != nil {
-		return nil, err
+	promResult, _, apiErr := q.reader.GetQueryRangeResult(ctx, params)
+	if apiErr != nil {
+		return nil, apiErr
 	}
 	matrix, promErr := promResult.Matrix()
 	if promErr != nil {
 		return nil, promErr
 	}
-	var seriesList []*v3.Series
 	for _, v := range matrix {
 		var s v3.Series
 		s.Labels = v.Metric.Copy().Map()
@@ -147,95 +179,41 @@ func (q *querier) execPromQuery(ctx context.Context, params *model.QueryRangePar
 	return seriesList, nil
 }
 
-// findMissingTimeRanges finds the missing time ranges in the seriesList
-// and returns a list of miss structs, It takes the fluxInterval into
-// account to find the missing time ranges.
-//
-// The [End - fluxInterval, End] is always added to the list of misses, because
-// the data might still be in flux and not yet available in the database.
-//
-// replaceCacheData is used to indicate if the cache data should be replaced instead of merging
-// with the new data
-// TODO: Remove replaceCacheData with a better logic
-func findMissingTimeRanges(start, end, step int64, seriesList []*v3.Series, fluxInterval time.Duration) (misses []missInterval, replaceCacheData bool) {
-	replaceCacheData = false
-	var cachedStart, cachedEnd int64
-	for idx := range seriesList {
-		series := seriesList[idx]
-		for pointIdx := range series.Points {
-			point := series.Points[pointIdx]
-			if cachedStart == 0 || point.Timestamp < cachedStart {
-				cachedStart = point.Timestamp
-			}
-			if cachedEnd == 0 || point.Timestamp > cachedEnd {
-				cachedEnd = point.Timestamp
-			}
-		}
+// endSpan records the number of series and the error if any on the span and ends it
+func endSpan(span trace.Span, seriesCount int, err error) {
+	span.SetAttributes(attrSeriesCount.Int(seriesCount))
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
 	}
+	span.End()
+}
 
-	// time.Now is used because here we are considering the case where data might not
-	// be fully ingested for last (fluxInterval) minutes
-	endMillis := time.Now().UnixMilli()
-	adjustStep := int64(math.Min(float64(step), 60))
-	roundedMillis := endMillis - (endMillis % (adjustStep * 1000))
-
-	// Exclude the flux interval from the cached end time
-	cachedEnd = int64(
-		math.Min(
-			float64(cachedEnd),
-			float64(roundedMillis-fluxInterval.Milliseconds()),
-		),
-	)
-
-	// There are five cases to consider
-	// 1. Cached time range is a subset of the requested time range
-	// 2. Cached time range is a superset of the requested time range
-	// 3. Cached time range is a left overlap of the requested time range
-	// 4. Cached time range is a right overlap of the requested time range
-	// 5. Cached time range is a disjoint of the requested time range
-	if cachedStart >= start && cachedEnd <= end {
-		// Case 1: Cached time range is a subset of the requested time range
-		// Add misses for the left and right sides of the cached time range
-		misses = append(misses, missInterval{start: start, end: cachedStart - 1})
-		misses = append(misses, missInterval{start: cachedEnd + 1, end: end})
-	} else if cachedStart <= start && cachedEnd >= end {
-		// Case 2: Cached time range is a superset of the requested time range
-		// No misses
-	} else if cachedStart <= start && cachedEnd >= start {
-		// Case 3: Cached time range is a left overlap of the requested time range
-		// Add a miss for the left side of the cached time range
-		misses = append(misses, missInterval{start: cachedEnd + 1, end: end})
-	} else if cachedStart <= end && cachedEnd >= end {
-		// Case 4: Cached time range is a right overlap of the requested time range
-		// Add a miss for the right side of the cached time range
-		misses = append(misses, missInterval{start: start, end: cachedStart - 1})
-	} else {
-		// Case 5: Cached time range is a disjoint of the requested time range
-		// Add a miss for the entire requested time range
-		misses = append(misses, missInterval{start: start, end: end})
-		replaceCacheData = true
-	}
-
-	// remove the struts with start > end
-	var validMisses []missInterval
-	for idx := range misses {
-		miss := misses[idx]
-		if miss.start < miss.end {
-			validMisses = append(validMisses, miss)
-		}
+// findMissingTimeRanges finds the missing time ranges in the seriesList
+// and returns a list of miss structs, see common.ComputeMissingRanges
+func findMissingTimeRanges(start, end, step int64, seriesList []*v3.Series, fluxInterval time.Duration) (misses []missInterval, replaceCacheData bool) {
+	ranges, replaceCacheData := common.ComputeMissingRanges(start, end, step, seriesList, fluxInterval)
+	for _, r := range ranges {
+		misses = append(misses, missInterval{start: r.Start, end: r.End})
 	}
-	return validMisses, replaceCacheData
+	return misses, replaceCacheData
 }
 
-// findMissingTimeRanges finds the missing time ranges in the cached data
-// and returns them as a list of misses
-func (q *querier) findMissingTimeRanges(start, end, step int64, cachedData []byte) (misses []missInterval, replaceCachedData bool) {
-	var cachedSeriesList []*v3.Series
-	if err := json.Unmarshal(cachedData, &cachedSeriesList); err != nil {
-		// In case of error, we return the entire range as a miss
-		return []missInterval{{start: start, end: end}}, true
-	}
-	return findMissingTimeRanges(start, end, step, cachedSeriesList, q.fluxInterval)
+// findMissingTimeRanges decodes the cached data of the cache key and finds the
+// missing time ranges in it. Without cached data, or with cached data which fails
+// to decode, the entire range is a miss, and the corrupt entry is deleted
+func (q *querier) findMissingTimeRanges(cacheKey string, start, end, step int64, cachedData []byte) (cachedSeries []*v3.Series, misses []missInterval, replaceCachedData bool) {
+	cachedSeries = make([]*v3.Series, 0)
+	if cachedData == nil {
+		return cachedSeries, []missInterval{{start: start, end: end}}, true
+	}
+	if err := json.Unmarshal(cachedData, &cachedSeries); err != nil {
+		zap.L().Error("error unmarshalling cached data, deleting the cache entry", zap.String("cacheKey", cacheKey), zap.Error(err))
+		q.cache.Remove(cacheKey)
+		return make([]*v3.Series, 0), []missInterval{{start: start, end: end}}, true
+	}
+	misses, replaceCachedData = findMissingTimeRanges(start, end, step, cachedSeries, q.fluxInterval)
+	return cachedSeries, misses, replaceCachedData
 }
 
 // labelsToString converts the labels map to a string
@@ -297,10 +275,18 @@ func mergeSerieses(cachedSeries, missedSeries []*v3.Series) []*v3.Series {
 		seriesesByLabels[labelsToString(series.Labels)].Points = append(seriesesByLabels[labelsToString(series.Labels)].Points, series.Points...)
 	}
 
+	// The series are ordered by their labels rather than by the map iteration, so
+	// that the same query returns the series in the same order
+	labelsKeys := make([]string, 0, len(seriesesByLabels))
+	for labels := range seriesesByLabels {
+		labelsKeys = append(labelsKeys, labels)
+	}
+	sort.Strings(labelsKeys)
+
 	// Sort the points in each series by timestamp
 	// and remove duplicate points
-	for idx := range seriesesByLabels {
-		series := seriesesByLabels[idx]
+	for _, labels := range labelsKeys {
+		series := seriesesByLabels[labels]
 		series.SortPoints()
 		series.RemoveDuplicatePoints()
 		mergedSeries = append(mergedSeries, series)
@@ -308,9 +294,18 @@ func mergeSerieses(cachedSeries, missedSeries []*v3.Series) []*v3.Series {
 	return mergedSeries
 }
 
+// generateCacheKeys generates the cache keys for the query range params and
+// namespaces them with the org of the user in the context, so that tenants with
+// identical queries never share cache entries
+func (q *querier) generateCacheKeys(ctx context.Context, params *v3.QueryRangeParamsV3) map[string]string {
+	return common.NamespaceCacheKeys(ctx, q.keyGenerator.GenerateKeys(params))
+}
+
 func (q *querier) runBuilderQueries(ctx context.Context, params *v3.QueryRangeParamsV3, keys map[string]v3.AttributeKey) ([]*v3.Result, map[string]error, error) {
+	ctx, span := q.tracer.Start(ctx, "runBuilderQueries")
+	defer span.End()
 
-	cacheKeys := q.keyGenerator.GenerateKeys(params)
+	cacheKeys := q.generateCacheKeys(ctx, params)
 
 	ch := make(chan channelResult, len(params.CompositeQuery.BuilderQueries))
 	var wg sync.WaitGroup
@@ -350,9 +345,12 @@ func (q *querier) runBuilderQueries(ctx context.Context, params *v3.QueryRangePa
 }
 
 func (q *querier) runPromQueries(ctx context.Context, params *v3.QueryRangeParamsV3) ([]*v3.Result, map[string]error, error) {
+	ctx, span := q.tracer.Start(ctx, "runPromQueries")
+	defer span.End()
+
 	channelResults := make(chan channelResult, len(params.CompositeQuery.PromQueries))
 	var wg sync.WaitGroup
-	cacheKeys := q.keyGenerator.GenerateKeys(params)
+	cacheKeys := q.generateCacheKeys(ctx, params)
 
 	for queryName, promQuery := range params.CompositeQuery.PromQueries {
 		if promQuery.Disabled {
@@ -361,19 +359,21 @@ func (q *querier) runPromQueries(ctx context.Context, params *v3.QueryRangeParam
 		wg.Add(1)
 		go func(queryName string, promQuery *v3.PromQuery) {
 			defer wg.Done()
+			ctx, span := q.tracer.Start(ctx, "runPromQuery", trace.WithAttributes(attrQueryName.String(queryName)))
+			defer span.End()
 			cacheKey, ok := cacheKeys[queryName]
 			var cachedData []byte
 			// Ensure NoCache is not set and cache is not nil
 			if !params.NoCache && q.cache != nil && ok {
 				data, retrieveStatus, err := q.cache.Retrieve(cacheKey, true)
 				zap.L().Info("cache retrieve status", zap.String("status", retrieveStatus.String()))
+				span.SetAttributes(attrCacheStatus.String(retrieveStatus.String()))
 				if err == nil {
 					cachedData = data
 				}
 			}
-			misses, replaceCachedData := q.findMissingTimeRanges(params.Start, params.End, params.Step, cachedData)
+			cachedSeries, misses, replaceCachedData := q.findMissingTimeRanges(cacheKey, params.Start, params.End, params.Step, cachedData)
 			missedSeries := make([]*v3.Series, 0)
-			cachedSeries := make([]*v3.Series, 0)
 			for _, miss := range misses {
 				query := metricsV4.BuildPromQuery(promQuery, params.Step, miss.start, miss.end)
 				series, err := q.execPromQuery(ctx, query)
@@ -383,10 +383,6 @@ func (q *querier) runPromQueries(ctx context.Context, params *v3.QueryRangeParam
 				}
 				missedSeries = append(missedSeries, series...)
 			}
-			if err := json.Unmarshal(cachedData, &cachedSeries); err != nil && cachedData != nil {
-				// ideally we should not be getting an error here
-				zap.L().Error("error unmarshalling cached data", zap.Error(err))
-			}
 			mergedSeries := mergeSerieses(cachedSeries, missedSeries)
 			if replaceCachedData {
 				mergedSeries = missedSeries
@@ -435,7 +431,11 @@ func (q *querier) runPromQueries(ctx context.Context, params *v3.QueryRangeParam
 	return results, errQueriesByName, err
 }
 
-func (q *querier) runClickHouseQueries(ctx context.Context, params *v3.QueryRangeParamsV3) ([]*v3.Result, map[string]error, error) {
+// runClickHouseQueries runs each enabled clickhouse query of the params with exec
+func (q *querier) runClickHouseQueries(ctx context.Context, params *v3.QueryRangeParamsV3, exec func(context.Context, string) ([]*v3.Series, error)) ([]*v3.Result, map[string]error, error) {
+	ctx, span := q.tracer.Start(ctx, "runClickHouseQueries")
+	defer span.End()
+
 	channelResults := make(chan channelResult, len(params.CompositeQuery.ClickHouseQueries))
 	var wg sync.WaitGroup
 	for queryName, clickHouseQuery := range params.CompositeQuery.ClickHouseQueries {
@@ -445,7 +445,9 @@ func (q *querier) runClickHouseQueries(ctx context.Context, params *v3.QueryRang
 		wg.Add(1)
 		go func(queryName string, clickHouseQuery *v3.ClickHouseQuery) {
 			defer wg.Done()
-			series, err := q.execClickHouseQuery(ctx, clickHouseQuery.Query)
+			ctx, span := q.tracer.Start(ctx, "runClickHouseQuery", trace.WithAttributes(attrQueryName.String(queryName)))
+			defer span.End()
+			series, err := exec(ctx, clickHouseQuery.Query)
 			channelResults <- channelResult{Err: err, Name: queryName, Query: clickHouseQuery.Query, Series: series}
 		}(queryName, clickHouseQuery)
 	}
@@ -476,6 +478,8 @@ func (q *querier) runClickHouseQueries(ctx context.Context, params *v3.QueryRang
 }
 
 func (q *querier) runBuilderListQueries(ctx context.Context, params *v3.QueryRangeParamsV3, keys map[string]v3.AttributeKey) ([]*v3.Result, map[string]error, error) {
+	ctx, span := q.tracer.Start(ctx, "runBuilderListQueries")
+	defer span.End()
 
 	queries, err := q.builder.PrepareQueries(params, keys)
 
@@ -490,7 +494,9 @@ func (q *querier) runBuilderListQueries(ctx context.Context, params *v3.QueryRan
 		wg.Add(1)
 		go func(name, query string) {
 			defer wg.Done()
+			ctx, span := q.tracer.Start(ctx, "execListQuery", trace.WithAttributes(attrQueryName.String(name)))
 			rowList, err := q.reader.GetListResultV3(ctx, query)
+			endSpan(span, len(rowList), err)
 
 			if err != nil {
 				ch <- channelResult{Err: fmt.Errorf("error in query-%s: %v", name, err), Name: name, Query: query}
@@ -527,6 +533,22 @@ func (q *querier) runBuilderListQueries(ctx context.Context, params *v3.QueryRan
 // QueryRange is the main function that runs the queries
 // and returns the results
 func (q *querier) QueryRange(ctx context.Context, params *v3.QueryRangeParamsV3, keys map[string]v3.AttributeKey) ([]*v3.Result, map[string]error, error) {
+	ctx, span := q.tracer.Start(ctx, "QueryRange")
+	defer span.End()
+	if params.CompositeQuery != nil {
+		span.SetAttributes(
+			attrQueryType.String(string(params.CompositeQuery.QueryType)),
+			attrPanelType.String(string(params.CompositeQuery.PanelType)),
+		)
+	}
+	// the query builders complete the builder queries, e.g. with the le group by of
+	// the quantiles, and the callers can share the params, so the params are copied
+	params = params.Clone()
+	priority := constants.DefaultQueryPriority
+	if params.Priority != nil {
+		priority = *params.Priority
+	}
+	ctx = context.WithValue(ctx, common.ClickHousePriorityKey, priority)
 	var results []*v3.Result
 	var err error
 	var errQueriesByName map[string]error
@@ -548,21 +570,31 @@ func (q *querier) QueryRange(ctx context.Context, params *v3.QueryRangeParamsV3,
 		case v3.QueryTypePromQL:
 			results, errQueriesByName, err = q.runPromQueries(ctx, params)
 		case v3.QueryTypeClickHouseSQL:
-			results, errQueriesByName, err = q.runClickHouseQueries(ctx, params)
+			results, errQueriesByName, err = q.runClickHouseQueries(ctx, params, q.execClickHouseQuery)
 		default:
 			err = fmt.Errorf("invalid query type")
 		}
 	}
 
-	// return error if the number of series is more than one for value type panel
+	// the series of a value type panel are reduced to one series with the strategy of
+	// the params, an error is returned for more than one series by default
 	if params.CompositeQuery.PanelType == v3.PanelTypeValue {
 		if len(results) > 1 && params.CompositeQuery.EnabledQueries() > 1 {
 			err = fmt.Errorf("there can be only one active query for value type panel")
 		} else if len(results) == 1 && len(results[0].Series) > 1 {
-			err = fmt.Errorf("there can be only one result series for value type panel but got %d", len(results[0].Series))
+			series, reduceErr := common.ReduceValuePanelSeries(results[0].Series, params.ValuePanelMultiSeriesStrategy)
+			if reduceErr != nil {
+				err = reduceErr
+			} else {
+				results[0].Series = series
+			}
 		}
 	}
 
+	for _, result := range results {
+		result.Step = resultStep(params, result.QueryName)
+	}
+
 	return results, errQueriesByName, err
 }
 
@@ -579,3 +611,17 @@ func (q *querier) QueriesExecuted() []string {
 func (q *querier) TimeRanges() [][]int {
 	return q.timeRanges
 }
+
+// resultStep returns the step in seconds the result of the query was computed
+// with, 0 if it is not known
+func resultStep(params *v3.QueryRangeParamsV3, queryName string) int64 {
+	switch params.CompositeQuery.QueryType {
+	case v3.QueryTypeBuilder:
+		if query, ok := params.CompositeQuery.BuilderQueries[queryName]; ok {
+			return query.StepInterval
+		}
+	case v3.QueryTypePromQL:
+		return params.Step
+	}
+	return 0
+}
@@ -7,8 +7,13 @@ import (
 	"testing"
 	"time"
 
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 	"go.signoz.io/signoz/pkg/query-service/app/queryBuilder"
 	"go.signoz.io/signoz/pkg/query-service/cache/inmemory"
+	"go.signoz.io/signoz/pkg/query-service/constants"
+	"go.signoz.io/signoz/pkg/query-service/model"
 	v3 "go.signoz.io/signoz/pkg/query-service/model/v3"
 )
 
@@ -1107,3 +1112,204 @@ func TestV2QueryRangeValueTypePromQL(t *testing.T) {
 		}
 	}
 }
+
+func TestV2QueryRangeTracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tracerProvider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	params := &v3.QueryRangeParamsV3{
+		Start: 1675115596722,
+		End:   1675115596722 + 120*60*1000,
+		Step:  60,
+		CompositeQuery: &v3.CompositeQuery{
+			QueryType: v3.QueryTypePromQL,
+			PanelType: v3.PanelTypeGraph,
+			PromQueries: map[string]*v3.PromQuery{
+				"A": {Query: "signoz_calls_total"},
+			},
+		},
+	}
+	q := NewQuerier(QuerierOptions{
+		Cache:          inmemory.New(&inmemory.Options{TTL: 60 * time.Minute, CleanupInterval: 10 * time.Minute}),
+		Reader:         nil,
+		FluxInterval:   5 * time.Minute,
+		KeyGenerator:   queryBuilder.NewKeyGenerator(),
+		TracerProvider: tracerProvider,
+		TestingMode:    true,
+		ReturnedSeries: []*v3.Series{
+			{
+				Labels: map[string]string{"service_name": "test"},
+				Points: []v3.Point{{Timestamp: 1675115596722, Value: 1}},
+			},
+		},
+	})
+
+	_, _, err := q.QueryRange(context.Background(), params, nil)
+	if err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+
+	spansByName := make(map[string]sdktrace.ReadOnlySpan)
+	for _, span := range recorder.Ended() {
+		spansByName[span.Name()] = span
+	}
+
+	expectedParents := map[string]string{
+		"runPromQueries": "QueryRange",
+		"runPromQuery":   "runPromQueries",
+		"execPromQuery":  "runPromQuery",
+	}
+	for name, parentName := range expectedParents {
+		span, ok := spansByName[name]
+		if !ok {
+			t.Fatalf("expected span %s to be recorded", name)
+		}
+		parent, ok := spansByName[parentName]
+		if !ok {
+			t.Fatalf("expected span %s to be recorded", parentName)
+		}
+		if span.Parent().SpanID() != parent.SpanContext().SpanID() {
+			t.Errorf("expected span %s to be a child of %s", name, parentName)
+		}
+	}
+
+	hasAttribute := func(span sdktrace.ReadOnlySpan, expected attribute.KeyValue) bool {
+		for _, attr := range span.Attributes() {
+			if attr == expected {
+				return true
+			}
+		}
+		return false
+	}
+	expectedAttributes := map[string][]attribute.KeyValue{
+		"QueryRange":    {attrQueryType.String(string(v3.QueryTypePromQL)), attrPanelType.String(string(v3.PanelTypeGraph))},
+		"runPromQuery":  {attrQueryName.String("A"), attrCacheStatus.String("key miss")},
+		"execPromQuery": {attrSeriesCount.Int(1)},
+	}
+	for name, attrs := range expectedAttributes {
+		for _, attr := range attrs {
+			if !hasAttribute(spansByName[name], attr) {
+				t.Errorf("expected span %s to have attribute %v, got %v", name, attr, spansByName[name].Attributes())
+			}
+		}
+	}
+}
+
+func TestV2QueryRangeCacheKeysNamespacedByTenant(t *testing.T) {
+	params := &v3.QueryRangeParamsV3{
+		Start: 1675115596722,
+		End:   1675115596722 + 120*60*1000,
+		Step:  60,
+		CompositeQuery: &v3.CompositeQuery{
+			QueryType: v3.QueryTypePromQL,
+			PanelType: v3.PanelTypeGraph,
+			PromQueries: map[string]*v3.PromQuery{
+				"A": {Query: "signoz_calls_total"},
+			},
+		},
+	}
+	tenantCtx := func(orgID string) context.Context {
+		user := &model.UserPayload{User: model.User{OrgId: orgID}}
+		return context.WithValue(context.Background(), constants.ContextUserKey, user)
+	}
+	ctxA, ctxB := tenantCtx("org-a"), tenantCtx("org-b")
+
+	q := NewQuerier(QuerierOptions{
+		Cache:        inmemory.New(&inmemory.Options{TTL: 60 * time.Minute, CleanupInterval: 10 * time.Minute}),
+		Reader:       nil,
+		FluxInterval: 5 * time.Minute,
+		KeyGenerator: queryBuilder.NewKeyGenerator(),
+		TestingMode:  true,
+		ReturnedSeries: []*v3.Series{
+			{
+				Labels: map[string]string{"service_name": "test"},
+				Points: []v3.Point{
+					{Timestamp: 1675115596722, Value: 1},
+					{Timestamp: 1675115596722 + 120*60*1000, Value: 1},
+				},
+			},
+		},
+	})
+
+	keysA := q.(*querier).generateCacheKeys(ctxA, params)
+	keysB := q.(*querier).generateCacheKeys(ctxB, params)
+	if keysA["A"] == keysB["A"] {
+		t.Fatalf("expected distinct cache keys for distinct tenants, got %s", keysA["A"])
+	}
+
+	for _, ctx := range []context.Context{ctxA, ctxB} {
+		_, _, err := q.QueryRange(ctx, params, nil)
+		if err != nil {
+			t.Fatalf("expected no error, got %s", err)
+		}
+	}
+	// the second tenant must not be served the first tenant's cached data
+	if len(q.TimeRanges()) != 2 {
+		t.Fatalf("expected two fetches, got %v", q.TimeRanges())
+	}
+	for _, timeRange := range q.TimeRanges() {
+		if timeRange[0] != int(params.Start) || timeRange[1] != int(params.End) {
+			t.Errorf("expected full range fetch %d-%d, got %v", params.Start, params.End, timeRange)
+		}
+	}
+}
+
+func TestV2QueryRangeValuePanelMultiSeriesStrategy(t *testing.T) {
+	end := int64(1675115596722)
+	q := NewQuerier(QuerierOptions{
+		Reader:       nil,
+		KeyGenerator: queryBuilder.NewKeyGenerator(),
+		TestingMode:  true,
+		ReturnedSeries: []*v3.Series{
+			{Labels: map[string]string{"service_name": "redis"}, Points: []v3.Point{{Timestamp: end, Value: 5}}},
+			{Labels: map[string]string{"service_name": "cart"}, Points: []v3.Point{{Timestamp: end, Value: 2}}},
+		},
+	})
+
+	for _, tc := range []struct {
+		strategy       v3.ValuePanelMultiSeriesStrategy
+		expectedLabels map[string]string
+		expectedValue  float64
+		expectedErr    bool
+	}{
+		{strategy: "", expectedErr: true},
+		{strategy: v3.ValuePanelMultiSeriesStrategyError, expectedErr: true},
+		{strategy: v3.ValuePanelMultiSeriesStrategyFirst, expectedLabels: map[string]string{"service_name": "cart"}, expectedValue: 2},
+		{strategy: v3.ValuePanelMultiSeriesStrategySum, expectedLabels: map[string]string{}, expectedValue: 7},
+		{strategy: v3.ValuePanelMultiSeriesStrategyMax, expectedLabels: map[string]string{}, expectedValue: 5},
+	} {
+		t.Run(string(tc.strategy), func(t *testing.T) {
+			params := &v3.QueryRangeParamsV3{
+				Start: end - 60*60*1000,
+				End:   end,
+				Step:  60,
+				CompositeQuery: &v3.CompositeQuery{
+					QueryType:         v3.QueryTypeClickHouseSQL,
+					PanelType:         v3.PanelTypeValue,
+					ClickHouseQueries: map[string]*v3.ClickHouseQuery{"A": {Query: "SELECT service_name, value FROM metrics"}},
+				},
+				ValuePanelMultiSeriesStrategy: tc.strategy,
+			}
+			results, _, err := q.QueryRange(context.Background(), params, nil)
+			if tc.expectedErr {
+				if err == nil || !strings.Contains(err.Error(), "only one result series") {
+					t.Errorf("expected the only one result series error, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected no error, got %s", err)
+			}
+			if len(results) != 1 || len(results[0].Series) != 1 {
+				t.Fatalf("expected a single series, got %v", results)
+			}
+			series := results[0].Series[0]
+			if fmt.Sprint(series.Labels) != fmt.Sprint(tc.expectedLabels) {
+				t.Errorf("expected the labels %v, got %v", tc.expectedLabels, series.Labels)
+			}
+			if len(series.Points) != 1 || series.Points[0].Value != tc.expectedValue {
+				t.Errorf("expected the value %v, got %v", tc.expectedValue, series.Points)
+			}
+		})
+	}
+}
@@ -0,0 +1,24 @@
+package v2
+
+import (
+	"context"
+	"fmt"
+
+	v3 "go.signoz.io/signoz/pkg/query-service/model/v3"
+)
+
+// ValidateClickHouseQueries checks the syntax of the enabled clickhouse queries of
+// the params without running them. The errors are returned by query name
+func (q *querier) ValidateClickHouseQueries(ctx context.Context, params *v3.QueryRangeParamsV3) (map[string]error, error) {
+	if params.CompositeQuery.QueryType != v3.QueryTypeClickHouseSQL {
+		return nil, fmt.Errorf("validation is not supported for %s queries", params.CompositeQuery.QueryType)
+	}
+	_, errQueriesByName, _ := q.runClickHouseQueries(ctx, params, q.validateClickHouseQuery)
+	return errQueriesByName, nil
+}
+
+// validateClickHouseQuery is used in place of execClickHouseQuery to validate
+// the query instead of running it
+func (q *querier) validateClickHouseQuery(ctx context.Context, query string) ([]*v3.Series, error) {
+	return nil, q.reader.ValidateQuery(ctx, query)
+}
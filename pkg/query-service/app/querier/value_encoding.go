@@ -0,0 +1,28 @@
+package querier
+
+import (
+	v3 "go.signoz.io/signoz/pkg/query-service/model/v3"
+)
+
+// encodeIntValues encodes the integer point values of the series of the builder
+// queries with ValueEncodingInt64 as JSON numbers. The points are new points, the
+// merged series are shared with the cache
+func encodeIntValues(results []*v3.Result, builderQueries map[string]*v3.BuilderQuery) {
+	for _, result := range results {
+		builderQuery, ok := builderQueries[result.QueryName]
+		if !ok || builderQuery.ValueEncoding != v3.ValueEncodingInt64 {
+			continue
+		}
+		seriesList := make([]*v3.Series, 0, len(result.Series))
+		for _, series := range result.Series {
+			encoded := *series
+			encoded.Points = make([]v3.Point, len(series.Points))
+			for idx, point := range series.Points {
+				point.IntEncoded = true
+				encoded.Points[idx] = point
+			}
+			seriesList = append(seriesList, &encoded)
+		}
+		result.Series = seriesList
+	}
+}
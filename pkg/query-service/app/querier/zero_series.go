@@ -0,0 +1,36 @@
+package querier
+
+import (
+	"math"
+
+	v3 "go.signoz.io/signoz/pkg/query-service/model/v3"
+)
+
+// dropZeroSeries drops the series of the builder queries with DropZeroSeries whose
+// every point value is zero or NaN, including the series without points. It is
+// applied to the returned series after they are merged and cached, so the dropped
+// series are still merged with the next fetched points
+func dropZeroSeries(results []*v3.Result, builderQueries map[string]*v3.BuilderQuery) {
+	for _, result := range results {
+		builderQuery, ok := builderQueries[result.QueryName]
+		if !ok || !builderQuery.DropZeroSeries {
+			continue
+		}
+		seriesList := make([]*v3.Series, 0, len(result.Series))
+		for _, series := range result.Series {
+			if !isZeroSeries(series) {
+				seriesList = append(seriesList, series)
+			}
+		}
+		result.Series = seriesList
+	}
+}
+
+func isZeroSeries(series *v3.Series) bool {
+	for _, point := range series.Points {
+		if point.Value != 0 && !math.IsNaN(point.Value) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,115 @@
+package queryBuilder
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	v3 "go.signoz.io/signoz/pkg/query-service/model/v3"
+)
+
+// keyConstraint is what the filters of a filter set require of the values of a key
+type keyConstraint struct {
+	// allowed are the values the key can take, nil means any value
+	allowed map[string]struct{}
+	// excluded are the values the key can't take
+	excluded  map[string]struct{}
+	exists    bool
+	notExists bool
+}
+
+// ContradictoryFilters returns true if the items of the filter set, which are
+// ANDed, can never all match, e.g. a key equal to two different values, a key
+// in an empty list, or a key both required to exist and not to exist. Only the
+// equality, membership and existence operators are checked, the other items are
+// assumed to match
+func ContradictoryFilters(filters *v3.FilterSet) bool {
+	if filters == nil || strings.EqualFold(filters.Operator, "OR") {
+		return false
+	}
+
+	constraints := make(map[string]*keyConstraint)
+	for _, item := range filters.Items {
+		key := item.Key.Key + "." + string(item.Key.Type)
+		constraint, ok := constraints[key]
+		if !ok {
+			constraint = &keyConstraint{excluded: make(map[string]struct{})}
+			constraints[key] = constraint
+		}
+
+		switch v3.FilterOperator(strings.ToLower(strings.TrimSpace(string(item.Operator)))) {
+		case v3.FilterOperatorEqual:
+			if values := filterValues(item.Value); len(values) == 1 {
+				constraint.allow(values)
+			}
+		case v3.FilterOperatorIn:
+			constraint.allow(filterValues(item.Value))
+		case v3.FilterOperatorNotEqual, v3.FilterOperatorNotIn:
+			for _, value := range filterValues(item.Value) {
+				constraint.excluded[value] = struct{}{}
+			}
+		case v3.FilterOperatorExists:
+			constraint.exists = true
+		case v3.FilterOperatorNotExists:
+			constraint.notExists = true
+		}
+	}
+
+	for _, constraint := range constraints {
+		if constraint.contradictory() {
+			return true
+		}
+	}
+	return false
+}
+
+// allow intersects the allowed values of the key with the values
+func (c *keyConstraint) allow(values []string) {
+	allowed := make(map[string]struct{}, len(values))
+	for _, value := range values {
+		if _, ok := c.allowed[value]; c.allowed == nil || ok {
+			allowed[value] = struct{}{}
+		}
+	}
+	c.allowed = allowed
+}
+
+func (c *keyConstraint) contradictory() bool {
+	if c.exists && c.notExists {
+		return true
+	}
+	if c.allowed == nil {
+		return false
+	}
+	for value := range c.allowed {
+		if _, ok := c.excluded[value]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// filterValues returns the values of a filter item as strings, the elements of
+// a list value or the value itself. An empty list value has no values
+func filterValues(value interface{}) []string {
+	v := reflect.ValueOf(value)
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+		return []string{filterValueString(value)}
+	}
+	values := make([]string, 0, v.Len())
+	for idx := 0; idx < v.Len(); idx++ {
+		values = append(values, filterValueString(v.Index(idx).Interface()))
+	}
+	return values
+}
+
+// filterValueString returns the value as a string, the numbers in a canonical
+// form so that e.g. 5, 5.0 and "5" are the same value
+func filterValueString(value interface{}) string {
+	s := fmt.Sprint(value)
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		return strconv.FormatFloat(f, 'g', -1, 64)
+	}
+	return s
+}
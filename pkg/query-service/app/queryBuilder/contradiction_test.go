@@ -0,0 +1,118 @@
+package queryBuilder
+
+import (
+	"testing"
+
+	v3 "go.signoz.io/signoz/pkg/query-service/model/v3"
+)
+
+func TestContradictoryFilters(t *testing.T) {
+	service := v3.AttributeKey{Key: "service.name", DataType: v3.AttributeKeyDataTypeString, Type: v3.AttributeKeyTypeResource}
+	status := v3.AttributeKey{Key: "status_code", DataType: v3.AttributeKeyDataTypeInt64, Type: v3.AttributeKeyTypeTag}
+
+	tests := []struct {
+		name    string
+		filters *v3.FilterSet
+		want    bool
+	}{
+		{
+			name:    "no filters",
+			filters: nil,
+			want:    false,
+		},
+		{
+			name: "conflicting equals",
+			filters: &v3.FilterSet{Operator: "AND", Items: []v3.FilterItem{
+				{Key: service, Value: "cart", Operator: v3.FilterOperatorEqual},
+				{Key: service, Value: "checkout", Operator: v3.FilterOperatorEqual},
+			}},
+			want: true,
+		},
+		{
+			name: "same equals",
+			filters: &v3.FilterSet{Operator: "AND", Items: []v3.FilterItem{
+				{Key: service, Value: "cart", Operator: v3.FilterOperatorEqual},
+				{Key: service, Value: "cart", Operator: "="},
+			}},
+			want: false,
+		},
+		{
+			name: "equals on different keys",
+			filters: &v3.FilterSet{Operator: "AND", Items: []v3.FilterItem{
+				{Key: service, Value: "cart", Operator: v3.FilterOperatorEqual},
+				{Key: v3.AttributeKey{Key: "service.name", Type: v3.AttributeKeyTypeTag}, Value: "checkout", Operator: v3.FilterOperatorEqual},
+			}},
+			want: false,
+		},
+		{
+			name: "in with empty list",
+			filters: &v3.FilterSet{Operator: "AND", Items: []v3.FilterItem{
+				{Key: service, Value: []interface{}{}, Operator: v3.FilterOperatorIn},
+			}},
+			want: true,
+		},
+		{
+			name: "equals not in the in list",
+			filters: &v3.FilterSet{Operator: "AND", Items: []v3.FilterItem{
+				{Key: service, Value: "cart", Operator: v3.FilterOperatorEqual},
+				{Key: service, Value: []interface{}{"checkout", "search"}, Operator: "IN"},
+			}},
+			want: true,
+		},
+		{
+			name: "in lists with a common value",
+			filters: &v3.FilterSet{Operator: "AND", Items: []v3.FilterItem{
+				{Key: service, Value: []interface{}{"cart", "search"}, Operator: v3.FilterOperatorIn},
+				{Key: service, Value: []interface{}{"checkout", "search"}, Operator: v3.FilterOperatorIn},
+			}},
+			want: false,
+		},
+		{
+			name: "equals and not equals the same value",
+			filters: &v3.FilterSet{Operator: "AND", Items: []v3.FilterItem{
+				{Key: status, Value: 500, Operator: v3.FilterOperatorEqual},
+				{Key: status, Value: "500", Operator: v3.FilterOperatorNotEqual},
+			}},
+			want: true,
+		},
+		{
+			name: "in values all excluded",
+			filters: &v3.FilterSet{Operator: "AND", Items: []v3.FilterItem{
+				{Key: status, Value: []interface{}{500, 503}, Operator: v3.FilterOperatorIn},
+				{Key: status, Value: []interface{}{500.0, 503}, Operator: v3.FilterOperatorNotIn},
+			}},
+			want: true,
+		},
+		{
+			name: "exists and not exists",
+			filters: &v3.FilterSet{Operator: "AND", Items: []v3.FilterItem{
+				{Key: service, Operator: v3.FilterOperatorExists},
+				{Key: service, Operator: v3.FilterOperatorNotExists},
+			}},
+			want: true,
+		},
+		{
+			name: "other operators are assumed to match",
+			filters: &v3.FilterSet{Operator: "AND", Items: []v3.FilterItem{
+				{Key: status, Value: 500, Operator: v3.FilterOperatorEqual},
+				{Key: status, Value: 400, Operator: v3.FilterOperatorLessThan},
+			}},
+			want: false,
+		},
+		{
+			name: "or filters",
+			filters: &v3.FilterSet{Operator: "OR", Items: []v3.FilterItem{
+				{Key: service, Value: "cart", Operator: v3.FilterOperatorEqual},
+				{Key: service, Value: "checkout", Operator: v3.FilterOperatorEqual},
+			}},
+			want: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ContradictoryFilters(tt.filters); got != tt.want {
+				t.Errorf("ContradictoryFilters() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
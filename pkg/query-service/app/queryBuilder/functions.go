@@ -86,6 +86,27 @@ func funcRunningDiff(result *v3.Result) *v3.Result {
 	return result
 }
 
+// funcDelta converts the cumulative counter values to the difference between
+// consecutive points. A decrease is treated as a counter reset, the value after
+// the reset is the delta in that case.
+func funcDelta(result *v3.Result) *v3.Result {
+	for _, series := range result.Series {
+		if len(series.Points) == 0 {
+			continue
+		}
+		// iterate over the point in reverse order
+		for idx := len(series.Points) - 1; idx > 0; idx-- {
+			current, previous := series.Points[idx].Value, series.Points[idx-1].Value
+			if current >= previous {
+				series.Points[idx].Value = current - previous
+			}
+		}
+		// remove the first point, there is no previous value to compute its delta
+		series.Points = series.Points[1:]
+	}
+	return result
+}
+
 // funcLog2 returns the log2 of each point
 func funcLog2(result *v3.Result) *v3.Result {
 	for _, series := range result.Series {
@@ -274,6 +295,8 @@ func ApplyFunction(fn v3.Function, result *v3.Result) *v3.Result {
 		return funcAbsolute(result)
 	case v3.FunctionNameRunningDiff:
 		return funcRunningDiff(result)
+	case v3.FunctionNameDelta:
+		return funcDelta(result)
 	case v3.FunctionNameLog2:
 		return funcLog2(result)
 	case v3.FunctionNameLog10:
@@ -305,6 +328,12 @@ func ApplyFunction(fn v3.Function, result *v3.Result) *v3.Result {
 			return result
 		}
 		return funcTimeShift(result, shift)
+	case v3.FunctionNameCrossSeriesQuantile:
+		quantile, ok := fn.Args[0].(float64)
+		if !ok {
+			return result
+		}
+		return funcCrossSeriesQuantile(result, quantile)
 	}
 	return result
 }
@@ -317,3 +346,37 @@ func funcTimeShift(result *v3.Result, shift float64) *v3.Result {
 	}
 	return result
 }
+
+// funcCrossSeriesQuantile replaces the series with a single series whose value at each
+// timestamp is the quantile of the values of all the series at that timestamp
+// The quantile is computed with linear interpolation between the closest ranks
+func funcCrossSeriesQuantile(result *v3.Result, quantile float64) *v3.Result {
+	valuesByTimestamp := make(map[int64][]float64)
+	for _, series := range result.Series {
+		for _, point := range series.Points {
+			if math.IsNaN(point.Value) {
+				continue
+			}
+			valuesByTimestamp[point.Timestamp] = append(valuesByTimestamp[point.Timestamp], point.Value)
+		}
+	}
+
+	quantileSeries := &v3.Series{Labels: map[string]string{}, Points: make([]v3.Point, 0, len(valuesByTimestamp))}
+	for timestamp, values := range valuesByTimestamp {
+		quantileSeries.Points = append(quantileSeries.Points, v3.Point{Timestamp: timestamp, Value: quantileOf(values, quantile)})
+	}
+	quantileSeries.SortPoints()
+
+	result.Series = []*v3.Series{quantileSeries}
+	return result
+}
+
+// quantileOf returns the quantile of the values, the values are sorted in place
+func quantileOf(values []float64, quantile float64) float64 {
+	sort.Float64s(values)
+	rank := quantile * float64(len(values)-1)
+	lower := math.Floor(rank)
+	upper := math.Ceil(rank)
+	weight := rank - lower
+	return values[int(lower)]*(1-weight) + values[int(upper)]*weight
+}
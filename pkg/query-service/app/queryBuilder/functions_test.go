@@ -1,6 +1,7 @@
 package queryBuilder
 
 import (
+	"fmt"
 	"math"
 	"testing"
 
@@ -669,3 +670,94 @@ func TestFuncRunningDiff(t *testing.T) {
 		})
 	}
 }
+
+func TestFuncDelta(t *testing.T) {
+	tests := []struct {
+		name   string
+		points []v3.Point
+		want   []v3.Point
+	}{
+		{
+			name:   "monotonic counter",
+			points: []v3.Point{{Timestamp: 1, Value: 10}, {Timestamp: 2, Value: 15}, {Timestamp: 3, Value: 15}, {Timestamp: 4, Value: 22}},
+			want:   []v3.Point{{Timestamp: 2, Value: 5}, {Timestamp: 3, Value: 0}, {Timestamp: 4, Value: 7}},
+		},
+		{
+			name:   "counter reset",
+			points: []v3.Point{{Timestamp: 1, Value: 10}, {Timestamp: 2, Value: 20}, {Timestamp: 3, Value: 4}, {Timestamp: 4, Value: 9}},
+			want:   []v3.Point{{Timestamp: 2, Value: 10}, {Timestamp: 3, Value: 4}, {Timestamp: 4, Value: 5}},
+		},
+		{
+			name:   "no points",
+			points: []v3.Point{},
+			want:   []v3.Point{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := funcDelta(&v3.Result{Series: []*v3.Series{{Points: tt.points}}})
+			points := got.Series[0].Points
+			if len(points) != len(tt.want) {
+				t.Fatalf("funcDelta() = len(points) %v, want %v", len(points), len(tt.want))
+			}
+			for k, point := range points {
+				if point != tt.want[k] {
+					t.Errorf("funcDelta() = %v, want %v", point, tt.want[k])
+				}
+			}
+		})
+	}
+}
+
+func TestFuncCrossSeriesQuantile(t *testing.T) {
+	// five series with values 1..5 at the first timestamp and 10..50 at the second
+	result := &v3.Result{}
+	for idx := 1; idx <= 5; idx++ {
+		result.Series = append(result.Series, &v3.Series{
+			Labels: map[string]string{"pod": fmt.Sprintf("pod-%d", idx)},
+			Points: []v3.Point{
+				{Timestamp: 1, Value: float64(idx)},
+				{Timestamp: 2, Value: float64(idx * 10)},
+			},
+		})
+	}
+
+	tests := []struct {
+		name     string
+		quantile float64
+		want     []v3.Point
+	}{
+		{
+			name:     "p50",
+			quantile: 0.5,
+			want:     []v3.Point{{Timestamp: 1, Value: 3}, {Timestamp: 2, Value: 30}},
+		},
+		{
+			name:     "p95",
+			quantile: 0.95,
+			want:     []v3.Point{{Timestamp: 1, Value: 4.8}, {Timestamp: 2, Value: 48}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input := &v3.Result{}
+			for _, series := range result.Series {
+				input.Series = append(input.Series, &v3.Series{Labels: series.Labels, Points: append([]v3.Point{}, series.Points...)})
+			}
+			got := ApplyFunction(v3.Function{Name: v3.FunctionNameCrossSeriesQuantile, Args: []interface{}{tt.quantile}}, input)
+			if len(got.Series) != 1 {
+				t.Fatalf("expected one series, got %d", len(got.Series))
+			}
+			if len(got.Series[0].Points) != len(tt.want) {
+				t.Fatalf("expected %d points, got %d", len(tt.want), len(got.Series[0].Points))
+			}
+			for idx, point := range got.Series[0].Points {
+				if point.Timestamp != tt.want[idx].Timestamp || math.Abs(point.Value-tt.want[idx].Value) > 1e-9 {
+					t.Errorf("expected %v at index %d, got %v", tt.want[idx], idx, point)
+				}
+			}
+		})
+	}
+}
@@ -12,6 +12,7 @@ import (
 	"go.signoz.io/signoz/pkg/query-service/constants"
 	"go.signoz.io/signoz/pkg/query-service/interfaces"
 	v3 "go.signoz.io/signoz/pkg/query-service/model/v3"
+	"go.signoz.io/signoz/pkg/query-service/utils"
 	"go.uber.org/zap"
 )
 
@@ -173,6 +174,31 @@ func (qb *QueryBuilder) PrepareLiveTailQuery(params *v3.QueryRangeParamsV3) (str
 	return queryStr, nil
 }
 
+// buildMetricQuery builds the query of the metrics query. With additional metrics, the
+// query of each of the metrics is built with the aggregation of the query and the
+// queries are unioned, with the series of each metric labelled with its __name__
+func (qb *QueryBuilder) buildMetricQuery(start, end int64, queryType v3.QueryType, panelType v3.PanelType, query *v3.BuilderQuery, options metricsV3.Options) (string, error) {
+	if len(query.AdditionalMetrics) == 0 {
+		return qb.options.BuildMetricQuery(start, end, queryType, panelType, query, options)
+	}
+
+	metrics := append([]v3.AttributeKey{query.AggregateAttribute}, query.AdditionalMetrics...)
+	subQueries := make([]string, 0, len(metrics))
+	for _, metric := range metrics {
+		// the metric query builders complete the query they are given, e.g. with
+		// the le group by of the quantiles, so each metric is built from its own copy
+		metricQuery := query.Clone()
+		metricQuery.AggregateAttribute = metric
+		metricQuery.AdditionalMetrics = nil
+		subQuery, err := qb.options.BuildMetricQuery(start, end, queryType, panelType, metricQuery, options)
+		if err != nil {
+			return "", err
+		}
+		subQueries = append(subQueries, fmt.Sprintf("SELECT %s as __name__, * FROM (%s)", utils.ClickHouseFormattedValue(metric.Key), subQuery))
+	}
+	return strings.Join(subQueries, " UNION ALL "), nil
+}
+
 func (qb *QueryBuilder) PrepareQueries(params *v3.QueryRangeParamsV3, args ...interface{}) (map[string]string, error) {
 	queries := make(map[string]string)
 
@@ -240,7 +266,7 @@ func (qb *QueryBuilder) PrepareQueries(params *v3.QueryRangeParamsV3, args ...in
 						queries[queryName] = queryString
 					}
 				case v3.DataSourceMetrics:
-					queryString, err := qb.options.BuildMetricQuery(start, end, compositeQuery.QueryType, compositeQuery.PanelType, query, metricsV3.Options{PreferRPM: PreferRPMFeatureEnabled})
+					queryString, err := qb.buildMetricQuery(start, end, compositeQuery.QueryType, compositeQuery.PanelType, query, metricsV3.Options{PreferRPM: PreferRPMFeatureEnabled})
 					if err != nil {
 						return nil, err
 					}
@@ -331,12 +357,13 @@ func (c *cacheKeyGenerator) GenerateKeys(params *v3.QueryRangeParamsV3) map[stri
 		for name, query := range params.CompositeQuery.PromQueries {
 			keys[name] = query.Query
 		}
-		return keys
+		return withCacheTagSuffix(keys, params.CacheTagSuffix)
 	}
 
 	// Build keys for each builder query
 	for queryName, query := range params.CompositeQuery.BuilderQueries {
-		if query.Expression == queryName && query.DataSource == v3.DataSourceLogs {
+		// the counts and the bands of the points and the heatmaps are not merged with the cached points
+		if query.Expression == queryName && query.DataSource == v3.DataSourceLogs && !query.IncludeCount && !query.IncludeBand && query.Heatmap == nil {
 
 			if params.CompositeQuery.PanelType != v3.PanelTypeGraph {
 				continue
@@ -354,16 +381,30 @@ func (c *cacheKeyGenerator) GenerateKeys(params *v3.QueryRangeParamsV3) map[stri
 				parts = append(parts, fmt.Sprintf("shiftBy=%d", query.ShiftBy))
 			}
 
+			if query.AlignmentOffset != 0 {
+				parts = append(parts, fmt.Sprintf("alignmentOffset=%d", query.AlignmentOffset))
+			}
+
 			if query.AggregateAttribute.Key != "" {
 				parts = append(parts, fmt.Sprintf("aggregateAttribute=%s", query.AggregateAttribute.CacheKey()))
 			}
 
+			if query.ArrayAggregation != "" {
+				parts = append(parts, fmt.Sprintf("arrayAggregation=%s", query.ArrayAggregation))
+			}
+
 			if query.Filters != nil && len(query.Filters.Items) > 0 {
 				for idx, filter := range query.Filters.Items {
 					parts = append(parts, fmt.Sprintf("filter-%d=%s", idx, filter.CacheKey()))
 				}
 			}
 
+			if query.AggregateFilters != nil && len(query.AggregateFilters.Items) > 0 {
+				for idx, filter := range query.AggregateFilters.Items {
+					parts = append(parts, fmt.Sprintf("aggregateFilter-%d=%s", idx, filter.CacheKey()))
+				}
+			}
+
 			if len(query.GroupBy) > 0 {
 				for idx, groupBy := range query.GroupBy {
 					parts = append(parts, fmt.Sprintf("groupBy-%d=%s", idx, groupBy.CacheKey()))
@@ -419,6 +460,10 @@ func (c *cacheKeyGenerator) GenerateKeys(params *v3.QueryRangeParamsV3) map[stri
 				parts = append(parts, fmt.Sprintf("aggregateAttribute=%s", query.AggregateAttribute.CacheKey()))
 			}
 
+			for idx, metric := range query.AdditionalMetrics {
+				parts = append(parts, fmt.Sprintf("additionalMetric-%d=%s", idx, metric.CacheKey()))
+			}
+
 			if query.Filters != nil && len(query.Filters.Items) > 0 {
 				for idx, filter := range query.Filters.Items {
 					parts = append(parts, fmt.Sprintf("filter-%d=%s", idx, filter.CacheKey()))
@@ -456,6 +501,17 @@ func (c *cacheKeyGenerator) GenerateKeys(params *v3.QueryRangeParamsV3) map[stri
 		}
 	}
 
+	return withCacheTagSuffix(keys, params.CacheTagSuffix)
+}
+
+// withCacheTagSuffix appends the cache tag suffix given by the caller to the keys
+func withCacheTagSuffix(keys map[string]string, suffix string) map[string]string {
+	if suffix == "" {
+		return keys
+	}
+	for name, key := range keys {
+		keys[name] = fmt.Sprintf("%s&cacheTag=%s", key, suffix)
+	}
 	return keys
 }
 
@@ -63,6 +63,52 @@ func TestBuildQueryWithMultipleQueriesAndFormula(t *testing.T) {
 	})
 }
 
+func TestBuildQueryWithAdditionalMetrics(t *testing.T) {
+	q := &v3.QueryRangeParamsV3{
+		Start: 1650991982000,
+		End:   1651078382000,
+		CompositeQuery: &v3.CompositeQuery{
+			PanelType: v3.PanelTypeGraph,
+			BuilderQueries: map[string]*v3.BuilderQuery{
+				"A": {
+					QueryName:          "A",
+					StepInterval:       60,
+					DataSource:         v3.DataSourceMetrics,
+					AggregateAttribute: v3.AttributeKey{Key: "system_cpu_usage"},
+					AdditionalMetrics:  []v3.AttributeKey{{Key: "system_memory_usage"}},
+					GroupBy:            []v3.AttributeKey{{Key: "host_name"}},
+					AggregateOperator:  v3.AggregateOperatorHistQuant95,
+					Temporality:        v3.Cumulative,
+					Expression:         "A",
+				},
+			},
+		},
+	}
+	qbOptions := QueryBuilderOptions{
+		BuildMetricQuery: metricsv3.PrepareMetricQuery,
+	}
+	qb := NewQueryBuilder(qbOptions, featureManager.StartManager())
+
+	queries, err := qb.PrepareQueries(q)
+	require.NoError(t, err)
+
+	subQueries := strings.Split(queries["A"], " UNION ALL ")
+	require.Len(t, subQueries, 2)
+	require.True(t, strings.HasPrefix(subQueries[0], "SELECT 'system_cpu_usage' as __name__, * FROM ("))
+	require.Contains(t, subQueries[0], "metric_name = 'system_cpu_usage'")
+	require.NotContains(t, subQueries[0], "system_memory_usage")
+	require.True(t, strings.HasPrefix(subQueries[1], "SELECT 'system_memory_usage' as __name__, * FROM ("))
+	require.Contains(t, subQueries[1], "metric_name = 'system_memory_usage'")
+
+	// the query is not completed with the le group by of the quantiles
+	require.Equal(t, []v3.AttributeKey{{Key: "host_name"}}, q.CompositeQuery.BuilderQueries["A"].GroupBy)
+
+	// the additional metrics are part of the cache key
+	keys := NewKeyGenerator().GenerateKeys(q)
+	q.CompositeQuery.BuilderQueries["A"].AdditionalMetrics = nil
+	require.NotEqual(t, keys["A"], NewKeyGenerator().GenerateKeys(q)["A"])
+}
+
 func TestBuildQueryWithIncorrectQueryRef(t *testing.T) {
 	t.Run("TestBuildQueryWithFilters", func(t *testing.T) {
 		q := &v3.QueryRangeParamsV3{
@@ -1098,6 +1144,51 @@ func TestGenerateCacheKeysLogs(t *testing.T) {
 				"A": "source=logs&step=60&aggregate=count&limit=0&aggregateAttribute=log_level---false&filter-0=key:service_name---false,op:=,value:A&groupBy-0=service_name---false&groupBy-1=log_level---false&orderBy-0=#SIGNOZ_VALUE-desc&having-0=column:value,op:>,value:100",
 			},
 		},
+		{
+			name: "panelType=graph;dataSource=logs;queryType=builder;arrayAggregation",
+			query: &v3.QueryRangeParamsV3{
+				CompositeQuery: &v3.CompositeQuery{
+					PanelType: v3.PanelTypeGraph,
+					QueryType: v3.QueryTypeBuilder,
+					BuilderQueries: map[string]*v3.BuilderQuery{
+						"A": {
+							QueryName:          "A",
+							StepInterval:       60,
+							DataSource:         v3.DataSourceLogs,
+							AggregateOperator:  v3.AggregateOperatorCount,
+							AggregateAttribute: v3.AttributeKey{Key: "body.values[*]", DataType: v3.AttributeKeyDataTypeArrayString, IsJSON: true},
+							ArrayAggregation:   v3.ArrayAggregationJoin,
+							Expression:         "A",
+						},
+					},
+				},
+			},
+			expectedCacheKeys: map[string]string{
+				"A": "source=logs&step=60&aggregate=count&limit=0&aggregateAttribute=body.values[*]-array(string)--false&arrayAggregation=arrayJoin",
+			},
+		},
+		{
+			name: "panelType=graph;dataSource=logs;queryType=builder;alignmentOffset",
+			query: &v3.QueryRangeParamsV3{
+				CompositeQuery: &v3.CompositeQuery{
+					PanelType: v3.PanelTypeGraph,
+					QueryType: v3.QueryTypeBuilder,
+					BuilderQueries: map[string]*v3.BuilderQuery{
+						"A": {
+							QueryName:         "A",
+							StepInterval:      3600,
+							DataSource:        v3.DataSourceLogs,
+							AggregateOperator: v3.AggregateOperatorCount,
+							Expression:        "A",
+							AlignmentOffset:   1800,
+						},
+					},
+				},
+			},
+			expectedCacheKeys: map[string]string{
+				"A": "source=logs&step=3600&aggregate=count&limit=0&alignmentOffset=1800",
+			},
+		},
 		{
 			name: "panelType=table;dataSource=logs;queryType=builder",
 			query: &v3.QueryRangeParamsV3{
@@ -1218,6 +1309,24 @@ func TestGenerateCacheKeysMetricsPromQL(t *testing.T) {
 				"A": "histogram_quantile(0.9, sum(rate(signoz_latency_bucket[1m])) by (le))",
 			},
 		},
+		{
+			name: "panelType=graph;dataSource=metrics;queryType=promql;cacheTagSuffix",
+			query: &v3.QueryRangeParamsV3{
+				CacheTagSuffix: "service=frontend",
+				CompositeQuery: &v3.CompositeQuery{
+					PanelType: v3.PanelTypeGraph,
+					QueryType: v3.QueryTypePromQL,
+					PromQueries: map[string]*v3.PromQuery{
+						"A": {
+							Query: "signoz_latency_bucket",
+						},
+					},
+				},
+			},
+			expectedCacheKeys: map[string]string{
+				"A": "signoz_latency_bucket&cacheTag=service=frontend",
+			},
+		},
 		{
 			name: "panelType=value;dataSource=metrics;queryType=promql",
 			query: &v3.QueryRangeParamsV3{
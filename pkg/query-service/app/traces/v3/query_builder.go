@@ -3,6 +3,7 @@ package v3
 import (
 	"fmt"
 	"math"
+	"slices"
 	"strings"
 
 	"go.signoz.io/signoz/pkg/query-service/constants"
@@ -101,6 +102,43 @@ func enrichKeyWithMetadata(key v3.AttributeKey, keys map[string]v3.AttributeKey)
 	return key
 }
 
+// selectCount returns the select of the count of the rows aggregated in each
+// point, for the queries with IncludeCount
+func selectCount(mq *v3.BuilderQuery) string {
+	if !mq.IncludeCount {
+		return ""
+	}
+	return " toUInt64(count(*)) as " + constants.ResultCountColumn + ","
+}
+
+// selectBand returns the select of the min and max of the aggregate attribute
+// in each point, for the queries with IncludeBand
+func selectBand(mq *v3.BuilderQuery, keys map[string]v3.AttributeKey) string {
+	if !mq.IncludeBand {
+		return ""
+	}
+	aggregationKey := getColumnName(mq.AggregateAttribute, keys)
+	return fmt.Sprintf(" toFloat64(min(%s)) as %s, toFloat64(max(%s)) as %s,", aggregationKey, constants.ResultBandMinColumn, aggregationKey, constants.ResultBandMaxColumn)
+}
+
+// selectHeatmapBucket returns the select of the index of the value bucket of the
+// aggregate attribute, for the heatmap queries
+func selectHeatmapBucket(mq *v3.BuilderQuery, keys map[string]v3.AttributeKey) string {
+	if mq.Heatmap == nil {
+		return ""
+	}
+	bucket := utils.HeatmapBucketExpr(getColumnName(mq.AggregateAttribute, keys), mq.Heatmap.Min, mq.Heatmap.Max, mq.Heatmap.Count)
+	return fmt.Sprintf(" toString(%s) as %s,", bucket, constants.ResultHeatmapBucketColumn)
+}
+
+// heatmapGroupBy returns the group by of the query, with the value bucket for the heatmap queries
+func heatmapGroupBy(mq *v3.BuilderQuery) []v3.AttributeKey {
+	if mq.Heatmap == nil {
+		return mq.GroupBy
+	}
+	return append(slices.Clone(mq.GroupBy), v3.AttributeKey{Key: constants.ResultHeatmapBucketColumn})
+}
+
 // getSelectLabels returns the select labels for the query based on groupBy and aggregateOperator
 func getSelectLabels(aggregatorOperator v3.AggregateOperator, groupBy []v3.AttributeKey, keys map[string]v3.AttributeKey) string {
 	var selectLabels string
@@ -268,10 +306,10 @@ func buildTracesQuery(start, end, step int64, mq *v3.BuilderQuery, tableName str
 	} else if panelType == v3.PanelTypeGraph || panelType == v3.PanelTypeValue {
 		// Select the aggregate value for interval
 		queryTmpl =
-			fmt.Sprintf("SELECT toStartOfInterval(timestamp, INTERVAL %d SECOND) AS ts,", step)
+			fmt.Sprintf("SELECT %s AS ts,", utils.StartOfIntervalExpr("timestamp", step, mq.AlignmentOffset))
 	}
 
-	queryTmpl = queryTmpl + selectLabels +
+	queryTmpl = queryTmpl + selectLabels + selectCount(mq) + selectBand(mq, keys) + selectHeatmapBucket(mq, keys) +
 		" %s as value " +
 		"from " + constants.SIGNOZ_TRACE_DBNAME + "." + constants.SIGNOZ_SPAN_INDEX_TABLENAME +
 		" where " + spanIndexTableTimeFilter + "%s" +
@@ -289,7 +327,7 @@ func buildTracesQuery(start, end, step int64, mq *v3.BuilderQuery, tableName str
 	}
 	filterSubQuery += emptyValuesInGroupByFilter
 
-	groupBy := groupByAttributeKeyTags(panelType, options.GraphLimitQtype, mq.GroupBy...)
+	groupBy := groupByAttributeKeyTags(panelType, options.GraphLimitQtype, heatmapGroupBy(mq)...)
 	if groupBy != "" {
 		groupBy = " group by " + groupBy
 	}
@@ -368,7 +406,11 @@ func buildTracesQuery(start, end, step int64, mq *v3.BuilderQuery, tableName str
 			if mq.Offset != 0 {
 				withSubQuery = addOffsetToQuery(withSubQuery, mq.Offset)
 			}
-			query = withSubQuery + ") " + fmt.Sprintf(constants.TracesExplorerViewSQLSelectQuery, constants.SIGNOZ_TRACE_DBNAME, constants.SIGNOZ_SPAN_INDEX_TABLENAME, constants.SIGNOZ_SPAN_INDEX_TABLENAME)
+			if mq.SpanTree {
+				query = withSubQuery + ") " + fmt.Sprintf(constants.TracesExplorerViewSQLSelectSpansQuery, constants.SIGNOZ_TRACE_DBNAME, constants.SIGNOZ_SPAN_INDEX_TABLENAME)
+			} else {
+				query = withSubQuery + ") " + fmt.Sprintf(constants.TracesExplorerViewSQLSelectQuery, constants.SIGNOZ_TRACE_DBNAME, constants.SIGNOZ_SPAN_INDEX_TABLENAME, constants.SIGNOZ_SPAN_INDEX_TABLENAME)
+			}
 		} else if panelType == v3.PanelTypeList {
 			if len(mq.SelectColumns) == 0 {
 				return "", fmt.Errorf("select columns cannot be empty for panelType %s", panelType)
@@ -509,9 +551,10 @@ func addOffsetToQuery(query string, offset uint64) string {
 // start and end are in epoch millisecond
 // step is in seconds
 func PrepareTracesQuery(start, end int64, panelType v3.PanelType, mq *v3.BuilderQuery, keys map[string]v3.AttributeKey, options Options) (string, error) {
-	// adjust the start and end time to the step interval
-	start = start - (start % (mq.StepInterval * 1000))
-	end = end - (end % (mq.StepInterval * 1000))
+	// adjust the start and end time to the step interval, shifted by the alignment offset
+	alignmentOffset := mq.AlignmentOffset * 1000
+	start = start - ((start - alignmentOffset) % (mq.StepInterval * 1000))
+	end = end - ((end - alignmentOffset) % (mq.StepInterval * 1000))
 	if options.GraphLimitQtype == constants.FirstQueryGraphLimit {
 		// give me just the group by names
 		query, err := buildTracesQuery(start, end, mq.StepInterval, mq, constants.SIGNOZ_SPAN_INDEX_TABLENAME, keys, panelType, options)
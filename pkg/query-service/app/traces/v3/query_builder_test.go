@@ -676,6 +676,54 @@ var testBuildTracesQueryData = []struct {
 			"order by `http.method` ASC",
 		PanelType: v3.PanelTypeGraph,
 	},
+	{
+		Name:  "Test aggregate avg with include count",
+		Start: 1680066360726210000,
+		End:   1680066458000000000,
+		BuilderQuery: &v3.BuilderQuery{
+			QueryName:          "A",
+			StepInterval:       60,
+			AggregateAttribute: v3.AttributeKey{Key: "durationNano", IsColumn: true, DataType: v3.AttributeKeyDataTypeFloat64, Type: v3.AttributeKeyTypeTag},
+			AggregateOperator:  v3.AggregateOperatorAvg,
+			Expression:         "A",
+			GroupBy:            []v3.AttributeKey{{Key: "http.method", DataType: v3.AttributeKeyDataTypeString, Type: v3.AttributeKeyTypeTag}},
+			IncludeCount:       true,
+		},
+		TableName: "signoz_traces.distributed_signoz_index_v2",
+		ExpectedQuery: "SELECT toStartOfInterval(timestamp, INTERVAL 60 SECOND) AS ts," +
+			" stringTagMap['http.method'] as `http.method`," +
+			" toUInt64(count(*)) as __count, " +
+			"avg(durationNano) as value from signoz_traces.distributed_signoz_index_v2 " +
+			"where (timestamp >= '1680066360726210000' AND timestamp <= '1680066458000000000') " +
+			"AND has(stringTagMap, 'http.method') group by `http.method`,ts " +
+			"order by value DESC",
+		PanelType: v3.PanelTypeGraph,
+	},
+	{
+		Name:  "Test aggregate avg with include band",
+		Start: 1680066360726210000,
+		End:   1680066458000000000,
+		BuilderQuery: &v3.BuilderQuery{
+			QueryName:          "A",
+			StepInterval:       60,
+			AggregateAttribute: v3.AttributeKey{Key: "durationNano", IsColumn: true, DataType: v3.AttributeKeyDataTypeFloat64, Type: v3.AttributeKeyTypeTag},
+			AggregateOperator:  v3.AggregateOperatorAvg,
+			Expression:         "A",
+			GroupBy:            []v3.AttributeKey{{Key: "http.method", DataType: v3.AttributeKeyDataTypeString, Type: v3.AttributeKeyTypeTag}},
+			IncludeCount:       true,
+			IncludeBand:        true,
+		},
+		TableName: "signoz_traces.distributed_signoz_index_v2",
+		ExpectedQuery: "SELECT toStartOfInterval(timestamp, INTERVAL 60 SECOND) AS ts," +
+			" stringTagMap['http.method'] as `http.method`," +
+			" toUInt64(count(*)) as __count," +
+			" toFloat64(min(durationNano)) as __band_min, toFloat64(max(durationNano)) as __band_max, " +
+			"avg(durationNano) as value from signoz_traces.distributed_signoz_index_v2 " +
+			"where (timestamp >= '1680066360726210000' AND timestamp <= '1680066458000000000') " +
+			"AND has(stringTagMap, 'http.method') group by `http.method`,ts " +
+			"order by value DESC",
+		PanelType: v3.PanelTypeGraph,
+	},
 	{
 		Name:  "Test aggregate count with multiple filter,groupBy and orderBy",
 		Start: 1680066360726210000,
@@ -1168,6 +1216,28 @@ var testBuildTracesQueryData = []struct {
 			"ORDER BY subQuery.durationNano desc;",
 		PanelType: v3.PanelTypeTrace,
 	},
+	{
+		Name:  "Test Noop trace view span tree",
+		Start: 1680066360726210000,
+		End:   1680066458000000000,
+		BuilderQuery: &v3.BuilderQuery{
+			QueryName:         "A",
+			AggregateOperator: v3.AggregateOperatorNoOp,
+			Expression:        "A",
+			Filters: &v3.FilterSet{
+				Operator: "AND", Items: []v3.FilterItem{
+					{Key: v3.AttributeKey{Key: "method", DataType: v3.AttributeKeyDataTypeString, Type: v3.AttributeKeyTypeTag}, Value: "GET", Operator: "="},
+				},
+			},
+			SpanTree: true,
+		},
+		ExpectedQuery: "WITH subQuery AS (SELECT distinct on (traceID) traceID, durationNano, serviceName," +
+			" name FROM signoz_traces.distributed_signoz_index_v2 WHERE parentSpanID = '' AND (timestamp >= '1680066360726210000' AND " +
+			"timestamp <= '1680066458000000000')  AND stringTagMap['method'] = 'GET' ORDER BY durationNano DESC  LIMIT 100)" +
+			" SELECT timestamp as timestamp_datetime, spanID, parentSpanID, traceID, serviceName, name, durationNano" +
+			" FROM signoz_traces.distributed_signoz_index_v2 WHERE traceID GLOBAL IN (SELECT traceID FROM subQuery) ORDER BY timestamp;",
+		PanelType: v3.PanelTypeTrace,
+	},
 }
 
 func TestBuildTracesQuery(t *testing.T) {
@@ -1395,6 +1465,23 @@ var testPrepTracesQueryData = []struct {
 			GraphLimitQtype: constants.SecondQueryGraphLimit,
 		},
 	},
+	{
+		Name:      "Test TS with alignment offset",
+		PanelType: v3.PanelTypeGraph,
+		Start:     1680066360726,
+		End:       1680066458000,
+		BuilderQuery: &v3.BuilderQuery{
+			QueryName:         "A",
+			AggregateOperator: v3.AggregateOperatorCount,
+			Expression:        "A",
+			StepInterval:      60,
+			AlignmentOffset:   30,
+		},
+		ExpectedQuery: "SELECT toStartOfInterval(timestamp - INTERVAL 30 SECOND, INTERVAL 60 SECOND) + INTERVAL 30 SECOND AS ts," +
+			" toFloat64(count()) as value from signoz_traces.distributed_signoz_index_v2" +
+			" where (timestamp >= '1680066330000000000' AND timestamp <= '1680066450000000000')" +
+			" group by ts order by value DESC",
+	},
 }
 
 func TestPrepareTracesQuery(t *testing.T) {
@@ -4,6 +4,7 @@ import (
 	"os"
 	"time"
 
+	clickhouse "go.signoz.io/signoz/pkg/query-service/cache/clickhouse"
 	inmemory "go.signoz.io/signoz/pkg/query-service/cache/inmemory"
 	redis "go.signoz.io/signoz/pkg/query-service/cache/redis"
 	"go.signoz.io/signoz/pkg/query-service/cache/status"
@@ -12,10 +13,11 @@ import (
 )
 
 type Options struct {
-	Name     string            `yaml:"-"`
-	Provider string            `yaml:"provider"`
-	Redis    *redis.Options    `yaml:"redis,omitempty"`
-	InMemory *inmemory.Options `yaml:"inmemory,omitempty"`
+	Name       string              `yaml:"-"`
+	Provider   string              `yaml:"provider"`
+	Redis      *redis.Options      `yaml:"redis,omitempty"`
+	InMemory   *inmemory.Options   `yaml:"inmemory,omitempty"`
+	ClickHouse *clickhouse.Options `yaml:"clickhouse,omitempty"`
 }
 
 // Cache is the interface for the storage backend
@@ -63,6 +65,8 @@ func NewCache(options *Options) Cache {
 		return redis.New(options.Redis)
 	case "inmemory":
 		return inmemory.New(options.InMemory)
+	case "clickhouse":
+		return clickhouse.New(options.ClickHouse)
 	default:
 		return nil
 	}
@@ -50,3 +50,14 @@ func TestLoadFromYAMLCacheConfigFile(t *testing.T) {
 		t.Fatalf("unexpected error: %s", err)
 	}
 }
+
+func TestNewCacheClickHouse(t *testing.T) {
+	c := NewCache(&Options{
+		Name:     "test",
+		Provider: "clickhouse",
+	})
+
+	if c == nil {
+		t.Fatalf("expected non-nil, got nil")
+	}
+}
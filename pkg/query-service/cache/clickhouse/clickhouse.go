@@ -0,0 +1,132 @@
+package clickhouse
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ClickHouse/clickhouse-go/v2"
+	"go.signoz.io/signoz/pkg/query-service/cache/status"
+	"go.uber.org/zap"
+)
+
+// the entries are versioned by the time they are stored at, the latest version of a key
+// is the entry of the key. An entry without an expiry is stored with the zero expiry.
+// The expired entries are deleted by the table TTL.
+const createTableQuery = `CREATE TABLE IF NOT EXISTS %s (
+	key String,
+	data String,
+	expires_at DateTime64(3),
+	stored_at DateTime64(3)
+) ENGINE = ReplacingMergeTree(stored_at)
+ORDER BY key
+TTL toDateTime(expires_at) DELETE WHERE expires_at > toDateTime64(0, 3)`
+
+type cache struct {
+	conn clickhouse.Conn
+	opts *Options
+}
+
+// New creates a new cache
+func New(opts *Options) *cache {
+	if opts == nil {
+		opts = defaultOptions()
+	}
+	if opts.Table == "" {
+		opts.Table = defaultTable
+	}
+	return &cache{opts: opts}
+}
+
+// WithConn creates a new cache with the given connection, storing the entries in the given table
+func WithConn(conn clickhouse.Conn, table string) *cache {
+	return &cache{conn: conn, opts: &Options{Table: table}}
+}
+
+// Connect connects to the ClickHouse server and creates the table of the cache entries
+func (c *cache) Connect() error {
+	options, err := clickhouse.ParseDSN(c.opts.DSN)
+	if err != nil {
+		return err
+	}
+	conn, err := clickhouse.Open(options)
+	if err != nil {
+		return err
+	}
+	c.conn = conn
+	return c.conn.Exec(context.Background(), fmt.Sprintf(createTableQuery, c.opts.Table))
+}
+
+// expiry returns the expiry of an entry stored now with the given TTL, the zero time
+// for the entries that don't expire
+func expiry(ttl time.Duration) time.Time {
+	if ttl <= 0 {
+		return time.UnixMilli(0)
+	}
+	return time.Now().Add(ttl)
+}
+
+// Store stores the data in the cache
+func (c *cache) Store(cacheKey string, data []byte, ttl time.Duration) error {
+	query := fmt.Sprintf("INSERT INTO %s (key, data, expires_at, stored_at) VALUES (?, ?, ?, ?)", c.opts.Table)
+	return c.conn.Exec(context.Background(), query, cacheKey, string(data), expiry(ttl), time.Now())
+}
+
+// Retrieve retrieves the data from the cache, the expired entries are only retrieved if allowExpired is true
+func (c *cache) Retrieve(cacheKey string, allowExpired bool) ([]byte, status.RetrieveStatus, error) {
+	query := fmt.Sprintf("SELECT data, expires_at FROM %s WHERE key = ? ORDER BY stored_at DESC LIMIT 1", c.opts.Table)
+	rows, err := c.conn.Query(context.Background(), query, cacheKey)
+	if err != nil {
+		return nil, status.RetrieveStatusError, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return nil, status.RetrieveStatusError, err
+		}
+		return nil, status.RetrieveStatusKeyMiss, nil
+	}
+	var data string
+	var expiresAt time.Time
+	if err := rows.Scan(&data, &expiresAt); err != nil {
+		return nil, status.RetrieveStatusError, err
+	}
+	if expiresAt.UnixMilli() > 0 && time.Now().After(expiresAt) && !allowExpired {
+		return nil, status.RetrieveStatusKeyMiss, nil
+	}
+	return []byte(data), status.RetrieveStatusHit, nil
+}
+
+// SetTTL sets the TTL for the cache entry, by storing the latest version of the entry with the new expiry
+func (c *cache) SetTTL(cacheKey string, ttl time.Duration) {
+	query := fmt.Sprintf("INSERT INTO %[1]s (key, data, expires_at, stored_at) SELECT key, data, ?, ? FROM %[1]s WHERE key = ? ORDER BY stored_at DESC LIMIT 1", c.opts.Table)
+	err := c.conn.Exec(context.Background(), query, expiry(ttl), time.Now(), cacheKey)
+	if err != nil {
+		zap.L().Error("error setting TTL for cache key", zap.String("cacheKey", cacheKey), zap.Duration("ttl", ttl), zap.Error(err))
+	}
+}
+
+// Remove removes the cache entry
+func (c *cache) Remove(cacheKey string) {
+	c.BulkRemove([]string{cacheKey})
+}
+
+// BulkRemove removes the cache entries
+func (c *cache) BulkRemove(cacheKeys []string) {
+	query := fmt.Sprintf("DELETE FROM %s WHERE key IN ?", c.opts.Table)
+	err := c.conn.Exec(context.Background(), query, cacheKeys)
+	if err != nil {
+		zap.L().Error("error deleting cache keys", zap.Strings("cacheKeys", cacheKeys), zap.Error(err))
+	}
+}
+
+// Close closes the connection to the ClickHouse server
+func (c *cache) Close() error {
+	return c.conn.Close()
+}
+
+// GetOptions returns the options
+func (c *cache) GetOptions() *Options {
+	return c.opts
+}
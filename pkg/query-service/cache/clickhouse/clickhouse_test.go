@@ -0,0 +1,137 @@
+package clickhouse
+
+import (
+	"testing"
+	"time"
+
+	cmock "github.com/srikanthccv/ClickHouse-go-mock"
+	"go.signoz.io/signoz/pkg/query-service/cache/status"
+)
+
+const (
+	testTable           = "signoz_metrics.query_cache"
+	testStoreQuery      = "INSERT INTO signoz_metrics.query_cache (key, data, expires_at, stored_at) VALUES (?, ?, ?, ?)"
+	testRetrieveQuery   = "SELECT data, expires_at FROM signoz_metrics.query_cache WHERE key = ? ORDER BY stored_at DESC LIMIT 1"
+	testSetTTLQuery     = "INSERT INTO signoz_metrics.query_cache (key, data, expires_at, stored_at) SELECT key, data, ?, ? FROM signoz_metrics.query_cache WHERE key = ? ORDER BY stored_at DESC LIMIT 1"
+	testBulkRemoveQuery = "DELETE FROM signoz_metrics.query_cache WHERE key IN ?"
+)
+
+var testColumns = []cmock.ColumnType{
+	{Name: "data", Type: "String"},
+	{Name: "expires_at", Type: "DateTime64(3)"},
+}
+
+func TestStore(t *testing.T) {
+	mock, err := cmock.NewClickHouseNative(nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	c := WithConn(mock, testTable)
+
+	mock.ExpectExec(testStoreQuery)
+	if err := c.Store("key", []byte("value"), 10*time.Second); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestRetrieve(t *testing.T) {
+	cases := []struct {
+		name           string
+		values         [][]interface{}
+		allowExpired   bool
+		expectedStatus status.RetrieveStatus
+		expectedData   string
+	}{
+		{
+			name:           "hit",
+			values:         [][]interface{}{{"value", time.Now().Add(time.Minute)}},
+			expectedStatus: status.RetrieveStatusHit,
+			expectedData:   "value",
+		},
+		{
+			name:           "hit without expiry",
+			values:         [][]interface{}{{"value", time.UnixMilli(0)}},
+			expectedStatus: status.RetrieveStatusHit,
+			expectedData:   "value",
+		},
+		{
+			name:           "key miss",
+			values:         [][]interface{}{},
+			expectedStatus: status.RetrieveStatusKeyMiss,
+		},
+		{
+			name:           "expired",
+			values:         [][]interface{}{{"value", time.Now().Add(-time.Minute)}},
+			expectedStatus: status.RetrieveStatusKeyMiss,
+		},
+		{
+			name:           "expired allowed",
+			values:         [][]interface{}{{"value", time.Now().Add(-time.Minute)}},
+			allowExpired:   true,
+			expectedStatus: status.RetrieveStatusHit,
+			expectedData:   "value",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			mock, err := cmock.NewClickHouseNative(nil)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			c := WithConn(mock, testTable)
+
+			mock.ExpectQuery(testRetrieveQuery).WithArgs("key").WillReturnRows(cmock.NewRows(testColumns, tc.values))
+			data, retrieveStatus, err := c.Retrieve("key", tc.allowExpired)
+			if err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+
+			if retrieveStatus != tc.expectedStatus {
+				t.Errorf("expected status %s, got %s", tc.expectedStatus, retrieveStatus)
+			}
+
+			if string(data) != tc.expectedData {
+				t.Errorf("expected value %q, got %q", tc.expectedData, string(data))
+			}
+
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Errorf("there were unfulfilled expectations: %s", err)
+			}
+		})
+	}
+}
+
+func TestSetTTL(t *testing.T) {
+	mock, err := cmock.NewClickHouseNative(nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	c := WithConn(mock, testTable)
+
+	mock.ExpectExec(testSetTTLQuery)
+	c.SetTTL("key", 4*time.Second)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestBulkRemove(t *testing.T) {
+	mock, err := cmock.NewClickHouseNative(nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	c := WithConn(mock, testTable)
+
+	mock.ExpectExec(testBulkRemoveQuery)
+	c.BulkRemove([]string{"key", "key2"})
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
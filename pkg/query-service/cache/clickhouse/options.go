@@ -0,0 +1,18 @@
+package clickhouse
+
+const (
+	defaultDSN   = "tcp://localhost:9000"
+	defaultTable = "signoz_metrics.query_cache"
+)
+
+// Options holds the options for the ClickHouse cache
+type Options struct {
+	// DSN is the ClickHouse server to store the cache entries in
+	DSN string `yaml:"dsn,omitempty"`
+	// Table is the table of the cache entries, created on connect if it doesn't exist
+	Table string `yaml:"table,omitempty"`
+}
+
+func defaultOptions() *Options {
+	return &Options{DSN: defaultDSN, Table: defaultTable}
+}
@@ -3,3 +3,27 @@ package common
 type LogCommentContextKeyType string
 
 const LogCommentKey LogCommentContextKeyType = "logComment"
+
+type ClickHouseQueryIDContextKeyType string
+
+// ClickHouseQueryIDKey is the context key of the query_id the clickhouse
+// queries are run with, so that they can be killed by id
+const ClickHouseQueryIDKey ClickHouseQueryIDContextKeyType = "clickhouseQueryId"
+
+type ClickHousePriorityContextKeyType string
+
+// ClickHousePriorityKey is the context key of the priority the clickhouse queries
+// are run with, so that the ad-hoc queries don't starve the alert queries
+const ClickHousePriorityKey ClickHousePriorityContextKeyType = "clickhousePriority"
+
+type ClickHouseScanMeterContextKeyType string
+
+// ClickHouseScanMeterKey is the context key of the ScanMeter the rows and bytes
+// read by the clickhouse queries are reported to, from their progress
+const ClickHouseScanMeterKey ClickHouseScanMeterContextKeyType = "clickhouseScanMeter"
+
+// ScanMeter records the rows and bytes read by the clickhouse queries, the
+// progress of the queries is reported as increments
+type ScanMeter interface {
+	AddScanned(rows, bytes uint64)
+}
@@ -0,0 +1,118 @@
+package common
+
+import (
+	"math"
+	"time"
+
+	v3 "go.signoz.io/signoz/pkg/query-service/model/v3"
+)
+
+// MissInterval is a time range missing in the cache, in milliseconds
+type MissInterval struct {
+	Start, End int64
+}
+
+// FluxBoundaryAt returns the timestamp in milliseconds after which the data might
+// still be in flux at now, i.e. not be fully ingested yet. now is rounded down to
+// the step grid shifted by the alignment offset, in seconds
+func FluxBoundaryAt(step, alignmentOffset int64, fluxInterval time.Duration, now time.Time) int64 {
+	endMillis := now.UnixMilli()
+	adjustStep := int64(math.Min(float64(step), 60))
+	roundedMillis := endMillis - ((endMillis - alignmentOffset*1000) % (adjustStep * 1000))
+	return roundedMillis - fluxInterval.Milliseconds()
+}
+
+// ComputeMissingRanges finds the time ranges of [start, end] missing in the cached
+// seriesList and returns them as a list of miss intervals, It takes the fluxInterval
+// into account to find the missing time ranges.
+//
+// The [End - fluxInterval, End] is always added to the list of misses, because
+// the data might still be in flux and not yet available in the database.
+//
+// replaceCacheData is used to indicate if the cache data should be replaced instead of merging
+// with the new data
+// TODO: Remove replaceCacheData with a better logic
+func ComputeMissingRanges(start, end, step int64, seriesList []*v3.Series, fluxInterval time.Duration) (misses []MissInterval, replaceCacheData bool) {
+	return ComputeMissingRangesAt(start, end, step, 0, seriesList, fluxInterval, time.Now())
+}
+
+// ComputeMissingRangesAt is ComputeMissingRanges with the flux interval
+// measured back from now instead of the current time, and the step grid
+// shifted by the alignment offset
+func ComputeMissingRangesAt(start, end, step, alignmentOffset int64, seriesList []*v3.Series, fluxInterval time.Duration, now time.Time) (misses []MissInterval, replaceCacheData bool) {
+	cachedStart, cachedEnd := CachedSpan(seriesList)
+	return ComputeMissingRangesForSpan(start, end, cachedStart, cachedEnd, FluxBoundaryAt(step, alignmentOffset, fluxInterval, now))
+}
+
+// CachedSpan returns the timestamps of the first and the last cached points of
+// the seriesList, in milliseconds, 0 without any point
+func CachedSpan(seriesList []*v3.Series) (cachedStart, cachedEnd int64) {
+	for idx := range seriesList {
+		series := seriesList[idx]
+		for pointIdx := range series.Points {
+			point := series.Points[pointIdx]
+			if cachedStart == 0 || point.Timestamp < cachedStart {
+				cachedStart = point.Timestamp
+			}
+			if cachedEnd == 0 || point.Timestamp > cachedEnd {
+				cachedEnd = point.Timestamp
+			}
+		}
+	}
+	return cachedStart, cachedEnd
+}
+
+// ComputeMissingRangesForSpan finds the time ranges of [start, end] missing in the
+// cached span [cachedStart, cachedEnd], whose points after the flux boundary are
+// considered missing. The misses only depend on the time window and the cached
+// span, so they can be shared by the queries with the same window and span
+func ComputeMissingRangesForSpan(start, end, cachedStart, cachedEnd, fluxBoundary int64) (misses []MissInterval, replaceCacheData bool) {
+	replaceCacheData = false
+
+	// Exclude the flux interval from the cached end time
+	cachedEnd = int64(
+		math.Min(
+			float64(cachedEnd),
+			float64(fluxBoundary),
+		),
+	)
+
+	// There are five cases to consider
+	// 1. Cached time range is a subset of the requested time range
+	// 2. Cached time range is a superset of the requested time range
+	// 3. Cached time range is a left overlap of the requested time range
+	// 4. Cached time range is a right overlap of the requested time range
+	// 5. Cached time range is a disjoint of the requested time range
+	if cachedStart >= start && cachedEnd <= end {
+		// Case 1: Cached time range is a subset of the requested time range
+		// Add misses for the left and right sides of the cached time range
+		misses = append(misses, MissInterval{Start: start, End: cachedStart - 1})
+		misses = append(misses, MissInterval{Start: cachedEnd + 1, End: end})
+	} else if cachedStart <= start && cachedEnd >= end {
+		// Case 2: Cached time range is a superset of the requested time range
+		// No misses
+	} else if cachedStart <= start && cachedEnd >= start {
+		// Case 3: Cached time range is a left overlap of the requested time range
+		// Add a miss for the left side of the cached time range
+		misses = append(misses, MissInterval{Start: cachedEnd + 1, End: end})
+	} else if cachedStart <= end && cachedEnd >= end {
+		// Case 4: Cached time range is a right overlap of the requested time range
+		// Add a miss for the right side of the cached time range
+		misses = append(misses, MissInterval{Start: start, End: cachedStart - 1})
+	} else {
+		// Case 5: Cached time range is a disjoint of the requested time range
+		// Add a miss for the entire requested time range
+		misses = append(misses, MissInterval{Start: start, End: end})
+		replaceCacheData = true
+	}
+
+	// remove the struts with start > end
+	var validMisses []MissInterval
+	for idx := range misses {
+		miss := misses[idx]
+		if miss.Start < miss.End {
+			validMisses = append(validMisses, miss)
+		}
+	}
+	return validMisses, replaceCacheData
+}
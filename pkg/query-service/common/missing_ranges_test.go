@@ -0,0 +1,139 @@
+package common
+
+import (
+	"testing"
+	"time"
+
+	v3 "go.signoz.io/signoz/pkg/query-service/model/v3"
+)
+
+func TestComputeMissingRanges(t *testing.T) {
+	base := int64(1675115596722)
+	minute := time.Minute.Milliseconds()
+	start, end := base, base+60*minute
+
+	cachedSeries := func(from, to int64) []*v3.Series {
+		return []*v3.Series{
+			{
+				Labels: map[string]string{"__name__": "http_server_requests_seconds_count"},
+				Points: []v3.Point{{Timestamp: from, Value: 1}, {Timestamp: to, Value: 1}},
+			},
+		}
+	}
+
+	testCases := []struct {
+		name            string
+		cachedSeries    []*v3.Series
+		expectedMisses  []MissInterval
+		expectedReplace bool
+	}{
+		{
+			name:         "cached range is a subset of the requested range",
+			cachedSeries: cachedSeries(base+10*minute, base+20*minute),
+			expectedMisses: []MissInterval{
+				{Start: start, End: base + 10*minute - 1},
+				{Start: base + 20*minute + 1, End: end},
+			},
+		},
+		{
+			name:           "cached range is a superset of the requested range",
+			cachedSeries:   cachedSeries(base-10*minute, base+70*minute),
+			expectedMisses: nil,
+		},
+		{
+			name:         "cached range overlaps the start of the requested range",
+			cachedSeries: cachedSeries(base-10*minute, base+30*minute),
+			expectedMisses: []MissInterval{
+				{Start: base + 30*minute + 1, End: end},
+			},
+		},
+		{
+			name:         "cached range overlaps the end of the requested range",
+			cachedSeries: cachedSeries(base+30*minute, base+70*minute),
+			expectedMisses: []MissInterval{
+				{Start: start, End: base + 30*minute - 1},
+			},
+		},
+		{
+			name:         "cached range is disjoint from the requested range",
+			cachedSeries: cachedSeries(base+70*minute, base+80*minute),
+			expectedMisses: []MissInterval{
+				{Start: start, End: end},
+			},
+			expectedReplace: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			misses, replace := ComputeMissingRanges(start, end, 60, tc.cachedSeries, 0)
+			if replace != tc.expectedReplace {
+				t.Errorf("expected replace to be %v, got %v", tc.expectedReplace, replace)
+			}
+			if len(misses) != len(tc.expectedMisses) {
+				t.Fatalf("expected misses %v, got %v", tc.expectedMisses, misses)
+			}
+			for idx, miss := range misses {
+				if miss != tc.expectedMisses[idx] {
+					t.Errorf("expected misses %v, got %v", tc.expectedMisses, misses)
+				}
+			}
+		})
+	}
+}
+
+func TestComputeMissingRangesFluxInterval(t *testing.T) {
+	now := time.Now().UnixMilli()
+	start, end := now-60*time.Minute.Milliseconds(), now
+	cachedSeries := []*v3.Series{
+		{
+			Labels: map[string]string{"__name__": "http_server_requests_seconds_count"},
+			Points: []v3.Point{{Timestamp: start, Value: 1}, {Timestamp: end, Value: 1}},
+		},
+	}
+
+	// the points in the flux interval are refetched even though they are cached
+	misses, _ := ComputeMissingRanges(start, end, 60, cachedSeries, 5*time.Minute)
+	if len(misses) != 1 || misses[0].End != end || misses[0].Start > end-5*time.Minute.Milliseconds() {
+		t.Errorf("expected the flux interval to be a miss, got %v", misses)
+	}
+}
+
+func TestComputeMissingRangesAtFixedClock(t *testing.T) {
+	end := int64(1675115580000)
+	start := end - 60*time.Minute.Milliseconds()
+	cachedSeries := []*v3.Series{
+		{
+			Labels: map[string]string{"__name__": "http_server_requests_seconds_count"},
+			Points: []v3.Point{{Timestamp: start, Value: 1}, {Timestamp: end, Value: 1}},
+		},
+	}
+
+	// the clock is rounded down to the step before the flux interval is excluded
+	now := time.UnixMilli(end + 45*1000)
+	misses, replace := ComputeMissingRangesAt(start, end, 60, 0, cachedSeries, 5*time.Minute, now)
+	expected := MissInterval{Start: end - 5*time.Minute.Milliseconds() + 1, End: end}
+	if replace || len(misses) != 1 || misses[0] != expected {
+		t.Errorf("expected misses [%v], got %v (replace %v)", expected, misses, replace)
+	}
+}
+
+func TestComputeMissingRangesAtAlignmentOffset(t *testing.T) {
+	// the buckets start 30s past the minute
+	end := int64(1675115580000) + 30*1000
+	start := end - 60*time.Minute.Milliseconds()
+	cachedSeries := []*v3.Series{
+		{
+			Labels: map[string]string{"__name__": "http_server_requests_seconds_count"},
+			Points: []v3.Point{{Timestamp: start, Value: 1}, {Timestamp: end, Value: 1}},
+		},
+	}
+
+	// the clock is rounded down to the shifted grid, the refetch starts on a bucket
+	now := time.UnixMilli(end + 45*1000)
+	misses, _ := ComputeMissingRangesAt(start, end, 60, 30, cachedSeries, 5*time.Minute, now)
+	expected := MissInterval{Start: end - 5*time.Minute.Milliseconds() + 1, End: end}
+	if len(misses) != 1 || misses[0] != expected {
+		t.Errorf("expected misses [%v], got %v", expected, misses)
+	}
+}
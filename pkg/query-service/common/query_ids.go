@@ -0,0 +1,68 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// QueryIDs are the clickhouse query ids issued for the queries of the users, so
+// that a user can only cancel the queries run for them. The ids are forgotten
+// after the ttl, by when the queries have long finished
+type QueryIDs struct {
+	ttl    time.Duration
+	mu     sync.Mutex
+	issued map[string]issuedQueryID
+}
+
+type issuedQueryID struct {
+	owner    string
+	issuedAt time.Time
+}
+
+// NewQueryIDs returns the registry of the query ids issued in the last ttl
+func NewQueryIDs(ttl time.Duration) *QueryIDs {
+	return &QueryIDs{ttl: ttl, issued: make(map[string]issuedQueryID)}
+}
+
+// Issue returns a new query id owned by the user in the context
+func (ids *QueryIDs) Issue(ctx context.Context) string {
+	queryID := uuid.NewString()
+	now := time.Now()
+	ids.mu.Lock()
+	defer ids.mu.Unlock()
+	for id, issued := range ids.issued {
+		if now.Sub(issued.issuedAt) > ids.ttl {
+			delete(ids.issued, id)
+		}
+	}
+	ids.issued[queryID] = issuedQueryID{owner: queryIDOwner(ctx), issuedAt: now}
+	return queryID
+}
+
+// CheckOwner returns an error unless the query id is a uuid issued for the user
+// in the context in the last ttl
+func (ids *QueryIDs) CheckOwner(ctx context.Context, queryID string) error {
+	if parsed, err := uuid.Parse(queryID); err != nil || parsed.String() != queryID {
+		return fmt.Errorf("invalid query id %q", queryID)
+	}
+	ids.mu.Lock()
+	issued, ok := ids.issued[queryID]
+	ids.mu.Unlock()
+	if !ok || time.Since(issued.issuedAt) > ids.ttl || issued.owner != queryIDOwner(ctx) {
+		return fmt.Errorf("unknown query id %q", queryID)
+	}
+	return nil
+}
+
+// queryIDOwner returns the id of the user in the context, empty for the
+// queries run by the server itself, e.g. the alerts
+func queryIDOwner(ctx context.Context) string {
+	if user := GetUserFromContext(ctx); user != nil {
+		return user.Id
+	}
+	return ""
+}
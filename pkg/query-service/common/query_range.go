@@ -18,7 +18,8 @@ func AdjustedMetricTimeRange(start, end, step int64, mq v3.BuilderQuery) (int64,
 	// so that we can calculate the rate for the first data point
 	hasRunningDiff := false
 	for _, fn := range mq.Functions {
-		if fn.Name == v3.FunctionNameRunningDiff {
+		// delta also drops the first point
+		if fn.Name == v3.FunctionNameRunningDiff || fn.Name == v3.FunctionNameDelta {
 			hasRunningDiff = true
 			break
 		}
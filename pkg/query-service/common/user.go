@@ -2,6 +2,7 @@ package common
 
 import (
 	"context"
+	"fmt"
 
 	"go.signoz.io/signoz/pkg/query-service/constants"
 	"go.signoz.io/signoz/pkg/query-service/model"
@@ -14,3 +15,17 @@ func GetUserFromContext(ctx context.Context) *model.UserPayload {
 	}
 	return user
 }
+
+// NamespaceCacheKeys namespaces the cache keys of the queries with the org of the
+// user in the context, so that tenants with identical queries never share cache
+// entries. The keys are returned as is without an org in the context
+func NamespaceCacheKeys(ctx context.Context, cacheKeys map[string]string) map[string]string {
+	user := GetUserFromContext(ctx)
+	if user == nil || user.OrgId == "" {
+		return cacheKeys
+	}
+	for queryName, cacheKey := range cacheKeys {
+		cacheKeys[queryName] = fmt.Sprintf("org=%s&%s", user.OrgId, cacheKey)
+	}
+	return cacheKeys
+}
@@ -0,0 +1,73 @@
+package common
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	v3 "go.signoz.io/signoz/pkg/query-service/model/v3"
+)
+
+// ReduceValuePanelSeries reduces the series of a value panel query to a single series
+// using the given strategy. The default strategy is to return an error.
+func ReduceValuePanelSeries(seriesList []*v3.Series, strategy v3.ValuePanelMultiSeriesStrategy) ([]*v3.Series, error) {
+	switch strategy {
+	case v3.ValuePanelMultiSeriesStrategyFirst:
+		// series order is not stable, so the first series is the one with the smallest labels
+		first := seriesList[0]
+		for _, series := range seriesList[1:] {
+			if labelsString(series.Labels) < labelsString(first.Labels) {
+				first = series
+			}
+		}
+		return []*v3.Series{first}, nil
+	case v3.ValuePanelMultiSeriesStrategySum, v3.ValuePanelMultiSeriesStrategyMax:
+		valuesByTimestamp := make(map[int64]float64)
+		for _, series := range seriesList {
+			for _, point := range series.Points {
+				value, ok := valuesByTimestamp[point.Timestamp]
+				if !ok {
+					valuesByTimestamp[point.Timestamp] = point.Value
+					continue
+				}
+				if strategy == v3.ValuePanelMultiSeriesStrategySum {
+					value += point.Value
+				} else {
+					value = math.Max(value, point.Value)
+				}
+				valuesByTimestamp[point.Timestamp] = value
+			}
+		}
+		reduced := &v3.Series{Labels: map[string]string{}, Points: make([]v3.Point, 0, len(valuesByTimestamp))}
+		for timestamp, value := range valuesByTimestamp {
+			reduced.Points = append(reduced.Points, v3.Point{Timestamp: timestamp, Value: value})
+		}
+		reduced.SortPoints()
+		return []*v3.Series{reduced}, nil
+	default:
+		return nil, fmt.Errorf("there can be only one result series for value type panel but got %d", len(seriesList))
+	}
+}
+
+// labelsString serializes the labels sorted by key, like the querier orders the series
+func labelsString(labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	b.WriteByte('{')
+	for idx, k := range keys {
+		if idx > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(k)
+		b.WriteByte('=')
+		b.WriteString(labels[k])
+	}
+	b.WriteByte('}')
+	return b.String()
+}
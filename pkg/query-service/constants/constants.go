@@ -217,6 +217,7 @@ var GroupByColMap = map[string]struct{}{
 const (
 	SIGNOZ_METRIC_DBNAME                      = "signoz_metrics"
 	SIGNOZ_SAMPLES_V4_TABLENAME               = "distributed_samples_v4"
+	SIGNOZ_EXEMPLARS_TABLENAME                = "distributed_exemplars"
 	SIGNOZ_TRACE_DBNAME                       = "signoz_traces"
 	SIGNOZ_SPAN_INDEX_TABLENAME               = "distributed_signoz_index_v2"
 	SIGNOZ_TIMESERIES_v4_LOCAL_TABLENAME      = "time_series_v4"
@@ -316,6 +317,10 @@ const (
 	TracesExplorerViewSQLSelectQuery = "SELECT subQuery.serviceName, subQuery.name, count() AS " +
 		"span_count, subQuery.durationNano, traceID FROM %s.%s GLOBAL INNER JOIN subQuery ON %s.traceID = subQuery.traceID GROUP " +
 		"BY traceID, subQuery.durationNano, subQuery.name, subQuery.serviceName ORDER BY subQuery.durationNano desc;"
+	// TracesExplorerViewSQLSelectSpansQuery selects the spans of the traces of the
+	// subquery, for assembling them into trees
+	TracesExplorerViewSQLSelectSpansQuery = "SELECT timestamp as timestamp_datetime, spanID, parentSpanID, traceID, " +
+		"serviceName, name, durationNano FROM %s.%s WHERE traceID GLOBAL IN (SELECT traceID FROM subQuery) ORDER BY timestamp;"
 )
 
 // ReservedColumnTargetAliases identifies result value from a user
@@ -329,6 +334,25 @@ var ReservedColumnTargetAliases = map[string]struct{}{
 	"value":    {},
 }
 
+// ResultCountColumn is the column alias of the count of the rows aggregated in
+// each point, selected alongside the value for the queries with IncludeCount
+const ResultCountColumn = "__count"
+
+// ResultBandMinColumn and ResultBandMaxColumn are the column aliases of the min and
+// max of the aggregated values in each point, for the queries with IncludeBand
+const (
+	ResultBandMinColumn = "__band_min"
+	ResultBandMaxColumn = "__band_max"
+)
+
+// ResultHeatmapBucketColumn is the column alias of the index of the value bucket
+// of the rows counted in each point, for the heatmap queries. It is read as a label
+const ResultHeatmapBucketColumn = "__bucket"
+
+// ResultCompanionColumns are the columns selected alongside the value that are
+// neither a label nor the value of the points
+var ResultCompanionColumns = []string{ResultCountColumn, ResultBandMinColumn, ResultBandMaxColumn}
+
 // logsPPLPfx is a short constant for logsPipelinePrefix
 const LogsPPLPfx = "logstransform/pipeline_"
 
@@ -418,3 +442,12 @@ var TracesListViewDefaultSelectedColumns = []v3.AttributeKey{
 }
 
 const DefaultFilterSuggestionsLimit = 100
+
+// MinQueryPriority and MaxQueryPriority are the range of the clickhouse priorities
+// the queries can be run with, the lower the value the higher the priority, and
+// DefaultQueryPriority is the priority of the queries which don't set one
+const (
+	MinQueryPriority     = 1
+	MaxQueryPriority     = 10
+	DefaultQueryPriority = 5
+)
@@ -70,6 +70,17 @@ type Reader interface {
 	// QB V3 metrics/traces/logs
 	GetTimeSeriesResultV3(ctx context.Context, query string) ([]*v3.Series, error)
 	GetListResultV3(ctx context.Context, query string) ([]*v3.Row, error)
+	EstimateQueryCost(ctx context.Context, query string) (*v3.QueryCostEstimate, error)
+	// ValidateQuery checks the syntax of the query without running it
+	ValidateQuery(ctx context.Context, query string) error
+	// CancelQuery kills the running query with the clickhouse query_id
+	CancelQuery(ctx context.Context, queryID string) error
+	// GetExemplarsV3 returns the exemplar trace ids of the metric query intervals
+	GetExemplarsV3(ctx context.Context, query string) ([]v3.Exemplar, error)
+	// GetAnnotations returns the annotation events between start and end, in milliseconds
+	GetAnnotations(ctx context.Context, start, end int64) ([]v3.Annotation, error)
+	// CreateAnnotation stores the annotation event and sets its id
+	CreateAnnotation(ctx context.Context, annotation *v3.Annotation) error
 	LiveTailLogsV3(ctx context.Context, query string, timestampStart uint64, idStart string, client *v3.LogsLiveTailClient)
 
 	GetDashboardsInfo(ctx context.Context) (*model.DashboardsInfo, error)
@@ -97,6 +108,10 @@ type Reader interface {
 		ctx context.Context,
 		req *v3.QBFilterSuggestionsRequest,
 	) (*v3.QBFilterSuggestionsResponse, *model.ApiError)
+	GetAttributeColumnStatus(
+		ctx context.Context,
+		req *v3.AttributeColumnStatusRequest,
+	) (*v3.AttributeColumnStatusResponse, *model.ApiError)
 
 	// Connection needed for rules, not ideal but required
 	GetConn() clickhouse.Conn
@@ -125,6 +140,14 @@ type Reader interface {
 
 type Querier interface {
 	QueryRange(context.Context, *v3.QueryRangeParamsV3, map[string]v3.AttributeKey) ([]*v3.Result, map[string]error, error)
+	// EstimateCost estimates the amount of data the clickhouse and builder queries read
+	EstimateCost(context.Context, *v3.QueryRangeParamsV3, map[string]v3.AttributeKey) (*v3.QueryCostEstimate, error)
+	// ValidateClickHouseQueries checks the syntax of the clickhouse queries without
+	// running them and returns the errors by query name
+	ValidateClickHouseQueries(context.Context, *v3.QueryRangeParamsV3) (map[string]error, error)
+	// CancelQuery kills the running clickhouse query with the query id of a result,
+	// only the query ids issued for the user in the context can be cancelled
+	CancelQuery(ctx context.Context, queryID string) error
 
 	// test helpers
 	QueriesExecuted() []string
@@ -544,6 +544,15 @@ type ShowCreateTableStatement struct {
 	Statement string `json:"statement" ch:"statement"`
 }
 
+// ExplainEstimateRow is a row of the EXPLAIN ESTIMATE output, one per table read
+type ExplainEstimateRow struct {
+	Database string `ch:"database"`
+	Table    string `ch:"table"`
+	Parts    uint64 `ch:"parts"`
+	Rows     uint64 `ch:"rows"`
+	Marks    uint64 `ch:"marks"`
+}
+
 type LogField struct {
 	Name     string `json:"name" ch:"name"`
 	DataType string `json:"dataType" ch:"datatype"`
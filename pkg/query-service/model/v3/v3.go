@@ -4,6 +4,8 @@ import (
 	"database/sql/driver"
 	"encoding/json"
 	"fmt"
+	"math"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
@@ -11,6 +13,7 @@ import (
 
 	"github.com/google/uuid"
 	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/promql"
 	"go.signoz.io/signoz/pkg/query-service/model"
 )
 
@@ -37,6 +40,7 @@ const (
 	AggregateOperatorNoOp          AggregateOperator = "noop"
 	AggregateOperatorCount         AggregateOperator = "count"
 	AggregateOperatorCountDistinct AggregateOperator = "count_distinct"
+	AggregateOperatorCountIf       AggregateOperator = "count_if"
 	AggregateOperatorSum           AggregateOperator = "sum"
 	AggregateOperatorAvg           AggregateOperator = "avg"
 	AggregateOperatorMin           AggregateOperator = "min"
@@ -71,6 +75,7 @@ func (a AggregateOperator) Validate() error {
 	case AggregateOperatorNoOp,
 		AggregateOperatorCount,
 		AggregateOperatorCountDistinct,
+		AggregateOperatorCountIf,
 		AggregateOperatorSum,
 		AggregateOperatorAvg,
 		AggregateOperatorMin,
@@ -120,6 +125,7 @@ func (a AggregateOperator) RequireAttribute(dataSource DataSource) bool {
 		switch a {
 		case AggregateOperatorNoOp,
 			AggregateOperatorCount,
+			AggregateOperatorCountIf,
 			AggregateOperatorRate:
 			return false
 		default:
@@ -176,6 +182,300 @@ func (r ReduceToOperator) Validate() error {
 	}
 }
 
+// ValuePanelMultiSeriesStrategy is the strategy used to reduce the result of a
+// value panel query to a single series when the query produces more than one
+type ValuePanelMultiSeriesStrategy string
+
+const (
+	ValuePanelMultiSeriesStrategyError ValuePanelMultiSeriesStrategy = "error"
+	ValuePanelMultiSeriesStrategyFirst ValuePanelMultiSeriesStrategy = "first"
+	ValuePanelMultiSeriesStrategySum   ValuePanelMultiSeriesStrategy = "sum"
+	ValuePanelMultiSeriesStrategyMax   ValuePanelMultiSeriesStrategy = "max"
+)
+
+func (s ValuePanelMultiSeriesStrategy) Validate() error {
+	switch s {
+	case "", ValuePanelMultiSeriesStrategyError, ValuePanelMultiSeriesStrategyFirst, ValuePanelMultiSeriesStrategySum, ValuePanelMultiSeriesStrategyMax:
+		return nil
+	default:
+		return fmt.Errorf("invalid value panel multi series strategy: %s", s)
+	}
+}
+
+// SeriesSortMetric is the value of a series the series are sorted by
+type SeriesSortMetric string
+
+const (
+	SeriesSortMetricLast SeriesSortMetric = "last"
+	SeriesSortMetricAvg  SeriesSortMetric = "avg"
+	SeriesSortMetricMax  SeriesSortMetric = "max"
+)
+
+// SeriesSort orders the series of each result by the reduced value of the series
+type SeriesSort struct {
+	Metric SeriesSortMetric `json:"metric"`
+	// Order is either asc or desc, defaults to desc
+	Order string `json:"order,omitempty"`
+}
+
+func (s *SeriesSort) Validate() error {
+	if s == nil {
+		return nil
+	}
+	switch s.Metric {
+	case SeriesSortMetricLast, SeriesSortMetricAvg, SeriesSortMetricMax:
+	default:
+		return fmt.Errorf("invalid series sort metric: %s", s.Metric)
+	}
+	if s.Order != "" && s.Order != "asc" && s.Order != "desc" {
+		return fmt.Errorf("invalid series sort order: %s", s.Order)
+	}
+	return nil
+}
+
+// maxMovingAvgWindow is the max number of points a moving average can average
+const maxMovingAvgWindow = 1000
+
+// MovingAvgLabel labels the smoothed companion series of a query with MovingAvg,
+// with the window of the moving average
+const MovingAvgLabel = "__moving_avg"
+
+// MovingAvg smooths each series with the average of the points in a trailing
+// window. The first points, with fewer points before them than the window, are
+// averaged over the points available
+type MovingAvg struct {
+	// Window is the number of points averaged, including the point itself
+	Window int `json:"window"`
+	// Companion returns a smoothed series next to each raw series, labelled with
+	// MovingAvgLabel, instead of replacing the values of the series
+	Companion bool `json:"companion,omitempty"`
+}
+
+func (m *MovingAvg) Validate() error {
+	if m.Window < 2 || m.Window > maxMovingAvgWindow {
+		return fmt.Errorf("invalid moving average window: %d, must be between 2 and %d", m.Window, maxMovingAvgWindow)
+	}
+	return nil
+}
+
+// maxHeatmapBuckets is the max number of value buckets of a heatmap
+const maxHeatmapBuckets = 1000
+
+// HeatmapBuckets are the value buckets of a heatmap, Count buckets of equal width
+// over [Min, Max). The values below Min and above Max are in the first and the last bucket
+type HeatmapBuckets struct {
+	Min   float64 `json:"min"`
+	Max   float64 `json:"max"`
+	Count int     `json:"count"`
+}
+
+func (h *HeatmapBuckets) Validate() error {
+	if h.Count < 1 || h.Count > maxHeatmapBuckets {
+		return fmt.Errorf("invalid heatmap bucket count: %d, must be between 1 and %d", h.Count, maxHeatmapBuckets)
+	}
+	if h.Max <= h.Min {
+		return fmt.Errorf("invalid heatmap range: max %v must be greater than min %v", h.Max, h.Min)
+	}
+	return nil
+}
+
+// Bounds returns the Count+1 bounds of the buckets
+func (h *HeatmapBuckets) Bounds() []float64 {
+	width := (h.Max - h.Min) / float64(h.Count)
+	bounds := make([]float64, 0, h.Count+1)
+	for idx := 0; idx < h.Count; idx++ {
+		bounds = append(bounds, h.Min+float64(idx)*width)
+	}
+	return append(bounds, h.Max)
+}
+
+// Heatmap is the result of a heatmap query, the number of rows in each value
+// bucket in each step interval
+type Heatmap struct {
+	// Timestamps are the starts of the step intervals, in milliseconds
+	Timestamps []int64 `json:"timestamps"`
+	// Bounds are the bounds of the value buckets, bucket j is [Bounds[j], Bounds[j+1])
+	Bounds []float64 `json:"bounds"`
+	// Counts are the counts of the rows, Counts[i][j] is the count of the value
+	// bucket j in the step interval of Timestamps[i]
+	Counts [][]int64 `json:"counts"`
+}
+
+// TrailingWindowOperators are the aggregations whose step intervals can be
+// combined into a trailing window
+var TrailingWindowOperators = []AggregateOperator{
+	AggregateOperatorCount,
+	AggregateOperatorSum,
+	AggregateOperatorMin,
+	AggregateOperatorMax,
+}
+
+func (b *BuilderQuery) validateTrailingWindow(panelType PanelType) error {
+	if b.DataSource == DataSourceMetrics {
+		return fmt.Errorf("trailing window is only supported for logs and traces")
+	}
+	if panelType != PanelTypeGraph {
+		return fmt.Errorf("trailing window is only supported for graph panels")
+	}
+	if !slices.Contains(TrailingWindowOperators, b.AggregateOperator) {
+		return fmt.Errorf("trailing window is not supported for the %s aggregation, supported aggregations are %v", b.AggregateOperator, TrailingWindowOperators)
+	}
+	if b.StepInterval <= 0 || b.TrailingWindow <= b.StepInterval || b.TrailingWindow%b.StepInterval != 0 {
+		return fmt.Errorf("invalid trailing window: %d, must be a multiple of the step interval %d larger than it", b.TrailingWindow, b.StepInterval)
+	}
+	return nil
+}
+
+func (b *BuilderQuery) validateArrayAggregation() error {
+	if err := b.ArrayAggregation.Validate(); err != nil {
+		return err
+	}
+	if b.DataSource != DataSourceLogs {
+		return fmt.Errorf("array aggregation is only supported for logs")
+	}
+	if !b.AggregateAttribute.DataType.IsArray() || !b.AggregateAttribute.IsJSON {
+		return fmt.Errorf("array aggregation requires an array typed JSON aggregate attribute, got %s of type %q", b.AggregateAttribute.Key, b.AggregateAttribute.DataType)
+	}
+	if b.ArrayAggregation == ArrayAggregationSum && b.AggregateAttribute.DataType != AttributeKeyDataTypeArrayInt64 && b.AggregateAttribute.DataType != AttributeKeyDataTypeArrayFloat64 {
+		return fmt.Errorf("array aggregation %s requires a numeric array, got %s", b.ArrayAggregation, b.AggregateAttribute.DataType)
+	}
+	if b.AggregateOperator == AggregateOperatorNoOp || b.AggregateOperator == AggregateOperatorCountIf {
+		return fmt.Errorf("array aggregation is not supported for the %s aggregation", b.AggregateOperator)
+	}
+	if b.IncludeBand || b.Heatmap != nil {
+		return fmt.Errorf("array aggregation is not supported with band or heatmap")
+	}
+	return nil
+}
+
+// ValueEncoding is how the point values are encoded in the response JSON
+type ValueEncoding string
+
+const (
+	// ValueEncodingString encodes the values as decimal strings, the integer
+	// values too large for a float64 are encoded exactly
+	ValueEncodingString ValueEncoding = "string"
+	// ValueEncodingInt64 encodes the integer values as JSON numbers, the other
+	// values are encoded as decimal strings
+	ValueEncodingInt64 ValueEncoding = "int64"
+)
+
+func (v ValueEncoding) Validate() error {
+	switch v {
+	case ValueEncodingString, ValueEncodingInt64:
+		return nil
+	default:
+		return fmt.Errorf("invalid value encoding: %s", v)
+	}
+}
+
+// ArrayAggregation is how the elements of an array aggregate attribute are
+// expanded for the aggregate operator
+type ArrayAggregation string
+
+const (
+	// ArrayAggregationJoin aggregates each element of the arrays, a row with n
+	// elements is aggregated n times
+	ArrayAggregationJoin ArrayAggregation = "arrayJoin"
+	// ArrayAggregationSum aggregates the sum of the elements of the array of each row
+	ArrayAggregationSum ArrayAggregation = "arraySum"
+)
+
+func (a ArrayAggregation) Validate() error {
+	switch a {
+	case ArrayAggregationJoin, ArrayAggregationSum:
+		return nil
+	default:
+		return fmt.Errorf("invalid array aggregation: %s", a)
+	}
+}
+
+// TopNWithOther keeps the top N series of each result and sums the other series
+// into a single series, labelled OtherSeriesLabelValue
+type TopNWithOther struct {
+	N int `json:"n"`
+	// Metric is the value of the series they are ranked by, defaults to avg
+	Metric SeriesSortMetric `json:"metric,omitempty"`
+}
+
+// OtherSeriesLabelValue is the value of the labels of the series the overflow
+// series are summed into
+const OtherSeriesLabelValue = "__other__"
+
+func (t *TopNWithOther) Validate() error {
+	if t == nil {
+		return nil
+	}
+	if t.N < 1 {
+		return fmt.Errorf("invalid top n with other: %d, must be at least 1", t.N)
+	}
+	switch t.Metric {
+	case "", SeriesSortMetricLast, SeriesSortMetricAvg, SeriesSortMetricMax:
+	default:
+		return fmt.Errorf("invalid top n with other metric: %s", t.Metric)
+	}
+	return nil
+}
+
+// ListMerge merges the rows of the list queries into a single result ordered
+// by a timestamp, e.g. to interleave two log streams
+type ListMerge struct {
+	// TimestampField is the field of the rows data the rows are ordered by,
+	// defaults to the timestamp of the rows
+	TimestampField string `json:"timestampField,omitempty"`
+	// Order is either asc or desc, defaults to desc
+	Order string `json:"order,omitempty"`
+	// Limit is the max number of merged rows, 0 means no limit
+	Limit int `json:"limit,omitempty"`
+	// QueryName is the name of the merged result, defaults to the names of
+	// the merged queries joined with a comma
+	QueryName string `json:"queryName,omitempty"`
+}
+
+func (m *ListMerge) Validate() error {
+	if m == nil {
+		return nil
+	}
+	if m.Order != "" && m.Order != "asc" && m.Order != "desc" {
+		return fmt.Errorf("invalid list merge order: %s", m.Order)
+	}
+	if m.Limit < 0 {
+		return fmt.Errorf("invalid list merge limit: %d", m.Limit)
+	}
+	return nil
+}
+
+// Ratio divides the series of the numerator query by the series of the
+// denominator query with the same labels, e.g. errors by total calls
+type Ratio struct {
+	// QueryName is the name of the derived result
+	QueryName   string `json:"queryName"`
+	Numerator   string `json:"numerator"`
+	Denominator string `json:"denominator"`
+}
+
+// Validate validates the ratio against the builder queries it divides
+func (r *Ratio) Validate(cq *CompositeQuery) error {
+	if r == nil {
+		return fmt.Errorf("ratio is empty")
+	}
+	if r.QueryName == "" {
+		return fmt.Errorf("ratio query name is required")
+	}
+	if cq == nil || cq.QueryType != QueryTypeBuilder {
+		return fmt.Errorf("ratio %s is only supported for builder queries", r.QueryName)
+	}
+	if _, ok := cq.BuilderQueries[r.QueryName]; ok {
+		return fmt.Errorf("ratio query name %s is already used by a query", r.QueryName)
+	}
+	for _, queryName := range []string{r.Numerator, r.Denominator} {
+		if _, ok := cq.BuilderQueries[queryName]; !ok {
+			return fmt.Errorf("ratio %s references unknown query %q", r.QueryName, queryName)
+		}
+	}
+	return nil
+}
+
 type QueryType string
 
 const (
@@ -250,18 +550,64 @@ type FilterAttributeKeyRequest struct {
 	AggregateAttribute string            `json:"aggregateAttribute"`
 	SearchText         string            `json:"searchText"`
 	Limit              int               `json:"limit"`
+	// Start and End limit the keys to the ones seen in the time range,
+	// in unix nanoseconds. Zero means unbounded.
+	Start int64 `json:"start,omitempty"`
+	End   int64 `json:"end,omitempty"`
 }
 
 type QBFilterSuggestionsRequest struct {
 	DataSource     DataSource `json:"dataSource"`
 	SearchText     string     `json:"searchText"`
+	ValuePrefix    string     `json:"valuePrefix"`
 	Limit          int        `json:"limit"`
 	ExistingFilter *FilterSet `json:"existing_filter"`
+	// Start and End limit the suggestions to the attributes seen in the
+	// time range, in unix nanoseconds. Zero means unbounded.
+	Start int64 `json:"start,omitempty"`
+	End   int64 `json:"end,omitempty"`
+	// HideFilteredKeys omits the attribute keys already in the existing filter
+	// from the suggestions, instead of ranking them last
+	HideFilteredKeys bool `json:"hideFilteredKeys,omitempty"`
 }
 
 type QBFilterSuggestionsResponse struct {
 	AttributeKeys  []AttributeKey `json:"attributes"`
 	ExampleQueries []FilterSet    `json:"example_queries"`
+	// RecommendedOperators are the filter operators which make sense for each of
+	// the suggested attribute keys, in the order of the keys
+	RecommendedOperators []AttributeKeyOperators `json:"recommended_operators"`
+}
+
+// AttributeKeyOperators are the filter operators recommended for an attribute key
+type AttributeKeyOperators struct {
+	Key       AttributeKey     `json:"key"`
+	Operators []FilterOperator `json:"operators"`
+}
+
+type AttributeColumnStatusRequest struct {
+	DataSource    DataSource     `json:"dataSource"`
+	AttributeKeys []AttributeKey `json:"attributes"`
+}
+
+// AttributeColumnStatusResponse echoes the requested keys with IsColumn set
+// to whether the key is a materialized column or is extracted from a map.
+type AttributeColumnStatusResponse struct {
+	AttributeKeys []AttributeKey `json:"attributes"`
+}
+
+// QueryCostEstimate is the estimated amount of data a query reads
+type QueryCostEstimate struct {
+	Rows  uint64 `json:"rows"`
+	Parts uint64 `json:"parts"`
+	Marks uint64 `json:"marks"`
+}
+
+// ClickHouseQueryValidation is the result of validating the clickhouse queries
+type ClickHouseQueryValidation struct {
+	Valid bool `json:"valid"`
+	// Errors are the parse errors by query name
+	Errors map[string]string `json:"errors,omitempty"`
 }
 
 type AttributeKeyDataType string
@@ -287,6 +633,16 @@ func (q AttributeKeyDataType) Validate() error {
 	}
 }
 
+// IsArray returns true for the array data types
+func (q AttributeKeyDataType) IsArray() bool {
+	switch q {
+	case AttributeKeyDataTypeArrayString, AttributeKeyDataTypeArrayInt64, AttributeKeyDataTypeArrayFloat64, AttributeKeyDataTypeArrayBool:
+		return true
+	default:
+		return false
+	}
+}
+
 // FilterAttributeValueRequest is a request to fetch possible attribute values
 // for a selected aggregate operator, aggregate attribute, filter attribute key
 // and search text.
@@ -298,7 +654,12 @@ type FilterAttributeValueRequest struct {
 	FilterAttributeKeyDataType AttributeKeyDataType `json:"filterAttributeKeyDataType"`
 	TagType                    TagType              `json:"tagType"`
 	SearchText                 string               `json:"searchText"`
+	ValuePrefix                string               `json:"valuePrefix"`
 	Limit                      int                  `json:"limit"`
+	// Start and End limit the values to the ones seen in the time range,
+	// in unix nanoseconds. Zero means unbounded.
+	Start int64 `json:"start,omitempty"`
+	End   int64 `json:"end,omitempty"`
 }
 
 type AggregateAttributeResponse struct {
@@ -368,6 +729,106 @@ type QueryRangeParamsV3 struct {
 	NoCache        bool                   `json:"noCache"`
 	Version        string                 `json:"-"`
 	FormatForWeb   bool                   `json:"formatForWeb,omitempty"`
+	// ValuePanelMultiSeriesStrategy decides what to do when a value panel
+	// query produces more than one series. Defaults to returning an error.
+	ValuePanelMultiSeriesStrategy ValuePanelMultiSeriesStrategy `json:"valuePanelMultiSeriesStrategy,omitempty"`
+	// ReturnPromMatrix returns the native prometheus matrix alongside the converted
+	// series for promql queries. Used by the callers that need the native promql types
+	// such as histograms, which are dropped in the conversion to series.
+	ReturnPromMatrix bool `json:"-"`
+	// CacheStats reports how much of each cached query was served from the cache
+	CacheStats bool `json:"cacheStats,omitempty"`
+	// SeriesSort orders the series of each result, after merging with the cache
+	SeriesSort *SeriesSort `json:"seriesSort,omitempty"`
+	// CacheTagSuffix is appended to the cache keys of the queries, letting the
+	// callers namespace the cached results, e.g. per dashboard variable values
+	CacheTagSuffix string `json:"cacheTagSuffix,omitempty"`
+	// DetectCounterResets reports the points where the value of each series
+	// decreased, which for a counter means it was reset. The points are not changed
+	DetectCounterResets bool `json:"detectCounterResets,omitempty"`
+	// ListMerge merges the rows of the list queries into a single result
+	ListMerge *ListMerge `json:"listMerge,omitempty"`
+	// MissingGroupByPlaceholder is the value of the group by labels missing from
+	// the returned series of the builder queries, e.g. "(none)". By default the
+	// labels are left missing
+	MissingGroupByPlaceholder string `json:"missingGroupByPlaceholder,omitempty"`
+	// Ratios are derived results dividing the series of two builder queries
+	Ratios []*Ratio `json:"ratios,omitempty"`
+	// RoundDecimals rounds the returned point values to the number of decimals,
+	// the cached values keep the full precision. Not set means no rounding
+	RoundDecimals *int `json:"roundDecimals,omitempty"`
+	// IncludeListTotal also counts the rows matching each list query, in Result.Total,
+	// so that the pages can be shown out of the total
+	IncludeListTotal bool `json:"includeListTotal,omitempty"`
+	// SampleTo downsamples each returned series to at most the number of points,
+	// e.g. for sparklines, keeping the extremes. The cached series keep the full
+	// resolution. Not set means no sampling
+	SampleTo int `json:"sampleTo,omitempty"`
+	// AuditFilters returns the filters each builder query was run with, in
+	// Result.AppliedFilters, so that they can be recorded for audit
+	AuditFilters bool `json:"auditFilters,omitempty"`
+	// TopNWithOther caps the number of series of each result, the series over
+	// the cap are summed into a single other series instead of being dropped
+	TopNWithOther *TopNWithOther `json:"topNWithOther,omitempty"`
+	// IncludeLabelKeys returns the label keys present across the series of each
+	// result, in Result.LabelKeys, e.g. for the legends and the group by discovery
+	IncludeLabelKeys bool `json:"includeLabelKeys,omitempty"`
+	// Priority is the clickhouse priority the queries are run with, the lower the
+	// value the higher the priority. Not set means the default priority
+	Priority *int `json:"priority,omitempty"`
+	// IncludeAnnotations returns the annotation events within the time range,
+	// e.g. the deploys, in Result.Annotations so that they can be drawn as markers
+	IncludeAnnotations bool `json:"includeAnnotations,omitempty"`
+	// IncludeUnits returns the unit of the metric of each metrics builder query,
+	// from the metric metadata, in Result.Unit so that the axes can be formatted
+	IncludeUnits bool `json:"includeUnits,omitempty"`
+	// IncludeCompleteness returns the completeness of each series of the graph
+	// panels, in Series.Completeness, e.g. to flag the series over ingestion gaps
+	IncludeCompleteness bool `json:"includeCompleteness,omitempty"`
+	// MarkGaps inserts a null point at each step of the time range a series of
+	// the graph panels has no value at, so that the lines break at the gaps.
+	// Unlike FillGaps, no value is filled in
+	MarkGaps bool `json:"markGaps,omitempty"`
+}
+
+// Clone returns a deep copy of the params, except for the values of the
+// variables, filters and having clauses, which are never modified in place
+func (q *QueryRangeParamsV3) Clone() *QueryRangeParamsV3 {
+	if q == nil {
+		return nil
+	}
+	clone := *q
+	if q.Variables != nil {
+		clone.Variables = make(map[string]interface{}, len(q.Variables))
+		for key, value := range q.Variables {
+			clone.Variables[key] = value
+		}
+	}
+	clone.CompositeQuery = q.CompositeQuery.Clone()
+	if q.SeriesSort != nil {
+		seriesSort := *q.SeriesSort
+		clone.SeriesSort = &seriesSort
+	}
+	if q.ListMerge != nil {
+		listMerge := *q.ListMerge
+		clone.ListMerge = &listMerge
+	}
+	if q.Ratios != nil {
+		clone.Ratios = make([]*Ratio, 0, len(q.Ratios))
+		for _, ratio := range q.Ratios {
+			ratioClone := *ratio
+			clone.Ratios = append(clone.Ratios, &ratioClone)
+		}
+	}
+	if q.RoundDecimals != nil {
+		roundDecimals := *q.RoundDecimals
+		clone.RoundDecimals = &roundDecimals
+	}
+	if q.TopNWithOther != nil {
+		topNWithOther := *q.TopNWithOther
+		clone.TopNWithOther = &topNWithOther
+	}
+	return &clone
 }
 
 type PromQuery struct {
@@ -420,6 +881,35 @@ type CompositeQuery struct {
 	FillGaps bool `json:"fillGaps,omitempty"`
 }
 
+// Clone returns a deep copy of the composite query
+func (c *CompositeQuery) Clone() *CompositeQuery {
+	if c == nil {
+		return nil
+	}
+	clone := *c
+	if c.BuilderQueries != nil {
+		clone.BuilderQueries = make(map[string]*BuilderQuery, len(c.BuilderQueries))
+		for name, query := range c.BuilderQueries {
+			clone.BuilderQueries[name] = query.Clone()
+		}
+	}
+	if c.ClickHouseQueries != nil {
+		clone.ClickHouseQueries = make(map[string]*ClickHouseQuery, len(c.ClickHouseQueries))
+		for name, query := range c.ClickHouseQueries {
+			queryClone := *query
+			clone.ClickHouseQueries[name] = &queryClone
+		}
+	}
+	if c.PromQueries != nil {
+		clone.PromQueries = make(map[string]*PromQuery, len(c.PromQueries))
+		for name, query := range c.PromQueries {
+			queryClone := *query
+			clone.PromQueries[name] = &queryClone
+		}
+	}
+	return &clone
+}
+
 func (c *CompositeQuery) EnabledQueries() int {
 	count := 0
 	switch c.QueryType {
@@ -645,6 +1135,9 @@ const (
 	FunctionNameMedian5     FunctionName = "median5"
 	FunctionNameMedian7     FunctionName = "median7"
 	FunctionNameTimeShift   FunctionName = "timeShift"
+	FunctionNameDelta       FunctionName = "delta"
+
+	FunctionNameCrossSeriesQuantile FunctionName = "crossSeriesQuantile"
 )
 
 func (f FunctionName) Validate() error {
@@ -664,7 +1157,9 @@ func (f FunctionName) Validate() error {
 		FunctionNameMedian3,
 		FunctionNameMedian5,
 		FunctionNameMedian7,
-		FunctionNameTimeShift:
+		FunctionNameTimeShift,
+		FunctionNameDelta,
+		FunctionNameCrossSeriesQuantile:
 		return nil
 	default:
 		return fmt.Errorf("invalid function name: %s", f)
@@ -684,6 +1179,7 @@ type BuilderQuery struct {
 	AggregateAttribute AttributeKey      `json:"aggregateAttribute,omitempty"`
 	Temporality        Temporality       `json:"temporality,omitempty"`
 	Filters            *FilterSet        `json:"filters,omitempty"`
+	AggregateFilters   *FilterSet        `json:"aggregateFilters,omitempty"`
 	GroupBy            []AttributeKey    `json:"groupBy,omitempty"`
 	Expression         string            `json:"expression"`
 	Disabled           bool              `json:"disabled"`
@@ -694,11 +1190,111 @@ type BuilderQuery struct {
 	PageSize           uint64            `json:"pageSize"`
 	OrderBy            []OrderBy         `json:"orderBy,omitempty"`
 	ReduceTo           ReduceToOperator  `json:"reduceTo,omitempty"`
-	SelectColumns      []AttributeKey    `json:"selectColumns,omitempty"`
-	TimeAggregation    TimeAggregation   `json:"timeAggregation,omitempty"`
-	SpaceAggregation   SpaceAggregation  `json:"spaceAggregation,omitempty"`
-	Functions          []Function        `json:"functions,omitempty"`
-	ShiftBy            int64
+	// MultiReduceTo reduces the series of a value panel with each of the operators,
+	// in addition to ReduceTo. The values are returned in Result.ReducedValues
+	MultiReduceTo    []ReduceToOperator `json:"multiReduceTo,omitempty"`
+	SelectColumns    []AttributeKey     `json:"selectColumns,omitempty"`
+	TimeAggregation  TimeAggregation    `json:"timeAggregation,omitempty"`
+	SpaceAggregation SpaceAggregation   `json:"spaceAggregation,omitempty"`
+	Functions        []Function         `json:"functions,omitempty"`
+	AlignmentOffset  int64              `json:"alignmentOffset,omitempty"`
+	// IncludeCount also returns the number of rows aggregated in each point,
+	// in Series.Counts. The queries with counts are not cached
+	IncludeCount bool `json:"includeCount,omitempty"`
+	// IncludeBand also returns the min and max of the aggregated values in each
+	// point, in Series.BandMin and Series.BandMax. The queries with a band are not cached
+	IncludeBand bool `json:"includeBand,omitempty"`
+	// IncludeExemplars attaches an exemplar trace id to the points of the
+	// metrics queries, in Series.Exemplars
+	IncludeExemplars bool `json:"includeExemplars,omitempty"`
+	// DropZeroSeries drops the series whose every point value is zero or NaN,
+	// after merging with the cache. The cached series are kept
+	DropZeroSeries bool `json:"dropZeroSeries,omitempty"`
+	// PercentOfTotal returns each point value as the percentage of the total of the
+	// point values of all the series at its timestamp, after merging with the cache.
+	// The points at the timestamps with a zero total are null
+	PercentOfTotal bool `json:"percentOfTotal,omitempty"`
+	// SpanTree returns the spans of the traces of a trace panel query, assembled
+	// into trees by their parent span ids in Result.SpanTrees, rather than a
+	// summary row per trace
+	SpanTree bool `json:"spanTree,omitempty"`
+	// TrailingWindow aggregates each point over the trailing window of the number of
+	// seconds ending at it rather than over its step interval, e.g. the errors in
+	// the last 5 minutes at each step. The step intervals are queried and cached as
+	// usual and combined into the overlapping windows after merging with the cache
+	TrailingWindow int64 `json:"trailingWindow,omitempty"`
+	// ValueEncoding is how the point values of the query are encoded in the
+	// response, defaults to ValueEncodingString
+	ValueEncoding ValueEncoding `json:"valueEncoding,omitempty"`
+	// ArrayAggregation aggregates an array aggregate attribute, a JSON body key of
+	// the logs, over its elements expanded with arrayJoin or arraySum
+	ArrayAggregation ArrayAggregation `json:"arrayAggregation,omitempty"`
+	// LegendFormat names each series of the query in Series.Name, from a template
+	// over its labels, e.g. "{service.name} / {http.route}". The missing labels
+	// render as empty
+	LegendFormat string `json:"legendFormat,omitempty"`
+	// CompareShift also runs the query over the window shifted back by the number
+	// of seconds, e.g. the previous week, and returns it as an additional result
+	// of the query with the timestamps realigned to the requested window
+	CompareShift int64 `json:"compareShift,omitempty"`
+	// AdditionalMetrics are queried with the aggregation of the metrics query in
+	// the same database query as its aggregate attribute. The series of each of
+	// the metrics are labelled with the __name__ of their metric
+	AdditionalMetrics []AttributeKey `json:"additionalMetrics,omitempty"`
+	// Heatmap counts the rows in each value bucket of the aggregate attribute, in
+	// each step interval, and returns the counts in Result.Heatmap. The heatmap
+	// queries are not cached
+	Heatmap *HeatmapBuckets `json:"heatmap,omitempty"`
+	// ListColumns projects the rows of a logs list query to the columns, out of the
+	// LogsListColumns, for the list panels which show only a few of the columns.
+	// Not set means all the columns
+	ListColumns []string `json:"listColumns,omitempty"`
+	// MovingAvg smooths the returned series with a moving average, after merging
+	// with the cache. The cached series keep the raw values
+	MovingAvg *MovingAvg `json:"movingAvg,omitempty"`
+	ShiftBy   int64
+}
+
+// LogsListColumns are the columns of the rows of the logs list queries. The timestamp
+// and the id are selected even when not in the ListColumns, to order and page the rows
+var LogsListColumns = []string{
+	"timestamp", "id", "trace_id", "span_id", "trace_flags", "severity_text", "severity_number", "body",
+	"attributes_string", "attributes_int64", "attributes_float64", "attributes_bool", "resources_string",
+}
+
+// Clone returns a deep copy of the builder query
+func (b *BuilderQuery) Clone() *BuilderQuery {
+	if b == nil {
+		return nil
+	}
+	clone := *b
+	clone.Filters = b.Filters.Clone()
+	clone.AggregateFilters = b.AggregateFilters.Clone()
+	clone.GroupBy = slices.Clone(b.GroupBy)
+	clone.Having = slices.Clone(b.Having)
+	clone.OrderBy = slices.Clone(b.OrderBy)
+	clone.MultiReduceTo = slices.Clone(b.MultiReduceTo)
+	clone.SelectColumns = slices.Clone(b.SelectColumns)
+	clone.AdditionalMetrics = slices.Clone(b.AdditionalMetrics)
+	clone.ListColumns = slices.Clone(b.ListColumns)
+	if b.Heatmap != nil {
+		heatmap := *b.Heatmap
+		clone.Heatmap = &heatmap
+	}
+	if b.MovingAvg != nil {
+		movingAvg := *b.MovingAvg
+		clone.MovingAvg = &movingAvg
+	}
+	if b.Functions != nil {
+		clone.Functions = make([]Function, 0, len(b.Functions))
+		for _, function := range b.Functions {
+			clone.Functions = append(clone.Functions, Function{
+				Name: function.Name,
+				Args: slices.Clone(function.Args),
+			})
+		}
+	}
+	return &clone
 }
 
 // CanDefaultZero returns true if the missing value can be substituted by zero
@@ -722,7 +1318,8 @@ func (b *BuilderQuery) CanDefaultZero() bool {
 	case DataSourceTraces, DataSourceLogs:
 		if b.AggregateOperator.IsRateOperator() ||
 			b.AggregateOperator == AggregateOperatorCount ||
-			b.AggregateOperator == AggregateOperatorCountDistinct {
+			b.AggregateOperator == AggregateOperatorCountDistinct ||
+			b.AggregateOperator == AggregateOperatorCountIf {
 			return true
 		}
 	}
@@ -777,6 +1374,134 @@ func (b *BuilderQuery) Validate(panelType PanelType) error {
 			return fmt.Errorf("filters are invalid: %w", err)
 		}
 	}
+	if b.AlignmentOffset != 0 {
+		if b.DataSource == DataSourceMetrics {
+			return fmt.Errorf("alignment offset is not supported for metrics")
+		}
+		if b.AlignmentOffset < 0 || b.AlignmentOffset >= b.StepInterval {
+			return fmt.Errorf("alignment offset must be in [0, step interval), got %d", b.AlignmentOffset)
+		}
+	}
+	if b.IncludeCount && b.DataSource == DataSourceMetrics {
+		return fmt.Errorf("include count is only supported for logs and traces")
+	}
+	if b.IncludeBand {
+		if b.DataSource == DataSourceMetrics {
+			return fmt.Errorf("include band is only supported for logs and traces")
+		}
+		if b.AggregateAttribute.Key == "" {
+			return fmt.Errorf("include band requires an aggregate attribute")
+		}
+	}
+	if b.IncludeExemplars && b.DataSource != DataSourceMetrics {
+		return fmt.Errorf("include exemplars is only supported for metrics")
+	}
+	if b.Heatmap != nil {
+		if b.DataSource == DataSourceMetrics {
+			return fmt.Errorf("heatmap is only supported for logs and traces")
+		}
+		if panelType != PanelTypeGraph {
+			return fmt.Errorf("heatmap is only supported for graph panels")
+		}
+		if b.AggregateOperator != AggregateOperatorCount || b.AggregateAttribute.Key == "" {
+			return fmt.Errorf("heatmap requires the count aggregate operator with an aggregate attribute")
+		}
+		if len(b.GroupBy) > 0 {
+			return fmt.Errorf("heatmap is not supported with group by")
+		}
+		if err := b.Heatmap.Validate(); err != nil {
+			return err
+		}
+	}
+	if b.PercentOfTotal && panelType != PanelTypeGraph {
+		return fmt.Errorf("percent of total is only supported for graph panels")
+	}
+	if b.SpanTree {
+		if b.DataSource != DataSourceTraces {
+			return fmt.Errorf("span tree is only supported for traces")
+		}
+		if panelType != PanelTypeTrace {
+			return fmt.Errorf("span tree is only supported for trace panels")
+		}
+	}
+	if b.TrailingWindow != 0 {
+		if err := b.validateTrailingWindow(panelType); err != nil {
+			return err
+		}
+	}
+	if b.ValueEncoding != "" {
+		if err := b.ValueEncoding.Validate(); err != nil {
+			return err
+		}
+	}
+	if b.ArrayAggregation != "" {
+		if err := b.validateArrayAggregation(); err != nil {
+			return err
+		}
+	}
+	if b.MovingAvg != nil {
+		if panelType != PanelTypeGraph {
+			return fmt.Errorf("moving average is only supported for graph panels")
+		}
+		if err := b.MovingAvg.Validate(); err != nil {
+			return err
+		}
+	}
+	if len(b.ListColumns) > 0 {
+		if b.DataSource != DataSourceLogs {
+			return fmt.Errorf("list columns are only supported for logs, the traces list is projected to the select columns")
+		}
+		if panelType != PanelTypeList {
+			return fmt.Errorf("list columns are only supported for list panels")
+		}
+		for _, column := range b.ListColumns {
+			if !slices.Contains(LogsListColumns, column) {
+				return fmt.Errorf("invalid list column: %s, must be one of %v", column, LogsListColumns)
+			}
+		}
+	}
+	if len(b.AdditionalMetrics) > 0 {
+		if b.DataSource != DataSourceMetrics {
+			return fmt.Errorf("additional metrics are only supported for metrics")
+		}
+		if b.IncludeExemplars {
+			return fmt.Errorf("additional metrics are not supported with exemplars")
+		}
+		for _, metric := range b.AdditionalMetrics {
+			if metric.Key == "" {
+				return fmt.Errorf("additional metric name is required")
+			}
+		}
+	}
+	if b.CompareShift != 0 {
+		if b.CompareShift < 0 {
+			return fmt.Errorf("compare shift must be positive, got %d", b.CompareShift)
+		}
+		if b.QueryName != b.Expression {
+			return fmt.Errorf("compare shift is not supported for formulas")
+		}
+	}
+	if len(b.MultiReduceTo) > 0 {
+		if b.DataSource != DataSourceMetrics || panelType != PanelTypeValue {
+			return fmt.Errorf("multi reduce to is only supported for metrics value panels")
+		}
+		for _, reduceTo := range b.MultiReduceTo {
+			if err := reduceTo.Validate(); err != nil {
+				return err
+			}
+		}
+	}
+	if b.AggregateOperator == AggregateOperatorCountIf {
+		if b.DataSource != DataSourceLogs {
+			return fmt.Errorf("aggregate operator %s is only supported for logs", b.AggregateOperator)
+		}
+		if b.AggregateFilters == nil || len(b.AggregateFilters.Items) == 0 {
+			return fmt.Errorf("aggregate filters are required for aggregate operator %s", b.AggregateOperator)
+		}
+		if err := b.AggregateFilters.Validate(); err != nil {
+			return fmt.Errorf("aggregate filters are invalid: %w", err)
+		}
+	}
 	if b.GroupBy != nil {
 		// if len(b.GroupBy) > 0 && panelType == PanelTypeList {
 		// 	return fmt.Errorf("group by is not supported for list panel type")
@@ -865,6 +1590,23 @@ func (b *BuilderQuery) Validate(panelType PanelType) error {
 					}
 					function.Args[0] = threshold
 				}
+			} else if function.Name == FunctionNameCrossSeriesQuantile {
+				if len(function.Args) == 0 {
+					return fmt.Errorf("quantile param missing in query")
+				}
+				quantile, ok := function.Args[0].(float64)
+				if !ok {
+					// if string, attempt to convert to float
+					var err error
+					quantile, err = strconv.ParseFloat(fmt.Sprintf("%v", function.Args[0]), 64)
+					if err != nil {
+						return fmt.Errorf("quantile param should be a float")
+					}
+					function.Args[0] = quantile
+				}
+				if quantile < 0 || quantile > 1 {
+					return fmt.Errorf("quantile param should be between 0 and 1")
+				}
 			}
 		}
 	}
@@ -877,6 +1619,14 @@ type FilterSet struct {
 	Items    []FilterItem `json:"items"`
 }
 
+// Clone returns a copy of the filter set, with its own items
+func (f *FilterSet) Clone() *FilterSet {
+	if f == nil {
+		return nil
+	}
+	return &FilterSet{Operator: f.Operator, Items: slices.Clone(f.Items)}
+}
+
 func (f *FilterSet) Validate() error {
 	if f == nil {
 		return nil
@@ -935,6 +1685,58 @@ const (
 	FilterOperatorNotHas FilterOperator = "nhas"
 )
 
+// ExampleFilterOperator returns the operator of the example queries suggested for
+// the attributes of the data type. The numbers are compared, they are rarely
+// filtered by an exact value, the other values are matched exactly
+func ExampleFilterOperator(dataType AttributeKeyDataType) FilterOperator {
+	switch dataType {
+	case AttributeKeyDataTypeInt64, AttributeKeyDataTypeFloat64:
+		return FilterOperatorGreaterThan
+	default:
+		return FilterOperatorEqual
+	}
+}
+
+// RecommendedFilterOperators returns the filter operators which make sense for the
+// attribute keys of the data type, e.g. the comparisons for the numbers
+func RecommendedFilterOperators(dataType AttributeKeyDataType) []FilterOperator {
+	switch dataType {
+	case AttributeKeyDataTypeString:
+		return []FilterOperator{
+			FilterOperatorEqual, FilterOperatorNotEqual,
+			FilterOperatorContains, FilterOperatorNotContains,
+			FilterOperatorLike, FilterOperatorNotLike,
+			FilterOperatorRegex, FilterOperatorNotRegex,
+			FilterOperatorIn, FilterOperatorNotIn,
+			FilterOperatorExists, FilterOperatorNotExists,
+		}
+	case AttributeKeyDataTypeInt64, AttributeKeyDataTypeFloat64:
+		return []FilterOperator{
+			FilterOperatorEqual, FilterOperatorNotEqual,
+			FilterOperatorGreaterThan, FilterOperatorGreaterThanOrEq,
+			FilterOperatorLessThan, FilterOperatorLessThanOrEq,
+			FilterOperatorIn, FilterOperatorNotIn,
+			FilterOperatorExists, FilterOperatorNotExists,
+		}
+	case AttributeKeyDataTypeBool:
+		return []FilterOperator{
+			FilterOperatorEqual, FilterOperatorNotEqual,
+			FilterOperatorExists, FilterOperatorNotExists,
+		}
+	case AttributeKeyDataTypeArrayString, AttributeKeyDataTypeArrayInt64,
+		AttributeKeyDataTypeArrayFloat64, AttributeKeyDataTypeArrayBool:
+		return []FilterOperator{
+			FilterOperatorHas, FilterOperatorNotHas,
+			FilterOperatorExists, FilterOperatorNotExists,
+		}
+	default:
+		return []FilterOperator{
+			FilterOperatorEqual, FilterOperatorNotEqual,
+			FilterOperatorExists, FilterOperatorNotExists,
+		}
+	}
+}
+
 type FilterItem struct {
 	Key      AttributeKey   `json:"key"`
 	Value    interface{}    `json:"value"`
@@ -1032,6 +1834,65 @@ type Result struct {
 	Series    []*Series `json:"series,omitempty"`
 	List      []*Row    `json:"list,omitempty"`
 	Table     *Table    `json:"table,omitempty"`
+	// Truncated is set when the series were truncated to the max number of series
+	Truncated bool `json:"truncated,omitempty"`
+	// MinTimestamp and MaxTimestamp are the timestamps of the first and the last
+	// point of the series, i.e. the window the series actually cover
+	MinTimestamp int64 `json:"minTimestamp,omitempty"`
+	MaxTimestamp int64 `json:"maxTimestamp,omitempty"`
+	// Matrix is the native prometheus matrix, only set when requested with ReturnPromMatrix
+	Matrix promql.Matrix `json:"-"`
+	// CacheStats is only set when requested with CacheStats, for the cached queries
+	CacheStats *CacheStats `json:"cacheStats,omitempty"`
+	// Step is the step in seconds the series were computed with, which can differ
+	// from the requested step when it is raised to limit the number of points.
+	// It is not set for clickhouse queries, which define their own step
+	Step int64 `json:"step,omitempty"`
+	// ReducedValues are the values of the series reduced with each of the
+	// MultiReduceTo operators of the query
+	ReducedValues map[ReduceToOperator]float64 `json:"reducedValues,omitempty"`
+	// ExecutionMillis is the wall-clock time spent in the database calls of the
+	// query, in milliseconds. It is not set when the query was served from the cache
+	ExecutionMillis int64 `json:"executionMillis,omitempty"`
+	// QueryID is the clickhouse query_id the query was run with, which the
+	// query can be cancelled with. Only set for the clickhouse and list queries
+	QueryID string `json:"queryId,omitempty"`
+	// Total is the number of rows matching the list query, of which List is a page.
+	// Only set when requested with IncludeListTotal
+	Total *int64 `json:"total,omitempty"`
+	// CompareShift is set on the additional result of a query with CompareShift,
+	// whose series cover the window shifted back by the number of seconds
+	CompareShift int64 `json:"compareShift,omitempty"`
+	// AppliedFilters are the filters the query was run with, after the variables
+	// were substituted. Only set when requested with AuditFilters
+	AppliedFilters *FilterSet `json:"appliedFilters,omitempty"`
+	// Heatmap is the result of a query with Heatmap, in place of the series
+	Heatmap *Heatmap `json:"heatmap,omitempty"`
+	// LabelKeys are the sorted label keys present across the series, the union of
+	// the keys of the labels of each series. Only set when requested with IncludeLabelKeys
+	LabelKeys []string `json:"labelKeys,omitempty"`
+	// Notes are informational notes about how the query was run, e.g. when its
+	// step was raised to the min step of the server
+	Notes []string `json:"notes,omitempty"`
+	// Annotations are the annotation events within the time range of the query,
+	// sorted by timestamp. Only set when requested with IncludeAnnotations
+	Annotations []Annotation `json:"annotations,omitempty"`
+	// SpanTrees are the spans of a trace panel query with SpanTree assembled into
+	// trees, the roots are the spans without a parent among the returned spans.
+	// Set in place of List
+	SpanTrees []*SpanNode `json:"spanTrees,omitempty"`
+	// Unit is the unit of the metric of the query, e.g. bytes or seconds, the rates
+	// are per second of it. Only set when requested with IncludeUnits, for the metrics
+	// builder queries whose metric has a unit and that don't count the samples
+	Unit string `json:"unit,omitempty"`
+}
+
+// CacheStats reports how much of the requested range of a query was served from
+// the cache and how much was fetched
+type CacheStats struct {
+	RetrieveStatus string `json:"retrieveStatus"`
+	CachedMillis   int64  `json:"cachedMillis"`
+	FetchedMillis  int64  `json:"fetchedMillis"`
 }
 
 type LogsLiveTailClient struct {
@@ -1045,6 +1906,27 @@ type Series struct {
 	Labels      map[string]string   `json:"labels"`
 	LabelsArray []map[string]string `json:"labelsArray"`
 	Points      []Point             `json:"values"`
+	// ResetTimestamps are the timestamps of the points where the value of the
+	// counter decreased, only set when requested with DetectCounterResets
+	ResetTimestamps []int64 `json:"resetTimestamps,omitempty"`
+	// Counts are the number of rows aggregated in each of the points, aligned
+	// with the points. Only set for the builder queries with IncludeCount
+	Counts []int64 `json:"counts,omitempty"`
+	// BandMin and BandMax are the min and max of the values aggregated in each of
+	// the points, aligned with the points. Only set for the builder queries with IncludeBand
+	BandMin []float64 `json:"bandMin,omitempty"`
+	BandMax []float64 `json:"bandMax,omitempty"`
+	// Completeness is the ratio, in [0, 1], of the steps of the time range with a
+	// (non NaN) value to all the steps of the time range. Only set when requested
+	// with IncludeCompleteness
+	Completeness *float64 `json:"completeness,omitempty"`
+	// Name is the name of the series rendered from the LegendFormat of the
+	// builder query, only set for the queries with a legend format
+	Name string `json:"name,omitempty"`
+	// Exemplars are the trace ids of the exemplars recorded in the intervals of
+	// the points, by the timestamps of the points. Only set for the builder
+	// queries with IncludeExemplars, few of the points have an exemplar
+	Exemplars map[int64]string `json:"exemplars,omitempty"`
 }
 
 func (s *Series) SortPoints() {
@@ -1085,34 +1967,125 @@ func (s *Series) RemoveDuplicatePoints() {
 type Row struct {
 	Timestamp time.Time              `json:"timestamp"`
 	Data      map[string]interface{} `json:"data"`
+	// QueryName is the query the row is from, only set for the merged list results
+	QueryName string `json:"queryName,omitempty"`
 }
 
 type Point struct {
 	Timestamp int64
 	Value     float64
+	// IntValue is the exact value of the points read from an integer column
+	// whose value a float64 can't represent exactly, Value is its approximation
+	IntValue *int64
+	// IntEncoded encodes the integer value of the point as a JSON number,
+	// set for the points of the queries with ValueEncodingInt64
+	IntEncoded bool
+	// Null marks a step without a value, encoded as a null value. Its Value is NaN
+	Null bool
+}
+
+// maxExactFloatInt is the magnitude up to which a float64 represents every integer
+const maxExactFloatInt = 1 << 53
+
+// SetIntValue sets the value of the point to the integer, keeping the exact
+// value when a float64 can't represent it
+func (p *Point) SetIntValue(value int64) {
+	p.Value = float64(value)
+	p.IntValue = nil
+	if value > maxExactFloatInt || value < -maxExactFloatInt {
+		p.IntValue = &value
+	}
+}
+
+// jsonValue returns the value of the point as it is encoded in JSON
+func (p *Point) jsonValue() interface{} {
+	if p.Null {
+		return nil
+	}
+	if p.IntValue != nil {
+		if p.IntEncoded {
+			return *p.IntValue
+		}
+		return strconv.FormatInt(*p.IntValue, 10)
+	}
+	if p.IntEncoded && p.Value == math.Trunc(p.Value) && math.Abs(p.Value) <= maxExactFloatInt {
+		return int64(p.Value)
+	}
+	return strconv.FormatFloat(p.Value, 'f', -1, 64)
 }
 
 // MarshalJSON implements json.Marshaler.
 func (p *Point) MarshalJSON() ([]byte, error) {
-	v := strconv.FormatFloat(p.Value, 'f', -1, 64)
+	v := p.jsonValue()
 	return json.Marshal(map[string]interface{}{"timestamp": p.Timestamp, "value": v})
 }
 
-// UnmarshalJSON implements json.Unmarshaler.
+// UnmarshalJSON implements json.Unmarshaler. The value is either a decimal
+// string or, for the integer encoded points, a JSON number
 func (p *Point) UnmarshalJSON(data []byte) error {
 	var v struct {
-		Timestamp int64  `json:"timestamp"`
-		Value     string `json:"value"`
+		Timestamp int64           `json:"timestamp"`
+		Value     json.RawMessage `json:"value"`
 	}
 	if err := json.Unmarshal(data, &v); err != nil {
 		return err
 	}
 	p.Timestamp = v.Timestamp
+	p.IntValue, p.IntEncoded, p.Null = nil, false, false
+	if string(v.Value) == "null" {
+		p.Value, p.Null = math.NaN(), true
+		return nil
+	}
+
+	var value string
+	if len(v.Value) > 0 && v.Value[0] != '"' {
+		p.IntEncoded = true
+		value = string(v.Value)
+	} else if err := json.Unmarshal(v.Value, &value); err != nil {
+		return err
+	}
+	if intValue, err := strconv.ParseInt(value, 10, 64); err == nil {
+		p.SetIntValue(intValue)
+		return nil
+	}
 	var err error
-	p.Value, err = strconv.ParseFloat(v.Value, 64)
+	p.Value, err = strconv.ParseFloat(value, 64)
 	return err
 }
 
+// Exemplar is a trace id recorded with the samples of a metric, bucketed
+// to the step of the query and labelled with its group by attributes
+type Exemplar struct {
+	Labels    map[string]string
+	Timestamp int64
+	TraceID   string
+}
+
+// SpanNode is a span of a trace and its child spans, ordered by timestamp
+type SpanNode struct {
+	Span     *Row        `json:"span"`
+	Children []*SpanNode `json:"children,omitempty"`
+}
+
+// Annotation is an event, e.g. a deploy, drawn as a marker over the series
+type Annotation struct {
+	ID int64 `json:"id" db:"id"`
+	// Timestamp is the time of the event in milliseconds
+	Timestamp int64  `json:"timestamp" db:"timestamp"`
+	Title     string `json:"title" db:"title"`
+	Text      string `json:"text,omitempty" db:"text"`
+}
+
+func (a *Annotation) Validate() error {
+	if a.Timestamp <= 0 {
+		return fmt.Errorf("invalid annotation timestamp: %d", a.Timestamp)
+	}
+	if strings.TrimSpace(a.Title) == "" {
+		return fmt.Errorf("annotation title is required")
+	}
+	return nil
+}
+
 // SavedView is a saved query for the explore page
 // It is a composite query with a source page name and user defined tags
 // The source page name is used to identify the page that initiated the query
@@ -64,6 +64,7 @@ func PostProcessResult(result []*v3.Result, queryRangeParams *v3.QueryRangeParam
 				return nil, err
 			}
 			formulaResult.QueryName = query.QueryName
+			formulaResult.Step = query.StepInterval
 			ApplyHavingClause([]*v3.Result{formulaResult}, queryRangeParams)
 			ApplyMetricLimit([]*v3.Result{formulaResult}, queryRangeParams)
 			result = append(result, formulaResult)
@@ -20,6 +20,12 @@ func applyReduceTo(result []*v3.Result, queryRangeParams *v3.QueryRangeParamsV3)
 		// and for table and value panels
 		if builderQueries[result.QueryName] != nil && (builderQueries[result.QueryName].DataSource == v3.DataSourceMetrics &&
 			(queryRangeParams.CompositeQuery.PanelType == v3.PanelTypeTable || queryRangeParams.CompositeQuery.PanelType == v3.PanelTypeValue)) {
+			// the multi reduce values are computed from the same series, before
+			// it is reduced with reduceTo
+			if len(builderQueries[result.QueryName].MultiReduceTo) > 0 && len(result.Series) == 1 {
+				result.ReducedValues = multiReduce(result.Series[0].Points, builderQueries[result.QueryName].MultiReduceTo)
+			}
+
 			reduceTo := builderQueries[result.QueryName].ReduceTo
 
 			switch reduceTo {
@@ -69,3 +75,40 @@ func applyReduceTo(result []*v3.Result, queryRangeParams *v3.QueryRangeParamsV3)
 		}
 	}
 }
+
+// multiReduce reduces the points with each of the operators, no values
+// are returned when there are no points
+func multiReduce(points []v3.Point, operators []v3.ReduceToOperator) map[v3.ReduceToOperator]float64 {
+	if len(points) == 0 {
+		return nil
+	}
+
+	var sum float64
+	min, max := points[0].Value, points[0].Value
+	for _, point := range points {
+		sum += point.Value
+		if point.Value < min {
+			min = point.Value
+		}
+		if point.Value > max {
+			max = point.Value
+		}
+	}
+
+	values := make(map[v3.ReduceToOperator]float64, len(operators))
+	for _, operator := range operators {
+		switch operator {
+		case v3.ReduceToOperatorLast:
+			values[operator] = points[len(points)-1].Value
+		case v3.ReduceToOperatorSum:
+			values[operator] = sum
+		case v3.ReduceToOperatorAvg:
+			values[operator] = sum / float64(len(points))
+		case v3.ReduceToOperatorMin:
+			values[operator] = min
+		case v3.ReduceToOperatorMax:
+			values[operator] = max
+		}
+	}
+	return values
+}
@@ -1,6 +1,7 @@
 package postprocess
 
 import (
+	"math"
 	"testing"
 
 	v3 "go.signoz.io/signoz/pkg/query-service/model/v3"
@@ -97,3 +98,59 @@ func TestApplyReduceTo(t *testing.T) {
 		})
 	}
 }
+
+func TestApplyReduceToMultiReduce(t *testing.T) {
+	results := []*v3.Result{
+		{
+			QueryName: "A",
+			Series: []*v3.Series{
+				{
+					Points: []v3.Point{
+						{Timestamp: 1, Value: 0.5},
+						{Timestamp: 2, Value: 0.1},
+						{Timestamp: 3, Value: 0.9},
+						{Timestamp: 4, Value: 0.3},
+					},
+				},
+			},
+		},
+	}
+	params := &v3.QueryRangeParamsV3{
+		CompositeQuery: &v3.CompositeQuery{
+			PanelType: v3.PanelTypeValue,
+			BuilderQueries: map[string]*v3.BuilderQuery{
+				"A": {
+					DataSource: v3.DataSourceMetrics,
+					ReduceTo:   v3.ReduceToOperatorLast,
+					MultiReduceTo: []v3.ReduceToOperator{
+						v3.ReduceToOperatorMin,
+						v3.ReduceToOperatorMax,
+						v3.ReduceToOperatorAvg,
+						v3.ReduceToOperatorLast,
+					},
+				},
+			},
+		},
+	}
+
+	applyReduceTo(results, params)
+
+	want := map[v3.ReduceToOperator]float64{
+		v3.ReduceToOperatorMin:  0.1,
+		v3.ReduceToOperatorMax:  0.9,
+		v3.ReduceToOperatorAvg:  0.45,
+		v3.ReduceToOperatorLast: 0.3,
+	}
+	if len(results[0].ReducedValues) != len(want) {
+		t.Fatalf("got %v, want %v", results[0].ReducedValues, want)
+	}
+	for operator, value := range want {
+		if math.Abs(results[0].ReducedValues[operator]-value) > 1e-9 {
+			t.Errorf("got %v for %s, want %v", results[0].ReducedValues[operator], operator, value)
+		}
+	}
+	// the series is still reduced with reduceTo
+	if len(results[0].Series[0].Points) != 1 || results[0].Series[0].Points[0].Value != 0.3 {
+		t.Errorf("got %v, want the last point", results[0].Series[0].Points)
+	}
+}
@@ -0,0 +1,25 @@
+package tests
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.signoz.io/signoz/pkg/query-service/featureManager"
+	"go.signoz.io/signoz/pkg/query-service/utils"
+)
+
+func TestCancelQueryKillsQuery(t *testing.T) {
+	require := require.New(t)
+
+	testDB := utils.NewQueryServiceDBForTests(t)
+	reader, mockClickhouse := NewMockClickhouseReader(t, testDB, featureManager.StartManager())
+
+	queryID := "4f0c2a7e-8c1d-4a55-9d2e-1b6f0e3c9a10"
+	mockClickhouse.ExpectExec(
+		`KILL QUERY WHERE query_id = \? ASYNC`,
+	).WithArgs(queryID)
+
+	require.Nil(reader.CancelQuery(context.Background(), queryID))
+	require.Nil(mockClickhouse.ExpectationsWereMet())
+}
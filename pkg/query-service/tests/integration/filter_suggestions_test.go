@@ -131,6 +131,337 @@ func TestLogsFilterSuggestionsWithExistingFilter(t *testing.T) {
 	}
 }
 
+// Attribute keys already in the existing filter should be ranked last,
+// or omitted if requested
+func TestLogsFilterSuggestionsDemoteFilteredKeys(t *testing.T) {
+	testFilterAttrib := v3.AttributeKey{
+		Key:      "container_id",
+		Type:     v3.AttributeKeyTypeResource,
+		DataType: v3.AttributeKeyDataTypeString,
+		IsColumn: false,
+	}
+	testAttrib := v3.AttributeKey{
+		Key:      "tenant_id",
+		Type:     v3.AttributeKeyTypeTag,
+		DataType: v3.AttributeKeyDataTypeString,
+		IsColumn: false,
+	}
+	testFilter := v3.FilterSet{
+		Operator: "AND",
+		Items: []v3.FilterItem{
+			{
+				Key:      testFilterAttrib,
+				Operator: "=",
+				Value:    "test-container",
+			},
+		},
+	}
+	testFilterJson, err := json.Marshal(testFilter)
+	require.Nil(t, err, "couldn't serialize existing filter to JSON")
+
+	isFilteredKey := func(a v3.AttributeKey) bool {
+		return a.Key == testFilterAttrib.Key && a.Type == testFilterAttrib.Type
+	}
+
+	for _, hide := range []bool{false, true} {
+		t.Run(fmt.Sprintf("hideFilteredKeys=%t", hide), func(t *testing.T) {
+			require := require.New(t)
+			tb := NewFilterSuggestionsTestBed(t)
+
+			tb.mockAttribKeysQueryResponse([]v3.AttributeKey{testFilterAttrib, testAttrib})
+			tb.mockAttribValuesQueryResponse(testAttrib, []string{"test-tenant"})
+
+			suggestionsResp := tb.GetQBFilterSuggestionsForLogs(map[string]string{
+				"existingFilter":   base64.RawURLEncoding.EncodeToString(testFilterJson),
+				"hideFilteredKeys": fmt.Sprintf("%t", hide),
+			})
+
+			// the filtered resource key no longer outranks the tag key
+			require.Greater(len(suggestionsResp.AttributeKeys), 1)
+			require.Equal(testAttrib.Key, suggestionsResp.AttributeKeys[0].Key)
+
+			filteredIdx := slices.IndexFunc(suggestionsResp.AttributeKeys, isFilteredKey)
+			if hide {
+				require.Equal(-1, filteredIdx, "expected the filtered key to be omitted")
+			} else {
+				require.Equal(len(suggestionsResp.AttributeKeys)-1, filteredIdx, "expected the filtered key to be ranked last")
+			}
+		})
+	}
+}
+
+// Attribute keys should be suggested regardless of the case of the search text
+func TestLogsFilterSuggestionsCaseInsensitiveSearchText(t *testing.T) {
+	require := require.New(t)
+	tb := NewFilterSuggestionsTestBed(t)
+
+	testAttrib := v3.AttributeKey{
+		Key:      "service.name",
+		Type:     v3.AttributeKeyTypeResource,
+		DataType: v3.AttributeKeyDataTypeString,
+		IsColumn: false,
+	}
+	searchText := "Service.Name"
+
+	cols := []mockhouse.ColumnType{
+		{Type: "String", Name: "tagKey"},
+		{Type: "String", Name: "tagType"},
+		{Type: "String", Name: "tagDataType"},
+	}
+	values := [][]any{{testAttrib.Key, string(testAttrib.Type), string(testAttrib.DataType)}}
+	tb.mockClickhouse.ExpectQuery(
+		`select distinct tagKey.*from.*signoz_logs.distributed_tag_attributes.*lower\(tagKey\) LIKE lower\(\$1\).*`,
+	).WithArgs(
+		"%"+searchText+"%", constants.DefaultFilterSuggestionsLimit,
+	).WillReturnRows(mockhouse.NewRows(cols, values))
+	tb.mockCreateTableStatement("CREATE TABLE signoz_logs.distributed_logs")
+	tb.mockAttribValuesQueryResponse(testAttrib, []string{"frontend"})
+
+	suggestionsResp := tb.GetQBFilterSuggestionsForLogs(map[string]string{
+		"searchText": searchText,
+	})
+
+	require.True(slices.ContainsFunc(
+		suggestionsResp.AttributeKeys, func(a v3.AttributeKey) bool {
+			return a.Key == testAttrib.Key
+		},
+	), "expected the key to be found with the mixed-case search text")
+	require.Nil(tb.mockClickhouse.ExpectationsWereMet())
+}
+
+// Values suggested for the example queries should only be the ones
+// starting with the prefix the user is typing
+func TestLogsFilterSuggestionsWithValuePrefix(t *testing.T) {
+	require := require.New(t)
+	tb := NewFilterSuggestionsTestBed(t)
+
+	testAttrib := v3.AttributeKey{
+		Key:      "deployment_name",
+		Type:     v3.AttributeKeyTypeResource,
+		DataType: v3.AttributeKeyDataTypeString,
+		IsColumn: false,
+	}
+	valuePrefix := "prod-"
+
+	tb.mockAttribKeysQueryResponse([]v3.AttributeKey{testAttrib})
+
+	cols := []mockhouse.ColumnType{{Type: "String", Name: "stringTagValue"}}
+	values := [][]any{{"prod-api"}}
+	tb.mockClickhouse.ExpectQuery(
+		"select distinct.*stringTagValue.*from.*signoz_logs.distributed_tag_attributes.*stringTagValue LIKE.*",
+	).WithArgs(
+		testAttrib.Key, v3.TagType(testAttrib.Type), "prod-%", 1,
+	).WillReturnRows(mockhouse.NewRows(cols, values))
+
+	suggestionsResp := tb.GetQBFilterSuggestionsForLogs(map[string]string{
+		"valuePrefix": valuePrefix,
+	})
+
+	require.Greater(len(suggestionsResp.ExampleQueries), 0)
+	for _, q := range suggestionsResp.ExampleQueries {
+		for _, item := range q.Items {
+			if item.Key.Key != testAttrib.Key {
+				continue
+			}
+			value, ok := item.Value.(string)
+			require.True(ok)
+			require.True(strings.HasPrefix(value, valuePrefix))
+		}
+	}
+	require.True(slices.ContainsFunc(
+		suggestionsResp.ExampleQueries, func(q v3.FilterSet) bool {
+			return slices.ContainsFunc(q.Items, func(i v3.FilterItem) bool {
+				return i.Key.Key == testAttrib.Key && i.Value == "prod-api"
+			})
+		},
+	))
+	require.Nil(tb.mockClickhouse.ExpectationsWereMet())
+}
+
+// Suggestions should only contain the attributes seen in the selected
+// time range when one is specified
+func TestLogsFilterSuggestionsWithTimeRange(t *testing.T) {
+	require := require.New(t)
+	tb := NewFilterSuggestionsTestBed(t)
+
+	testAttrib := v3.AttributeKey{
+		Key:      "container_id",
+		Type:     v3.AttributeKeyTypeResource,
+		DataType: v3.AttributeKeyDataTypeString,
+		IsColumn: false,
+	}
+	testAttribValue := "test-container"
+
+	// the tag attributes timestamp is in seconds, the range is widened to whole seconds
+	start := int64(1715000000000000000)
+	end := int64(1715003600500000000)
+	startSeconds := int64(1715000000)
+	endSeconds := int64(1715003601)
+
+	keyCols := []mockhouse.ColumnType{
+		{Type: "String", Name: "tagKey"},
+		{Type: "String", Name: "tagType"},
+		{Type: "String", Name: "tagDataType"},
+	}
+	keyValues := [][]any{{testAttrib.Key, string(testAttrib.Type), string(testAttrib.DataType)}}
+	tb.mockClickhouse.ExpectQuery(
+		`select.*from.*signoz_logs.distributed_tag_attributes where timestamp >= toDateTime\(\$1\) and timestamp <= toDateTime\(\$2\) limit \$3`,
+	).WithArgs(
+		startSeconds, endSeconds, constants.DefaultFilterSuggestionsLimit,
+	).WillReturnRows(mockhouse.NewRows(keyCols, keyValues))
+	tb.mockCreateTableStatement("CREATE TABLE signoz_logs.distributed_logs")
+
+	valueCols := []mockhouse.ColumnType{{Type: "String", Name: "stringTagValue"}}
+	tb.mockClickhouse.ExpectQuery(
+		`select distinct.*stringTagValue.*from.*signoz_logs.distributed_tag_attributes.*timestamp >= toDateTime\(\$3\) and timestamp <= toDateTime\(\$4\)`,
+	).WithArgs(
+		testAttrib.Key, v3.TagType(testAttrib.Type), startSeconds, endSeconds, 1,
+	).WillReturnRows(mockhouse.NewRows(valueCols, [][]any{{testAttribValue}}))
+
+	suggestionsResp := tb.GetQBFilterSuggestionsForLogs(map[string]string{
+		"start": fmt.Sprintf("%d", start),
+		"end":   fmt.Sprintf("%d", end),
+	})
+
+	require.True(slices.ContainsFunc(
+		suggestionsResp.AttributeKeys, func(a v3.AttributeKey) bool {
+			return a.Key == testAttrib.Key && a.Type == testAttrib.Type
+		},
+	))
+	require.True(slices.ContainsFunc(
+		suggestionsResp.ExampleQueries, func(q v3.FilterSet) bool {
+			return slices.ContainsFunc(q.Items, func(i v3.FilterItem) bool {
+				return i.Key.Key == testAttrib.Key && i.Value == testAttribValue
+			})
+		},
+	))
+	require.Nil(tb.mockClickhouse.ExpectationsWereMet())
+}
+
+// Each suggested key should come with the operators which make sense
+// for its data type
+func TestLogsFilterSuggestionsRecommendedOperators(t *testing.T) {
+	require := require.New(t)
+	tb := NewFilterSuggestionsTestBed(t)
+
+	stringAttrib := v3.AttributeKey{
+		Key:      "service.name",
+		Type:     v3.AttributeKeyTypeResource,
+		DataType: v3.AttributeKeyDataTypeString,
+		IsColumn: false,
+	}
+	numericAttrib := v3.AttributeKey{
+		Key:      "response_time",
+		Type:     v3.AttributeKeyTypeTag,
+		DataType: v3.AttributeKeyDataTypeFloat64,
+		IsColumn: false,
+	}
+
+	tb.mockAttribKeysQueryResponse([]v3.AttributeKey{numericAttrib, stringAttrib})
+	tb.mockAttribValuesQueryResponse(stringAttrib, []string{"frontend"})
+	suggestionsResp := tb.GetQBFilterSuggestionsForLogs(map[string]string{})
+
+	require.Equal(len(suggestionsResp.AttributeKeys), len(suggestionsResp.RecommendedOperators))
+	operatorsOf := func(key string) []v3.FilterOperator {
+		for _, keyOperators := range suggestionsResp.RecommendedOperators {
+			if keyOperators.Key.Key == key {
+				return keyOperators.Operators
+			}
+		}
+		return nil
+	}
+
+	numericOperators := operatorsOf(numericAttrib.Key)
+	for _, op := range []v3.FilterOperator{">", ">=", "<", "<="} {
+		require.Contains(numericOperators, op)
+	}
+	require.NotContains(numericOperators, v3.FilterOperatorContains)
+
+	stringOperators := operatorsOf(stringAttrib.Key)
+	for _, op := range []v3.FilterOperator{"contains", "="} {
+		require.Contains(stringOperators, op)
+	}
+	require.NotContains(stringOperators, v3.FilterOperatorGreaterThan)
+}
+
+// The example query for a numeric attribute should compare its values
+// rather than match a single value
+func TestLogsFilterSuggestionsNumericExampleQuery(t *testing.T) {
+	require := require.New(t)
+	tb := NewFilterSuggestionsTestBed(t)
+
+	numericAttrib := v3.AttributeKey{
+		Key:      "duration_nano",
+		Type:     v3.AttributeKeyTypeTag,
+		DataType: v3.AttributeKeyDataTypeInt64,
+		IsColumn: false,
+	}
+
+	tb.mockAttribKeysQueryResponse([]v3.AttributeKey{numericAttrib})
+	cols := []mockhouse.ColumnType{{Type: "Int64", Name: "int64TagValue"}}
+	tb.mockClickhouse.ExpectQuery(
+		"select distinct.*int64TagValue.*from.*signoz_logs.distributed_tag_attributes.*",
+	).WithArgs(
+		numericAttrib.Key, v3.TagType(numericAttrib.Type), 1,
+	).WillReturnRows(mockhouse.NewRows(cols, [][]any{{int64(100000000)}}))
+
+	suggestionsResp := tb.GetQBFilterSuggestionsForLogs(map[string]string{})
+
+	require.True(slices.ContainsFunc(
+		suggestionsResp.ExampleQueries, func(q v3.FilterSet) bool {
+			return slices.ContainsFunc(q.Items, func(i v3.FilterItem) bool {
+				return i.Key.Key == numericAttrib.Key &&
+					i.Operator == v3.FilterOperatorGreaterThan &&
+					i.Value == float64(100000000)
+			})
+		},
+	), "expected a comparison example query for the numeric attribute, got %v", suggestionsResp.ExampleQueries)
+	require.Nil(tb.mockClickhouse.ExpectationsWereMet())
+}
+
+func TestLogsAttributeColumnStatus(t *testing.T) {
+	require := require.New(t)
+	tb := NewFilterSuggestionsTestBed(t)
+
+	materializedAttrib := v3.AttributeKey{
+		Key:      "http.method",
+		Type:     v3.AttributeKeyTypeTag,
+		DataType: v3.AttributeKeyDataTypeString,
+	}
+	jsonAttrib := v3.AttributeKey{
+		Key:      "container_id",
+		Type:     v3.AttributeKeyTypeResource,
+		DataType: v3.AttributeKeyDataTypeString,
+	}
+
+	tb.mockCreateTableStatement(
+		"CREATE TABLE signoz_logs.logs (`timestamp` UInt64, " +
+			"`attribute_string_http$$method` String MATERIALIZED attributes_string_value[indexOf(attributes_string_key, 'http.method')])",
+	)
+
+	attribsJson, err := json.Marshal([]v3.AttributeKey{materializedAttrib, jsonAttrib})
+	require.Nil(err, "couldn't serialize attributes to JSON")
+	resp := tb.GetAttributeColumnStatusForLogs(map[string]string{
+		"attributes": base64.RawURLEncoding.EncodeToString(attribsJson),
+	})
+
+	require.Equal(2, len(resp.AttributeKeys))
+	require.Equal(materializedAttrib.Key, resp.AttributeKeys[0].Key)
+	require.True(resp.AttributeKeys[0].IsColumn)
+	require.Equal(jsonAttrib.Key, resp.AttributeKeys[1].Key)
+	require.False(resp.AttributeKeys[1].IsColumn)
+}
+
+// Mocks response for the create table query used to determine
+// if an attribute is a column
+func (tb *FilterSuggestionsTestBed) mockCreateTableStatement(statement string) {
+	cols := []mockhouse.ColumnType{{Type: "String", Name: "statement"}}
+	values := [][]any{{statement}}
+	tb.mockClickhouse.ExpectSelect(
+		"SHOW CREATE TABLE.*",
+	).WillReturnRows(mockhouse.NewRows(cols, values))
+}
+
 // Mocks response for CH queries made by reader.GetLogAttributeKeys
 func (tb *FilterSuggestionsTestBed) mockAttribKeysQueryResponse(
 	attribsToReturn []v3.AttributeKey,
@@ -157,14 +488,7 @@ func (tb *FilterSuggestionsTestBed) mockAttribKeysQueryResponse(
 		mockhouse.NewRows(cols, values),
 	)
 
-	// Add expectation for the create table query used to determine
-	// if an attribute is a column
-	cols = []mockhouse.ColumnType{{Type: "String", Name: "statement"}}
-	values = [][]any{{"CREATE TABLE signoz_logs.distributed_logs"}}
-	tb.mockClickhouse.ExpectSelect(
-		"SHOW CREATE TABLE.*",
-	).WillReturnRows(mockhouse.NewRows(cols, values))
-
+	tb.mockCreateTableStatement("CREATE TABLE signoz_logs.distributed_logs")
 }
 
 // Mocks response for CH queries made by reader.GetLogAttributeValues
@@ -218,6 +542,27 @@ func (tb *FilterSuggestionsTestBed) GetQBFilterSuggestionsForLogs(
 	return &resp
 }
 
+func (tb *FilterSuggestionsTestBed) GetAttributeColumnStatusForLogs(
+	queryParams map[string]string,
+) *v3.AttributeColumnStatusResponse {
+	queryParams["dataSource"] = "logs"
+
+	result := tb.QSGetRequest("/api/v3/attribute_column_status", queryParams)
+
+	dataJson, err := json.Marshal(result.Data)
+	if err != nil {
+		tb.t.Fatalf("could not marshal apiResponse.Data: %v", err)
+	}
+
+	var resp v3.AttributeColumnStatusResponse
+	err = json.Unmarshal(dataJson, &resp)
+	if err != nil {
+		tb.t.Fatalf("could not unmarshal apiResponse.Data json into AttributeColumnStatusResponse")
+	}
+
+	return &resp
+}
+
 func NewFilterSuggestionsTestBed(t *testing.T) *FilterSuggestionsTestBed {
 	testDB := utils.NewQueryServiceDBForTests(t)
 
@@ -264,6 +264,25 @@ func GetClickhouseColumnName(typeName string, dataType, field string) string {
 	return colName
 }
 
+// StartOfIntervalExpr returns the clickhouse expression of the start of the step
+// interval of the timestamp expression. The intervals start at the alignment offset
+// past the multiples of the step, both in seconds
+func StartOfIntervalExpr(timestampExpr string, step, alignmentOffset int64) string {
+	if alignmentOffset == 0 {
+		return fmt.Sprintf("toStartOfInterval(%s, INTERVAL %d SECOND)", timestampExpr, step)
+	}
+	return fmt.Sprintf("toStartOfInterval(%s - INTERVAL %d SECOND, INTERVAL %d SECOND) + INTERVAL %d SECOND", timestampExpr, alignmentOffset, step, alignmentOffset)
+}
+
+// HeatmapBucketExpr returns the clickhouse expression of the index of the value
+// bucket of the value expression, of count buckets of equal width over [min, max).
+// The values below min and above max are in the first and the last bucket
+func HeatmapBucketExpr(valueExpr string, min, max float64, count int) string {
+	width := (max - min) / float64(count)
+	return fmt.Sprintf("least(greatest(toInt64(floor((toFloat64(%s) - %s) / %s)), 0), %d)",
+		valueExpr, strconv.FormatFloat(min, 'f', -1, 64), strconv.FormatFloat(width, 'f', -1, 64), count-1)
+}
+
 // GetEpochNanoSecs takes epoch and returns it in ns
 func GetEpochNanoSecs(epoch int64) int64 {
 	temp := epoch
@@ -24,4 +24,6 @@ func AssignReservedVarsV3(queryRangeParams *v3.QueryRangeParamsV3) {
 	queryRangeParams.Variables["start_datetime"] = fmt.Sprintf("toDateTime(%d)", queryRangeParams.Start/1000)
 	queryRangeParams.Variables["end_datetime"] = fmt.Sprintf("toDateTime(%d)", queryRangeParams.End/1000)
 
+	queryRangeParams.Variables["step"] = queryRangeParams.Step
+
 }